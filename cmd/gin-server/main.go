@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"log"
 	"time"
 
@@ -13,6 +14,11 @@ import (
 )
 
 func main() {
+	dbFlag := flag.String("db", "", "Path to a SQLite database file; uses the in-memory store when empty")
+	seedFlag := flag.String("seed", "", "Directory of JSON/YAML fixtures to seed an empty store with; uses the built-in sample data when empty")
+	tenantsFlag := flag.String("tenants", "", "Comma-separated tenant IDs to serve besides \"default\"; \"*\" accepts any valid tenant ID")
+	flag.Parse()
+
 	ctx := context.Background()
 
 	// Create graph with timing enabled
@@ -30,14 +36,26 @@ func main() {
 		MaxComplexity:          1000, // Overall query complexity score
 	}
 
+	// Create the store that backs all handlers, partitioned by tenant
+	tenants, err := handlers.ParseTenantList(*tenantsFlag)
+	if err != nil {
+		log.Fatalf("Invalid -tenants: %v", err)
+	}
+	store := handlers.NewTenantStore(tenantOpener(*dbFlag, *seedFlag), tenants...)
+	defer store.Close()
+	if _, err := store.For(ctx); err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+
 	// Register handlers (same as main server)
 	graph.RegisterQuery(ctx, "greeting", handlers.Greeting, "name")
-	handlers.RegisterWidgetHandlers(ctx, &graph)
-	handlers.RegisterEmployeeHandlers(ctx, &graph)
-	handlers.RegisterProductHandlers(ctx, &graph)
-	handlers.RegisterSearchHandlers(ctx, &graph)
+	handlers.RegisterWidgetHandlers(ctx, &graph, store)
+	handlers.RegisterEmployeeHandlers(ctx, &graph, store)
+	handlers.RegisterProductHandlers(ctx, &graph, store)
+	handlers.RegisterSearchHandlers(ctx, &graph, store)
 	handlers.RegisterAuthHandlers(ctx, &graph)
-	handlers.RegisterSubscriptionHandlers(ctx, &graph)
+	handlers.RegisterSubscriptionHandlers(ctx, &graph, store)
+	handlers.RegisterAuditHandlers(ctx, &graph, store)
 
 	// Enable introspection
 	graph.EnableIntrospection(ctx)
@@ -51,24 +69,35 @@ func main() {
 	server := gin.Default()
 
 	type graphqlRequest struct {
-		Query     string          `json:"query"`
-		Variables json.RawMessage `json:"variables"`
+		Query         string          `json:"query"`
+		OperationName string          `json:"operationName"`
+		Variables     json.RawMessage `json:"variables"`
 	}
 
 	// GraphQL endpoint
 	server.POST("/graphql", func(c *gin.Context) {
+		// Resolve the tenant first; users are looked up in its store
+		tenant, err := handlers.TenantFromRequest(c.Request)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		reqCtx := handlers.WithTenant(c, tenant)
+
 		// Apply authentication middleware logic
 		authHeader := c.GetHeader("Authorization")
 		if authHeader != "" {
-			user := handlers.GetUserFromAuthHeader(authHeader)
+			user := handlers.GetUserFromAuthHeader(reqCtx, store, authHeader)
 			if user != nil {
-				c.Set("user", user)
+				reqCtx = context.WithValue(reqCtx, handlers.UserContextKey, user)
 			}
 		}
 
 		// Pull the query and variables from the request
 		var request graphqlRequest
-		err := c.BindJSON(&request)
+		err = c.BindJSON(&request)
 		if err != nil {
 			c.JSON(400, gin.H{
 				"error": err.Error(),
@@ -77,7 +106,8 @@ func main() {
 		}
 
 		// Process the GraphQL request
-		res, err := graph.ProcessRequest(c, request.Query, string(request.Variables))
+		auditCtx := handlers.WithAuditRequest(reqCtx, request.Query, request.OperationName, request.Variables)
+		res, err := graph.ProcessRequest(auditCtx, request.Query, string(request.Variables))
 		if err != nil {
 			// Log the error here, but the response still has a GraphQL response that can be returned
 			log.Printf("GraphQL processing error: %v", err)
@@ -94,6 +124,9 @@ func main() {
 		c.String(200, schema)
 	})
 
+	// CSV export endpoint, e.g. /export/products.csv
+	server.GET("/export/:file", gin.WrapH(handlers.TenantMiddleware(handlers.AuthMiddleware(store, handlers.CSVExportHandler(store)))))
+
 	// Health check endpoint
 	server.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -104,10 +137,11 @@ func main() {
 
 	log.Println("Gin-based GraphQL server starting on http://localhost:8081/graphql")
 	log.Println("Health check available at http://localhost:8081/health")
+	log.Println("CSV exports available at http://localhost:8081/export/widgets.csv and /export/products.csv")
 	log.Println("GraphQL schema available at GET http://localhost:8081/graphql")
 	log.Println("Note: This example does not implement WebSocket subscriptions (though Gin can support them)")
 
-	err := server.Run(":8081")
+	err = server.Run(":8081")
 	if err != nil {
 		log.Fatal("Failed to start Gin server:", err)
 	}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"github.com/gburgyan/go-quickgraph-sample/handlers"
+	"log"
+)
+
+// tenantOpener returns a handlers.TenantOpener that opens and seeds the
+// store of a tenant, with a database file per tenant, and audits every
+// write made after seeding.
+func tenantOpener(dbPath, seedDir string) handlers.TenantOpener {
+	return func(ctx context.Context, tenant string) (handlers.Store, func() error, error) {
+		log.Printf("Opening store for tenant %s", tenant)
+		store, closeStore, err := handlers.OpenStore(ctx, handlers.TenantFile(dbPath, tenant))
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := handlers.SeedStore(ctx, store, seedDir); err != nil {
+			closeStore()
+			return nil, nil, err
+		}
+		return handlers.NewAuditStore(store), closeStore, nil
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -19,6 +21,12 @@ func main() {
 	// Parse command line flags
 	queryFlag := flag.String("query", "", "Execute a GraphQL query directly and print the result")
 	variablesFlag := flag.String("variables", "{}", "Variables for the query in JSON format")
+	dbFlag := flag.String("db", "", "Path to a SQLite database file; uses the in-memory store when empty")
+	seedFlag := flag.String("seed", "", "Directory of JSON/YAML fixtures to seed an empty store with; uses the built-in sample data when empty")
+	dataDirFlag := flag.String("data-dir", "", "Directory for state snapshots; snapshots are disabled when empty")
+	eventLogFlag := flag.String("event-log", "", "Append every write to this event log file and rebuild state from it on start")
+	tenantsFlag := flag.String("tenants", "", "Comma-separated tenant IDs to serve besides \"default\"; \"*\" accepts any valid tenant ID")
+	snapshotIntervalFlag := flag.Duration("snapshot-interval", 5*time.Minute, "How often to snapshot state to -data-dir; 0 disables periodic snapshots")
 	flag.Parse()
 
 	ctx := context.Background()
@@ -37,7 +45,7 @@ func main() {
 			// "http://localhost:3000",
 		},
 		AllowedMethods:        []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:        []string{"Content-Type", "Authorization"},
+		AllowedHeaders:        []string{"Content-Type", "Authorization", handlers.TenantHeader},
 		AllowCredentials:      false, // Set to true if you need cookies/auth
 		MaxAge:                86400, // 24 hours
 		EnableForAllResponses: true,  // Important for GraphQL responses
@@ -87,19 +95,40 @@ func main() {
 		log.Fatalf("Failed to register scalar handlers: %v", err)
 	}
 
+	// Create the store that backs all handlers. Every tenant gets its own
+	// store, opened on first use; the default tenant is opened right away.
+	tenants, err := handlers.ParseTenantList(*tenantsFlag)
+	if err != nil {
+		log.Fatalf("Invalid -tenants: %v", err)
+	}
+	store := handlers.NewTenantStore(tenantOpener(storeConfig{
+		dbPath:       *dbFlag,
+		eventLogPath: *eventLogFlag,
+		dataDir:      *dataDirFlag,
+		seedDir:      *seedFlag,
+	}), tenants...)
+	defer store.Close()
+	if _, err := store.For(ctx); err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+
 	// Register original handlers
 	graph.RegisterQuery(ctx, "greeting", handlers.Greeting, "name")
-	handlers.RegisterWidgetHandlers(ctx, &graph)
+	handlers.RegisterWidgetHandlers(ctx, &graph, store)
 
 	// Register new feature handlers
-	handlers.RegisterEmployeeHandlers(ctx, &graph)
-	handlers.RegisterProductHandlers(ctx, &graph)
-	handlers.RegisterSearchHandlers(ctx, &graph)
+	handlers.RegisterEmployeeHandlers(ctx, &graph, store)
+	handlers.RegisterProductHandlers(ctx, &graph, store)
+	handlers.RegisterSearchHandlers(ctx, &graph, store)
 	handlers.RegisterAuthHandlers(ctx, &graph)
-	handlers.RegisterSubscriptionHandlers(ctx, &graph)
+	handlers.RegisterSubscriptionHandlers(ctx, &graph, store)
+	handlers.RegisterAuditHandlers(ctx, &graph, store)
+	if *dataDirFlag != "" {
+		handlers.RegisterSnapshotHandlers(ctx, &graph, store, *dataDirFlag)
+	}
 
 	// Register scalar demo handlers
-	handlers.RegisterScalarDemoHandlers(ctx, &graph)
+	handlers.RegisterScalarDemoHandlers(ctx, &graph, store)
 
 	// Explicitly register types that aren't directly returned by any GraphQL function
 	// This ensures they appear in the schema and can be used in unions
@@ -110,7 +139,7 @@ func main() {
 
 	// Generate and save schema to file
 	schema := graph.SchemaDefinition(ctx)
-	err := os.WriteFile("schema.graphql", []byte(schema), 0644)
+	err = os.WriteFile("schema.graphql", []byte(schema), 0644)
 	if err != nil {
 		log.Printf("Failed to write schema file: %v", err)
 	} else {
@@ -130,30 +159,66 @@ func main() {
 	// Create WebSocket upgrader
 	upgrader := NewGorillaUpgrader()
 
-	// Create HTTP handler with authentication middleware and WebSocket support
-	graphHandler := handlers.AuthMiddleware(graph.HttpHandlerWithWebSocket(upgrader))
+	// Create HTTP handler with tenant and authentication middleware and
+	// WebSocket support. The tenant must be resolved first, since users are
+	// looked up in the tenant's store.
+	graphHandler := handlers.TenantMiddleware(handlers.AuthMiddleware(store, handlers.AuditMiddleware(graph.HttpHandlerWithWebSocket(upgrader))))
+
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", graphHandler)
 
-	http.Handle("/graphql", graphHandler)
+	// CSV downloads of the widgets and products, e.g. /export/products.csv
+	mux.Handle("/export/", handlers.TenantMiddleware(handlers.AuthMiddleware(store, handlers.CSVExportHandler(store))))
 
 	// Add a health check endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, "OK")
 	})
 
+	server := &http.Server{Addr: ":8080", Handler: mux}
+
+	// Stop on Ctrl+C or SIGTERM so the final snapshot gets written
+	runCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *dataDirFlag != "" && *snapshotIntervalFlag > 0 {
+		go runPeriodicSnapshots(runCtx, store, *dataDirFlag, *snapshotIntervalFlag)
+	}
+
 	log.Println("GraphQL server starting on http://localhost:8080/graphql")
 	log.Println("WebSocket endpoint available at ws://localhost:8080/graphql")
 	log.Println("Health check available at http://localhost:8080/health")
+	log.Println("CSV exports available at http://localhost:8080/export/widgets.csv and /export/products.csv")
 	log.Println("GraphQL schema available at GET http://localhost:8080/graphql")
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatal("Failed to start server:", err)
+	case <-runCtx.Done():
+	}
+
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Graceful shutdown failed: %v", err)
+	}
+
+	if *dataDirFlag != "" {
+		saveSnapshots(ctx, store, *dataDirFlag)
 	}
 }
 
 // executeQueryAndExit executes a GraphQL query and prints the result, then exits
 func executeQueryAndExit(ctx context.Context, graph *quickgraph.Graphy, query string, variablesJSON string) {
 	// Execute the query
+	ctx = handlers.WithAuditRequest(ctx, query, "", json.RawMessage(variablesJSON))
 	result, err := graph.ProcessRequest(ctx, query, variablesJSON)
 	if err != nil {
 		log.Fatalf("Failed to execute query: %v", err)
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gburgyan/go-quickgraph-sample/handlers"
+	"log"
+	"os"
+	"time"
+)
+
+// loadInitialState restores the snapshot in dataDir when there is one, and
+// seeds the store from seedDir (or the sample data) otherwise. Stores that
+// already hold data, e.g. a -db database written after the last snapshot,
+// are kept as they are.
+func loadInitialState(ctx context.Context, store handlers.Store, dataDir, seedDir string) error {
+	if dataDir != "" {
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return err
+		}
+		snap, err := handlers.LoadSnapshotIfEmpty(ctx, store, dataDir)
+		if err == nil && snap == nil {
+			log.Printf("Store already holds data; ignoring any snapshot in %s", dataDir)
+			return nil
+		}
+		if err == nil {
+			log.Printf("Restored snapshot taken at %s from %s", snap.TakenAt.Format(time.RFC3339), dataDir)
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		log.Printf("No snapshot in %s yet", dataDir)
+	}
+	if seedDir != "" {
+		log.Printf("Seeding from fixtures in %s", seedDir)
+	}
+	return handlers.SeedStore(ctx, store, seedDir)
+}
+
+// runPeriodicSnapshots saves a snapshot of every open tenant every interval
+// until ctx is done.
+func runPeriodicSnapshots(ctx context.Context, store *handlers.TenantStore, dataDir string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			saveSnapshots(ctx, store, dataDir)
+		}
+	}
+}
+
+// saveSnapshots writes a snapshot of every open tenant into its directory.
+func saveSnapshots(ctx context.Context, store *handlers.TenantStore, dataDir string) {
+	for _, tenant := range store.Tenants() {
+		saveSnapshot(handlers.WithTenant(ctx, tenant), store, handlers.TenantDir(dataDir, tenant))
+	}
+}
+
+// saveSnapshot writes a snapshot and logs the outcome.
+func saveSnapshot(ctx context.Context, store handlers.Store, dataDir string) {
+	if _, err := handlers.SaveSnapshot(ctx, store, dataDir); err != nil {
+		log.Printf("Failed to save snapshot: %v", err)
+		return
+	}
+	log.Printf("Snapshot saved to %s", dataDir)
+}
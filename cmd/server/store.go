@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"github.com/gburgyan/go-quickgraph-sample/handlers"
+	"log"
+)
+
+// storeConfig holds the command line settings that locate a store's data.
+// Each tenant gets its own database, event log and data directory derived
+// from them with handlers.TenantFile and handlers.TenantDir.
+type storeConfig struct {
+	dbPath       string
+	eventLogPath string
+	dataDir      string
+	seedDir      string
+}
+
+// tenantOpener returns a handlers.TenantOpener that opens the store of a
+// tenant, rebuilds its state from the event log, a snapshot or the seed
+// data, and audits every write from then on.
+func tenantOpener(cfg storeConfig) handlers.TenantOpener {
+	return func(ctx context.Context, tenant string) (handlers.Store, func() error, error) {
+		log.Printf("Opening store for tenant %s", tenant)
+		store, closeStore, err := handlers.OpenStore(ctx, handlers.TenantFile(cfg.dbPath, tenant))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Record every write in the event log, rebuilding state from it first
+		replayed := 0
+		closeAll := closeStore
+		if cfg.eventLogPath != "" {
+			eventLogPath := handlers.TenantFile(cfg.eventLogPath, tenant)
+			logStore, n, err := handlers.OpenEventLogStore(ctx, store, eventLogPath)
+			if err != nil {
+				closeStore()
+				return nil, nil, err
+			}
+			store, replayed = logStore, n
+			closeAll = func() error {
+				logErr := logStore.Close()
+				if err := closeStore(); err != nil {
+					return err
+				}
+				return logErr
+			}
+			log.Printf("Recording events in %s (%d replayed)", eventLogPath, replayed)
+		}
+
+		if replayed == 0 {
+			if err := loadInitialState(ctx, store, handlers.TenantDir(cfg.dataDir, tenant), cfg.seedDir); err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+		}
+
+		// Audit every write from here on; seeding and replay are not audited
+		return handlers.NewAuditStore(store), closeAll, nil
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
@@ -36,7 +37,10 @@ func runSubscriptionClient() {
 	u := url.URL{Scheme: "ws", Host: "localhost:8080", Path: "/graphql"}
 	log.Printf("Connecting to %s", u.String())
 
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	// Order status updates are only sent to the customer of the order, so
+	// connect as the demo customer
+	header := http.Header{"Authorization": {"Bearer user-token"}}
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), header)
 	if err != nil {
 		log.Fatal("dial:", err)
 	}
@@ -91,7 +95,7 @@ func runSubscriptionClient() {
 	subscribeToProductUpdates(conn)
 
 	// Example 3: Subscribe to order status
-	subscribeToOrderStatus(conn, "order-123")
+	subscribeToOrderStatus(conn, 1)
 
 	// Wait for interrupt
 	select {
@@ -162,8 +166,8 @@ func subscribeToProductUpdates(conn *websocket.Conn) {
 	}
 }
 
-func subscribeToOrderStatus(conn *websocket.Conn, orderID string) {
-	query := `subscription OrderStatus($orderId: String!) {
+func subscribeToOrderStatus(conn *websocket.Conn, orderID int) {
+	query := `subscription OrderStatus($orderId: Int!) {
 		orderStatusUpdates(orderId: $orderId) {
 			orderId
 			status
@@ -172,7 +176,7 @@ func subscribeToOrderStatus(conn *websocket.Conn, orderID string) {
 		}
 	}`
 
-	variables := map[string]string{"orderId": orderID}
+	variables := map[string]int{"orderId": orderID}
 	varsJSON, _ := json.Marshal(variables)
 
 	subMsg := WebSocketMessage{
@@ -187,7 +191,7 @@ func subscribeToOrderStatus(conn *websocket.Conn, orderID string) {
 	if err := conn.WriteJSON(subMsg); err != nil {
 		log.Printf("Failed to subscribe to order status: %v", err)
 	} else {
-		log.Printf("Subscribed to order %s status updates", orderID)
+		log.Printf("Subscribed to order %d status updates", orderID)
 	}
 }
 
@@ -200,7 +204,7 @@ func main() {
 	fmt.Println("1. Connect to the GraphQL WebSocket endpoint")
 	fmt.Println("2. Subscribe to current time (updates every second)")
 	fmt.Println("3. Subscribe to product updates")
-	fmt.Println("4. Subscribe to order status for order-123")
+	fmt.Println("4. Subscribe to the status of order 1, as the demo customer")
 	fmt.Println("\nPress Ctrl+C to exit\n")
 
 	runSubscriptionClient()
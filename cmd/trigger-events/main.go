@@ -38,7 +38,7 @@ func triggerProductUpdate() {
 		"input": map[string]interface{}{
 			"name":        fmt.Sprintf("Test Product %d", time.Now().Unix()),
 			"description": "Product created to test subscriptions",
-			"price":       99.99,
+			"price":       "99.99 USD",
 			"categoryId":  1,
 		},
 	}
@@ -59,8 +59,8 @@ func triggerProductUpdate() {
 		time.Sleep(2 * time.Second)
 
 		updateQuery := `
-			mutation UpdateStatus($id: Int!, $status: ProductStatus!) {
-				UpdateProductStatus(id: $id, status: $status) {
+			mutation UpdateStatus($id: Int!, $status: ProductStatus!, $version: Int!) {
+				UpdateProductStatus(id: $id, status: $status, version: $version) {
 					id
 					name
 					status
@@ -69,8 +69,9 @@ func triggerProductUpdate() {
 		`
 
 		updateVars := map[string]interface{}{
-			"id":     createResult.CreateProduct.ID,
-			"status": "ACTIVE",
+			"id":      createResult.CreateProduct.ID,
+			"status":  "ACTIVE",
+			"version": 1, // Newly created products start at version 1
 		}
 
 		resp = executeGraphQL(updateQuery, updateVars)
@@ -87,6 +88,7 @@ func triggerWidgetUpdate() {
 				id
 				name
 				quantity
+				version
 			}
 		}
 	`
@@ -94,7 +96,7 @@ func triggerWidgetUpdate() {
 	createVars := map[string]interface{}{
 		"widget": map[string]interface{}{
 			"name":     fmt.Sprintf("Test Widget %d", time.Now().Unix()),
-			"price":    49.99,
+			"price":    "49.99 USD",
 			"quantity": 100,
 		},
 	}
@@ -105,7 +107,8 @@ func triggerWidgetUpdate() {
 	// Parse response to get widget ID
 	var createResult struct {
 		CreateWidget struct {
-			ID int `json:"id"`
+			ID      int `json:"id"`
+			Version int `json:"version"`
 		} `json:"CreateWidget"`
 	}
 	json.Unmarshal(resp.Data, &createResult)
@@ -128,8 +131,9 @@ func triggerWidgetUpdate() {
 			"widget": map[string]interface{}{
 				"id":       createResult.CreateWidget.ID,
 				"name":     fmt.Sprintf("Updated Widget %d", time.Now().Unix()),
-				"price":    59.99,
+				"price":    "59.99 USD",
 				"quantity": 150,
+				"version":  createResult.CreateWidget.Version,
 			},
 		}
 
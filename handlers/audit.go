@@ -0,0 +1,636 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gburgyan/go-quickgraph"
+)
+
+// AuditEntry records one change to one entity, together with the user and
+// the GraphQL request that made it.
+type AuditEntry struct {
+	ID        int
+	Time      time.Time
+	UserID    *int          // Acting user, if authenticated
+	Operation string        // Operation name sent with the request, if any
+	Query     string        // GraphQL document of the request
+	Variables *string       // Request variables as JSON, with secrets redacted
+	Entity    string        // "widget", "product", "category", "review", "user", "employee", "order", "cart", "variant" or "store"
+	EntityID  int           // Zero for whole-store changes such as a snapshot restore
+	Action    string        // "created", "updated", "deleted" or "restored"
+	Changes   []AuditChange // Fields that differ between before and after
+}
+
+// AuditChange is one changed field. Values are JSON; Before is null for
+// created entities.
+type AuditChange struct {
+	Field  string
+	Before *string
+	After  *string
+}
+
+// AuditFilter selects audit entries. All set fields must match.
+type AuditFilter struct {
+	Entity   *string    `json:"entity"`
+	EntityID *int       `json:"entityId"`
+	UserID   *int       `json:"userId"`
+	Since    *time.Time `json:"since"` // Inclusive
+	Until    *time.Time `json:"until"` // Exclusive
+}
+
+// matches reports whether entry passes the filter.
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	if f.Entity != nil && entry.Entity != *f.Entity {
+		return false
+	}
+	if f.EntityID != nil && entry.EntityID != *f.EntityID {
+		return false
+	}
+	if f.UserID != nil && (entry.UserID == nil || *entry.UserID != *f.UserID) {
+		return false
+	}
+	if f.Since != nil && entry.Time.Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && !entry.Time.Before(*f.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditStore is a Store decorator that appends an AuditEntry to the
+// store's AuditRepository for every write that succeeds. Writes are
+// serialized so that the recorded before state is the one that was
+// actually replaced.
+//
+// The write is committed before its entry is appended, so a failure to
+// record the entry is logged rather than returned: reporting an error for a
+// write that happened would make clients retry it.
+type AuditStore struct {
+	Store
+	mu sync.Mutex
+}
+
+// NewAuditStore wraps store so that all writes are audited.
+func NewAuditStore(store Store) *AuditStore {
+	return &AuditStore{Store: store}
+}
+
+func (s *AuditStore) Widgets() WidgetRepository {
+	return auditedWidgets{s.Store.Widgets(), s}
+}
+
+func (s *AuditStore) Products() ProductRepository {
+	return auditedProducts{s.Store.Products(), s}
+}
+
+func (s *AuditStore) Categories() CategoryRepository {
+	return auditedCategories{s.Store.Categories(), s}
+}
+
+func (s *AuditStore) Reviews() ReviewRepository {
+	return auditedReviews{s.Store.Reviews(), s}
+}
+
+func (s *AuditStore) Users() UserRepository {
+	return auditedUsers{s.Store.Users(), s}
+}
+
+func (s *AuditStore) Employees() EmployeeRepository {
+	return auditedEmployees{s.Store.Employees(), s}
+}
+
+func (s *AuditStore) Orders() OrderRepository {
+	return auditedOrders{s.Store.Orders(), s}
+}
+
+func (s *AuditStore) Carts() CartRepository {
+	return auditedCarts{s.Store.Carts(), s}
+}
+
+func (s *AuditStore) ProductVariants() ProductVariantRepository {
+	return auditedVariants{s.Store.ProductVariants(), s}
+}
+
+// Restore records the restore as a single whole-store entry.
+func (s *AuditStore) Restore(ctx context.Context, snapshot *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Store.Restore(ctx, snapshot); err != nil {
+		return err
+	}
+	s.record(ctx, "store", 0, "restored", nil)
+	return nil
+}
+
+// audited runs write and, if it succeeds, records the difference between
+// before and the stored result. A nil before marks a newly created entity.
+// Only errors of before and write are returned, see AuditStore.
+func audited[T any](ctx context.Context, s *AuditStore, entity string, before func() (*T, error), write func() (T, error), id func(T) int) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var old *T
+	if before != nil {
+		var err error
+		if old, err = before(); err != nil && !errors.Is(err, ErrNotFound) {
+			var zero T
+			return zero, err
+		}
+	}
+	v, err := write()
+	if err != nil {
+		return v, err
+	}
+
+	action := "created"
+	if before != nil {
+		action = updateAction(old, &v)
+	}
+	changes, err := auditChanges(old, v)
+	if err != nil {
+		log.Printf("Failed to audit %s %s %d: %v", action, entity, id(v), err)
+		return v, nil
+	}
+	s.record(ctx, entity, id(v), action, changes)
+	return v, nil
+}
+
+// updateAction names an update, telling soft deletes and restores apart
+// from ordinary changes.
+func updateAction[T any](before, after *T) string {
+	if before == nil {
+		return "updated"
+	}
+	b, ok1 := any(*before).(softDeletable)
+	a, ok2 := any(*after).(softDeletable)
+	switch {
+	case !ok1 || !ok2 || b.isDeleted() == a.isDeleted():
+		return "updated"
+	case a.isDeleted():
+		return "deleted"
+	default:
+		return "restored"
+	}
+}
+
+// record appends an entry for the request in ctx, logging any failure.
+func (s *AuditStore) record(ctx context.Context, entity string, id int, action string, changes []AuditChange) {
+	entry := AuditEntry{
+		Time:     time.Now().UTC(),
+		Entity:   entity,
+		EntityID: id,
+		Action:   action,
+		Changes:  changes,
+	}
+	if user, ok := ctx.Value(UserContextKey).(*User); ok && user != nil {
+		userID := user.ID
+		entry.UserID = &userID
+	}
+	if req, ok := ctx.Value(auditRequestKey).(auditRequest); ok {
+		entry.Operation = req.operationName
+		entry.Query = req.query
+		entry.Variables = redactVariables(req.variables)
+	}
+	if _, err := s.Store.Audit().Append(ctx, entry); err != nil {
+		log.Printf("Failed to audit %s %s %d: %v", action, entity, id, err)
+	}
+}
+
+// auditChanges compares the JSON forms of before and after field by field.
+func auditChanges(before, after interface{}) ([]AuditChange, error) {
+	oldFields, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for name := range oldFields {
+		names[name] = true
+	}
+	for name := range newFields {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []AuditChange
+	for _, name := range sorted {
+		oldValue, newValue := oldFields[name], newFields[name]
+		if oldValue == newValue {
+			continue
+		}
+		change := AuditChange{Field: name}
+		if _, ok := oldFields[name]; ok {
+			change.Before = strPtr(oldValue)
+		}
+		if _, ok := newFields[name]; ok {
+			change.After = strPtr(newValue)
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// jsonFields returns the top-level fields of v's JSON object form. A nil
+// pointer has no fields.
+func jsonFields(v interface{}) (map[string]string, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(fields))
+	for name, value := range fields {
+		result[name] = string(value)
+	}
+	return result, nil
+}
+
+// getter adapts a repository Get to the before function audited expects.
+func getter[T any](get func() (T, error)) func() (*T, error) {
+	return func() (*T, error) {
+		v, err := get()
+		if err != nil {
+			return nil, err
+		}
+		return &v, nil
+	}
+}
+
+type auditedWidgets struct {
+	WidgetRepository
+	s *AuditStore
+}
+
+func widgetID(w Widget) int { return w.ID }
+
+func (r auditedWidgets) Create(ctx context.Context, widget Widget) (Widget, error) {
+	return audited(ctx, r.s, "widget", nil, func() (Widget, error) { return r.WidgetRepository.Create(ctx, widget) }, widgetID)
+}
+
+func (r auditedWidgets) Update(ctx context.Context, widget Widget) (Widget, error) {
+	return audited(ctx, r.s, "widget",
+		getter(func() (Widget, error) { return r.WidgetRepository.Get(ctx, widget.ID) }),
+		func() (Widget, error) { return r.WidgetRepository.Update(ctx, widget) }, widgetID)
+}
+
+// AdjustStock is audited as an update of the widget.
+func (r auditedWidgets) AdjustStock(ctx context.Context, entry StockEntry) (Widget, StockEntry, error) {
+	widget, err := audited(ctx, r.s, "widget",
+		getter(func() (Widget, error) { return r.WidgetRepository.Get(ctx, entry.WidgetID) }),
+		func() (Widget, error) {
+			var err error
+			var w Widget
+			w, entry, err = r.WidgetRepository.AdjustStock(ctx, entry)
+			return w, err
+		}, widgetID)
+	return widget, entry, err
+}
+
+type auditedProducts struct {
+	ProductRepository
+	s *AuditStore
+}
+
+func productID(p Product) int { return p.ID }
+
+func (r auditedProducts) Create(ctx context.Context, product Product) (Product, error) {
+	return audited(ctx, r.s, "product", nil, func() (Product, error) { return r.ProductRepository.Create(ctx, product) }, productID)
+}
+
+func (r auditedProducts) Update(ctx context.Context, product Product) (Product, error) {
+	return audited(ctx, r.s, "product",
+		getter(func() (Product, error) { return r.ProductRepository.Get(ctx, product.ID) }),
+		func() (Product, error) { return r.ProductRepository.Update(ctx, product) }, productID)
+}
+
+type auditedCategories struct {
+	CategoryRepository
+	s *AuditStore
+}
+
+func categoryID(c Category) int { return c.ID }
+
+func (r auditedCategories) Create(ctx context.Context, category Category) (Category, error) {
+	return audited(ctx, r.s, "category", nil, func() (Category, error) { return r.CategoryRepository.Create(ctx, category) }, categoryID)
+}
+
+func (r auditedCategories) Update(ctx context.Context, category Category) (Category, error) {
+	return audited(ctx, r.s, "category",
+		getter(func() (Category, error) { return r.CategoryRepository.Get(ctx, category.ID) }),
+		func() (Category, error) { return r.CategoryRepository.Update(ctx, category) }, categoryID)
+}
+
+// Move is audited as an update of the category.
+func (r auditedCategories) Move(ctx context.Context, id int, parentID *int) (Category, error) {
+	return audited(ctx, r.s, "category",
+		getter(func() (Category, error) { return r.CategoryRepository.Get(ctx, id) }),
+		func() (Category, error) { return r.CategoryRepository.Move(ctx, id, parentID) }, categoryID)
+}
+
+func (r auditedCategories) Delete(ctx context.Context, id int, deletedAt time.Time, deletedBy int) (Category, error) {
+	return audited(ctx, r.s, "category",
+		getter(func() (Category, error) { return r.CategoryRepository.Get(ctx, id) }),
+		func() (Category, error) { return r.CategoryRepository.Delete(ctx, id, deletedAt, deletedBy) }, categoryID)
+}
+
+type auditedReviews struct {
+	ReviewRepository
+	s *AuditStore
+}
+
+func reviewID(r Review) int { return r.ID }
+
+func (r auditedReviews) Create(ctx context.Context, review Review) (Review, error) {
+	return audited(ctx, r.s, "review", nil, func() (Review, error) { return r.ReviewRepository.Create(ctx, review) }, reviewID)
+}
+
+func (r auditedReviews) Update(ctx context.Context, review Review) (Review, error) {
+	return audited(ctx, r.s, "review",
+		getter(func() (Review, error) { return r.ReviewRepository.Get(ctx, review.ID) }),
+		func() (Review, error) { return r.ReviewRepository.Update(ctx, review) }, reviewID)
+}
+
+type auditedUsers struct {
+	UserRepository
+	s *AuditStore
+}
+
+func (r auditedUsers) Create(ctx context.Context, user User) (User, error) {
+	return audited(ctx, r.s, "user", nil, func() (User, error) { return r.UserRepository.Create(ctx, user) },
+		func(u User) int { return u.ID })
+}
+
+// Employees are diffed in their EmployeeRecord form, so a promotion shows
+// up as a change of type.
+
+type auditedEmployees struct {
+	EmployeeRepository
+	s *AuditStore
+}
+
+func (r auditedEmployees) Create(ctx context.Context, employee *Employee) (*Employee, error) {
+	return r.write(ctx, nil, func() (*Employee, error) { return r.EmployeeRepository.Create(ctx, employee) })
+}
+
+func (r auditedEmployees) Update(ctx context.Context, employee *Employee) (*Employee, error) {
+	return r.write(ctx, r.before(ctx, employee.ID), func() (*Employee, error) { return r.EmployeeRepository.Update(ctx, employee) })
+}
+
+func (r auditedEmployees) AssignManager(ctx context.Context, id int, managerID *int, version int) (*Employee, error) {
+	return r.write(ctx, r.before(ctx, id), func() (*Employee, error) {
+		return r.EmployeeRepository.AssignManager(ctx, id, managerID, version)
+	})
+}
+
+// ReassignReports is audited as an update of every moved employee.
+func (r auditedEmployees) ReassignReports(ctx context.Context, fromManagerID int, toManagerID *int) ([]*Employee, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	reports, err := r.EmployeeRepository.ListByManagers(ctx, []int{fromManagerID})
+	if err != nil {
+		return nil, err
+	}
+	moved, err := r.EmployeeRepository.ReassignReports(ctx, fromManagerID, toManagerID)
+	if err != nil {
+		return nil, err
+	}
+	before := indexEmployees(reports)
+	for _, emp := range moved {
+		changes, err := employeeChanges(before[emp.ID], emp)
+		if err != nil {
+			log.Printf("Failed to audit updated employee %d: %v", emp.ID, err)
+			continue
+		}
+		r.s.record(ctx, "employee", emp.ID, "updated", changes)
+	}
+	return moved, nil
+}
+
+// before returns the record of employee id as it is before a write.
+func (r auditedEmployees) before(ctx context.Context, id int) func() (*EmployeeRecord, error) {
+	return func() (*EmployeeRecord, error) {
+		emp, err := r.EmployeeRepository.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		record, err := newEmployeeRecord(emp)
+		return &record, err
+	}
+}
+
+// employeeChanges compares the records of an employee before and after an
+// update.
+func employeeChanges(before, after *Employee) ([]AuditChange, error) {
+	old, err := newEmployeeRecord(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := newEmployeeRecord(after)
+	if err != nil {
+		return nil, err
+	}
+	return auditChanges(&old, updated)
+}
+
+func (r auditedEmployees) write(ctx context.Context, before func() (*EmployeeRecord, error), write func() (*Employee, error)) (*Employee, error) {
+	var stored *Employee
+	_, err := audited(ctx, r.s, "employee", before, func() (EmployeeRecord, error) {
+		var err error
+		if stored, err = write(); err != nil {
+			return EmployeeRecord{}, err
+		}
+		return newEmployeeRecord(stored)
+	}, func(e EmployeeRecord) int { return e.ID })
+	return stored, err
+}
+
+// isDeleted makes terminations show up as deletions in the audit log.
+func (r EmployeeRecord) isDeleted() bool { return r.DeletedAt != nil }
+
+// AuditHandlers serves the audit log from a Store.
+type AuditHandlers struct {
+	store Store
+}
+
+// NewAuditHandlers creates audit handlers backed by store.
+func NewAuditHandlers(store Store) *AuditHandlers {
+	return &AuditHandlers{store: store}
+}
+
+func RegisterAuditHandlers(ctx context.Context, graphy *quickgraph.Graphy, store Store) {
+	h := NewAuditHandlers(store)
+	graphy.RegisterQuery(ctx, "AuditLog", h.AuditLog, "filter", "first", "after")
+}
+
+// AuditLogPage is one page of audit entries, newest first.
+type AuditLogPage struct {
+	Entries     []AuditEntry
+	EndCursor   *string // Pass as after to get the next page
+	HasNextPage bool
+}
+
+const (
+	defaultAuditPageSize = 20
+	maxAuditPageSize     = 100
+)
+
+// AuditLog returns the audit entries matching filter, newest first. first
+// limits the page size and after continues from a previous page's
+// EndCursor. Only admins can read the audit log.
+func (h *AuditHandlers) AuditLog(ctx context.Context, filter *AuditFilter, first *int, after *string) (*AuditLogPage, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	limit := defaultAuditPageSize
+	if first != nil {
+		if *first < 0 || *first > maxAuditPageSize {
+			return nil, fmt.Errorf("first must be between 0 and %d", maxAuditPageSize)
+		}
+		limit = *first
+	}
+	before := 0
+	if after != nil {
+		id, err := decodeAuditCursor(*after)
+		if err != nil {
+			return nil, err
+		}
+		before = id
+	}
+	var f AuditFilter
+	if filter != nil {
+		f = *filter
+	}
+
+	// One extra entry tells whether another page follows
+	entries, err := h.store.Audit().List(ctx, f, before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &AuditLogPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries, page.HasNextPage = entries[:limit], true
+	}
+	if len(page.Entries) > 0 {
+		cursor := encodeAuditCursor(page.Entries[len(page.Entries)-1].ID)
+		page.EndCursor = &cursor
+	}
+	return page, nil
+}
+
+func encodeAuditCursor(id int) string {
+	return base64.URLEncoding.EncodeToString([]byte("audit:" + strconv.Itoa(id)))
+}
+
+func decodeAuditCursor(cursor string) (int, error) {
+	raw, err := base64.URLEncoding.DecodeString(cursor)
+	if err == nil {
+		if rest, ok := strings.CutPrefix(string(raw), "audit:"); ok {
+			if id, err := strconv.Atoi(rest); err == nil && id > 0 {
+				return id, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid cursor %q", cursor)
+}
+
+// Orders are audited as orders; the stock that Place and Cancel move is part
+// of the order entry.
+type auditedOrders struct {
+	OrderRepository
+	s *AuditStore
+}
+
+func orderID(o Order) int { return o.ID }
+
+func (r auditedOrders) Create(ctx context.Context, order Order) (Order, error) {
+	return audited(ctx, r.s, "order", nil, func() (Order, error) { return r.OrderRepository.Create(ctx, order) }, orderID)
+}
+
+func (r auditedOrders) Place(ctx context.Context, order Order) (Order, error) {
+	return audited(ctx, r.s, "order", nil, func() (Order, error) { return r.OrderRepository.Place(ctx, order) }, orderID)
+}
+
+func (r auditedOrders) Update(ctx context.Context, order Order) (Order, error) {
+	return audited(ctx, r.s, "order",
+		getter(func() (Order, error) { return r.OrderRepository.Get(ctx, order.ID) }),
+		func() (Order, error) { return r.OrderRepository.Update(ctx, order) }, orderID)
+}
+
+func (r auditedOrders) Cancel(ctx context.Context, order Order) (Order, error) {
+	return audited(ctx, r.s, "order",
+		getter(func() (Order, error) { return r.OrderRepository.Get(ctx, order.ID) }),
+		func() (Order, error) { return r.OrderRepository.Cancel(ctx, order) }, orderID)
+}
+
+// Carts are audited under the ID of their user.
+type auditedCarts struct {
+	CartRepository
+	s *AuditStore
+}
+
+func (r auditedCarts) Put(ctx context.Context, cart Cart) (Cart, error) {
+	return audited(ctx, r.s, "cart",
+		getter(func() (Cart, error) { return r.CartRepository.Get(ctx, cart.UserID) }),
+		func() (Cart, error) { return r.CartRepository.Put(ctx, cart) }, func(c Cart) int { return c.UserID })
+}
+
+type auditedVariants struct {
+	ProductVariantRepository
+	s *AuditStore
+}
+
+func variantID(v ProductVariant) int { return v.ID }
+
+func (r auditedVariants) Create(ctx context.Context, variant ProductVariant) (ProductVariant, error) {
+	return audited(ctx, r.s, "variant", nil, func() (ProductVariant, error) {
+		return r.ProductVariantRepository.Create(ctx, variant)
+	}, variantID)
+}
+
+func (r auditedVariants) Update(ctx context.Context, variant ProductVariant) (ProductVariant, error) {
+	return audited(ctx, r.s, "variant",
+		getter(func() (ProductVariant, error) { return r.ProductVariantRepository.Get(ctx, variant.ID) }),
+		func() (ProductVariant, error) { return r.ProductVariantRepository.Update(ctx, variant) }, variantID)
+}
+
+// Delete records every field of the removed variant as cleared.
+func (r auditedVariants) Delete(ctx context.Context, id int) (ProductVariant, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	variant, err := r.ProductVariantRepository.Delete(ctx, id)
+	if err != nil {
+		return variant, err
+	}
+	changes, err := auditChanges(&variant, (*ProductVariant)(nil))
+	if err != nil {
+		log.Printf("Failed to audit deleted variant %d: %v", variant.ID, err)
+		return variant, nil
+	}
+	r.s.record(ctx, "variant", variant.ID, "deleted", changes)
+	return variant, nil
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// auditRequest is the GraphQL request being executed, as seen by the
+// AuditStore when it records a write.
+type auditRequest struct {
+	query         string
+	operationName string
+	variables     json.RawMessage
+}
+
+const auditRequestKey contextKey = "auditRequest"
+
+// WithAuditRequest returns a context that attributes the writes made while
+// executing query with variables to that request in the audit log.
+// operationName is recorded as sent and may be empty.
+func WithAuditRequest(ctx context.Context, query, operationName string, variables json.RawMessage) context.Context {
+	return context.WithValue(ctx, auditRequestKey, auditRequest{query: query, operationName: operationName, variables: variables})
+}
+
+// AuditMiddleware captures the query, operation name and variables of
+// GraphQL POST requests for the audit log. The body is left intact for next.
+// POST is the only way to run a mutation: GET returns the schema and
+// WebSocket connections only accept subscriptions.
+func AuditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.Body != nil {
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			var request struct {
+				Query         string          `json:"query"`
+				OperationName string          `json:"operationName"`
+				Variables     json.RawMessage `json:"variables"`
+			}
+			if json.Unmarshal(body, &request) == nil {
+				r = r.WithContext(WithAuditRequest(r.Context(), request.Query, request.OperationName, request.Variables))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// secretKeys are matched case-insensitively against variable names at any
+// depth; values under a matching name are never written to the audit log.
+var secretKeys = []string{"password", "secret", "token", "apikey", "api_key", "authorization", "credential"}
+
+const redacted = "[REDACTED]"
+
+// redactVariables returns variables as JSON with the values of secret
+// looking keys replaced. It returns nil if there are no variables.
+func redactVariables(variables json.RawMessage) *string {
+	if len(bytes.TrimSpace(variables)) == 0 || string(bytes.TrimSpace(variables)) == "null" {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(variables, &v); err != nil {
+		// Unparseable variables fail the request anyway; record nothing
+		// rather than something that might contain a secret.
+		return nil
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return nil
+	}
+	return strPtr(string(out))
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, value := range t {
+			if isSecretKey(key) {
+				t[key] = redacted
+			} else {
+				t[key] = redactValue(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range t {
+			t[i] = redactValue(value)
+		}
+	}
+	return v
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
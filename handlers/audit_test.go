@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogRecordsMutations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, base Store) {
+		ctx := context.Background()
+		store := NewAuditStore(base)
+		graph := newTestGraph(ctx, store)
+		RegisterAuditHandlers(ctx, graph, store)
+
+		adminCtx := userContext(t, store, 1)
+		customerCtx := userContext(t, store, 2)
+
+		// run executes request the way AuditMiddleware would
+		run := func(ctx context.Context, request, variables string) string {
+			res, _ := graph.ProcessRequest(WithAuditRequest(ctx, request, "", json.RawMessage(variables)), request, variables)
+			return res
+		}
+
+		rename := `mutation Rename($widget: WidgetInput!) { renamed: UpdateWidget(widget: $widget) { id } }`
+		variables := `{"widget": {"id": 1, "name": "Renamed", "price": 1, "quantity": 10, "version": 1}}`
+		graph.ProcessRequest(WithAuditRequest(customerCtx, rename, "Rename", json.RawMessage(variables)), rename, variables)
+		run(adminCtx, `mutation { DeleteWidget(id: 1) { id } }`, "")
+		run(adminCtx, `mutation { CreateProduct(input: {name: "Tablet", description: "Big", price: 299, categoryId: 1}) { ID } }`, "")
+
+		if res := run(customerCtx, `{ AuditLog { HasNextPage } }`, ""); !strings.Contains(res, "admin role required") {
+			t.Errorf("expected the audit log to require admin, got %s", res)
+		}
+
+		res := run(adminCtx, `{ AuditLog(filter: {entity: "widget", since: "2000-01-01T00:00:00Z"}) {
+			Entries { UserID Operation Query Variables Entity EntityID Action Changes { Field Before After } }
+			HasNextPage
+		} }`, "")
+		var page struct {
+			Data struct {
+				AuditLog struct {
+					Entries     []AuditEntry
+					HasNextPage bool
+				}
+			}
+		}
+		if err := json.Unmarshal([]byte(res), &page); err != nil {
+			t.Fatalf("unexpected response %s: %v", res, err)
+		}
+		entries := page.Data.AuditLog.Entries
+		if len(entries) != 2 || page.Data.AuditLog.HasNextPage {
+			t.Fatalf("expected the two widget entries, got %s", res)
+		}
+
+		deleted, updated := entries[0], entries[1]
+		if deleted.Action != "deleted" || deleted.Operation != "" || deleted.Query != `mutation { DeleteWidget(id: 1) { id } }` || deleted.UserID == nil || *deleted.UserID != 1 {
+			t.Errorf("unexpected delete entry %+v", deleted)
+		}
+		if updated.Action != "updated" || updated.Operation != "Rename" || updated.Query != rename || updated.Variables == nil {
+			t.Errorf("unexpected update entry %+v", updated)
+		}
+		wantChanges := []AuditChange{
+			{Field: "name", Before: strPtr(`"Widget 1"`), After: strPtr(`"Renamed"`)},
+			{Field: "version", Before: strPtr("1"), After: strPtr("2")},
+		}
+		if !reflect.DeepEqual(updated.Changes, wantChanges) {
+			t.Errorf("expected changes %+v, got %+v", wantChanges, updated.Changes)
+		}
+
+		// Paging by user: the customer only renamed the widget
+		res = run(adminCtx, `{ AuditLog(filter: {userId: 2}, first: 1) { Entries { Entity Action } HasNextPage } }`, "")
+		if !strings.Contains(res, `"Action":"updated","Entity":"widget"`) || !strings.Contains(res, `"HasNextPage":false`) {
+			t.Errorf("expected only the widget update for the customer, got %s", res)
+		}
+		res = run(adminCtx, `{ AuditLog(first: 2) { EndCursor HasNextPage } }`, "")
+		cursor := regexp.MustCompile(`"EndCursor":"([^"]+)"`).FindStringSubmatch(res)
+		if cursor == nil || !strings.Contains(res, `"HasNextPage":true`) {
+			t.Fatalf("expected a further page, got %s", res)
+		}
+		res = run(adminCtx, `{ AuditLog(after: "`+cursor[1]+`") { Entries { Operation } HasNextPage } }`, "")
+		if !strings.Contains(res, `[{"Operation":"Rename"}]`) {
+			t.Errorf("expected the oldest entry on the last page, got %s", res)
+		}
+	})
+}
+
+// failingAuditStore is a Store whose audit log rejects every entry.
+type failingAuditStore struct{ Store }
+
+func (s failingAuditStore) Audit() AuditRepository { return failingAudit{s.Store.Audit()} }
+
+type failingAudit struct{ AuditRepository }
+
+func (failingAudit) Append(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	return AuditEntry{}, errors.New("disk full")
+}
+
+func TestAuditFailureDoesNotFailWrite(t *testing.T) {
+	ctx := context.Background()
+	base := NewMemoryStore()
+	store := NewAuditStore(failingAuditStore{base})
+
+	widget, err := store.Widgets().Create(ctx, Widget{Name: "Committed"})
+	if err != nil {
+		t.Fatalf("expected the committed write to succeed, got %v", err)
+	}
+	if _, err := base.Widgets().Get(ctx, widget.ID); err != nil {
+		t.Errorf("expected the widget to be stored, got %v", err)
+	}
+}
+
+func TestRedactVariables(t *testing.T) {
+	got := redactVariables(json.RawMessage(`{"input": {"name": "x", "Password": "hunter2", "keys": [{"apiToken": "abc"}]}}`))
+	want := `{"input":{"Password":"[REDACTED]","keys":[{"apiToken":"[REDACTED]"}],"name":"x"}}`
+	if got == nil || *got != want {
+		t.Errorf("expected %s, got %v", want, got)
+	}
+	if redactVariables(nil) != nil || redactVariables(json.RawMessage("null")) != nil {
+		t.Error("expected missing variables to be recorded as nil")
+	}
+}
+
+func TestAuditMiddlewareCapturesTheRequest(t *testing.T) {
+	query := `# a comment with a { brace
+mutation Del { DeleteWidget(id: 1) { ...F } } fragment F on Widget { id }`
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "operationName": "Del", "variables": map[string]int{"id": 1}})
+
+	var got auditRequest
+	var rest string
+	handler := AuditMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = r.Context().Value(auditRequestKey).(auditRequest)
+		b, _ := io.ReadAll(r.Body)
+		rest = string(b)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/graphql", bytes.NewReader(body)))
+
+	if got.query != query || got.operationName != "Del" || string(got.variables) != `{"id":1}` {
+		t.Errorf("unexpected captured request %+v", got)
+	}
+	if rest != string(body) {
+		t.Errorf("expected the body to be left for the next handler, got %q", rest)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/gburgyan/go-quickgraph"
 	"net/http"
+	"strings"
 )
 
 // Context key type for type safety
@@ -18,7 +19,7 @@ const (
 func RegisterAuthHandlers(ctx context.Context, graphy *quickgraph.Graphy) {
 	// Register query that uses context
 	graphy.RegisterQuery(ctx, "GetCurrentUser", GetCurrentUser)
-	
+
 	// Register the PersonalDetails method on Employee interface
 	// Note: This registration might not be necessary as methods are usually auto-discovered
 	// graphy.RegisterFunction(ctx, (*Employee).PersonalDetails)
@@ -44,79 +45,67 @@ func GetCurrentUser(ctx context.Context) (*User, error) {
 
 // AuthMiddleware is an example HTTP middleware that could be used
 // to inject user into context before GraphQL processing
-func AuthMiddleware(next http.Handler) http.Handler {
+func AuthMiddleware(store Store, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// In a real app, you'd validate JWT token or session here
 		// For demo, we'll simulate authentication based on a header
 		authHeader := r.Header.Get("Authorization")
-		
+
 		if authHeader != "" {
 			// Simulate user lookup
-			var user *User
-			switch authHeader {
-			case "Bearer admin-token":
-				// Use existing user from product.go users slice
-				if len(users) > 0 {
-					user = &users[0]
-				}
-			case "Bearer user-token":
-				// Use existing user from product.go users slice
-				if len(users) > 1 {
-					user = &users[1]
-				}
-			}
-			
-			if user != nil {
+			if user := GetUserFromAuthHeader(r.Context(), store, authHeader); user != nil {
 				// Add user to context
 				ctx := context.WithValue(r.Context(), UserContextKey, user)
 				r = r.WithContext(ctx)
 			}
 		}
-		
+
 		next.ServeHTTP(w, r)
 	})
 }
 
-// GetUserFromAuthHeader extracts user based on authorization header
-// This is useful for non-middleware based servers like Gin
-func GetUserFromAuthHeader(authHeader string) *User {
-	if authHeader == "" {
-		return nil
+// demoTokenUsers maps the demo bearer tokens to the IDs of seeded users.
+// The tokens sign in to the default tenant; "acme:admin-token" signs in to
+// tenant acme instead.
+var demoTokenUsers = map[string]int{
+	"admin-token": 1,
+	"user-token":  2,
+}
+
+// demoToken returns the tenant and user ID of a demo bearer token, or false
+// if authHeader does not carry one.
+func demoToken(authHeader string) (tenant string, userID int, ok bool) {
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok {
+		return "", 0, false
 	}
-	
-	switch authHeader {
-	case "Bearer admin-token":
-		if len(users) > 0 {
-			return &users[0]
-		}
-	case "Bearer user-token":
-		if len(users) > 1 {
-			return &users[1]
-		}
+	tenant = DefaultTenant
+	if prefix, rest, found := strings.Cut(token, ":"); found {
+		tenant, token = prefix, rest
 	}
-	
-	return nil
+	userID, ok = demoTokenUsers[token]
+	return tenant, userID, ok && ValidTenantID(tenant)
 }
 
-// Example of a mutation that uses context for authorization
-func CreateProductWithAuth(ctx context.Context, input ProductInput) (*Product, error) {
-	// Check if user is authenticated and has admin role
-	userValue := ctx.Value(UserContextKey)
-	if userValue == nil {
-		return nil, errors.New("authentication required")
+// GetUserFromAuthHeader extracts user based on authorization header
+// This is useful for non-middleware based servers like Gin
+// A token only signs in to its own tenant, which must be the one of ctx.
+func GetUserFromAuthHeader(ctx context.Context, store Store, authHeader string) *User {
+	if authHeader == "" {
+		return nil
 	}
 
-	user, ok := userValue.(*User)
-	if !ok {
-		return nil, errors.New("invalid user in context")
+	tenant, id, ok := demoToken(authHeader)
+	if !ok || tenant != TenantFromContext(ctx) {
+		return nil
 	}
 
-	if user.Role != UserRoleAdmin {
-		return nil, errors.New("admin role required")
+	user, err := store.Users().Get(ctx, id)
+	if err != nil {
+		return nil
 	}
-
-	// Proceed with normal creation
-	return CreateProduct(input)
+	user.scope = newScope(ctx, store)
+	return &user
 }
 
 // PersonalDetails method demonstrates field-level authorization
@@ -138,7 +127,7 @@ func (e *Employee) PersonalDetails(ctx context.Context) (*PersonalInfo, error) {
 		return &PersonalInfo{
 			Salary:      e.Salary,
 			Email:       e.Email,
-			PhoneNumber: "+1-555-0123", // Mock data
+			PhoneNumber: "+1-555-0123",               // Mock data
 			Address:     "123 Main St, Anytown, USA", // Mock data
 		}, nil
 	}
@@ -152,4 +141,33 @@ type PersonalInfo struct {
 	Email       string  `json:"email"`
 	PhoneNumber string  `json:"phoneNumber"`
 	Address     string  `json:"address"`
-}
\ No newline at end of file
+}
+
+// requireAdmin returns the authenticated user from ctx, or an error unless
+// that user has the admin role.
+func requireAdmin(ctx context.Context) (*User, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role != UserRoleAdmin {
+		return nil, errors.New("admin role required")
+	}
+	return user, nil
+}
+
+// requireUser returns the authenticated user from ctx, or an error if the
+// request is anonymous.
+func requireUser(ctx context.Context) (*User, error) {
+	userValue := ctx.Value(UserContextKey)
+	if userValue == nil {
+		return nil, errors.New("authentication required")
+	}
+
+	user, ok := userValue.(*User)
+	if !ok || user == nil {
+		return nil, errors.New("invalid user in context")
+	}
+	return user, nil
+}
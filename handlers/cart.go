@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Every authenticated user has a cart of products, product variants and
+// widgets. The cart only
+// stores what was added and how many; unit prices, line totals and the
+// subtotal are computed by the server from the current prices whenever the
+// cart is read. Items are checked when they are added or changed and again
+// at checkout: products must be ACTIVE, and every item must have the
+// quantity in stock. CheckoutCart turns the cart into an order and empties
+// it.
+
+type Cart struct {
+	UserID    int
+	Items     []CartItem
+	UpdatedAt *time.Time // Unset until the first item is added
+	Version   int        // Incremented on every change
+
+	// Scope used by the field resolvers below
+	scope *scope `json:"-" graphy:"-"`
+}
+
+type CartItem struct {
+	ProductID *int // Set for product items
+	VariantID *int // Set for product items of a product with variants
+	WidgetID  *int // Set for widget items
+	Quantity  int
+
+	// Scope used by the field resolvers below
+	scope *scope `json:"-" graphy:"-"`
+}
+
+// cartOf returns the cart of the user with userID, which is empty if the
+// user never had one.
+func (h *ProductHandlers) cartOf(ctx context.Context, userID int) (Cart, error) {
+	cart, err := h.store.Carts().Get(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return Cart{UserID: userID, Items: []CartItem{}}, nil
+	}
+	return cart, err
+}
+
+// Cart returns the cart of the authenticated user.
+func (h *ProductHandlers) Cart(ctx context.Context) (*Cart, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cart, err := h.cartOf(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	cart.bind(newScope(ctx, h.store))
+	return &cart, nil
+}
+
+// AddToCart adds quantity of a product, a product variant or a widget to the cart of the
+// authenticated user, on top of what the cart already holds of it.
+func (h *ProductHandlers) AddToCart(ctx context.Context, productId *int, variantId *int, widgetId *int, quantity int) (*Cart, error) {
+	return h.changeCart(ctx, productId, variantId, widgetId, func(key itemKey, current int) (int, error) {
+		if quantity < 1 {
+			return 0, fmt.Errorf("quantity of %s must be at least 1", key)
+		}
+		return current + quantity, nil
+	})
+}
+
+// UpdateCartItem sets the quantity of a product, a product variant or a
+// widget in the cart of the authenticated user.
+func (h *ProductHandlers) UpdateCartItem(ctx context.Context, productId *int, variantId *int, widgetId *int, quantity int) (*Cart, error) {
+	return h.changeCart(ctx, productId, variantId, widgetId, func(key itemKey, current int) (int, error) {
+		if current == 0 {
+			return 0, fmt.Errorf("%s is not in the cart", key)
+		}
+		if quantity < 1 {
+			return 0, fmt.Errorf("quantity of %s must be at least 1; use RemoveFromCart to remove it", key)
+		}
+		return quantity, nil
+	})
+}
+
+// RemoveFromCart removes a product, a product variant or a widget from the
+// cart of the authenticated user.
+func (h *ProductHandlers) RemoveFromCart(ctx context.Context, productId *int, variantId *int, widgetId *int) (*Cart, error) {
+	return h.changeCart(ctx, productId, variantId, widgetId, func(key itemKey, current int) (int, error) {
+		if current == 0 {
+			return 0, fmt.Errorf("%s is not in the cart", key)
+		}
+		return 0, nil
+	})
+}
+
+// changeCart sets the quantity of the item with productID and variantID, or
+// widgetID, in the cart of the authenticated user to what change returns for the current
+// quantity, which is 0 if the item is not in the cart. A quantity of 0
+// removes the item; any other quantity must be orderable. If another
+// request changed the cart meanwhile, it fails with a version conflict.
+func (h *ProductHandlers) changeCart(ctx context.Context, productID, variantID, widgetID *int, change func(key itemKey, current int) (int, error)) (*Cart, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newItemKey(productID, variantID, widgetID)
+	if err != nil {
+		return nil, err
+	}
+	cart, err := h.cartOf(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	index, current := -1, 0
+	for i, item := range cart.Items {
+		if k, _ := newItemKey(item.ProductID, item.VariantID, item.WidgetID); k == key {
+			index, current = i, item.Quantity
+		}
+	}
+	quantity, err := change(key, current)
+	if err != nil {
+		return nil, err
+	}
+	if quantity > 0 {
+		if _, err := h.orderable(ctx, key, quantity); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case quantity == 0:
+		cart.Items = append(cart.Items[:index], cart.Items[index+1:]...)
+	case index >= 0:
+		cart.Items[index].Quantity = quantity
+	default:
+		cart.Items = append(cart.Items, CartItem{ProductID: productID, VariantID: variantID, WidgetID: widgetID, Quantity: quantity})
+	}
+
+	now := time.Now().UTC()
+	cart.UpdatedAt = &now
+	if cart, err = h.store.Carts().Put(ctx, cart); err != nil {
+		return nil, err
+	}
+	cart.bind(newScope(ctx, h.store))
+	return &cart, nil
+}
+
+// CheckoutCart places an order for the items in the cart of the
+// authenticated user, at their current prices, and empties the cart.
+func (h *ProductHandlers) CheckoutCart(ctx context.Context) (*Order, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cart, err := h.cartOf(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(cart.Items) == 0 {
+		return nil, errors.New("the cart is empty")
+	}
+
+	order := Order{UserID: user.ID, Status: OrderStatusPlaced, PlacedAt: time.Now().UTC()}
+	for _, item := range cart.Items {
+		order.Items = append(order.Items, OrderItem{ProductID: item.ProductID, VariantID: item.VariantID, WidgetID: item.WidgetID, Quantity: item.Quantity})
+	}
+	placed, err := h.placeOrder(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	cart.Items, cart.UpdatedAt = []CartItem{}, &order.PlacedAt
+	if _, err := h.store.Carts().Put(ctx, cart); err != nil {
+		return nil, fmt.Errorf("order %d was placed: %w", placed.ID, err)
+	}
+	return placed, nil
+}
+
+// bind attaches sc to the cart and its items so their field resolvers work.
+func (c *Cart) bind(sc *scope) {
+	c.scope = sc
+	for i := range c.Items {
+		c.Items[i].scope = sc
+	}
+}
+
+// Field resolvers
+
+// Currency returns the currency of the prices in the cart.
+func (c *Cart) Currency() string {
+	return storeCurrency
+}
+
+// Subtotal returns the sum of the line totals.
+func (c *Cart) Subtotal() (Money, error) {
+	subtotal := Money{Currency: storeCurrency}
+	for i := range c.Items {
+		total, err := c.Items[i].LineTotal()
+		if err != nil {
+			return Money{}, err
+		}
+		if subtotal, err = subtotal.Add(total); err != nil {
+			return Money{}, err
+		}
+	}
+	return subtotal, nil
+}
+
+// Product returns the product of the item. It is null for widget items.
+func (i *CartItem) Product() (*Product, error) {
+	return itemProduct(i.scope, i.ProductID)
+}
+
+// Variant returns the product variant of the item. It is null for products
+// without variants, widgets, and variants that were removed since.
+func (i *CartItem) Variant() (*ProductVariant, error) {
+	return itemVariant(i.scope, i.VariantID)
+}
+
+// Widget returns the widget of the item. It is null for product items.
+func (i *CartItem) Widget() (*Widget, error) {
+	return itemWidget(i.scope, i.WidgetID)
+}
+
+// UnitPrice returns the current price of the product, its variant, or the
+// widget. It fails for a variant that was removed since, rather than falling
+// back to the product price.
+func (i *CartItem) UnitPrice() (Money, error) {
+	if i.WidgetID != nil {
+		w, err := itemWidget(i.scope, i.WidgetID)
+		if err != nil {
+			return Money{}, err
+		}
+		return w.Price, nil
+	}
+	p, err := itemProduct(i.scope, i.ProductID)
+	if err != nil {
+		return Money{}, err
+	}
+	v, err := itemVariant(i.scope, i.VariantID)
+	if err != nil {
+		return Money{}, err
+	}
+	if v != nil {
+		return v.unitPrice(*p), nil
+	}
+	if i.VariantID != nil {
+		return Money{}, fmt.Errorf("variant %d of product %d is no longer available", *i.VariantID, *i.ProductID)
+	}
+	return p.Price, nil
+}
+
+// LineTotal returns the unit price times the quantity.
+func (i *CartItem) LineTotal() (Money, error) {
+	price, err := i.UnitPrice()
+	if err != nil {
+		return Money{}, err
+	}
+	return price.Multiply(float64(i.Quantity)), nil
+}
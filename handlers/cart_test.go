@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCart(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		customer, other := userContext(t, store, 2), userContext(t, store, 3)
+		run := func(ctx context.Context, request string) string {
+			res, _ := graph.ProcessRequest(ctx, request, "")
+			return res
+		}
+
+		for _, tc := range []struct {
+			ctx           context.Context
+			request, want string
+		}{
+			{customer, `{ Cart { Currency Items { Quantity } Subtotal } }`,
+				`"Currency":"USD","Items":[],"Subtotal":"0.00 USD"`},
+			{ctx, `{ Cart { Subtotal } }`, "authentication required"},
+
+			// Adding the same product twice adds up
+			{customer, `mutation { AddToCart(productId: 2, quantity: 2) { Subtotal } }`, `"Subtotal":"79.98 USD"`},
+			{customer, `mutation { AddToCart(productId: 2, quantity: 1) { Subtotal } }`, `"Subtotal":"119.97 USD"`},
+			{customer, `mutation { AddToCart(widgetId: 1, quantity: 4) { Items { LineTotal Quantity UnitPrice } Subtotal } }`,
+				`"Items":[{"LineTotal":"119.97 USD","Quantity":3,"UnitPrice":"39.99 USD"},` +
+					`{"LineTotal":"4.00 USD","Quantity":4,"UnitPrice":"1.00 USD"}],"Subtotal":"123.97 USD"`},
+
+			// Items that cannot be ordered are rejected
+			{customer, `mutation { AddToCart(productId: 3, quantity: 1) { Subtotal } }`,
+				"product 3 is OUT_OF_STOCK and cannot be ordered"},
+			{customer, `mutation { AddToCart(productId: 4, quantity: 11) { Subtotal } }`,
+				"product 4 has 10 in stock, cannot order 11"},
+			{customer, `mutation { UpdateCartItem(widgetId: 1, quantity: 11) { Subtotal } }`,
+				"widget 1 has 10 in stock, cannot remove 11"},
+			{customer, `mutation { AddToCart(productId: 1, widgetId: 1, quantity: 1) { Subtotal } }`,
+				"exactly one of productId and widgetId is required"},
+			{customer, `mutation { UpdateCartItem(productId: 1, quantity: 1) { Subtotal } }`,
+				"product 1 is not in the cart"},
+			{customer, `mutation { UpdateCartItem(productId: 2, quantity: 0) { Subtotal } }`,
+				"quantity of product 2 must be at least 1; use RemoveFromCart to remove it"},
+
+			// Changing and removing items
+			{customer, `mutation { UpdateCartItem(widgetId: 1, quantity: 8) { Subtotal } }`, `"Subtotal":"127.97 USD"`},
+			{customer, `mutation { AddToCart(productId: 1, quantity: 1) { Subtotal } }`, `"Subtotal":"1127.96 USD"`},
+			{customer, `mutation { RemoveFromCart(productId: 1) { Items { ProductID WidgetID } } }`,
+				`"Items":[{"ProductID":2,"WidgetID":null},{"ProductID":null,"WidgetID":1}]`},
+			{other, `{ Cart { Items { Quantity } } }`, `"Items":[]`},
+
+			// Checking out places an order at the current prices and empties the cart
+			{customer, `mutation { CheckoutCart { ID Items { Quantity UnitPrice Widget { name } } Status Total } }`,
+				`"Items":[{"Quantity":3,"UnitPrice":"39.99 USD","Widget":null},{"Quantity":8,"UnitPrice":"1.00 USD","Widget":{"name":"Widget 1"}}],` +
+					`"Status":"PLACED","Total":"127.97 USD"`},
+			{customer, `{ Cart { Items { Quantity } Subtotal } }`, `"Items":[],"Subtotal":"0.00 USD"`},
+			{customer, `mutation { CheckoutCart { ID } }`, "the cart is empty"},
+			{customer, `{ GetWidget(id: 1) { quantity stockHistory { delta quantityAfter reason userId } } }`,
+				`"quantity":2,"stockHistory":[{"delta":-8,"quantityAfter":2,"reason":"order #1","userId":2}]`},
+
+			// Cancelling the order puts the widgets back
+			{customer, `mutation { CancelOrder(id: 1) { Status } }`, `"Status":"CANCELLED"`},
+			{customer, `{ GetWidget(id: 1) { quantity stockHistory { delta reason } } }`,
+				`"quantity":10,"stockHistory":[{"delta":-8,"reason":"order #1"},{"delta":8,"reason":"order #1 cancelled"}]`},
+		} {
+			if res := run(tc.ctx, tc.request); !strings.Contains(res, tc.want) {
+				t.Errorf("%s: expected %s, got %s", tc.request, tc.want, res)
+			}
+		}
+	})
+}
+
+func TestConcurrentCartChangesKeepEveryItem(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		if _, err := store.Carts().Put(ctx, Cart{UserID: 2, Items: []CartItem{}}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		_, err := store.Carts().Put(ctx, Cart{UserID: 2, Items: []CartItem{}})
+		var conflict *VersionConflictError
+		if !errors.As(err, &conflict) || conflict.Entity != "cart" || conflict.CurrentVersion != 1 {
+			t.Errorf("expected a cart version conflict at version 1, got %v", err)
+		}
+
+		// Every change either lands in the cart or fails with a conflict
+		h, customer := NewProductHandlers(store), userContext(t, store, 2)
+		var (
+			wg    sync.WaitGroup
+			added atomic.Int32
+		)
+		for _, id := range []int{1, 2, 4} {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				var conflict *VersionConflictError
+				if _, err := h.AddToCart(customer, &id, nil, nil, 1); err == nil {
+					added.Add(1)
+				} else if !errors.As(err, &conflict) {
+					t.Errorf("AddToCart(%d) failed: %v", id, err)
+				}
+			}(id)
+		}
+		wg.Wait()
+		if cart, err := h.Cart(customer); err != nil || len(cart.Items) != int(added.Load()) {
+			t.Errorf("expected %d items in the cart, got %+v, %v", added.Load(), cart, err)
+		}
+	})
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Categories form a tree: a category has at most one parent, set with
+// CreateCategory or MoveCategory. The mutations keep the tree free of
+// cycles, and a category can only be deleted once it has neither
+// subcategories nor products. The stores check both in the same step as the
+// change, so concurrent mutations cannot get around them.
+
+type CategoryInput struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	ParentID    *int    `json:"parentId"` // Unset for a top-level category
+}
+
+// CategoryUpdateInput changes the fields that are set.
+type CategoryUpdateInput struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// CategoryProductsArgs are the arguments of Category.Products. quickgraph
+// requires every argument of a field resolver, so both must be given.
+type CategoryProductsArgs struct {
+	Page               PageInput `json:"page"`
+	IncludeDescendants bool      `json:"includeDescendants"` // Also list the products of all subcategories
+}
+
+// CategoryNotFoundError is returned by the stores when a category to
+// change, or to put a category or product in, does not exist or is deleted.
+type CategoryNotFoundError struct {
+	ID int
+}
+
+func (e *CategoryNotFoundError) Error() string {
+	return fmt.Sprintf("category with id %d not found", e.ID)
+}
+
+// CategoryCycleError is returned by CategoryRepository.Move when the new
+// parent is the category itself or one of its subcategories.
+type CategoryCycleError struct {
+	ID       int
+	ParentID int
+}
+
+func (e *CategoryCycleError) Error() string {
+	if e.ID == e.ParentID {
+		return fmt.Sprintf("cannot move category %d below itself", e.ID)
+	}
+	return fmt.Sprintf("cannot move category %d below its own subcategory %d", e.ID, e.ParentID)
+}
+
+// CategoryNotEmptyError is returned by CategoryRepository.Delete when the
+// category still has subcategories or products that are not deleted.
+type CategoryNotEmptyError struct {
+	ID            int
+	Subcategories int
+	Products      int
+}
+
+func (e *CategoryNotEmptyError) Error() string {
+	if e.Subcategories > 0 {
+		return fmt.Sprintf("category %d still has %d subcategories", e.ID, e.Subcategories)
+	}
+	return fmt.Sprintf("category %d still has %d products", e.ID, e.Products)
+}
+
+// checkCategoryMove is the check CategoryRepository.Move makes against all
+// categories before moving category id below parentID.
+func checkCategoryMove(categories []Category, id int, parentID *int) error {
+	tree := newCategoryTree(categories)
+	if c, ok := tree.byID[id]; !ok || c.isDeleted() {
+		return &CategoryNotFoundError{ID: id}
+	}
+	if parentID == nil {
+		return nil
+	}
+	if parent, ok := tree.byID[*parentID]; !ok || parent.isDeleted() {
+		return &CategoryNotFoundError{ID: *parentID}
+	}
+	for _, descendant := range tree.descendants(id) {
+		if descendant == *parentID {
+			return &CategoryCycleError{ID: id, ParentID: *parentID}
+		}
+	}
+	return nil
+}
+
+// checkCategoryDelete is the check CategoryRepository.Delete makes against
+// all categories and the number of products of category id that are not
+// deleted.
+func checkCategoryDelete(categories []Category, id, products int) error {
+	tree := newCategoryTree(categories)
+	if c, ok := tree.byID[id]; !ok || c.isDeleted() {
+		return &CategoryNotFoundError{ID: id}
+	}
+	children := withoutDeleted(tree.children[id], false)
+	if len(children) > 0 || products > 0 {
+		return &CategoryNotEmptyError{ID: id, Subcategories: len(children), Products: products}
+	}
+	return nil
+}
+
+// categoryTree indexes categories by ID and by parent.
+type categoryTree struct {
+	byID     map[int]Category
+	children map[int][]Category // By parent ID, in ID order
+}
+
+func newCategoryTree(categories []Category) *categoryTree {
+	t := &categoryTree{byID: make(map[int]Category), children: make(map[int][]Category)}
+	for _, c := range categories {
+		t.byID[c.ID] = c
+		if c.ParentID != nil {
+			t.children[*c.ParentID] = append(t.children[*c.ParentID], c)
+		}
+	}
+	return t
+}
+
+// ancestors returns the ancestors of category id from the top-level
+// category down to its parent. It fails if the parents loop.
+func (t *categoryTree) ancestors(id int) ([]Category, error) {
+	var result []Category
+	seen := map[int]bool{id: true}
+	for c := t.byID[id]; c.ParentID != nil; {
+		parent, ok := t.byID[*c.ParentID]
+		if !ok {
+			break
+		}
+		if seen[parent.ID] {
+			return nil, fmt.Errorf("category %d is part of a cycle", id)
+		}
+		seen[parent.ID] = true
+		result = append([]Category{parent}, result...)
+		c = parent
+	}
+	return result, nil
+}
+
+// descendants returns the IDs of category id and of all categories below
+// it.
+func (t *categoryTree) descendants(id int) []int {
+	result := []int{id}
+	seen := map[int]bool{id: true}
+	for i := 0; i < len(result); i++ {
+		for _, child := range t.children[result[i]] {
+			if !seen[child.ID] {
+				seen[child.ID] = true
+				result = append(result, child.ID)
+			}
+		}
+	}
+	return result
+}
+
+// liveCategory returns the category with id unless it does not exist or is
+// deleted.
+func (h *ProductHandlers) liveCategory(ctx context.Context, id int) (Category, error) {
+	c, err := h.store.Categories().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && c.isDeleted()) {
+		return Category{}, &CategoryNotFoundError{ID: id}
+	}
+	return c, err
+}
+
+// GetCategories returns all categories. Admins can pass includeDeleted to
+// also get the deleted ones.
+func (h *ProductHandlers) GetCategories(ctx context.Context, includeDeleted *bool) ([]Category, error) {
+	withDeleted, err := showDeleted(ctx, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := h.store.Categories().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bindCategories(newScope(ctx, h.store), withoutDeleted(categories, withDeleted)), nil
+}
+
+// CreateCategory creates a category, below input.ParentID if it is set.
+// Requires the admin role.
+func (h *ProductHandlers) CreateCategory(ctx context.Context, input CategoryInput) (*Category, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if input.Name == "" {
+		return nil, errors.New("category name is required")
+	}
+	if input.ParentID != nil {
+		if _, err := h.liveCategory(ctx, *input.ParentID); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := h.store.Categories().Create(ctx, Category{Name: input.Name, Description: input.Description, ParentID: input.ParentID})
+	if err != nil {
+		return nil, err
+	}
+	c.scope = newScope(ctx, h.store)
+	return &c, nil
+}
+
+// UpdateCategory renames or redescribes a category. Requires the admin role.
+func (h *ProductHandlers) UpdateCategory(ctx context.Context, id int, input CategoryUpdateInput) (*Category, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	c, err := h.liveCategory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if input.Name != nil {
+		if *input.Name == "" {
+			return nil, errors.New("category name is required")
+		}
+		c.Name = *input.Name
+	}
+	if input.Description != nil {
+		c.Description = input.Description
+	}
+
+	c, err = h.store.Categories().Update(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	c.scope = newScope(ctx, h.store)
+	return &c, nil
+}
+
+// MoveCategory moves a category with all its subcategories below parentId,
+// or to the top level if parentId is not given. A category cannot be moved
+// below itself or one of its subcategories. Requires the admin role.
+func (h *ProductHandlers) MoveCategory(ctx context.Context, id int, parentId *int) (*Category, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	c, err := h.store.Categories().Move(ctx, id, parentId)
+	if err != nil {
+		return nil, err
+	}
+	c.scope = newScope(ctx, h.store)
+	return &c, nil
+}
+
+// DeleteCategory soft-deletes a category that has neither subcategories nor
+// products. Requires the admin role.
+func (h *ProductHandlers) DeleteCategory(ctx context.Context, id int) (*Category, error) {
+	user, err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c, err := h.store.Categories().Delete(ctx, id, time.Now().UTC(), user.ID)
+	if err != nil {
+		return nil, err
+	}
+	c.scope = newScope(ctx, h.store)
+	return &c, nil
+}
+
+// RestoreCategory undoes DeleteCategory. The parent must not be deleted.
+// Requires the admin role.
+func (h *ProductHandlers) RestoreCategory(ctx context.Context, id int) (*Category, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	c, err := h.store.Categories().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("category with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.isDeleted() {
+		return nil, fmt.Errorf("category %d is not deleted", id)
+	}
+	if c.ParentID != nil {
+		if _, err := h.liveCategory(ctx, *c.ParentID); err != nil {
+			return nil, fmt.Errorf("cannot restore category %d: %w", id, err)
+		}
+	}
+
+	c.DeletedAt, c.DeletedBy = nil, nil
+	c, err = h.store.Categories().Update(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	c.scope = newScope(ctx, h.store)
+	return &c, nil
+}
+
+// Field resolvers
+
+// Parent returns the category this one is directly below, if any.
+func (c *Category) Parent() (*Category, error) {
+	if c.ParentID == nil {
+		return nil, nil
+	}
+	parent, ok, err := c.scope.loaders.categories.load(*c.ParentID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("category with id %d not found", *c.ParentID)
+	}
+	return &parent, nil
+}
+
+// Children returns the categories directly below this one.
+func (c *Category) Children() ([]Category, error) {
+	tree, _, err := c.scope.loaders.categoryTree.load(struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return withoutDeleted(tree.children[c.ID], false), nil
+}
+
+// Ancestors returns the categories above this one, starting at the top
+// level.
+func (c *Category) Ancestors() ([]Category, error) {
+	tree, _, err := c.scope.loaders.categoryTree.load(struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return tree.ancestors(c.ID)
+}
+
+// Products returns a page of the products in this category and, with
+// includeDescendants, in all categories below it.
+func (c *Category) Products(args CategoryProductsArgs) (*ProductConnection, error) {
+	var products []Product
+	var err error
+	if args.IncludeDescendants {
+		var tree *categoryTree
+		if tree, _, err = c.scope.loaders.categoryTree.load(struct{}{}); err != nil {
+			return nil, err
+		}
+		if products, err = c.scope.store.Products().List(c.scope.ctx); err != nil {
+			return nil, err
+		}
+		inTree := idSet(tree.descendants(c.ID))
+		filtered := products[:0]
+		for _, p := range products {
+			if inTree[p.CategoryID] {
+				filtered = append(filtered, p)
+			}
+		}
+		products = filtered
+	} else if products, err = c.scope.store.Products().ListByCategory(c.scope.ctx, c.ID); err != nil {
+		return nil, err
+	}
+	return newProductConnection(bindProducts(c.scope, withoutDeleted(products, false)), args.Page, nil)
+}
+
+// bindCategories attaches sc to each category so its field resolvers work.
+func bindCategories(sc *scope, categories []Category) []Category {
+	for i := range categories {
+		categories[i].scope = sc
+	}
+	return categories
+}
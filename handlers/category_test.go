@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCategoryHierarchy(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		adminCtx := userContext(t, store, 1)
+		customerCtx := userContext(t, store, 2)
+		run := func(ctx context.Context, request string) string {
+			res, _ := graph.ProcessRequest(ctx, request, "")
+			return res
+		}
+
+		if res := run(customerCtx, `mutation { CreateCategory(input: {name: "Phones"}) { ID } }`); !strings.Contains(res, "admin role required") {
+			t.Errorf("expected customers to be rejected, got %s", res)
+		}
+
+		// Electronics (1) > Phones (4) > Accessories (5)
+		res := run(adminCtx, `mutation {
+			phones: CreateCategory(input: {name: "Phones", parentId: 1}) { ID }
+			accessories: CreateCategory(input: {name: "Accessories", parentId: 4}) { ID Ancestors { Name } }
+		}`)
+		if !strings.Contains(res, `"Ancestors":[{"Name":"Electronics"},{"Name":"Phones"}]`) {
+			t.Fatalf("expected the ancestors from the top down, got %s", res)
+		}
+		// The smartphone (4) moves to Phones, a case to Accessories
+		smartphone, err := store.Products().Get(ctx, 4)
+		if err != nil {
+			t.Fatalf("Get product failed: %v", err)
+		}
+		smartphone.CategoryID = 4
+		if smartphone, err = store.Products().Update(ctx, smartphone); err != nil {
+			t.Fatalf("Update product failed: %v", err)
+		}
+		if _, err := store.Products().Create(ctx, Product{Name: "Case", Price: NewMoney(9.99, storeCurrency), Status: ProductStatusActive, CategoryID: 5}); err != nil {
+			t.Fatalf("Create product failed: %v", err)
+		}
+
+		for _, tc := range []struct{ request, want string }{
+			{`mutation { MoveCategory(id: 1, parentId: 5) { ID } }`, "cannot move category 1 below its own subcategory 5"},
+			{`mutation { MoveCategory(id: 4, parentId: 4) { ID } }`, "cannot move category 4 below itself"},
+			{`mutation { DeleteCategory(id: 4) { ID } }`, "category 4 still has 1 subcategories"},
+			{`mutation { DeleteCategory(id: 5) { ID } }`, "category 5 still has 1 products"},
+		} {
+			if res := run(adminCtx, tc.request); !strings.Contains(res, tc.want) {
+				t.Errorf("%s: expected %q, got %s", tc.request, tc.want, res)
+			}
+		}
+
+		res = run(ctx, `{ GetCategories { Name Parent { Name } Children { Name } Products(page: {}, includeDescendants: false) { totalCount } } }`)
+		res += run(ctx, `{ GetCategories { Name Products(page: {}, includeDescendants: true) { totalCount } } }`)
+		for _, want := range []string{
+			`"Children":[{"Name":"Phones"}],"Name":"Electronics","Parent":null,"Products":{"totalCount":1}`,
+			`"Children":[{"Name":"Accessories"}],"Name":"Phones","Parent":{"Name":"Electronics"}`,
+			`"Name":"Electronics","Products":{"totalCount":3}`,
+			`"Name":"Phones","Products":{"totalCount":2}`,
+		} {
+			if !strings.Contains(res, want) {
+				t.Errorf("expected %s in %s", want, res)
+			}
+		}
+
+		h := NewProductHandlers(store)
+		conn, err := h.GetProducts(ctx, &ProductFilter{CategoryID: intPtr(4), IncludeSubcategories: boolPtr(true)}, nil, nil, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("GetProducts failed: %v", err)
+		}
+		if got := productIDs(conn); !reflect.DeepEqual(got, []int{4, 5}) {
+			t.Errorf("expected the products of Phones and Accessories, got %v", got)
+		}
+
+		// Moving Accessories to the top level takes its products along
+		res = run(adminCtx, `mutation { MoveCategory(id: 5) { Parent { ID } } }`)
+		if !strings.Contains(res, `"Parent":null`) {
+			t.Errorf("expected Accessories to be a top-level category, got %s", res)
+		}
+		smartphone.CategoryID = 1
+		if _, err := store.Products().Update(ctx, smartphone); err != nil {
+			t.Fatalf("Update product failed: %v", err)
+		}
+		res = run(adminCtx, `mutation { DeleteCategory(id: 4) { DeletedBy } }`)
+		if !strings.Contains(res, `"DeletedBy":1`) {
+			t.Errorf("expected Phones to be deleted, got %s", res)
+		}
+		if res := run(ctx, `{ GetCategories { Name } }`); strings.Contains(res, "Phones") {
+			t.Errorf("expected deleted categories to be hidden, got %s", res)
+		}
+	})
+}
+
+func TestUpdatesRejectSubcategories(t *testing.T) {
+	if _, err := NewSubscriptionHandlers(NewMemoryStore()).ProductUpdates(context.Background(), nil, &ProductFilter{
+		Not: &ProductFilter{CategoryID: intPtr(1), IncludeSubcategories: boolPtr(true)},
+	}); err == nil || !strings.Contains(err.Error(), "includeSubcategories is not supported") {
+		t.Errorf("expected includeSubcategories to be rejected, got %v", err)
+	}
+}
+
+func TestConcurrentCategoryChangesKeepTheTree(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		h, admin := NewProductHandlers(store), userContext(t, store, 1)
+
+		category, err := store.Categories().Get(ctx, 2)
+		if err != nil {
+			t.Fatalf("Get category failed: %v", err)
+		}
+		if _, err := store.Categories().Update(ctx, category); err != nil {
+			t.Fatalf("Update category failed: %v", err)
+		}
+		_, err = store.Categories().Update(ctx, category)
+		var conflict *VersionConflictError
+		if !errors.As(err, &conflict) || conflict.Entity != "category" || conflict.CurrentVersion != 2 {
+			t.Errorf("expected a category version conflict at version 2, got %v", err)
+		}
+
+		// Moving two categories below each other at once leaves at most one
+		// of them moved
+		var wg sync.WaitGroup
+		var moved atomic.Int32
+		for _, move := range [][2]int{{2, 3}, {3, 2}} {
+			wg.Add(1)
+			go func(id, parentID int) {
+				defer wg.Done()
+				var cycle *CategoryCycleError
+				if _, err := h.MoveCategory(admin, id, &parentID); err == nil {
+					moved.Add(1)
+				} else if !errors.As(err, &cycle) {
+					t.Errorf("MoveCategory(%d, %d) failed: %v", id, parentID, err)
+				}
+			}(move[0], move[1])
+		}
+		wg.Wait()
+		categories, err := store.Categories().List(ctx)
+		if err != nil {
+			t.Fatalf("List categories failed: %v", err)
+		}
+		if _, err := newCategoryTree(categories).ancestors(2); moved.Load() != 1 || err != nil {
+			t.Errorf("expected exactly one move, got %d moves, %v", moved.Load(), err)
+		}
+
+		// A product created while its category is deleted either stops the
+		// delete or is rejected
+		empty, err := h.CreateCategory(admin, CategoryInput{Name: "Empty"})
+		if err != nil {
+			t.Fatalf("CreateCategory failed: %v", err)
+		}
+		var notFound *CategoryNotFoundError
+		var notEmpty *CategoryNotEmptyError
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := h.CreateProduct(admin, ProductInput{Name: "Mug", Price: NewMoney(12, storeCurrency), CategoryID: empty.ID}); err != nil && !errors.As(err, &notFound) {
+				t.Errorf("CreateProduct failed: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := h.DeleteCategory(admin, empty.ID); err != nil && !errors.As(err, &notEmpty) {
+				t.Errorf("DeleteCategory failed: %v", err)
+			}
+		}()
+		wg.Wait()
+		deleted, err := store.Categories().Get(ctx, empty.ID)
+		if err != nil {
+			t.Fatalf("Get category failed: %v", err)
+		}
+		products, err := store.Products().ListByCategory(ctx, empty.ID)
+		if err != nil {
+			t.Fatalf("ListByCategory failed: %v", err)
+		}
+		if deleted.isDeleted() == (len(products) > 0) {
+			t.Errorf("expected either the category or its product, got %+v and %+v", deleted, products)
+		}
+	})
+}
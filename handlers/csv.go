@@ -0,0 +1,546 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Widgets and products can be imported from and exported to CSV, so that
+// catalogs maintained in spreadsheets can be loaded in bulk. The first CSV
+// line is a header naming the columns, in any order; the export writes the
+// same columns, so an exported file can be edited and imported again.
+
+// ImportMode selects what happens when some rows of an import are invalid.
+type ImportMode string
+
+const (
+	// ImportModePartial imports the valid rows and reports the others.
+	ImportModePartial ImportMode = "PARTIAL"
+	// ImportModeAllOrNothing imports nothing unless every row is valid.
+	ImportModeAllOrNothing ImportMode = "ALL_OR_NOTHING"
+)
+
+// EnumValues implements the StringEnumValues interface for schema generation
+func (ImportMode) EnumValues() []string {
+	return []string{"PARTIAL", "ALL_OR_NOTHING"}
+}
+
+// ImportRowStatus tells what happened to one row of an import.
+type ImportRowStatus string
+
+const (
+	ImportRowImported ImportRowStatus = "IMPORTED"
+	ImportRowFailed   ImportRowStatus = "FAILED"
+	ImportRowSkipped  ImportRowStatus = "SKIPPED" // Valid, but not imported because other rows failed
+)
+
+// EnumValues implements the StringEnumValues interface for schema generation
+func (ImportRowStatus) EnumValues() []string {
+	return []string{"IMPORTED", "FAILED", "SKIPPED"}
+}
+
+// ImportRow is the outcome of importing one CSV row.
+type ImportRow struct {
+	Line   int             `json:"line"` // Line in the CSV; the header is line 1
+	Status ImportRowStatus `json:"status"`
+	ID     *int            `json:"id"`     // ID of the created widget or product
+	Errors []string        `json:"errors"` // Everything wrong with the row
+}
+
+// ImportReport is the outcome of an import.
+type ImportReport struct {
+	Mode     ImportMode  `json:"mode"`
+	Imported int         `json:"imported"`
+	Failed   int         `json:"failed"`
+	Skipped  int         `json:"skipped"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+// maxImportRows bounds the size of a single import.
+const maxImportRows = 1000
+
+// The CSV columns of widgets and products. Exports write all of them, in
+// order; imports require the required ones, accept the optional ones and
+// ignore the others, which only make sense on export.
+var (
+	widgetColumnsCSV = csvColumns{
+		export:   []string{"id", "name", "price", "quantity", "reorderThreshold", "deletedAt"},
+		required: []string{"name", "price", "quantity"},
+		optional: []string{"reorderThreshold"},
+	}
+	productColumnsCSV = csvColumns{
+		export:   []string{"id", "name", "description", "price", "categoryId", "stock", "status", "inStock", "deletedAt"},
+		required: []string{"name", "price", "categoryId"},
+		optional: []string{"description", "stock", "status"},
+	}
+)
+
+type csvColumns struct {
+	export, required, optional []string
+}
+
+// csvRow is one data row of an imported CSV, keyed by column name.
+type csvRow struct {
+	line   int
+	fields map[string]string
+}
+
+// readCSV parses data into rows, checking its header against columns. Column
+// names are case-insensitive and surrounding spaces are trimmed. Rows with
+// the wrong number of fields are returned with an error so they show up in
+// the report.
+func readCSV(data string, columns csvColumns) ([]csvRow, []error, error) {
+	reader := csv.NewReader(strings.NewReader(strings.TrimPrefix(data, "\ufeff")))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, errors.New("csv is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid csv header: %w", err)
+	}
+
+	known := make(map[string]string)
+	for _, name := range columns.export {
+		known[strings.ToLower(name)] = name
+	}
+	names := make([]string, len(header))
+	seen := make(map[string]bool)
+	for i, column := range header {
+		name, ok := known[strings.ToLower(strings.TrimSpace(column))]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown csv column %q", column)
+		}
+		if seen[name] {
+			return nil, nil, fmt.Errorf("duplicate csv column %q", name)
+		}
+		names[i], seen[name] = name, true
+	}
+	for _, name := range columns.required {
+		if !seen[name] {
+			return nil, nil, fmt.Errorf("missing csv column %q", name)
+		}
+	}
+
+	var rows []csvRow
+	var rowErrs []error
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		var parseErr *csv.ParseError
+		if err != nil && !(errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount)) {
+			return nil, nil, fmt.Errorf("invalid csv: %w", err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, nil, fmt.Errorf("csv has more than %d rows", maxImportRows)
+		}
+
+		row := csvRow{line: line, fields: make(map[string]string)}
+		for i, value := range record {
+			if i < len(names) {
+				row.fields[names[i]] = strings.TrimSpace(value)
+			}
+		}
+		rows = append(rows, row)
+		if err != nil {
+			rowErrs = append(rowErrs, fmt.Errorf("expected %d fields, got %d", len(names), len(record)))
+		} else {
+			rowErrs = append(rowErrs, nil)
+		}
+	}
+	if len(rows) == 0 {
+		return nil, nil, errors.New("csv has no rows")
+	}
+	return rows, rowErrs, nil
+}
+
+// rowErrors collects the problems of a row so all of them can be reported
+// at once.
+type rowErrors []string
+
+func (e *rowErrors) add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+// parsePrice parses a required price column, a plain number in the store
+// currency.
+func (e *rowErrors) parsePrice(row csvRow, column string) Money {
+	return NewMoney(e.parseFloat(row, column), storeCurrency)
+}
+
+// parseFloat parses a required number column.
+func (e *rowErrors) parseFloat(row csvRow, column string) float64 {
+	value := row.fields[column]
+	if value == "" {
+		e.add("%s is required", column)
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.add("%s %q is not a number", column, value)
+	}
+	return f
+}
+
+// parseInt parses a required whole number column.
+func (e *rowErrors) parseInt(row csvRow, column string) int {
+	value := row.fields[column]
+	if value == "" {
+		e.add("%s is required", column)
+		return 0
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		e.add("%s %q is not a whole number", column, value)
+	}
+	return i
+}
+
+// runImport validates every row with parse and, depending on mode, creates
+// the valid ones with create. Each row is created on its own, so a failure
+// while creating, e.g. a store error, only fails that row; validation
+// catches everything else before anything is written.
+func runImport[T any](rows []csvRow, rowErrs []error, mode *ImportMode, parse func(csvRow) (T, rowErrors), create func(T) (int, error)) (*ImportReport, error) {
+	report := &ImportReport{Mode: ImportModeAllOrNothing, Rows: make([]ImportRow, len(rows))}
+	if mode != nil {
+		report.Mode = *mode
+	}
+	if report.Mode != ImportModePartial && report.Mode != ImportModeAllOrNothing {
+		return nil, fmt.Errorf("invalid import mode: %s", report.Mode)
+	}
+
+	inputs := make([]T, len(rows))
+	for i, row := range rows {
+		var errs rowErrors
+		if rowErrs[i] != nil {
+			errs.add("%s", rowErrs[i].Error())
+		} else {
+			inputs[i], errs = parse(row)
+		}
+		report.Rows[i] = ImportRow{Line: row.line, Status: ImportRowImported, Errors: []string{}}
+		if len(errs) > 0 {
+			report.Rows[i].Errors = errs
+			report.Rows[i].Status = ImportRowFailed
+			report.Failed++
+		}
+	}
+	if report.Failed > 0 && report.Mode == ImportModeAllOrNothing {
+		for i := range report.Rows {
+			if report.Rows[i].Status == ImportRowImported {
+				report.Rows[i].Status = ImportRowSkipped
+				report.Skipped++
+			}
+		}
+		return report, nil
+	}
+
+	for i := range report.Rows {
+		if report.Rows[i].Status != ImportRowImported {
+			continue
+		}
+		id, err := create(inputs[i])
+		if err != nil {
+			report.Rows[i].Status = ImportRowFailed
+			report.Rows[i].Errors = []string{err.Error()}
+			report.Failed++
+			continue
+		}
+		report.Rows[i].ID = &id
+		report.Imported++
+	}
+	return report, nil
+}
+
+// ImportWidgets creates a widget for every row of a CSV with the columns
+// name, price, quantity and optionally reorderThreshold. mode defaults to
+// ALL_OR_NOTHING. Requires the admin role.
+func (h *WidgetHandlers) ImportWidgets(ctx context.Context, data string, mode *ImportMode) (*ImportReport, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	rows, rowErrs, err := readCSV(data, widgetColumnsCSV)
+	if err != nil {
+		return nil, err
+	}
+
+	parse := func(row csvRow) (WidgetCreateInput, rowErrors) {
+		var errs rowErrors
+		input := WidgetCreateInput{Name: row.fields["name"]}
+		if input.Name == "" {
+			errs.add("name is required")
+		}
+		if input.Price = errs.parsePrice(row, "price"); input.Price.Amount < 0 {
+			errs.add("price cannot be negative")
+		}
+		if input.Quantity = errs.parseInt(row, "quantity"); input.Quantity < 0 {
+			errs.add("quantity cannot be negative")
+		}
+		if row.fields["reorderThreshold"] != "" {
+			threshold := errs.parseInt(row, "reorderThreshold")
+			if threshold < 0 {
+				errs.add("reorderThreshold cannot be negative")
+			}
+			input.ReorderThreshold = &threshold
+		}
+		return input, errs
+	}
+	return runImport(rows, rowErrs, mode, parse, func(input WidgetCreateInput) (int, error) {
+		widget, err := h.CreateWidget(ctx, input)
+		return widget.ID, err
+	})
+}
+
+// productImport is a validated product row.
+type productImport struct {
+	input  ProductInput
+	status ProductStatus
+}
+
+// ImportProducts creates a product for every row of a CSV with the columns
+// name, price, categoryId and optionally description, stock and status,
+// which defaults to DRAFT. Other statuses must be reachable from DRAFT, so their
+// guards apply to the row. mode defaults to ALL_OR_NOTHING. Requires the
+// admin role.
+func (h *ProductHandlers) ImportProducts(ctx context.Context, data string, mode *ImportMode) (*ImportReport, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	rows, rowErrs, err := readCSV(data, productColumnsCSV)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := h.store.Categories().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	liveCategories := make(map[int]*Category)
+	for _, c := range withoutDeleted(categories, false) {
+		c := c
+		liveCategories[c.ID] = &c
+	}
+
+	parse := func(row csvRow) (productImport, rowErrors) {
+		var errs rowErrors
+		p := productImport{
+			input:  ProductInput{Name: row.fields["name"], Description: row.fields["description"]},
+			status: ProductStatusDraft,
+		}
+		if p.input.Name == "" {
+			errs.add("name is required")
+		}
+		if p.input.Price = errs.parsePrice(row, "price"); p.input.Price.Amount < 0 {
+			errs.add("price cannot be negative")
+		}
+		parsed := len(errs)
+		if p.input.CategoryID = errs.parseInt(row, "categoryId"); len(errs) == parsed && liveCategories[p.input.CategoryID] == nil {
+			errs.add("category with id %d not found", p.input.CategoryID)
+		}
+		if row.fields["stock"] != "" {
+			stock := errs.parseInt(row, "stock")
+			if stock < 0 {
+				errs.add("stock cannot be negative")
+			}
+			p.input.Stock = &stock
+		}
+		if value := row.fields["status"]; value != "" {
+			p.status = ProductStatus(strings.ToUpper(value))
+			if !validProductStatus(p.status) {
+				errs.add("invalid product status %q, expected one of %s", value, strings.Join(ProductStatus("").EnumValues(), ", "))
+			} else if p.status != ProductStatusDraft {
+				draft := Product{Status: ProductStatusDraft, Price: p.input.Price, CategoryID: p.input.CategoryID}
+				if p.input.Stock != nil {
+					draft.Stock = *p.input.Stock
+				}
+				var transitionErr *StatusTransitionError
+				if err := checkStatusTransition(draft, liveCategories[draft.CategoryID], p.status); errors.As(err, &transitionErr) {
+					errs.add("a new product cannot be %s: %s", p.status, transitionErr.Reason)
+				}
+			}
+		}
+		return p, errs
+	}
+	return runImport(rows, rowErrs, mode, parse, func(p productImport) (int, error) {
+		product, err := h.CreateProduct(ctx, p.input)
+		if err != nil {
+			return 0, err
+		}
+		if p.status != ProductStatusDraft {
+			if _, err := h.UpdateProductStatus(ctx, product.ID, p.status, product.Version); err != nil {
+				return 0, fmt.Errorf("product %d was created as %s: %w", product.ID, ProductStatusDraft, err)
+			}
+		}
+		return product.ID, nil
+	})
+}
+
+// CSVExportHandler serves the widgets and products as CSV downloads at
+// <prefix>/widgets.csv and <prefix>/products.csv, with the same visibility
+// rules as GetWidgets and GetProducts. Products can be filtered with the
+// query parameters categoryId, minPrice, maxPrice, status and inStock, and
+// admins can pass includeDeleted=true. It expects TenantMiddleware and
+// AuthMiddleware to have run.
+func CSVExportHandler(store Store) http.Handler {
+	widgets := NewWidgetHandlers(store)
+	products := NewProductHandlers(store)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		includeDeleted, err := optionalQueryParam(query.Get("includeDeleted"), strconv.ParseBool)
+		if err != nil {
+			http.Error(w, "invalid includeDeleted: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := showDeleted(r.Context(), includeDeleted); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		var header []string
+		var records [][]string
+		switch file := path.Base(r.URL.Path); file {
+		case "widgets.csv":
+			list, err := widgets.listWidgets(r.Context(), includeDeleted)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			header = widgetColumnsCSV.export
+			for _, widget := range list {
+				records = append(records, widgetRecord(widget))
+			}
+		case "products.csv":
+			filter, err := productFilterFromQuery(query)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			list, _, err := products.listProducts(r.Context(), filter, nil, includeDeleted)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			header = productColumnsCSV.export
+			for _, product := range list {
+				records = append(records, productRecord(product))
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(r.URL.Path)))
+		// The csv writer buffers a few KB at a time, so large exports are
+		// streamed to the client as they are written
+		out := csv.NewWriter(w)
+		out.Write(header)
+		for _, record := range records {
+			if err := out.Write(record); err != nil {
+				return // The client went away
+			}
+		}
+		out.Flush()
+	})
+}
+
+// widgetRecord returns the fields of w in the order of widgetColumnsCSV.
+func widgetRecord(w Widget) []string {
+	return []string{strconv.Itoa(w.ID), w.Name, formatCSVPrice(w.Price), strconv.Itoa(w.Quantity), formatCSVInt(w.ReorderThreshold), formatCSVTime(w.DeletedAt)}
+}
+
+// productRecord returns the fields of p in the order of productColumnsCSV.
+func productRecord(p Product) []string {
+	return []string{strconv.Itoa(p.ID), p.Name, p.Description, formatCSVPrice(p.Price), strconv.Itoa(p.CategoryID), strconv.Itoa(p.Stock), string(p.Status), strconv.FormatBool(p.InStock), formatCSVTime(p.DeletedAt)}
+}
+
+// formatCSVPrice writes a price as a plain number in the store currency.
+func formatCSVPrice(m Money) string {
+	return strconv.FormatFloat(m.Dollars(), 'f', -1, 64)
+}
+
+func formatCSVInt(i *int) string {
+	if i == nil {
+		return ""
+	}
+	return strconv.Itoa(*i)
+}
+
+func formatCSVTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// productFilterFromQuery builds the ProductFilter of an export from its
+// query parameters.
+func productFilterFromQuery(query map[string][]string) (*ProductFilter, error) {
+	get := func(name string) string {
+		if values := query[name]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	parseFloat := func(s string) (float64, error) { return strconv.ParseFloat(s, 64) }
+
+	var filter ProductFilter
+	var err error
+	if filter.CategoryID, err = optionalQueryParam(get("categoryId"), strconv.Atoi); err != nil {
+		return nil, fmt.Errorf("invalid categoryId: %w", err)
+	}
+	if filter.MinPrice, err = optionalQueryParam(get("minPrice"), parseFloat); err != nil {
+		return nil, fmt.Errorf("invalid minPrice: %w", err)
+	}
+	if filter.MaxPrice, err = optionalQueryParam(get("maxPrice"), parseFloat); err != nil {
+		return nil, fmt.Errorf("invalid maxPrice: %w", err)
+	}
+	if filter.InStock, err = optionalQueryParam(get("inStock"), strconv.ParseBool); err != nil {
+		return nil, fmt.Errorf("invalid inStock: %w", err)
+	}
+	if filter.MinRating, err = optionalQueryParam(get("minRating"), parseFloat); err != nil {
+		return nil, fmt.Errorf("invalid minRating: %w", err)
+	}
+	if value := get("status"); value != "" {
+		status := ProductStatus(strings.ToUpper(value))
+		if !validProductStatus(status) {
+			return nil, fmt.Errorf("invalid product status: %s", value)
+		}
+		filter.Status = &status
+	}
+	if filter.IncludeSubcategories, err = optionalQueryParam(get("includeSubcategories"), strconv.ParseBool); err != nil {
+		return nil, fmt.Errorf("invalid includeSubcategories: %w", err)
+	}
+	if value := get("nameContains"); value != "" {
+		filter.NameContains = &value
+	}
+	return &filter, nil
+}
+
+// optionalQueryParam parses value with parse, returning nil if it is empty.
+func optionalQueryParam[T any](value string, parse func(string) (T, error)) (*T, error) {
+	if value == "" {
+		return nil, nil
+	}
+	v, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
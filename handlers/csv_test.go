@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImportWidgets(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	graph := newTestGraph(ctx, store)
+
+	admin, err := store.Users().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get admin failed: %v", err)
+	}
+	adminCtx := context.WithValue(ctx, UserContextKey, &admin)
+
+	data := "Name, price, quantity, reorderThreshold\n" +
+		"Sprocket,2.50,100,10\n" +
+		"Cog,-1,x,\n" +
+		"Gear,4,7,\n"
+	importWidgets := func(ctx context.Context, mode string) string {
+		variables, _ := json.Marshal(map[string]string{"csv": data, "mode": mode})
+		res, _ := graph.ProcessRequest(ctx, `mutation Import($csv: String!, $mode: String) {
+			ImportWidgets(csv: $csv, mode: $mode) { imported failed skipped rows { line status id errors } }
+		}`, string(variables))
+		return res
+	}
+
+	if res := importWidgets(ctx, "PARTIAL"); !strings.Contains(res, "authentication required") {
+		t.Errorf("expected anonymous imports to be rejected, got %s", res)
+	}
+
+	res := importWidgets(adminCtx, "ALL_OR_NOTHING")
+	want := `{"failed":1,"imported":0,"rows":[` +
+		`{"errors":[],"id":null,"line":2,"status":"SKIPPED"},` +
+		`{"errors":["price cannot be negative","quantity \"x\" is not a whole number"],"id":null,"line":3,"status":"FAILED"},` +
+		`{"errors":[],"id":null,"line":4,"status":"SKIPPED"}],"skipped":2}`
+	if !strings.Contains(res, want) {
+		t.Errorf("expected nothing to be imported, got %s", res)
+	}
+	if widgets, _ := store.Widgets().List(ctx); len(widgets) != 1 {
+		t.Fatalf("expected no widgets to be created, got %d widgets", len(widgets))
+	}
+
+	res = importWidgets(adminCtx, "PARTIAL")
+	if !strings.Contains(res, `"failed":1,"imported":2`) || !strings.Contains(res, `"id":2,"line":2,"status":"IMPORTED"`) {
+		t.Errorf("expected the valid rows to be imported, got %s", res)
+	}
+	sprocket, err := store.Widgets().Get(ctx, 2)
+	if err != nil || sprocket.Name != "Sprocket" || sprocket.Quantity != 100 || *sprocket.ReorderThreshold != 10 {
+		t.Errorf("unexpected imported widget %+v, %v", sprocket, err)
+	}
+	if ledger, _ := store.StockLedger().ListByWidget(ctx, 2); len(ledger) != 1 {
+		t.Errorf("expected the initial stock to be recorded, got %+v", ledger)
+	}
+}
+
+func TestImportProducts(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	h := NewProductHandlers(store)
+
+	admin, err := store.Users().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get admin failed: %v", err)
+	}
+	adminCtx := context.WithValue(ctx, UserContextKey, &admin)
+
+	partial := ImportModePartial
+	report, err := h.ImportProducts(adminCtx, `name,description,price,categoryId,stock,status
+Tablet,"10"" screen",299,1,5,active
+E-Reader,,-5,9,,SOLD
+Poster,Wall art,15,3,,
+Sticker,,0,3,,active
+`, &partial)
+	if err != nil {
+		t.Fatalf("ImportProducts failed: %v", err)
+	}
+	if report.Imported != 2 || report.Failed != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	wantErrors := []string{
+		"price cannot be negative",
+		"category with id 9 not found",
+		`invalid product status "SOLD", expected one of DRAFT, ACTIVE, DISCONTINUED, OUT_OF_STOCK`,
+	}
+	if got := report.Rows[1].Errors; strings.Join(got, "|") != strings.Join(wantErrors, "|") {
+		t.Errorf("expected errors %q, got %q", wantErrors, got)
+	}
+
+	if got := report.Rows[3].Errors; len(got) != 1 || got[0] != "a new product cannot be ACTIVE: a price is required" {
+		t.Errorf("expected the ACTIVE guard to reject the free sticker, got %q", got)
+	}
+
+	tablet, err := store.Products().Get(ctx, *report.Rows[0].ID)
+	if err != nil || tablet.Description != `10" screen` || tablet.Status != ProductStatusActive || !tablet.InStock || tablet.Stock != 5 {
+		t.Errorf("unexpected imported product %+v, %v", tablet, err)
+	}
+	poster, err := store.Products().Get(ctx, *report.Rows[2].ID)
+	if err != nil || poster.Status != ProductStatusDraft {
+		t.Errorf("expected the status to default to DRAFT, got %+v, %v", poster, err)
+	}
+
+	for data, want := range map[string]string{
+		"":                            "csv is empty",
+		"name,price\n":                `missing csv column "categoryId"`,
+		"name,price,categoryId,sku\n": `unknown csv column "sku"`,
+		"name,price,categoryId\n":     "csv has no rows",
+	} {
+		if _, err := h.ImportProducts(adminCtx, data, nil); err == nil || err.Error() != want {
+			t.Errorf("expected %q for %q, got %v", want, data, err)
+		}
+	}
+}
+
+func TestCSVExportHandler(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	handler := AuthMiddleware(store, CSVExportHandler(store))
+	get := func(path, auth string) (int, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	status, body := get("/export/products.csv?categoryId=1&status=active", "")
+	want := "id,name,description,price,categoryId,stock,status,inStock,deletedAt\n" +
+		"1,Laptop,High-performance laptop,999.99,1,25,ACTIVE,true,\n" +
+		"4,Smartphone,Latest model,699.99,1,10,ACTIVE,true,\n"
+	if status != http.StatusOK || body != want {
+		t.Errorf("expected the filtered products, got %d %q", status, body)
+	}
+
+	if status, _ := get("/export/widgets.csv?includeDeleted=true", "Bearer user-token"); status != http.StatusForbidden {
+		t.Errorf("expected deleted widgets to be admin-only, got %d", status)
+	}
+	if status, _ := get("/export/products.csv?minPrice=cheap", ""); status != http.StatusBadRequest {
+		t.Errorf("expected an invalid filter to be rejected, got %d", status)
+	}
+	if status, _ := get("/export/orders.csv", ""); status != http.StatusNotFound {
+		t.Errorf("expected unknown exports to be not found, got %d", status)
+	}
+
+	// An export can be imported again
+	_, body = get("/export/widgets.csv", "")
+	admin, _ := store.Users().Get(ctx, 1)
+	report, err := NewWidgetHandlers(store).ImportWidgets(context.WithValue(ctx, UserContextKey, &admin), body, nil)
+	if err != nil || report.Imported != 1 {
+		t.Fatalf("expected the export to import, got %+v, %v", report, err)
+	}
+	if imported, _ := store.Widgets().Get(ctx, 2); imported.Name != "Widget 1" || imported.Quantity != 10 || *imported.ReorderThreshold != 3 {
+		t.Errorf("unexpected imported widget %+v", imported)
+	}
+}
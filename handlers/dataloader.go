@@ -0,0 +1,234 @@
+package handlers
+
+import "sync"
+
+// Field resolvers such as Product.Category run once per parent object, so
+// resolving them with one store lookup each costs a lookup per row of a
+// list. Instead they go through the loaders of their scope. Every page a
+// connection returns queues the keys its nodes will need; the first field
+// that resolves fetches all queued keys in one batch call, and the rest are
+// served from memory.
+//
+// The loaders live as long as their scope, i.e. one top-level field of one
+// request, so they never serve data from before a mutation.
+
+// loader batches and memoizes the lookups of one kind of related data.
+type loader[K comparable, V any] struct {
+	fetch func(keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	queued  []K
+	pending map[K]bool // Keys in queued
+	values  map[K]V
+	fetched map[K]bool // Keys already fetched, found or not
+}
+
+// newLoader returns a loader that looks up batches of keys with fetch.
+// fetch leaves keys that do not exist out of its result.
+func newLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{
+		fetch:   fetch,
+		pending: make(map[K]bool),
+		values:  make(map[K]V),
+		fetched: make(map[K]bool),
+	}
+}
+
+// queue adds keys to the next batch.
+func (l *loader[K, V]) queue(keys ...K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if !l.fetched[key] && !l.pending[key] {
+			l.pending[key] = true
+			l.queued = append(l.queued, key)
+		}
+	}
+}
+
+// load returns the value of key and whether it exists, fetching it together
+// with all queued keys unless it was fetched before.
+func (l *loader[K, V]) load(key K) (V, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.fetched[key] {
+		keys := l.queued
+		if !l.pending[key] {
+			keys = append(keys, key)
+		}
+		values, err := l.fetch(keys)
+		if err != nil {
+			var zero V
+			return zero, false, err
+		}
+		l.queued, l.pending = nil, make(map[K]bool)
+		for _, k := range keys {
+			l.fetched[k] = true
+		}
+		for k, v := range values {
+			l.values[k] = v
+		}
+	}
+	v, ok := l.values[key]
+	return v, ok, nil
+}
+
+// loaders are the loaders of a scope. The entities they return are bound to
+// the scope.
+type loaders struct {
+	categories      *loader[int, Category]
+	users           *loader[int, User]
+	productReviews  *loader[int, []Review]           // Reviews that are not deleted, by product ID
+	userReviews     *loader[int, []Review]           // Reviews that are not deleted, by user ID
+	productVariants *loader[int, []ProductVariant]   // Variants by product ID
+	productRatings  *loader[int, RatingSummary]      // Rating summaries by product ID
+	employees       *loader[int, *Employee]          // Employees by ID
+	reports         *loader[int, []*Employee]        // Direct reports, terminated ones included, by manager ID
+	categoryTree    *loader[struct{}, *categoryTree] // Every category, fetched at most once
+	recommender     *loader[struct{}, *recommender]  // Built at most once
+}
+
+func newLoaders(sc *scope) *loaders {
+	ctx, store := sc.ctx, sc.store
+	l := &loaders{}
+	l.categories = newLoader(func(ids []int) (map[int]Category, error) {
+		categories, err := store.Categories().GetMany(ctx, ids)
+		result := make(map[int]Category, len(categories))
+		for _, c := range categories {
+			c.scope = sc
+			result[c.ID] = c
+		}
+		return result, err
+	})
+	l.categoryTree = newLoader(func([]struct{}) (map[struct{}]*categoryTree, error) {
+		categories, err := store.Categories().List(ctx)
+		return map[struct{}]*categoryTree{{}: newCategoryTree(bindCategories(sc, categories))}, err
+	})
+	l.recommender = newLoader(func([]struct{}) (map[struct{}]*recommender, error) {
+		r, err := newRecommender(sc)
+		return map[struct{}]*recommender{{}: r}, err
+	})
+	l.users = newLoader(func(ids []int) (map[int]User, error) {
+		users, err := store.Users().GetMany(ctx, ids)
+		result := make(map[int]User, len(users))
+		for _, u := range users {
+			u.scope = sc
+			result[u.ID] = u
+		}
+		// The users are likely to be asked for their reviews next
+		l.userReviews.queue(ids...)
+		return result, err
+	})
+	l.productReviews = newLoader(func(ids []int) (map[int][]Review, error) {
+		reviews, err := store.Reviews().ListByProducts(ctx, ids)
+		grouped := groupReviews(sc, reviews, func(r Review) int { return r.ProductID })
+		// Queue the authors of every review fetched, not just those of the
+		// page of reviews of the first product
+		for _, productReviews := range grouped {
+			queueReviews(productReviews)
+		}
+		return grouped, err
+	})
+	l.productVariants = newLoader(func(ids []int) (map[int][]ProductVariant, error) {
+		variants, err := store.ProductVariants().ListByProducts(ctx, ids)
+		result := make(map[int][]ProductVariant, len(ids))
+		for _, id := range ids {
+			result[id] = []ProductVariant{}
+		}
+		for _, v := range variants {
+			v.scope = sc
+			result[v.ProductID] = append(result[v.ProductID], v)
+		}
+		return result, err
+	})
+	l.productRatings = newLoader(func(ids []int) (map[int]RatingSummary, error) {
+		summaries, err := store.RatingSummaries().ListByProducts(ctx, ids)
+		result := make(map[int]RatingSummary, len(ids))
+		for _, id := range ids {
+			result[id] = RatingSummary{ProductID: id}
+		}
+		for _, s := range summaries {
+			result[s.ProductID] = s
+		}
+		return result, err
+	})
+	l.userReviews = newLoader(func(ids []int) (map[int][]Review, error) {
+		reviews, err := store.Reviews().ListByUsers(ctx, ids)
+		return groupReviews(sc, reviews, func(r Review) int { return r.UserID }), err
+	})
+	l.employees = newLoader(func(ids []int) (map[int]*Employee, error) {
+		employees, err := store.Employees().GetMany(ctx, ids)
+		result := make(map[int]*Employee, len(employees))
+		for _, e := range bindEmployees(sc, employees) {
+			result[e.ID] = e
+		}
+		return result, err
+	})
+	l.reports = newLoader(func(ids []int) (map[int][]*Employee, error) {
+		reports, err := store.Employees().ListByManagers(ctx, ids)
+		result := make(map[int][]*Employee, len(ids))
+		for _, id := range ids {
+			result[id] = []*Employee{}
+		}
+		for _, e := range bindEmployees(sc, reports) {
+			result[*e.ManagerID] = append(result[*e.ManagerID], e)
+		}
+		return result, err
+	})
+	return l
+}
+
+// groupReviews binds the reviews that are not deleted to sc and groups them
+// by key.
+func groupReviews(sc *scope, reviews []Review, key func(Review) int) map[int][]Review {
+	result := make(map[int][]Review)
+	for _, r := range bindReviews(sc, withoutDeleted(reviews, false)) {
+		result[key(r)] = append(result[key(r)], r)
+	}
+	return result
+}
+
+// queueProducts queues the lookups the field resolvers of products make.
+func queueProducts(products []Product) {
+	for i := range products {
+		if sc := products[i].scope; sc != nil {
+			sc.loaders.categories.queue(products[i].CategoryID)
+			sc.loaders.productReviews.queue(products[i].ID)
+			sc.loaders.productVariants.queue(products[i].ID)
+			sc.loaders.productRatings.queue(products[i].ID)
+		}
+	}
+}
+
+// queueReviews queues the lookups the field resolvers of reviews make.
+func queueReviews(reviews []Review) {
+	for i := range reviews {
+		if sc := reviews[i].scope; sc != nil {
+			sc.loaders.users.queue(reviews[i].UserID)
+		}
+	}
+}
+
+// queueEmployees queues the lookups the field resolvers of employees make.
+func queueEmployees(employees []*Employee) {
+	for _, e := range employees {
+		if m, ok := e.actualType.(*Manager); ok {
+			queueManagers([]*Manager{m})
+		} else if e.scope != nil && e.ManagerID != nil {
+			e.scope.loaders.employees.queue(*e.ManagerID)
+		}
+	}
+}
+
+// queueManagers queues the lookups the field resolvers of managers make.
+func queueManagers(managers []*Manager) {
+	for _, m := range managers {
+		if m.scope != nil {
+			m.scope.loaders.reports.queue(m.ID)
+			if m.ManagerID != nil {
+				m.scope.loaders.employees.queue(*m.ManagerID)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// countingStore counts the lookups made through the category, review and
+// user repositories.
+type countingStore struct {
+	Store
+	calls map[string]int
+}
+
+func (s *countingStore) Categories() CategoryRepository {
+	return countingCategories{s.Store.Categories(), s.calls}
+}
+
+func (s *countingStore) Reviews() ReviewRepository {
+	return countingReviews{s.Store.Reviews(), s.calls}
+}
+
+func (s *countingStore) Users() UserRepository {
+	return countingUsers{s.Store.Users(), s.calls}
+}
+
+type countingCategories struct {
+	CategoryRepository
+	calls map[string]int
+}
+
+func (r countingCategories) Get(ctx context.Context, id int) (Category, error) {
+	r.calls["Categories.Get"]++
+	return r.CategoryRepository.Get(ctx, id)
+}
+
+func (r countingCategories) GetMany(ctx context.Context, ids []int) ([]Category, error) {
+	r.calls["Categories.GetMany"]++
+	return r.CategoryRepository.GetMany(ctx, ids)
+}
+
+type countingReviews struct {
+	ReviewRepository
+	calls map[string]int
+}
+
+func (r countingReviews) ListByProduct(ctx context.Context, productID int) ([]Review, error) {
+	r.calls["Reviews.ListByProduct"]++
+	return r.ReviewRepository.ListByProduct(ctx, productID)
+}
+
+func (r countingReviews) ListByProducts(ctx context.Context, productIDs []int) ([]Review, error) {
+	r.calls["Reviews.ListByProducts"]++
+	return r.ReviewRepository.ListByProducts(ctx, productIDs)
+}
+
+type countingUsers struct {
+	UserRepository
+	calls map[string]int
+}
+
+func (r countingUsers) Get(ctx context.Context, id int) (User, error) {
+	r.calls["Users.Get"]++
+	return r.UserRepository.Get(ctx, id)
+}
+
+func (r countingUsers) GetMany(ctx context.Context, ids []int) ([]User, error) {
+	r.calls["Users.GetMany"]++
+	return r.UserRepository.GetMany(ctx, ids)
+}
+
+func TestFieldResolversBatchLookups(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryStore()
+	if err := SeedSampleData(ctx, memory); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		p, err := memory.Products().Create(ctx, Product{Name: fmt.Sprintf("Product %d", i), Price: NewMoney(10, storeCurrency), CategoryID: i%3 + 1, Status: ProductStatusActive})
+		if err != nil {
+			t.Fatalf("Create product failed: %v", err)
+		}
+		if _, err := memory.Reviews().Create(ctx, Review{ProductID: p.ID, UserID: i%3 + 1, Rating: 4, Comment: "Fine"}); err != nil {
+			t.Fatalf("Create review failed: %v", err)
+		}
+	}
+	store := &countingStore{Store: memory, calls: make(map[string]int)}
+	graph := newTestGraph(ctx, store)
+
+	res, err := graph.ProcessRequest(ctx, `{ GetProducts(first: 100) { edges { node {
+		ID Category { Name } AverageRating Reviews(page: {}) { edges { node { Rating User { Username } } } }
+	} } } }`, "")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if got := strings.Count(res, `"Category":{"Name"`); got != 100 {
+		t.Errorf("expected 100 products with a category, got %d", got)
+	}
+	want := map[string]int{"Categories.GetMany": 1, "Reviews.ListByProducts": 1, "Users.GetMany": 1}
+	if !reflect.DeepEqual(store.calls, want) {
+		t.Errorf("expected one batched lookup per field %v, got %v", want, store.calls)
+	}
+}
+
+func TestLoaderMemoizesMissingKeys(t *testing.T) {
+	var batches [][]int
+	l := newLoader(func(keys []int) (map[int]string, error) {
+		batches = append(batches, keys)
+		return map[int]string{1: "one"}, nil
+	})
+	l.queue(1, 2, 1)
+	if v, ok, err := l.load(2); err != nil || ok || v != "" {
+		t.Errorf("expected key 2 to be missing, got %q, %v, %v", v, ok, err)
+	}
+	if v, ok, _ := l.load(1); !ok || v != "one" {
+		t.Errorf("expected key 1 to be found, got %q, %v", v, ok)
+	}
+	l.load(2)
+	l.load(3)
+	if want := [][]int{{1, 2}, {3}}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("expected batches %v, got %v", want, batches)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"time"
+)
+
+// Widgets, products, categories, reviews and employees are soft-deleted: a delete only
+// stamps DeletedAt and DeletedBy, so the entity can be restored later and
+// history stays intact. Queries leave deleted entities out unless an admin
+// asks for them with includeDeleted.
+
+// softDeletable is implemented by every entity that can be soft-deleted.
+type softDeletable interface {
+	isDeleted() bool
+}
+
+func (w Widget) isDeleted() bool   { return w.DeletedAt != nil }
+func (p Product) isDeleted() bool  { return p.DeletedAt != nil }
+func (c Category) isDeleted() bool { return c.DeletedAt != nil }
+func (r Review) isDeleted() bool   { return r.DeletedAt != nil }
+func (e Employee) isDeleted() bool { return e.DeletedAt != nil }
+
+// withoutDeleted returns the entities of items that have not been deleted.
+// It returns items unchanged if includeDeleted is set.
+func withoutDeleted[T softDeletable](items []T, includeDeleted bool) []T {
+	if includeDeleted {
+		return items
+	}
+	result := items[:0:0]
+	for _, item := range items {
+		if !item.isDeleted() {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// deletionStamp returns the time and acting user to record when user
+// soft-deletes an entity.
+func deletionStamp(user *User) (*time.Time, *int) {
+	now := time.Now().UTC()
+	id := user.ID
+	return &now, &id
+}
+
+// showDeleted reports whether a query should return deleted entities.
+// Only admins may ask for them.
+func showDeleted(ctx context.Context, includeDeleted *bool) (bool, error) {
+	if includeDeleted == nil || !*includeDeleted {
+		return false, nil
+	}
+	if _, err := requireAdmin(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// canSeeDeleted reports whether the user in ctx may look up a deleted
+// entity by ID. Everyone else gets the usual not-found error.
+func canSeeDeleted(ctx context.Context) bool {
+	_, err := requireAdmin(ctx)
+	return err == nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		RegisterSearchHandlers(ctx, graph, store)
+
+		adminCtx := userContext(t, store, 1)
+		customerCtx := userContext(t, store, 2)
+
+		// Errors are checked through the response text
+		run := func(ctx context.Context, request string) string {
+			res, _ := graph.ProcessRequest(ctx, request, "")
+			return res
+		}
+
+		// Anonymous requests cannot delete
+		if res := run(ctx, `mutation { DeleteWidget(id: 1) { id } }`); !strings.Contains(res, "authentication required") {
+			t.Errorf("expected authentication error, got %s", res)
+		}
+
+		if res := run(customerCtx, `mutation { DeleteWidget(id: 1) { id } }`); !strings.Contains(res, "admin role required") {
+			t.Errorf("expected widget deletion to require admin, got %s", res)
+		}
+		if res := run(customerCtx, `mutation { DeleteProduct(id: 1) { ID } }`); !strings.Contains(res, "admin role required") {
+			t.Errorf("expected product deletion to require admin, got %s", res)
+		}
+
+		res := run(adminCtx, `mutation { DeleteWidget(id: 1) { deletedBy version } DeleteProduct(id: 1) { DeletedBy } }`)
+		if !strings.Contains(res, `"deletedBy":1`) || !strings.Contains(res, `"version":2`) || !strings.Contains(res, `"DeletedBy":1`) {
+			t.Fatalf("expected the deletions to record user 1, got %s", res)
+		}
+
+		res = run(customerCtx, `{ GetWidgets { edges { node { id } } } GetProducts { edges { node { ID } } } Search(query: "laptop") { totalCount } }`)
+		if strings.Contains(res, `"id":1`) || strings.Contains(res, `"ID":1`) || !strings.Contains(res, `"Search":{"totalCount":0}`) {
+			t.Errorf("expected deleted entities to be hidden, got %s", res)
+		}
+		if res := run(customerCtx, `{ GetWidget(id: 1) { id } }`); !strings.Contains(res, "widget not found") {
+			t.Errorf("expected a deleted widget to be not found, got %s", res)
+		}
+		if res := run(customerCtx, `{ GetWidgets(includeDeleted: true) { totalCount } }`); !strings.Contains(res, "admin role required") {
+			t.Errorf("expected includeDeleted to require admin, got %s", res)
+		}
+		if res := run(adminCtx, `{ GetWidgets(includeDeleted: true) { edges { node { id deletedBy } } } }`); !strings.Contains(res, `"deletedBy":1`) {
+			t.Errorf("expected admins to see the deleted widget, got %s", res)
+		}
+
+		if res := run(customerCtx, `mutation { RestoreWidget(id: 1) { id } }`); !strings.Contains(res, "admin role required") {
+			t.Errorf("expected restore to require admin, got %s", res)
+		}
+		run(adminCtx, `mutation { RestoreWidget(id: 1) { id } RestoreProduct(id: 1) { ID } }`)
+		res = run(customerCtx, `{ GetWidgets { edges { node { id deletedAt } } } GetProduct(id: 1) { Name DeletedAt } }`)
+		if !strings.Contains(res, `"deletedAt":null,"id":1`) || !strings.Contains(res, `"Laptop"`) {
+			t.Errorf("expected restored entities to be visible again, got %s", res)
+		}
+	})
+}
+
+func TestDeletedReviewsDoNotCount(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	graph := newTestGraph(ctx, store)
+
+	jane, err := store.Users().Get(ctx, 3)
+	if err != nil {
+		t.Fatalf("Get user failed: %v", err)
+	}
+	janeCtx := context.WithValue(ctx, UserContextKey, &jane)
+
+	// Review 1 was written by john, not jane
+	res, _ := graph.ProcessRequest(janeCtx, `mutation { DeleteReview(id: 1) { ID } }`, "")
+	if !strings.Contains(res, "only the author or an admin can delete a review") {
+		t.Errorf("expected non-authors to be rejected, got %s", res)
+	}
+
+	if _, err := graph.ProcessRequest(janeCtx, `mutation { DeleteReview(id: 2) { ID } }`, ""); err != nil {
+		t.Fatalf("DeleteReview failed: %v", err)
+	}
+	res, err = graph.ProcessRequest(ctx, `{ GetProduct(id: 1) { AverageRating Reviews(page: {}) { edges { node { ID } } } } }`, "")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if !strings.Contains(res, `"AverageRating":5`) || strings.Contains(res, `"ID":2`) {
+		t.Errorf("expected the deleted review to be ignored, got %s", res)
+	}
+}
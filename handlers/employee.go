@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gburgyan/go-quickgraph"
-	"sync"
 	"time"
 )
 
@@ -16,9 +15,21 @@ type Employee struct {
 	Email    string
 	Salary   float64
 	HireDate string
+	Version  int // Incremented on every update
+
+	// ID of the manager the employee reports to, nil at the top of the
+	// organization. Only managers have reports.
+	ManagerID *int `json:"managerId"`
+
+	// Set when the employee is terminated
+	DeletedAt *time.Time
+	DeletedBy *int // ID of the user who terminated the employee
 
 	// Field for type discovery - allows runtime resolution of actual type
 	actualType interface{} `json:"-" graphy:"-"`
+
+	// Scope used by the field resolvers
+	scope *scope `json:"-" graphy:"-"`
 }
 
 // ActualType implements the TypeDiscoverable interface for Employee
@@ -53,15 +64,15 @@ func NewDeveloper(id int, name, email string, salary float64, hireDate string, l
 	return d
 }
 
-// Manager implements Employee interface via anonymous embedding
+// Manager implements Employee interface via anonymous embedding. Its team is
+// whoever reports to it; see Reports and TeamSize.
 type Manager struct {
 	Employee   // Anonymous embedding for interface
 	Department string
-	TeamSize   int
 }
 
 // NewManager creates a new Manager with type discovery enabled
-func NewManager(id int, name, email string, salary float64, hireDate string, department string, teamSize int) *Manager {
+func NewManager(id int, name, email string, salary float64, hireDate string, department string) *Manager {
 	m := &Manager{
 		Employee: Employee{
 			ID:       id,
@@ -71,7 +82,6 @@ func NewManager(id int, name, email string, salary float64, hireDate string, dep
 			HireDate: hireDate,
 		},
 		Department: department,
-		TeamSize:   teamSize,
 	}
 	m.Employee.actualType = m // Enable type discovery
 	return m
@@ -99,6 +109,7 @@ type EmployeeInput struct {
 	ProgrammingLanguages []string     `json:"programmingLanguages"`
 	GithubUsername       *string      `json:"githubUsername"`
 	Department           *string      `json:"department"`
+	ManagerID            *int         `json:"managerId"` // Optional; must be an active manager
 }
 
 // EmployeeResultUnion represents the possible results when creating an employee
@@ -108,56 +119,32 @@ type EmployeeResultUnion struct {
 	Manager   *Manager
 }
 
-var (
-	employees   []interface{} // Stores both Developer and Manager
-	employeeMux sync.RWMutex
-	nextEmpID   = 1
-)
+// EmployeeHandlers serves the employee queries and mutations from a Store.
+type EmployeeHandlers struct {
+	store Store
+}
 
-func init() {
-	// Initialize with sample data
-	github := "johndoe"
-	employees = []interface{}{
-		NewDeveloper(
-			1,
-			"John Doe",
-			"john@example.com",
-			120000,
-			"2020-01-15",
-			[]string{"Go", "Python", "JavaScript"},
-			&github,
-		),
-		NewManager(
-			2,
-			"Jane Smith",
-			"jane@example.com",
-			150000,
-			"2019-06-01",
-			"Engineering",
-			5,
-		),
-		NewDeveloper(
-			3,
-			"Bob Wilson",
-			"bob@example.com",
-			110000,
-			"2021-03-20",
-			[]string{"Go", "Rust"},
-			nil,
-		),
-	}
-	nextEmpID = 4
+// NewEmployeeHandlers creates employee handlers backed by store.
+func NewEmployeeHandlers(store Store) *EmployeeHandlers {
+	return &EmployeeHandlers{store: store}
 }
 
-func RegisterEmployeeHandlers(ctx context.Context, graphy *quickgraph.Graphy) {
+func RegisterEmployeeHandlers(ctx context.Context, graphy *quickgraph.Graphy, store Store) {
+	h := NewEmployeeHandlers(store)
+
 	// Query registrations
-	graphy.RegisterQuery(ctx, "GetEmployee", GetEmployee, "id")
-	graphy.RegisterQuery(ctx, "GetAllEmployees", GetAllEmployees)
-	graphy.RegisterQuery(ctx, "GetManagers", GetManagers)
+	graphy.RegisterQuery(ctx, "GetEmployee", h.GetEmployee, "id")
+	graphy.RegisterQuery(ctx, "GetAllEmployees", h.GetAllEmployees, "includeDeleted", "first", "after", "last", "before")
+	graphy.RegisterQuery(ctx, "GetManagers", h.GetManagers, "first", "after", "last", "before")
 
 	// Mutation registrations
-	graphy.RegisterMutation(ctx, "CreateEmployee", CreateEmployee, "input")
-	graphy.RegisterMutation(ctx, "PromoteToManager", PromoteToManager, "employeeId", "department")
+	graphy.RegisterMutation(ctx, "CreateEmployee", h.CreateEmployee, "input")
+	graphy.RegisterMutation(ctx, "PromoteToManager", h.PromoteToManager, "employeeId", "department", "version")
+	graphy.RegisterMutation(ctx, "ChangeEmployeeRole", h.ChangeEmployeeRole, "employeeId", "targetType", "department", "programmingLanguages", "githubUsername", "salary", "version")
+	graphy.RegisterMutation(ctx, "TerminateEmployee", h.TerminateEmployee, "employeeId")
+	graphy.RegisterMutation(ctx, "RestoreEmployee", h.RestoreEmployee, "employeeId")
+	graphy.RegisterMutation(ctx, "AssignManager", h.AssignManager, "employeeId", "managerId", "version")
+	graphy.RegisterMutation(ctx, "ReassignReports", h.ReassignReports, "fromManagerId", "toManagerId")
 
 	// Note: The Reports() method on Manager will be automatically exposed as a field
 	// when a Manager object is returned from a query
@@ -165,81 +152,56 @@ func RegisterEmployeeHandlers(ctx context.Context, graphy *quickgraph.Graphy) {
 
 // GetEmployee returns a single employee by ID
 // This demonstrates type discovery - we return *Employee but the actual type
-// (Developer or Manager) is discoverable at runtime
-func GetEmployee(id int) (*Employee, error) {
-	employeeMux.RLock()
-	defer employeeMux.RUnlock()
-
-	for _, emp := range employees {
-		switch e := emp.(type) {
-		case *Developer:
-			if e.ID == id {
-				return &e.Employee, nil
-			}
-		case *Manager:
-			if e.ID == id {
-				return &e.Employee, nil
-			}
-		}
+// (Developer or Manager) is discoverable at runtime. Terminated employees
+// are only visible to admins.
+func (h *EmployeeHandlers) GetEmployee(ctx context.Context, id int) (*Employee, error) {
+	emp, err := h.store.Employees().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && emp.isDeleted() && !canSeeDeleted(ctx)) {
+		return nil, fmt.Errorf("employee with id %d not found", id)
 	}
-
-	return nil, fmt.Errorf("employee with id %d not found", id)
+	if err != nil {
+		return nil, err
+	}
+	emp.scope = newScope(ctx, h.store)
+	return emp, nil
 }
 
-// GetAllEmployees returns all employees
-// This also demonstrates type discovery with slices - we return []*Employee
-// but each element's actual type (Developer or Manager) is discoverable
-func GetAllEmployees() ([]*Employee, error) {
-	employeeMux.RLock()
-	defer employeeMux.RUnlock()
-
-	result := make([]*Employee, 0, len(employees))
-	for _, emp := range employees {
-		switch e := emp.(type) {
-		case *Developer:
-			result = append(result, &e.Employee)
-		case *Manager:
-			result = append(result, &e.Employee)
-		}
+// GetAllEmployees returns a page of all employees
+// This also demonstrates type discovery in connections - the edges hold
+// *Employee but each node's actual type (Developer or Manager) is discoverable.
+// Admins can pass includeDeleted to also get terminated employees.
+func (h *EmployeeHandlers) GetAllEmployees(ctx context.Context, includeDeleted *bool, first *int, after *string, last *int, before *string) (*EmployeeConnection, error) {
+	withDeleted, err := showDeleted(ctx, includeDeleted)
+	if err != nil {
+		return nil, err
 	}
-
-	return result, nil
+	all, err := h.store.Employees().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	employees := bindEmployees(newScope(ctx, h.store), withoutDeleted(all, withDeleted))
+	return newEmployeeConnection(employees, pageArgs(first, after, last, before))
 }
 
-// GetManagers returns only managers
-func GetManagers() ([]*Manager, error) {
-	employeeMux.RLock()
-	defer employeeMux.RUnlock()
+// GetManagers returns a page of only managers
+func (h *EmployeeHandlers) GetManagers(ctx context.Context, first *int, after *string, last *int, before *string) (*ManagerConnection, error) {
+	all, err := h.store.Employees().List(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	var managers []*Manager
-	for _, emp := range employees {
-		if mgr, ok := emp.(*Manager); ok {
+	for _, emp := range bindEmployees(newScope(ctx, h.store), withoutDeleted(all, false)) {
+		if mgr, ok := quickgraph.Discover[*Manager](emp); ok {
 			managers = append(managers, mgr)
 		}
 	}
 
-	return managers, nil
-}
-
-// Reports method for Manager - demonstrates field resolution with type discovery
-func (m *Manager) Reports() ([]*Employee, error) {
-	employeeMux.RLock()
-	defer employeeMux.RUnlock()
-
-	var reports []*Employee
-	// In a real app, this would query by manager ID
-	// For demo, return some developers
-	for _, emp := range employees {
-		if dev, ok := emp.(*Developer); ok && len(reports) < m.TeamSize {
-			reports = append(reports, &dev.Employee)
-		}
-	}
-
-	return reports, nil
+	return newManagerConnection(managers, pageArgs(first, after, last, before))
 }
 
 // CreateEmployee mutation - returns a union of Developer or Manager
-func CreateEmployee(input EmployeeInput) (EmployeeResultUnion, error) {
+func (h *EmployeeHandlers) CreateEmployee(ctx context.Context, input EmployeeInput) (EmployeeResultUnion, error) {
 	// Validate input
 	if input.Type == EmployeeTypeDeveloper && len(input.ProgrammingLanguages) == 0 {
 		return EmployeeResultUnion{}, errors.New("developers must have at least one programming language")
@@ -247,62 +209,187 @@ func CreateEmployee(input EmployeeInput) (EmployeeResultUnion, error) {
 	if input.Type == EmployeeTypeManager && (input.Department == nil || *input.Department == "") {
 		return EmployeeResultUnion{}, errors.New("managers must have a department")
 	}
+	if input.ManagerID != nil {
+		if _, err := h.activeManager(ctx, *input.ManagerID); err != nil {
+			return EmployeeResultUnion{}, err
+		}
+	}
 
-	employeeMux.Lock()
-	defer employeeMux.Unlock()
-
-	nextID := nextEmpID
-	nextEmpID++
-
+	var emp *Employee
 	switch input.Type {
 	case EmployeeTypeDeveloper:
-		dev := NewDeveloper(
-			nextID,
+		emp = &NewDeveloper(
+			0,
 			input.Name,
 			input.Email,
 			input.Salary,
 			time.Now().Format("2006-01-02"),
 			input.ProgrammingLanguages,
 			input.GithubUsername,
-		)
-		employees = append(employees, dev)
-		return EmployeeResultUnion{Developer: dev}, nil
+		).Employee
 	case EmployeeTypeManager:
-		mgr := NewManager(
-			nextID,
+		emp = &NewManager(
+			0,
 			input.Name,
 			input.Email,
 			input.Salary,
 			time.Now().Format("2006-01-02"),
 			*input.Department,
-			0, // Start with no reports
-		)
-		employees = append(employees, mgr)
-		return EmployeeResultUnion{Manager: mgr}, nil
+		).Employee
 	default:
 		return EmployeeResultUnion{}, fmt.Errorf("invalid employee type: %s", input.Type)
 	}
+	emp.ManagerID = input.ManagerID
+
+	created, err := h.store.Employees().Create(ctx, emp)
+	if err != nil {
+		return EmployeeResultUnion{}, err
+	}
+	h.recordRoleChange(ctx, nil, created, time.Now().UTC())
+	created.scope = newScope(ctx, h.store)
+
+	h.store.Events().BroadcastEmployeeUpdate(ctx, created, "created")
+
+	switch e := created.ActualType().(type) {
+	case *Developer:
+		return EmployeeResultUnion{Developer: e}, nil
+	case *Manager:
+		return EmployeeResultUnion{Manager: e}, nil
+	}
+	return EmployeeResultUnion{}, fmt.Errorf("invalid employee type: %s", input.Type)
+}
+
+// PromoteToManager mutation - demonstrates type transformation. It is
+// ChangeEmployeeRole to MANAGER for developers, so it is admin-only too.
+// version must be the employee version the client last read
+func (h *EmployeeHandlers) PromoteToManager(ctx context.Context, employeeId int, department string, version int) (*Manager, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	emp, err := h.store.Employees().Get(ctx, employeeId)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("developer with id %d not found", employeeId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := quickgraph.Discover[*Developer](emp); !ok || emp.isDeleted() {
+		return nil, fmt.Errorf("developer with id %d not found", employeeId)
+	}
+
+	promoted, err := h.changeRole(ctx, emp, roleChange{targetType: EmployeeTypeManager, department: &department, version: version})
+	if err != nil {
+		return nil, err
+	}
+	mgr, _ := quickgraph.Discover[*Manager](promoted)
+	return mgr, nil
 }
 
-// PromoteToManager mutation - demonstrates type transformation
-func PromoteToManager(employeeId int, department string) (*Manager, error) {
-	employeeMux.Lock()
-	defer employeeMux.Unlock()
-
-	for i, emp := range employees {
-		if dev, ok := emp.(*Developer); ok && dev.ID == employeeId {
-			// Create new manager from developer
-			mgr := &Manager{
-				Employee:   dev.Employee,
-				Department: department,
-				TeamSize:   0,
-			}
-			mgr.Salary *= 1.2 // 20% raise with promotion
-
-			employees[i] = mgr
-			return mgr, nil
+// TerminateEmployee soft-deletes an employee, recording when and by whom.
+// Only admins can terminate employees.
+func (h *EmployeeHandlers) TerminateEmployee(ctx context.Context, employeeId int) (*Employee, error) {
+	user, err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	emp, err := h.store.Employees().Get(ctx, employeeId)
+	if errors.Is(err, ErrNotFound) || (err == nil && emp.isDeleted()) {
+		return nil, fmt.Errorf("employee with id %d not found", employeeId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	emp.DeletedAt, emp.DeletedBy = deletionStamp(user)
+	emp, err = h.store.Employees().Update(ctx, emp)
+	if err != nil {
+		return nil, err
+	}
+	emp.scope = newScope(ctx, h.store)
+
+	h.store.Events().BroadcastEmployeeUpdate(ctx, emp, "terminated")
+
+	return emp, nil
+}
+
+// RestoreEmployee undoes TerminateEmployee. Only admins can restore.
+func (h *EmployeeHandlers) RestoreEmployee(ctx context.Context, employeeId int) (*Employee, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	emp, err := h.store.Employees().Get(ctx, employeeId)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("employee with id %d not found", employeeId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !emp.isDeleted() {
+		return nil, fmt.Errorf("employee %d is not terminated", employeeId)
+	}
+
+	emp.DeletedAt, emp.DeletedBy = nil, nil
+	emp, err = h.store.Employees().Update(ctx, emp)
+	if err != nil {
+		return nil, err
+	}
+	emp.scope = newScope(ctx, h.store)
+
+	h.store.Events().BroadcastEmployeeUpdate(ctx, emp, "restored")
+
+	return emp, nil
+}
+
+// cloneEmployee returns a deep copy of emp whose type discovery points at the
+// copy rather than at the original *Developer or *Manager.
+func cloneEmployee(emp *Employee) *Employee {
+	switch e := emp.ActualType().(type) {
+	case *Developer:
+		var github *string
+		if e.GithubUsername != nil {
+			github = strPtr(*e.GithubUsername)
 		}
+		languages := append([]string(nil), e.ProgrammingLanguages...)
+		d := NewDeveloper(e.ID, e.Name, e.Email, e.Salary, e.HireDate, languages, github)
+		d.Version, d.ManagerID = e.Version, cloneInt(e.ManagerID)
+		d.DeletedAt, d.DeletedBy = cloneTime(e.DeletedAt), cloneInt(e.DeletedBy)
+		return &d.Employee
+	case *Manager:
+		m := NewManager(e.ID, e.Name, e.Email, e.Salary, e.HireDate, e.Department)
+		m.Version, m.ManagerID = e.Version, cloneInt(e.ManagerID)
+		m.DeletedAt, m.DeletedBy = cloneTime(e.DeletedAt), cloneInt(e.DeletedBy)
+		return &m.Employee
+	default:
+		c := *emp
+		c.actualType = nil
+		c.scope = nil
+		return &c
 	}
+}
+
+// bindEmployees attaches sc to each employee so its field resolvers work.
+func bindEmployees(sc *scope, employees []*Employee) []*Employee {
+	for _, emp := range employees {
+		emp.scope = sc
+	}
+	return employees
+}
 
-	return nil, fmt.Errorf("developer with id %d not found", employeeId)
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+func cloneInt(i *int) *int {
+	if i == nil {
+		return nil
+	}
+	c := *i
+	return &c
 }
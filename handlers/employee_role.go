@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// An employee's role is the type it is stored as, plus the department of a
+// manager. Changing it always rebuilds the employee with NewDeveloper or
+// NewManager, so type discovery resolves the new type. Every role an
+// employee is hired into or moved to is recorded as an EmployeeRoleChange.
+
+// EmployeeRoleChange is one entry of the role history of an employee.
+type EmployeeRoleChange struct {
+	ID             int           `json:"id"`
+	EmployeeID     int           `json:"employeeId"`
+	FromType       *EmployeeType `json:"fromType"` // Unset for the role the employee was hired into
+	ToType         EmployeeType  `json:"toType"`
+	FromDepartment *string       `json:"fromDepartment"` // Set if the previous role was a manager
+	ToDepartment   *string       `json:"toDepartment"`   // Set if the new role is a manager
+	UserID         *int          `json:"userId"`         // Acting user, if authenticated
+	ChangedAt      time.Time     `json:"changedAt"`
+}
+
+// promotionRaise is the salary factor of a developer promoted to manager
+// when no salary is given.
+const promotionRaise = 1.2
+
+// roleChange describes the role an employee is moved to.
+type roleChange struct {
+	targetType           EmployeeType
+	department           *string  // For managers
+	programmingLanguages []string // For developers
+	githubUsername       *string  // For developers
+	salary               *float64 // Kept, or raised on promotion, when nil
+	version              int      // Employee version the client last read
+}
+
+// ChangeEmployeeRole promotes a developer to manager of department, demotes
+// a manager to developer with programmingLanguages, or transfers a manager
+// to another department. Promotions come with a 20% raise unless salary is
+// given; otherwise the salary is kept unless given. Managers with reports
+// must hand them over with ReassignReports before they are demoted. version
+// must be the employee version the client last read. Only admins can change
+// roles.
+func (h *EmployeeHandlers) ChangeEmployeeRole(ctx context.Context, employeeId int, targetType EmployeeType, department *string, programmingLanguages *[]string, githubUsername *string, salary *float64, version int) (*Employee, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	emp, err := h.store.Employees().Get(ctx, employeeId)
+	if errors.Is(err, ErrNotFound) || (err == nil && emp.isDeleted()) {
+		return nil, fmt.Errorf("employee with id %d not found", employeeId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	change := roleChange{targetType: targetType, department: department, githubUsername: githubUsername, salary: salary, version: version}
+	// A pointer keeps the list optional; quickgraph requires plain slices
+	if programmingLanguages != nil {
+		change.programmingLanguages = *programmingLanguages
+	}
+	return h.changeRole(ctx, emp, change)
+}
+
+// changeRole rebuilds emp in its new role, stores it, records the change in
+// the role history and broadcasts it.
+func (h *EmployeeHandlers) changeRole(ctx context.Context, emp *Employee, change roleChange) (*Employee, error) {
+	from, err := newEmployeeRecord(emp)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed *Employee
+	var action string
+	switch change.targetType {
+	case EmployeeTypeManager:
+		if change.department == nil || *change.department == "" {
+			return nil, errors.New("managers must have a department")
+		}
+		if from.Type == EmployeeTypeManager && from.Department == *change.department {
+			return nil, fmt.Errorf("employee %d already manages %s", emp.ID, from.Department)
+		}
+		mgr := NewManager(emp.ID, emp.Name, emp.Email, emp.Salary, emp.HireDate, *change.department)
+		action = "transferred"
+		if from.Type == EmployeeTypeDeveloper {
+			mgr.Salary *= promotionRaise
+			action = "promoted"
+		}
+		changed = &mgr.Employee
+	case EmployeeTypeDeveloper:
+		if from.Type == EmployeeTypeDeveloper {
+			return nil, fmt.Errorf("employee %d is already a developer", emp.ID)
+		}
+		if len(change.programmingLanguages) == 0 {
+			return nil, errors.New("developers must have at least one programming language")
+		}
+		reports, err := h.store.Employees().ListByManagers(ctx, []int{emp.ID})
+		if err != nil {
+			return nil, err
+		}
+		if len(reports) > 0 {
+			return nil, fmt.Errorf("manager %d still has %d reports; move them with ReassignReports first", emp.ID, len(reports))
+		}
+		changed = &NewDeveloper(emp.ID, emp.Name, emp.Email, emp.Salary, emp.HireDate, change.programmingLanguages, change.githubUsername).Employee
+		action = "demoted"
+	default:
+		return nil, fmt.Errorf("invalid employee type: %s", change.targetType)
+	}
+	if change.salary != nil {
+		if *change.salary <= 0 {
+			return nil, errors.New("salary must be greater than zero")
+		}
+		changed.Salary = *change.salary
+	}
+	changed.ManagerID, changed.Version = emp.ManagerID, change.version
+
+	stored, err := h.store.Employees().Update(ctx, changed)
+	if err != nil {
+		return nil, err
+	}
+	h.recordRoleChange(ctx, &from, stored, time.Now().UTC())
+	stored.scope = newScope(ctx, h.store)
+
+	h.store.Events().BroadcastEmployeeUpdate(ctx, stored, action)
+
+	return stored, nil
+}
+
+// recordRoleChange appends a history entry for the role employee was just
+// stored with. from is nil when the employee was just hired. The employee
+// has already been committed, so a failure is logged rather than failing
+// the request.
+func (h *EmployeeHandlers) recordRoleChange(ctx context.Context, from *EmployeeRecord, employee *Employee, changedAt time.Time) {
+	to, err := newEmployeeRecord(employee)
+	if err != nil {
+		log.Printf("Failed to record role of employee %d: %v", employee.ID, err)
+		return
+	}
+	entry := EmployeeRoleChange{EmployeeID: employee.ID, ToType: to.Type, ToDepartment: managerDepartment(to), ChangedAt: changedAt}
+	if from != nil {
+		entry.FromType, entry.FromDepartment = &from.Type, managerDepartment(*from)
+	}
+	if user, ok := ctx.Value(UserContextKey).(*User); ok && user != nil {
+		entry.UserID = &user.ID
+	}
+	if _, err := h.store.EmployeeRoleHistory().Append(ctx, entry); err != nil {
+		log.Printf("Failed to record role of employee %d: %v", employee.ID, err)
+	}
+}
+
+// managerDepartment returns the department of a manager record, nil for
+// developers.
+func managerDepartment(r EmployeeRecord) *string {
+	if r.Type != EmployeeTypeManager {
+		return nil
+	}
+	return &r.Department
+}
+
+// RoleHistory returns the role changes of the employee, oldest first.
+// Employees from before the history was recorded start without an entry
+// for the role they were hired into.
+func (e *Employee) RoleHistory() ([]EmployeeRoleChange, error) {
+	history, err := e.scope.store.EmployeeRoleHistory().ListByEmployee(e.scope.ctx, e.ID)
+	if history == nil {
+		history = []EmployeeRoleChange{}
+	}
+	return history, err
+}
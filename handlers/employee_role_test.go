@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangeEmployeeRole(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		graph := newTestGraph(ctx, store)
+		admin, john := userContext(t, store, 1), userContext(t, store, 2)
+		updates := NewSubscriptionHandlers(store).EmployeeUpdates(ctx, intPtr(1))
+
+		for _, tc := range []struct {
+			ctx           context.Context
+			request, want string
+		}{
+			{john, `mutation { ChangeEmployeeRole(employeeId: 1, targetType: "MANAGER", department: "Platform", version: 1) { ID } }`, "admin role required"},
+
+			// A promotion comes with a raise and changes the discovered type
+			{admin, `mutation { ChangeEmployeeRole(employeeId: 1, targetType: "MANAGER", department: "Platform", version: 1) { __typename Salary } }`,
+				`"ChangeEmployeeRole":{"Salary":144000,"__typename":"Manager"}`},
+			{john, `{ GetEmployee(id: 1) { __typename managerId ... on Manager { Department } } }`,
+				`"Department":"Platform","__typename":"Manager","managerId":2`},
+
+			// A transfer keeps the salary
+			{admin, `mutation { ChangeEmployeeRole(employeeId: 1, targetType: "MANAGER", department: "Research", version: 2) { Salary ... on Manager { Department } } }`,
+				`"Department":"Research","Salary":144000`},
+			{admin, `mutation { ChangeEmployeeRole(employeeId: 1, targetType: "MANAGER", department: "Research", version: 3) { ID } }`,
+				"employee 1 already manages Research"},
+
+			// Demotions need languages, and no reports left behind
+			{admin, `mutation { ChangeEmployeeRole(employeeId: 2, targetType: "DEVELOPER", programmingLanguages: ["Go"], version: 1) { ID } }`,
+				"manager 2 still has 2 reports; move them with ReassignReports first"},
+			{admin, `mutation { ChangeEmployeeRole(employeeId: 1, targetType: "DEVELOPER", version: 3) { ID } }`,
+				"developers must have at least one programming language"},
+			{admin, `mutation { ChangeEmployeeRole(employeeId: 1, targetType: "DEVELOPER", programmingLanguages: ["Go"], salary: 0, version: 3) { ID } }`,
+				"salary must be greater than zero"},
+			{admin, `mutation { ChangeEmployeeRole(employeeId: 1, targetType: "DEVELOPER", programmingLanguages: ["Go"], version: 2) { ID } }`,
+				"modified concurrently"},
+			{admin, `mutation { ChangeEmployeeRole(employeeId: 1, targetType: "DEVELOPER", programmingLanguages: ["Go", "Zig"], salary: 125000, version: 3) { __typename Salary managerId ... on Developer { ProgrammingLanguages } } }`,
+				`"ChangeEmployeeRole":{"ProgrammingLanguages":["Go","Zig"],"Salary":125000,"__typename":"Developer","managerId":2}`},
+			{admin, `mutation { ChangeEmployeeRole(employeeId: 1, targetType: "DEVELOPER", programmingLanguages: ["Go"], version: 4) { ID } }`,
+				"employee 1 is already a developer"},
+			{admin, `mutation { ChangeEmployeeRole(employeeId: 1, targetType: "INTERN", version: 4) { ID } }`, "invalid employee type: INTERN"},
+
+			{john, `{ GetEmployee(id: 1) { RoleHistory { fromType toType fromDepartment toDepartment userId } } }`,
+				`"RoleHistory":[` +
+					`{"fromDepartment":null,"fromType":"DEVELOPER","toDepartment":"Platform","toType":"MANAGER","userId":1},` +
+					`{"fromDepartment":"Platform","fromType":"MANAGER","toDepartment":"Research","toType":"MANAGER","userId":1},` +
+					`{"fromDepartment":"Research","fromType":"MANAGER","toDepartment":null,"toType":"DEVELOPER","userId":1}]`},
+
+			// PromoteToManager and CreateEmployee are recorded too
+			{john, `mutation { PromoteToManager(employeeId: 3, department: "DevOps", version: 1) { Department } }`, "admin role required"},
+			{admin, `mutation { PromoteToManager(employeeId: 3, department: "DevOps", version: 1) { Department } }`, `"Department":"DevOps"`},
+			{john, `{ GetEmployee(id: 3) { __typename RoleHistory { fromType toType userId } } }`,
+				`"RoleHistory":[{"fromType":"DEVELOPER","toType":"MANAGER","userId":1}],"__typename":"Manager"`},
+			{admin, `mutation { PromoteToManager(employeeId: 9, department: "DevOps", version: 1) { ID } }`, "developer with id 9 not found"},
+			{john, `mutation { CreateEmployee(input: {name: "Eve", email: "eve@example.com", salary: 90000, type: DEVELOPER, programmingLanguages: ["Go"]}) { ... on Developer { RoleHistory { fromType toType } } } }`,
+				`"RoleHistory":[{"fromType":null,"toType":"DEVELOPER"}]`},
+		} {
+			if res, _ := graph.ProcessRequest(tc.ctx, tc.request, ""); !strings.Contains(res, tc.want) {
+				t.Errorf("%s: expected %s, got %s", tc.request, tc.want, res)
+			}
+		}
+
+		// Only the three successful changes of employee 1 were broadcast
+		for _, want := range []string{"promoted", "transferred", "demoted"} {
+			select {
+			case update := <-updates:
+				if update.Action != want || update.Employee.ID != 1 {
+					t.Errorf("expected employee 1 to be %s, got %s of %d", want, update.Action, update.Employee.ID)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expected employee 1 to be %s", want)
+			}
+		}
+		select {
+		case update := <-updates:
+			t.Errorf("unexpected update %+v", update)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+// failingRoleHistoryStore is a Store whose role history rejects every entry.
+type failingRoleHistoryStore struct{ Store }
+
+func (s failingRoleHistoryStore) EmployeeRoleHistory() EmployeeRoleHistoryRepository {
+	return failingRoleHistory{s.Store.EmployeeRoleHistory()}
+}
+
+type failingRoleHistory struct{ EmployeeRoleHistoryRepository }
+
+func (failingRoleHistory) Append(ctx context.Context, entry EmployeeRoleChange) (EmployeeRoleChange, error) {
+	return EmployeeRoleChange{}, errors.New("disk full")
+}
+
+func TestRoleHistoryFailureDoesNotFailRoleChange(t *testing.T) {
+	forEachStore(t, func(t *testing.T, base Store) {
+		ctx := context.Background()
+		h := NewEmployeeHandlers(failingRoleHistoryStore{base})
+
+		changed, err := h.ChangeEmployeeRole(userContext(t, base, 1), 1, EmployeeTypeManager, strPtr("Platform"), nil, nil, nil, 1)
+		if err != nil {
+			t.Fatalf("expected the committed change to be returned, got %v", err)
+		}
+		stored, err := base.Employees().Get(ctx, changed.ID)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if _, ok := stored.ActualType().(*Manager); !ok {
+			t.Errorf("expected the employee to be stored as a Manager, got %T", stored.ActualType())
+		}
+	})
+}
+
+func TestEmployeeUpdatesResolveForTheSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	user, err := store.Users().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get admin failed: %v", err)
+	}
+	admin := context.WithValue(ctx, UserContextKey, &user)
+	h := NewEmployeeHandlers(store)
+	if _, err := h.TerminateEmployee(admin, 2); err != nil {
+		t.Fatalf("TerminateEmployee failed: %v", err)
+	}
+	updates := NewSubscriptionHandlers(store).EmployeeUpdates(ctx, intPtr(1))
+
+	if _, err := h.ChangeEmployeeRole(admin, 1, EmployeeTypeManager, strPtr("Platform"), nil, nil, nil, 1); err != nil {
+		t.Fatalf("ChangeEmployeeRole failed: %v", err)
+	}
+	select {
+	case update := <-updates:
+		// Jane is terminated, which only the admin making the change may see
+		if mgr, err := update.Employee.Manager(); err != nil || mgr != nil {
+			t.Errorf("expected the terminated manager to be hidden from the subscriber, got %v, %v", mgr, err)
+		}
+		if _, ok := update.Employee.ActualType().(*Manager); !ok {
+			t.Errorf("expected a Manager, got %T", update.Employee.ActualType())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected an employee update")
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"testing"
 
 	"github.com/gburgyan/go-quickgraph"
@@ -26,7 +27,6 @@ func TestEmployeeTypeDiscovery(t *testing.T) {
 		120000,
 		"2022-01-01",
 		"Engineering",
-		5,
 	)
 
 	// Test Developer type discovery
@@ -79,9 +79,6 @@ func TestEmployeeTypeDiscovery(t *testing.T) {
 		if discovered.Department != "Engineering" {
 			t.Errorf("Expected Department to be Engineering, got %s", discovered.Department)
 		}
-		if discovered.TeamSize != 5 {
-			t.Errorf("Expected TeamSize to be 5, got %d", discovered.TeamSize)
-		}
 	})
 
 	// Test wrong type discovery
@@ -145,6 +142,9 @@ func TestEmployeeActualType(t *testing.T) {
 }
 
 func TestCreateEmployeeWithTypeDiscovery(t *testing.T) {
+	ctx := context.Background()
+	h := NewEmployeeHandlers(NewMemoryStore())
+
 	// Test creating Developer
 	t.Run("Create Developer", func(t *testing.T) {
 		input := EmployeeInput{
@@ -155,7 +155,7 @@ func TestCreateEmployeeWithTypeDiscovery(t *testing.T) {
 			ProgrammingLanguages: []string{"Go", "Rust"},
 		}
 
-		result, err := CreateEmployee(input)
+		result, err := h.CreateEmployee(ctx, input)
 		if err != nil {
 			t.Fatalf("CreateEmployee failed: %v", err)
 		}
@@ -189,7 +189,7 @@ func TestCreateEmployeeWithTypeDiscovery(t *testing.T) {
 			Department: &dept,
 		}
 
-		result, err := CreateEmployee(input)
+		result, err := h.CreateEmployee(ctx, input)
 		if err != nil {
 			t.Fatalf("CreateEmployee failed: %v", err)
 		}
@@ -0,0 +1,753 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventType names what an Event records. Each write produces one event, e.g.
+// DeleteProduct produces ProductUpdated. Soft deletes and restores are
+// recorded as updates. A mutation that writes twice, like UpdateWidget
+// changing the quantity (WidgetUpdated, then StockRecorded for the ledger) or
+// UpdateProductStatus (ProductUpdated, then ProductStatusRecorded), produces
+// one event per write.
+type EventType string
+
+const (
+	EventCategoryCreated EventType = "CategoryCreated"
+	EventCategoryUpdated EventType = "CategoryUpdated"
+	EventProductCreated  EventType = "ProductCreated"
+	EventProductUpdated  EventType = "ProductUpdated"
+	EventReviewCreated   EventType = "ReviewCreated"
+	EventReviewUpdated   EventType = "ReviewUpdated"
+	EventUserCreated     EventType = "UserCreated"
+	EventWidgetCreated   EventType = "WidgetCreated"
+	EventWidgetUpdated   EventType = "WidgetUpdated"
+	// Stock events carry a StockEntry. WidgetStockAdjusted applies its delta
+	// to the widget, StockRecorded only appends it to the ledger.
+	EventWidgetStockAdjusted EventType = "WidgetStockAdjusted"
+	EventStockRecorded       EventType = "StockRecorded"
+	// EventProductStatusRecorded carries a ProductStatusChange
+	EventProductStatusRecorded EventType = "ProductStatusRecorded"
+	// EventProductPriceRecorded carries a ProductPriceChange
+	EventProductPriceRecorded EventType = "ProductPriceRecorded"
+	// EventEmployeeRoleRecorded carries an EmployeeRoleChange
+	EventEmployeeRoleRecorded EventType = "EmployeeRoleRecorded"
+	// Variant events carry the ProductVariant
+	EventProductVariantCreated EventType = "ProductVariantCreated"
+	EventProductVariantUpdated EventType = "ProductVariantUpdated"
+	EventProductVariantDeleted EventType = "ProductVariantDeleted"
+	// Order events carry the Order. OrderPlaced and OrderCancelled also move
+	// the stock of its products, variants and widgets.
+	EventOrderCreated   EventType = "OrderCreated"
+	EventOrderPlaced    EventType = "OrderPlaced"
+	EventOrderUpdated   EventType = "OrderUpdated"
+	EventOrderCancelled EventType = "OrderCancelled"
+	// EventCartSaved carries the Cart
+	EventCartSaved       EventType = "CartSaved"
+	EventEmployeeCreated EventType = "EmployeeCreated"
+	EventEmployeeUpdated EventType = "EmployeeUpdated"
+	// EventReportsReassigned carries the moved employees as EmployeeRecords
+	EventReportsReassigned EventType = "ReportsReassigned"
+	// EventStoreRestored replaces all state, e.g. after RestoreSnapshot
+	EventStoreRestored EventType = "StoreRestored"
+)
+
+// Event is one entry of the event log. Data holds the complete entity as it
+// was stored (a Widget, Product, Review, EmployeeRecord, ...), so replaying
+// never depends on handler logic or on the clock.
+type Event struct {
+	Seq    int64           `json:"seq"`
+	Type   EventType       `json:"type"`
+	Time   time.Time       `json:"time"`
+	UserID *int            `json:"userId,omitempty"` // Acting user, if authenticated
+	Data   json.RawMessage `json:"data"`
+}
+
+// EventLog is an append-only file of JSON-encoded events, one per line.
+// Every append is synced to disk before it returns.
+type EventLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	lastSeq int64
+}
+
+// OpenEventLog opens (or creates) the event log at path for appending. A
+// torn final line left by a crash mid-write is cut off first.
+func OpenEventLog(path string) (*EventLog, error) {
+	events, validSize, err := readEventLog(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(validSize); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	l := &EventLog{file: file}
+	if len(events) > 0 {
+		l.lastSeq = events[len(events)-1].Seq
+	}
+	return l, nil
+}
+
+// Close closes the log file.
+func (l *EventLog) Close() error {
+	return l.file.Close()
+}
+
+// Empty reports whether no event has been appended yet.
+func (l *EventLog) Empty() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastSeq == 0
+}
+
+// Append records an event of type eventType carrying data. The acting user
+// is taken from ctx.
+func (l *EventLog) Append(ctx context.Context, eventType EventType, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event := Event{Seq: l.lastSeq + 1, Type: eventType, Time: time.Now().UTC(), Data: raw}
+	if user, ok := ctx.Value(UserContextKey).(*User); ok && user != nil {
+		event.UserID = &user.ID
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	offset, err := l.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to append event: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		l.discardFrom(offset)
+		return fmt.Errorf("failed to append event: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		l.discardFrom(offset)
+		return fmt.Errorf("failed to sync event log: %w", err)
+	}
+	l.lastSeq = event.Seq
+	return nil
+}
+
+// discardFrom cuts off whatever part of a failed append reached the file,
+// so the event is not replayed. This is best effort: the file may no longer
+// be writable at all.
+func (l *EventLog) discardFrom(offset int64) {
+	if err := l.file.Truncate(offset); err == nil {
+		l.file.Seek(offset, io.SeekStart)
+	}
+}
+
+// ReadEventLog returns every event in the log at path, oldest first.
+func ReadEventLog(path string) ([]Event, error) {
+	events, _, err := readEventLog(path)
+	return events, err
+}
+
+// readEventLog parses the log at path. It also returns the size of the
+// intact prefix: a final line without a newline is a torn write and is
+// neither returned nor counted.
+func readEventLog(path string) ([]Event, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var events []Event
+	var size int64
+	for lineNo := 1; ; lineNo++ {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			break // Empty or torn final line
+		}
+		var event Event
+		if err := json.Unmarshal(data[:end], &event); err != nil {
+			return nil, 0, fmt.Errorf("%s:%d: invalid event: %w", path, lineNo, err)
+		}
+		events = append(events, event)
+		size += int64(end + 1)
+		data = data[end+1:]
+	}
+	return events, size, nil
+}
+
+// ReplayEventLog rebuilds state by applying every event in the log at path
+// to store, which must be empty. It returns the number of events applied.
+func ReplayEventLog(ctx context.Context, path string, store Store) (int, error) {
+	events, err := ReadEventLog(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, event := range events {
+		if err := applyEvent(ctx, store, event); err != nil {
+			return 0, fmt.Errorf("failed to replay event %d (%s): %w", event.Seq, event.Type, err)
+		}
+	}
+	return len(events), nil
+}
+
+func applyEvent(ctx context.Context, store Store, event Event) error {
+	switch event.Type {
+	case EventCategoryCreated:
+		return applyEventData(event, func(c Category) error {
+			_, err := store.Categories().Create(ctx, c)
+			return err
+		})
+	case EventCategoryUpdated:
+		return applyEventData(event, func(c Category) error {
+			if c.Version == 0 {
+				// Logged before categories had versions
+				current, err := store.Categories().Get(ctx, c.ID)
+				if err != nil {
+					return err
+				}
+				c.Version = current.Version
+			} else {
+				c.Version--
+			}
+			_, err := store.Categories().Update(ctx, c)
+			return err
+		})
+	case EventProductCreated:
+		return applyEventData(event, func(p Product) error {
+			_, err := store.Products().Create(ctx, p)
+			return err
+		})
+	case EventProductUpdated:
+		return applyEventData(event, func(p Product) error {
+			p.Version-- // Events carry the version after the update
+			_, err := store.Products().Update(ctx, p)
+			return err
+		})
+	case EventReviewCreated:
+		return applyEventData(event, func(r Review) error {
+			_, err := store.Reviews().Create(ctx, r)
+			return err
+		})
+	case EventReviewUpdated:
+		return applyEventData(event, func(r Review) error {
+			_, err := store.Reviews().Update(ctx, r)
+			return err
+		})
+	case EventUserCreated:
+		return applyEventData(event, func(u User) error {
+			_, err := store.Users().Create(ctx, u)
+			return err
+		})
+	case EventWidgetCreated:
+		return applyEventData(event, func(w Widget) error {
+			_, err := store.Widgets().Create(ctx, w)
+			return err
+		})
+	case EventWidgetUpdated:
+		return applyEventData(event, func(w Widget) error {
+			w.Version--
+			_, err := store.Widgets().Update(ctx, w)
+			return err
+		})
+	case EventWidgetStockAdjusted:
+		return applyEventData(event, func(e StockEntry) error {
+			_, _, err := store.Widgets().AdjustStock(ctx, e)
+			return err
+		})
+	case EventStockRecorded:
+		return applyEventData(event, func(e StockEntry) error {
+			_, err := store.StockLedger().Append(ctx, e)
+			return err
+		})
+	case EventProductStatusRecorded:
+		return applyEventData(event, func(c ProductStatusChange) error {
+			_, err := store.ProductStatusHistory().Append(ctx, c)
+			return err
+		})
+	case EventProductPriceRecorded:
+		return applyEventData(event, func(c ProductPriceChange) error {
+			_, err := store.ProductPriceHistory().Append(ctx, c)
+			return err
+		})
+	case EventEmployeeRoleRecorded:
+		return applyEventData(event, func(c EmployeeRoleChange) error {
+			_, err := store.EmployeeRoleHistory().Append(ctx, c)
+			return err
+		})
+	case EventProductVariantCreated:
+		return applyEventData(event, func(v ProductVariant) error {
+			_, err := store.ProductVariants().Create(ctx, v)
+			return err
+		})
+	case EventProductVariantUpdated:
+		return applyEventData(event, func(v ProductVariant) error {
+			v.Version--
+			_, err := store.ProductVariants().Update(ctx, v)
+			return err
+		})
+	case EventProductVariantDeleted:
+		return applyEventData(event, func(v ProductVariant) error {
+			_, err := store.ProductVariants().Delete(ctx, v.ID)
+			return err
+		})
+	case EventOrderCreated:
+		return applyEventData(event, func(o Order) error {
+			_, err := store.Orders().Create(ctx, o)
+			return err
+		})
+	case EventOrderPlaced:
+		return applyEventData(event, func(o Order) error {
+			_, err := store.Orders().Place(ctx, o)
+			return err
+		})
+	case EventOrderUpdated:
+		return applyEventData(event, func(o Order) error {
+			o.Version--
+			_, err := store.Orders().Update(ctx, o)
+			return err
+		})
+	case EventOrderCancelled:
+		return applyEventData(event, func(o Order) error {
+			o.Version--
+			_, err := store.Orders().Cancel(ctx, o)
+			return err
+		})
+	case EventCartSaved:
+		return applyEventData(event, func(c Cart) error {
+			c.Version--
+			_, err := store.Carts().Put(ctx, c)
+			return err
+		})
+	case EventEmployeeCreated, EventEmployeeUpdated:
+		return applyEventData(event, func(r EmployeeRecord) error {
+			emp, err := r.Employee()
+			if err != nil {
+				return err
+			}
+			if event.Type == EventEmployeeCreated {
+				_, err = store.Employees().Create(ctx, emp)
+			} else {
+				emp.Version--
+				_, err = store.Employees().Update(ctx, emp)
+			}
+			return err
+		})
+	case EventReportsReassigned:
+		return applyEventData(event, func(records []EmployeeRecord) error {
+			for _, r := range records {
+				emp, err := r.Employee()
+				if err != nil {
+					return err
+				}
+				emp.Version--
+				if _, err := store.Employees().Update(ctx, emp); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case EventStoreRestored:
+		return applyEventData(event, func(snap Snapshot) error {
+			return store.Restore(ctx, &snap)
+		})
+	default:
+		return fmt.Errorf("unknown event type %q", event.Type)
+	}
+}
+
+func applyEventData[T any](event Event, apply func(T) error) error {
+	var v T
+	if err := json.Unmarshal(event.Data, &v); err != nil {
+		return err
+	}
+	return apply(v)
+}
+
+// EventLogStore is a Store decorator that appends an event to an EventLog
+// for every write that succeeds on the underlying store. Writes are
+// serialized so the log order always matches the order they were applied.
+//
+// Entities get their IDs from the underlying store, so an event can only be
+// appended after its write. If that append fails, the store no longer
+// matches the log and every later write is refused with ErrEventLogFailed.
+type EventLogStore struct {
+	Store
+	log    *EventLog
+	mu     sync.Mutex
+	failed error // The append error that stopped all writes, if any
+}
+
+// ErrEventLogFailed is returned for the write whose event could not be
+// appended and for every write after it, until the server is restarted.
+var ErrEventLogFailed = errors.New("event log failed; writes are disabled until restart")
+
+// NewEventLogStore wraps store so that all writes are recorded in log.
+func NewEventLogStore(store Store, log *EventLog) *EventLogStore {
+	return &EventLogStore{Store: store, log: log}
+}
+
+func (s *EventLogStore) Widgets() WidgetRepository {
+	return loggedWidgets{s.Store.Widgets(), s}
+}
+
+func (s *EventLogStore) Products() ProductRepository {
+	return loggedProducts{s.Store.Products(), s}
+}
+
+func (s *EventLogStore) Categories() CategoryRepository {
+	return loggedCategories{s.Store.Categories(), s}
+}
+
+func (s *EventLogStore) Reviews() ReviewRepository {
+	return loggedReviews{s.Store.Reviews(), s}
+}
+
+func (s *EventLogStore) Users() UserRepository {
+	return loggedUsers{s.Store.Users(), s}
+}
+
+func (s *EventLogStore) Employees() EmployeeRepository {
+	return loggedEmployees{s.Store.Employees(), s}
+}
+
+func (s *EventLogStore) StockLedger() StockLedgerRepository {
+	return loggedStockLedger{s.Store.StockLedger(), s}
+}
+
+func (s *EventLogStore) ProductStatusHistory() ProductStatusHistoryRepository {
+	return loggedStatusHistory{s.Store.ProductStatusHistory(), s}
+}
+
+func (s *EventLogStore) Orders() OrderRepository {
+	return loggedOrders{s.Store.Orders(), s}
+}
+
+func (s *EventLogStore) Carts() CartRepository {
+	return loggedCarts{s.Store.Carts(), s}
+}
+
+func (s *EventLogStore) ProductPriceHistory() ProductPriceHistoryRepository {
+	return loggedPriceHistory{s.Store.ProductPriceHistory(), s}
+}
+
+func (s *EventLogStore) EmployeeRoleHistory() EmployeeRoleHistoryRepository {
+	return loggedRoleHistory{s.Store.EmployeeRoleHistory(), s}
+}
+
+func (s *EventLogStore) ProductVariants() ProductVariantRepository {
+	return loggedVariants{s.Store.ProductVariants(), s}
+}
+
+// Restore records the whole snapshot, so replay reproduces the rollback.
+func (s *EventLogStore) Restore(ctx context.Context, snapshot *Snapshot) error {
+	_, err := logged(ctx, s, EventStoreRestored, func() (*Snapshot, error) {
+		return snapshot, s.Store.Restore(ctx, snapshot)
+	})
+	return err
+}
+
+// logged runs write and, if it succeeds, appends an event carrying the
+// stored entity. A failed append disables all further writes, see
+// EventLogStore.
+func logged[T any](ctx context.Context, s *EventLogStore, eventType EventType, write func() (T, error)) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failed != nil {
+		var zero T
+		return zero, fmt.Errorf("%w: %v", ErrEventLogFailed, s.failed)
+	}
+	v, err := write()
+	if err != nil {
+		return v, err
+	}
+	if err := s.log.Append(ctx, eventType, v); err != nil {
+		s.failed = err
+		var zero T
+		return zero, fmt.Errorf("%w: %v", ErrEventLogFailed, err)
+	}
+	return v, nil
+}
+
+type loggedWidgets struct {
+	WidgetRepository
+	s *EventLogStore
+}
+
+func (r loggedWidgets) Create(ctx context.Context, widget Widget) (Widget, error) {
+	return logged(ctx, r.s, EventWidgetCreated, func() (Widget, error) { return r.WidgetRepository.Create(ctx, widget) })
+}
+
+func (r loggedWidgets) Update(ctx context.Context, widget Widget) (Widget, error) {
+	return logged(ctx, r.s, EventWidgetUpdated, func() (Widget, error) { return r.WidgetRepository.Update(ctx, widget) })
+}
+
+func (r loggedWidgets) AdjustStock(ctx context.Context, entry StockEntry) (Widget, StockEntry, error) {
+	var widget Widget
+	entry, err := logged(ctx, r.s, EventWidgetStockAdjusted, func() (StockEntry, error) {
+		var err error
+		widget, entry, err = r.WidgetRepository.AdjustStock(ctx, entry)
+		return entry, err
+	})
+	return widget, entry, err
+}
+
+type loggedStockLedger struct {
+	StockLedgerRepository
+	s *EventLogStore
+}
+
+func (r loggedStockLedger) Append(ctx context.Context, entry StockEntry) (StockEntry, error) {
+	return logged(ctx, r.s, EventStockRecorded, func() (StockEntry, error) { return r.StockLedgerRepository.Append(ctx, entry) })
+}
+
+type loggedStatusHistory struct {
+	ProductStatusHistoryRepository
+	s *EventLogStore
+}
+
+func (r loggedStatusHistory) Append(ctx context.Context, entry ProductStatusChange) (ProductStatusChange, error) {
+	return logged(ctx, r.s, EventProductStatusRecorded, func() (ProductStatusChange, error) {
+		return r.ProductStatusHistoryRepository.Append(ctx, entry)
+	})
+}
+
+type loggedPriceHistory struct {
+	ProductPriceHistoryRepository
+	s *EventLogStore
+}
+
+func (r loggedPriceHistory) Append(ctx context.Context, entry ProductPriceChange) (ProductPriceChange, error) {
+	return logged(ctx, r.s, EventProductPriceRecorded, func() (ProductPriceChange, error) {
+		return r.ProductPriceHistoryRepository.Append(ctx, entry)
+	})
+}
+
+type loggedRoleHistory struct {
+	EmployeeRoleHistoryRepository
+	s *EventLogStore
+}
+
+func (r loggedRoleHistory) Append(ctx context.Context, entry EmployeeRoleChange) (EmployeeRoleChange, error) {
+	return logged(ctx, r.s, EventEmployeeRoleRecorded, func() (EmployeeRoleChange, error) {
+		return r.EmployeeRoleHistoryRepository.Append(ctx, entry)
+	})
+}
+
+type loggedOrders struct {
+	OrderRepository
+	s *EventLogStore
+}
+
+func (r loggedOrders) Create(ctx context.Context, order Order) (Order, error) {
+	return logged(ctx, r.s, EventOrderCreated, func() (Order, error) { return r.OrderRepository.Create(ctx, order) })
+}
+
+func (r loggedOrders) Place(ctx context.Context, order Order) (Order, error) {
+	return logged(ctx, r.s, EventOrderPlaced, func() (Order, error) { return r.OrderRepository.Place(ctx, order) })
+}
+
+func (r loggedOrders) Update(ctx context.Context, order Order) (Order, error) {
+	return logged(ctx, r.s, EventOrderUpdated, func() (Order, error) { return r.OrderRepository.Update(ctx, order) })
+}
+
+func (r loggedOrders) Cancel(ctx context.Context, order Order) (Order, error) {
+	return logged(ctx, r.s, EventOrderCancelled, func() (Order, error) { return r.OrderRepository.Cancel(ctx, order) })
+}
+
+type loggedCarts struct {
+	CartRepository
+	s *EventLogStore
+}
+
+func (r loggedCarts) Put(ctx context.Context, cart Cart) (Cart, error) {
+	return logged(ctx, r.s, EventCartSaved, func() (Cart, error) { return r.CartRepository.Put(ctx, cart) })
+}
+
+type loggedVariants struct {
+	ProductVariantRepository
+	s *EventLogStore
+}
+
+func (r loggedVariants) Create(ctx context.Context, variant ProductVariant) (ProductVariant, error) {
+	return logged(ctx, r.s, EventProductVariantCreated, func() (ProductVariant, error) {
+		return r.ProductVariantRepository.Create(ctx, variant)
+	})
+}
+
+func (r loggedVariants) Update(ctx context.Context, variant ProductVariant) (ProductVariant, error) {
+	return logged(ctx, r.s, EventProductVariantUpdated, func() (ProductVariant, error) {
+		return r.ProductVariantRepository.Update(ctx, variant)
+	})
+}
+
+func (r loggedVariants) Delete(ctx context.Context, id int) (ProductVariant, error) {
+	return logged(ctx, r.s, EventProductVariantDeleted, func() (ProductVariant, error) {
+		return r.ProductVariantRepository.Delete(ctx, id)
+	})
+}
+
+type loggedProducts struct {
+	ProductRepository
+	s *EventLogStore
+}
+
+func (r loggedProducts) Create(ctx context.Context, product Product) (Product, error) {
+	return logged(ctx, r.s, EventProductCreated, func() (Product, error) { return r.ProductRepository.Create(ctx, product) })
+}
+
+func (r loggedProducts) Update(ctx context.Context, product Product) (Product, error) {
+	return logged(ctx, r.s, EventProductUpdated, func() (Product, error) { return r.ProductRepository.Update(ctx, product) })
+}
+
+type loggedCategories struct {
+	CategoryRepository
+	s *EventLogStore
+}
+
+func (r loggedCategories) Create(ctx context.Context, category Category) (Category, error) {
+	return logged(ctx, r.s, EventCategoryCreated, func() (Category, error) { return r.CategoryRepository.Create(ctx, category) })
+}
+
+func (r loggedCategories) Update(ctx context.Context, category Category) (Category, error) {
+	return logged(ctx, r.s, EventCategoryUpdated, func() (Category, error) { return r.CategoryRepository.Update(ctx, category) })
+}
+
+// Move and Delete are logged as updates, which replay them without the
+// checks they already passed.
+func (r loggedCategories) Move(ctx context.Context, id int, parentID *int) (Category, error) {
+	return logged(ctx, r.s, EventCategoryUpdated, func() (Category, error) { return r.CategoryRepository.Move(ctx, id, parentID) })
+}
+
+func (r loggedCategories) Delete(ctx context.Context, id int, deletedAt time.Time, deletedBy int) (Category, error) {
+	return logged(ctx, r.s, EventCategoryUpdated, func() (Category, error) {
+		return r.CategoryRepository.Delete(ctx, id, deletedAt, deletedBy)
+	})
+}
+
+type loggedReviews struct {
+	ReviewRepository
+	s *EventLogStore
+}
+
+func (r loggedReviews) Create(ctx context.Context, review Review) (Review, error) {
+	return logged(ctx, r.s, EventReviewCreated, func() (Review, error) { return r.ReviewRepository.Create(ctx, review) })
+}
+
+func (r loggedReviews) Update(ctx context.Context, review Review) (Review, error) {
+	return logged(ctx, r.s, EventReviewUpdated, func() (Review, error) { return r.ReviewRepository.Update(ctx, review) })
+}
+
+type loggedUsers struct {
+	UserRepository
+	s *EventLogStore
+}
+
+func (r loggedUsers) Create(ctx context.Context, user User) (User, error) {
+	return logged(ctx, r.s, EventUserCreated, func() (User, error) { return r.UserRepository.Create(ctx, user) })
+}
+
+type loggedEmployees struct {
+	EmployeeRepository
+	s *EventLogStore
+}
+
+func (r loggedEmployees) Create(ctx context.Context, employee *Employee) (*Employee, error) {
+	return r.write(ctx, EventEmployeeCreated, func() (*Employee, error) { return r.EmployeeRepository.Create(ctx, employee) })
+}
+
+func (r loggedEmployees) Update(ctx context.Context, employee *Employee) (*Employee, error) {
+	return r.write(ctx, EventEmployeeUpdated, func() (*Employee, error) { return r.EmployeeRepository.Update(ctx, employee) })
+}
+
+func (r loggedEmployees) AssignManager(ctx context.Context, id int, managerID *int, version int) (*Employee, error) {
+	return r.write(ctx, EventEmployeeUpdated, func() (*Employee, error) {
+		return r.EmployeeRepository.AssignManager(ctx, id, managerID, version)
+	})
+}
+
+// ReassignReports is logged as one event, so a replay moves all reports or
+// none.
+func (r loggedEmployees) ReassignReports(ctx context.Context, fromManagerID int, toManagerID *int) ([]*Employee, error) {
+	var moved []*Employee
+	_, err := logged(ctx, r.s, EventReportsReassigned, func() ([]EmployeeRecord, error) {
+		var err error
+		if moved, err = r.EmployeeRepository.ReassignReports(ctx, fromManagerID, toManagerID); err != nil {
+			return nil, err
+		}
+		records := make([]EmployeeRecord, 0, len(moved))
+		for _, emp := range moved {
+			record, err := newEmployeeRecord(emp)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	})
+	return moved, err
+}
+
+// write logs employees in their EmployeeRecord form, which keeps the
+// Developer/Manager distinction.
+func (r loggedEmployees) write(ctx context.Context, eventType EventType, write func() (*Employee, error)) (*Employee, error) {
+	var stored *Employee
+	_, err := logged(ctx, r.s, eventType, func() (EmployeeRecord, error) {
+		var err error
+		if stored, err = write(); err != nil {
+			return EmployeeRecord{}, err
+		}
+		return newEmployeeRecord(stored)
+	})
+	return stored, err
+}
+
+// OpenEventLogStore opens the event log at path and wraps store with it. If
+// store is empty, the log is replayed into it first; a store that already
+// holds data, such as a SQLite database, is assumed to be up to date. It
+// returns the number of events replayed.
+func OpenEventLogStore(ctx context.Context, store Store, path string) (*EventLogStore, int, error) {
+	log, err := OpenEventLog(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	replayed := 0
+	if !log.Empty() {
+		empty, err := storeIsEmpty(ctx, store)
+		if err == nil && empty {
+			replayed, err = ReplayEventLog(ctx, path, store)
+		}
+		if err != nil {
+			log.Close()
+			return nil, 0, err
+		}
+	}
+	return NewEventLogStore(store, log), replayed, nil
+}
+
+// Close closes the event log. The wrapped store is left open.
+func (s *EventLogStore) Close() error {
+	return s.log.Close()
+}
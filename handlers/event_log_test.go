@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEventLogReplayRebuildsState(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	store, replayed, err := OpenEventLogStore(ctx, NewMemoryStore(), path)
+	if err != nil {
+		t.Fatalf("OpenEventLogStore failed: %v", err)
+	}
+	if replayed != 0 {
+		t.Fatalf("expected nothing to replay from a new log, got %d", replayed)
+	}
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+
+	admin, err := store.Users().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get admin failed: %v", err)
+	}
+	adminCtx := context.WithValue(ctx, UserContextKey, &admin)
+
+	graph := newTestGraph(ctx, store)
+	for _, mutation := range []string{
+		`mutation { CreateWidget(widget: {name: "Logged", price: 2.5, quantity: 4}) { id } }`,
+		`mutation { UpdateWidget(widget: {id: 1, name: "Widget 1", price: 1.5, quantity: 7, version: 1}) { id } }`,
+		`mutation { AdjustWidgetStock(widgetId: 1, delta: 5, reason: "restocked") { id } }`,
+		`mutation { CreateProduct(input: {name: "Tablet", description: "Big screen", price: 299, categoryId: 1}) { ID } }`,
+		`mutation { UpdateProductStatus(id: 3, status: "DISCONTINUED", version: 1) { ID } }`,
+		`mutation { AddProductReview(productId: 4, review: {rating: 3, comment: "Okay"}) { ID } }`,
+		`mutation { CreateEmployee(input: {name: "Eve", email: "eve@example.com", salary: 90000, type: "DEVELOPER", programmingLanguages: ["Go"]}) { __typename } }`,
+		`mutation { DeleteWidget(id: 2) { id } }`,
+		`mutation { DeleteReview(id: 4) { ID } }`,
+		`mutation { ReassignReports(fromManagerId: 2) { ID } }`,
+		`mutation { TerminateEmployee(employeeId: 2) { ID } }`,
+		`mutation { PromoteToManager(employeeId: 3, department: "Platform", version: 2) { Name } }`,
+	} {
+		res, err := graph.ProcessRequest(adminCtx, mutation, "")
+		if err != nil || strings.Contains(res, `"errors"`) {
+			t.Fatalf("%s failed: %v %s", mutation, err, res)
+		}
+	}
+	want, err := store.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	events, err := ReadEventLog(path)
+	if err != nil {
+		t.Fatalf("ReadEventLog failed: %v", err)
+	}
+	// The promotion is followed by its role history entry
+	promotion, role := events[len(events)-2], events[len(events)-1]
+	if promotion.Type != EventEmployeeUpdated || promotion.UserID == nil || *promotion.UserID != 1 {
+		t.Errorf("expected the promotion by user 1 to be the second to last event, got %+v", promotion)
+	}
+	if role.Type != EventEmployeeRoleRecorded {
+		t.Errorf("expected the role change to be the last event, got %+v", role)
+	}
+
+	replayedStore, replayed, err := OpenEventLogStore(ctx, NewMemoryStore(), path)
+	if err != nil {
+		t.Fatalf("reopening the log failed: %v", err)
+	}
+	defer replayedStore.Close()
+	if replayed != len(events) {
+		t.Errorf("expected %d events replayed, got %d", len(events), replayed)
+	}
+	got, err := replayedStore.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	got.TakenAt = want.TakenAt
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed state differs:\ngot:  %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestEventLogDropsTornFinalLine(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	l, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatalf("OpenEventLog failed: %v", err)
+	}
+	if err := l.Append(ctx, EventWidgetCreated, Widget{ID: 1, Name: "Kept"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	l.Close()
+
+	// Simulate a crash in the middle of writing the second event
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":2,"type":"WidgetCr`)
+	f.Close()
+
+	l, err = OpenEventLog(path)
+	if err != nil {
+		t.Fatalf("reopening a torn log failed: %v", err)
+	}
+	if err := l.Append(ctx, EventWidgetCreated, Widget{ID: 2, Name: "Appended"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	l.Close()
+
+	events, err := ReadEventLog(path)
+	if err != nil {
+		t.Fatalf("ReadEventLog failed: %v", err)
+	}
+	if len(events) != 2 || events[1].Seq != 2 {
+		t.Errorf("expected the torn line to be replaced by event 2, got %+v", events)
+	}
+}
+
+func TestEventLogStoreRefusesWritesAfterFailedAppend(t *testing.T) {
+	ctx := context.Background()
+	store, _, err := OpenEventLogStore(ctx, NewMemoryStore(), filepath.Join(t.TempDir(), "events.log"))
+	if err != nil {
+		t.Fatalf("OpenEventLogStore failed: %v", err)
+	}
+	if _, err := store.Widgets().Create(ctx, Widget{Name: "Logged"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Simulate a disk failure: every further append fails
+	store.log.file.Close()
+
+	if _, err := store.Widgets().Create(ctx, Widget{Name: "Unlogged"}); !errors.Is(err, ErrEventLogFailed) {
+		t.Fatalf("expected ErrEventLogFailed for the write whose append failed, got %v", err)
+	}
+	if _, err := store.Products().Create(ctx, Product{Name: "Refused"}); !errors.Is(err, ErrEventLogFailed) {
+		t.Fatalf("expected ErrEventLogFailed for later writes, got %v", err)
+	}
+	products, err := store.Products().List(ctx)
+	if err != nil || len(products) != 0 {
+		t.Errorf("expected later writes not to reach the store, got %v, %v", products, err)
+	}
+}
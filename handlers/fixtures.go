@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fixtureEntities are the per-entity fixture files a fixture directory may
+// contain, each as <name>.json, <name>.yaml or <name>.yml. Missing files
+// simply contribute no entities.
+var fixtureEntities = []string{"categories", "products", "users", "reviews", "widgets", "employees"}
+
+var fixtureExtensions = []string{".json", ".yaml", ".yml"}
+
+// LoadFixtures reads the fixture files in dir and validates them, including
+// the references between entities. All problems are reported together in one
+// joined error, each prefixed with the file and entry it was found in.
+//
+// JSON and YAML files accept the same keys: field names are matched case
+// insensitively, so both "categoryId" and "CategoryID" work.
+func LoadFixtures(dir string) (*Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string)
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		if !isFixtureExtension(ext) {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if !isFixtureEntity(name) {
+			errs = append(errs, fmt.Errorf("%s: unknown fixture file, expected one of %s", entry.Name(), strings.Join(fixtureEntities, ", ")))
+			continue
+		}
+		if other, ok := files[name]; ok {
+			errs = append(errs, fmt.Errorf("%s: duplicates %s", entry.Name(), other))
+			continue
+		}
+		files[name] = entry.Name()
+	}
+
+	snap := &Snapshot{FormatVersion: snapshotFormatVersion, TakenAt: time.Now().UTC()}
+	targets := map[string]interface{}{
+		"categories": &snap.Categories,
+		"products":   &snap.Products,
+		"users":      &snap.Users,
+		"reviews":    &snap.Reviews,
+		"widgets":    &snap.Widgets,
+		"employees":  &snap.Employees,
+	}
+	for _, name := range fixtureEntities {
+		file, ok := files[name]
+		if !ok {
+			continue
+		}
+		if err := decodeFixtureFile(filepath.Join(dir, file), targets[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file, err))
+		}
+	}
+
+	// Validating half-decoded data only produces follow-up noise
+	if len(errs) == 0 {
+		errs = validateFixtures(snap, files)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid fixtures in %s:\n%w", dir, errors.Join(errs...))
+	}
+	return snap, nil
+}
+
+// SeedFixturesIfEmpty loads the fixtures in dir into store unless the store
+// already holds data. Fixture errors are reported even when nothing is
+// seeded, so a broken data set is noticed right away.
+func SeedFixturesIfEmpty(ctx context.Context, store Store, dir string) error {
+	snap, err := LoadFixtures(dir)
+	if err != nil {
+		return err
+	}
+	empty, err := storeIsEmpty(ctx, store)
+	if err != nil || !empty {
+		return err
+	}
+	return store.Restore(ctx, snap)
+}
+
+// SeedStore fills an empty store with the fixtures in dir, or with the
+// built-in sample data when dir is empty.
+func SeedStore(ctx context.Context, store Store, dir string) error {
+	if dir == "" {
+		return SeedSampleDataIfEmpty(ctx, store)
+	}
+	return SeedFixturesIfEmpty(ctx, store, dir)
+}
+
+func isFixtureExtension(ext string) bool {
+	for _, e := range fixtureExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+func isFixtureEntity(name string) bool {
+	for _, e := range fixtureEntities {
+		if name == e {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeFixtureFile decodes the list in path into target. YAML is converted
+// to JSON first so that both formats go through the same strict decoder.
+func decodeFixtureFile(path string, target interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if filepath.Ext(path) != ".json" {
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(target)
+}
+
+// validateFixtures checks IDs, enum values and cross-entity references.
+// files maps entity names to the file they were loaded from.
+func validateFixtures(snap *Snapshot, files map[string]string) []error {
+	var errs []error
+	report := func(entity string, index, id int, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s[%d] (id %d): %s", files[entity], index, id, fmt.Sprintf(format, args...)))
+	}
+
+	// checkIDs reports missing and duplicate IDs and returns the set of IDs.
+	checkIDs := func(entity string, ids []int) map[int]bool {
+		seen := make(map[int]bool, len(ids))
+		for i, id := range ids {
+			if id <= 0 {
+				report(entity, i, id, "id must be a positive number")
+			} else if seen[id] {
+				report(entity, i, id, "duplicate id")
+			}
+			seen[id] = true
+		}
+		return seen
+	}
+
+	var ids []int
+	for _, c := range snap.Categories {
+		ids = append(ids, c.ID)
+	}
+	categoryIDs := checkIDs("categories", ids)
+	tree := newCategoryTree(snap.Categories)
+	for i, c := range snap.Categories {
+		if c.Name == "" {
+			report("categories", i, c.ID, "name is required")
+		}
+		if c.ParentID != nil && !categoryIDs[*c.ParentID] {
+			report("categories", i, c.ID, "parent category %d does not exist", *c.ParentID)
+		} else if _, err := tree.ancestors(c.ID); err != nil {
+			report("categories", i, c.ID, "parent categories form a cycle")
+		}
+	}
+
+	ids = nil
+	for _, p := range snap.Products {
+		ids = append(ids, p.ID)
+	}
+	productIDs := checkIDs("products", ids)
+	for i, p := range snap.Products {
+		if p.Name == "" {
+			report("products", i, p.ID, "name is required")
+		}
+		if err := checkPrice(p.Price); err != nil {
+			report("products", i, p.ID, "%v", err)
+		}
+		if p.Stock < 0 {
+			report("products", i, p.ID, "stock cannot be negative")
+		}
+		if !validProductStatus(p.Status) {
+			report("products", i, p.ID, "invalid status %q", p.Status)
+		}
+		if !categoryIDs[p.CategoryID] {
+			report("products", i, p.ID, "category %d does not exist", p.CategoryID)
+		}
+	}
+
+	ids = nil
+	for _, u := range snap.Users {
+		ids = append(ids, u.ID)
+	}
+	userIDs := checkIDs("users", ids)
+	for i, u := range snap.Users {
+		if u.Username == "" {
+			report("users", i, u.ID, "username is required")
+		}
+		if !validUserRole(u.Role) {
+			report("users", i, u.ID, "invalid role %q", u.Role)
+		}
+	}
+
+	ids = nil
+	for _, r := range snap.Reviews {
+		ids = append(ids, r.ID)
+	}
+	checkIDs("reviews", ids)
+	for i, r := range snap.Reviews {
+		if !productIDs[r.ProductID] {
+			report("reviews", i, r.ID, "product %d does not exist", r.ProductID)
+		}
+		if !userIDs[r.UserID] {
+			report("reviews", i, r.ID, "user %d does not exist", r.UserID)
+		}
+		if r.Rating < 1 || r.Rating > 5 {
+			report("reviews", i, r.ID, "rating must be between 1 and 5")
+		}
+		if r.Status != "" && !validReviewStatus(r.Status) {
+			report("reviews", i, r.ID, "invalid status %q", r.Status)
+		}
+	}
+
+	ids = nil
+	for _, w := range snap.Widgets {
+		ids = append(ids, w.ID)
+	}
+	checkIDs("widgets", ids)
+	for i, w := range snap.Widgets {
+		if err := checkPrice(w.Price); err != nil {
+			report("widgets", i, w.ID, "%v", err)
+		}
+		if w.Quantity < 0 {
+			report("widgets", i, w.ID, "quantity cannot be negative")
+		}
+	}
+
+	ids = nil
+	for _, e := range snap.Employees {
+		ids = append(ids, e.ID)
+	}
+	checkIDs("employees", ids)
+	employees := make(map[int]*Employee, len(snap.Employees))
+	managers := make(map[int]bool)
+	for _, e := range snap.Employees {
+		if emp, err := e.Employee(); err == nil {
+			employees[e.ID] = emp
+		}
+		managers[e.ID] = e.Type == EmployeeTypeManager
+	}
+	for i, e := range snap.Employees {
+		if e.Name == "" {
+			report("employees", i, e.ID, "name is required")
+		}
+		if e.ManagerID != nil {
+			if !managers[*e.ManagerID] {
+				report("employees", i, e.ID, "manager %d does not exist", *e.ManagerID)
+			} else if *e.ManagerID == e.ID || reportsTo(employees, *e.ManagerID, e.ID) {
+				report("employees", i, e.ID, "managers form a cycle")
+			}
+		}
+		switch e.Type {
+		case EmployeeTypeDeveloper:
+			if e.Department != "" {
+				report("employees", i, e.ID, "department only applies to managers")
+			}
+		case EmployeeTypeManager:
+			if len(e.ProgrammingLanguages) > 0 || e.GithubUsername != nil {
+				report("employees", i, e.ID, "programmingLanguages and githubUsername only apply to developers")
+			}
+		default:
+			report("employees", i, e.ID, "invalid type %q", e.Type)
+		}
+	}
+
+	return errs
+}
+
+func validProductStatus(status ProductStatus) bool {
+	for _, s := range status.EnumValues() {
+		if string(status) == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validReviewStatus(status ReviewStatus) bool {
+	for _, s := range status.EnumValues() {
+		if string(status) == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validUserRole(role UserRole) bool {
+	for _, r := range role.EnumValues() {
+		if string(role) == r {
+			return true
+		}
+	}
+	return false
+}
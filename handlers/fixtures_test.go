@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSampleFixturesMatchBuiltInSampleData(t *testing.T) {
+	ctx := context.Background()
+
+	fixtureStore := NewMemoryStore()
+	if err := SeedFixturesIfEmpty(ctx, fixtureStore, filepath.Join("..", "fixtures", "sample")); err != nil {
+		t.Fatalf("SeedFixturesIfEmpty failed: %v", err)
+	}
+	fromFixtures, err := fixtureStore.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	builtIn, err := store.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	fromFixtures.TakenAt = builtIn.TakenAt
+	if !reflect.DeepEqual(fromFixtures, builtIn) {
+		t.Errorf("fixtures/sample drifted from SeedSampleData:\nfixtures: %+v\nbuilt-in: %+v", fromFixtures, builtIn)
+	}
+}
+
+func TestSeedFixturesIfEmpty(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	dir := filepath.Join("..", "fixtures", "minimal")
+
+	if err := SeedFixturesIfEmpty(ctx, store, dir); err != nil {
+		t.Fatalf("SeedFixturesIfEmpty failed: %v", err)
+	}
+	products, err := store.Products().List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(products) != 2 || products[0].Name != "Pocket Gizmo" {
+		t.Fatalf("expected the minimal products, got %+v", products)
+	}
+
+	// A second call must not touch the already seeded store
+	if _, err := store.Products().Update(ctx, Product{ID: 1, Name: "Renamed", CategoryID: 1, Version: 1}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := SeedFixturesIfEmpty(ctx, store, dir); err != nil {
+		t.Fatalf("second SeedFixturesIfEmpty failed: %v", err)
+	}
+	p, err := store.Products().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if p.Name != "Renamed" {
+		t.Errorf("expected seeding to skip a non-empty store, got %q", p.Name)
+	}
+}
+
+func TestLoadFixturesReportsAllErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"categories.json": `[{"id": 1, "name": "Only"}, {"id": 2, "name": "Loop", "parentId": 3},
+			{"id": 3, "name": "Back", "parentId": 2}, {"id": 4, "name": "Lost", "parentId": 9}]`,
+		"products.yaml": `
+- id: 1
+  name: Orphan
+  price: -1
+  status: ON_SALE
+  categoryId: 7
+- id: 1
+  name: Duplicate
+  status: ACTIVE
+  categoryId: 1
+`,
+		"reviews.yml": `[{id: 1, productId: 9, userId: 4, rating: 6}]`,
+		"employees.json": `[{"type": "INTERN", "id": 1, "name": "Nobody"}, {"type": "MANAGER", "id": 2, "name": "Up", "managerId": 3},
+			{"type": "MANAGER", "id": 3, "name": "Down", "managerId": 2}, {"type": "DEVELOPER", "id": 4, "name": "Intern's", "managerId": 1}]`,
+		"catgories.json": `[]`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := LoadFixtures(dir)
+	if err == nil {
+		t.Fatal("expected fixture errors")
+	}
+	// The misspelled file is reported on its own, before any validation
+	if !strings.Contains(err.Error(), "catgories.json: unknown fixture file") {
+		t.Errorf("expected unknown file error, got %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "catgories.json")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadFixtures(dir)
+	if err == nil {
+		t.Fatal("expected fixture errors")
+	}
+	for _, want := range []string{
+		"categories.json[1] (id 2): parent categories form a cycle",
+		"categories.json[3] (id 4): parent category 9 does not exist",
+		"products.yaml[0] (id 1): price cannot be negative",
+		`products.yaml[0] (id 1): invalid status "ON_SALE"`,
+		"products.yaml[0] (id 1): category 7 does not exist",
+		"products.yaml[1] (id 1): duplicate id",
+		"reviews.yml[0] (id 1): product 9 does not exist",
+		"reviews.yml[0] (id 1): user 4 does not exist",
+		"reviews.yml[0] (id 1): rating must be between 1 and 5",
+		`employees.json[0] (id 1): invalid type "INTERN"`,
+		"employees.json[1] (id 2): managers form a cycle",
+		"employees.json[3] (id 4): manager 1 does not exist",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in error, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadFixturesRejectsUnknownFields(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "widgets.yaml"), []byte("- id: 1\n  name: W\n  qty: 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadFixtures(dir)
+	if err == nil || !strings.Contains(err.Error(), `widgets.yaml: json: unknown field "qty"`) {
+		t.Errorf("expected unknown field error, got %v", err)
+	}
+}
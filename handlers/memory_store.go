@@ -0,0 +1,1304 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is the default Store implementation. All data lives in process
+// memory and is lost on restart. A single lock guards every entity so that
+// operations spanning several repositories see a consistent view.
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	widgets    []Widget
+	products   []Product
+	categories []Category
+	reviews    []Review
+	users      []User
+	employees  []*Employee
+	stock      []StockEntry
+	statuses   []ProductStatusChange
+	prices     []ProductPriceChange
+	roles      []EmployeeRoleChange
+	orders     []Order
+	carts      []Cart
+	variants   []ProductVariant
+	audit      []AuditEntry
+	ratings    map[int]RatingSummary // By product ID
+	events     *Events
+
+	nextWidgetID   int
+	nextProductID  int
+	nextCategoryID int
+	nextReviewID   int
+	nextUserID     int
+	nextEmployeeID int
+	nextStockID    int
+	nextStatusID   int
+	nextPriceID    int
+	nextRoleID     int
+	nextOrderID    int
+	nextVariantID  int
+	nextAuditID    int
+}
+
+// NewMemoryStore creates an empty in-memory store. Use SeedSampleData to
+// populate it with the demo data set.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextWidgetID:   1,
+		nextProductID:  1,
+		nextCategoryID: 1,
+		nextReviewID:   1,
+		nextUserID:     1,
+		nextEmployeeID: 1,
+		nextStockID:    1,
+		nextStatusID:   1,
+		nextPriceID:    1,
+		nextRoleID:     1,
+		nextOrderID:    1,
+		nextVariantID:  1,
+		nextAuditID:    1,
+		ratings:        make(map[int]RatingSummary),
+		events:         NewEvents(),
+	}
+}
+
+func (s *MemoryStore) Widgets() WidgetRepository          { return memoryWidgets{s} }
+func (s *MemoryStore) Products() ProductRepository        { return memoryProducts{s} }
+func (s *MemoryStore) Categories() CategoryRepository     { return memoryCategories{s} }
+func (s *MemoryStore) Reviews() ReviewRepository          { return memoryReviews{s} }
+func (s *MemoryStore) Users() UserRepository              { return memoryUsers{s} }
+func (s *MemoryStore) Employees() EmployeeRepository      { return memoryEmployees{s} }
+func (s *MemoryStore) StockLedger() StockLedgerRepository { return memoryStockLedger{s} }
+func (s *MemoryStore) Audit() AuditRepository             { return memoryAudit{s} }
+func (s *MemoryStore) ProductStatusHistory() ProductStatusHistoryRepository {
+	return memoryStatusHistory{s}
+}
+func (s *MemoryStore) ProductPriceHistory() ProductPriceHistoryRepository {
+	return memoryPriceHistory{s}
+}
+func (s *MemoryStore) EmployeeRoleHistory() EmployeeRoleHistoryRepository {
+	return memoryRoleHistory{s}
+}
+func (s *MemoryStore) Orders() OrderRepository { return memoryOrders{s} }
+func (s *MemoryStore) Carts() CartRepository   { return memoryCarts{s} }
+func (s *MemoryStore) ProductVariants() ProductVariantRepository {
+	return memoryVariants{s}
+}
+func (s *MemoryStore) RatingSummaries() RatingSummaryRepository {
+	return memoryRatings{s}
+}
+func (s *MemoryStore) Events() *Events { return s.events }
+
+// Snapshot copies the store under its read lock.
+func (s *MemoryStore) Snapshot(ctx context.Context) (*Snapshot, error) {
+	s.mu.RLock()
+	frozen := &MemoryStore{
+		widgets:    append([]Widget(nil), s.widgets...),
+		products:   append([]Product(nil), s.products...),
+		categories: append([]Category(nil), s.categories...),
+		reviews:    append([]Review(nil), s.reviews...),
+		users:      append([]User(nil), s.users...),
+		employees:  append([]*Employee(nil), s.employees...),
+		stock:      append([]StockEntry(nil), s.stock...),
+		statuses:   append([]ProductStatusChange(nil), s.statuses...),
+		prices:     append([]ProductPriceChange(nil), s.prices...),
+		roles:      append([]EmployeeRoleChange(nil), s.roles...),
+		orders:     append([]Order(nil), s.orders...),
+		carts:      append([]Cart(nil), s.carts...),
+		variants:   append([]ProductVariant(nil), s.variants...),
+	}
+	s.mu.RUnlock()
+	return snapshotFrom(ctx, frozen)
+}
+
+// Restore builds the snapshot into a fresh store and swaps its contents in,
+// so readers never observe a partially restored state. The audit log is
+// kept.
+func (s *MemoryStore) Restore(ctx context.Context, snapshot *Snapshot) error {
+	fresh := NewMemoryStore()
+	if err := restoreInto(ctx, fresh, snapshot); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.widgets, s.nextWidgetID = fresh.widgets, fresh.nextWidgetID
+	s.products, s.nextProductID = fresh.products, fresh.nextProductID
+	s.categories, s.nextCategoryID = fresh.categories, fresh.nextCategoryID
+	s.reviews, s.nextReviewID = fresh.reviews, fresh.nextReviewID
+	s.users, s.nextUserID = fresh.users, fresh.nextUserID
+	s.employees, s.nextEmployeeID = fresh.employees, fresh.nextEmployeeID
+	s.stock, s.nextStockID = fresh.stock, fresh.nextStockID
+	s.statuses, s.nextStatusID = fresh.statuses, fresh.nextStatusID
+	s.prices, s.nextPriceID = fresh.prices, fresh.nextPriceID
+	s.roles, s.nextRoleID = fresh.roles, fresh.nextRoleID
+	s.orders, s.nextOrderID = fresh.orders, fresh.nextOrderID
+	s.carts = fresh.carts
+	s.variants, s.nextVariantID = fresh.variants, fresh.nextVariantID
+	s.ratings = fresh.ratings
+	return nil
+}
+
+// assignID returns id if it is set, otherwise the next free ID. The counter
+// is advanced past whichever ID is used.
+func assignID(id int, next *int) int {
+	if id == 0 {
+		id = *next
+	}
+	if id >= *next {
+		*next = id + 1
+	}
+	return id
+}
+
+// idSet returns the set of ids, for the batch lookups.
+func idSet(ids []int) map[int]bool {
+	set := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// Widgets
+
+type memoryWidgets struct{ s *MemoryStore }
+
+func (r memoryWidgets) Get(ctx context.Context, id int) (Widget, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, w := range r.s.widgets {
+		if w.ID == id {
+			return w, nil
+		}
+	}
+	return Widget{}, ErrNotFound
+}
+
+func (r memoryWidgets) List(ctx context.Context) ([]Widget, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := make([]Widget, len(r.s.widgets))
+	copy(result, r.s.widgets)
+	return result, nil
+}
+
+func (r memoryWidgets) Create(ctx context.Context, widget Widget) (Widget, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	widget.ID = assignID(widget.ID, &r.s.nextWidgetID)
+	widget.Version = initialVersion(widget.Version)
+	r.s.widgets = append(r.s.widgets, widget)
+	return widget, nil
+}
+
+func (r memoryWidgets) Update(ctx context.Context, widget Widget) (Widget, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for i, w := range r.s.widgets {
+		if w.ID == widget.ID {
+			if w.Version != widget.Version {
+				return Widget{}, &VersionConflictError{Entity: "widget", ID: widget.ID, ExpectedVersion: widget.Version, CurrentVersion: w.Version}
+			}
+			widget.Version++
+			r.s.widgets[i] = widget
+			return widget, nil
+		}
+	}
+	return Widget{}, ErrNotFound
+}
+
+func (r memoryWidgets) AdjustStock(ctx context.Context, entry StockEntry) (Widget, StockEntry, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for i, w := range r.s.widgets {
+		if w.ID == entry.WidgetID {
+			if w.Quantity+entry.Delta < 0 {
+				return Widget{}, StockEntry{}, &InsufficientStockError{WidgetID: w.ID, Quantity: w.Quantity, Delta: entry.Delta}
+			}
+			w.Quantity += entry.Delta
+			w.Version++
+			r.s.widgets[i] = w
+
+			entry.QuantityAfter = w.Quantity
+			entry.ID = assignID(entry.ID, &r.s.nextStockID)
+			r.s.stock = append(r.s.stock, entry)
+			return w, entry, nil
+		}
+	}
+	return Widget{}, StockEntry{}, ErrNotFound
+}
+
+// Stock ledger
+
+type memoryStockLedger struct{ s *MemoryStore }
+
+func (r memoryStockLedger) List(ctx context.Context) ([]StockEntry, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return append([]StockEntry(nil), r.s.stock...), nil
+}
+
+func (r memoryStockLedger) ListByWidget(ctx context.Context, widgetID int) ([]StockEntry, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var result []StockEntry
+	for _, e := range r.s.stock {
+		if e.WidgetID == widgetID {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryStockLedger) Append(ctx context.Context, entry StockEntry) (StockEntry, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	entry.ID = assignID(entry.ID, &r.s.nextStockID)
+	r.s.stock = append(r.s.stock, entry)
+	return entry, nil
+}
+
+// Product status history
+
+type memoryStatusHistory struct{ s *MemoryStore }
+
+func (r memoryStatusHistory) List(ctx context.Context) ([]ProductStatusChange, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return append([]ProductStatusChange(nil), r.s.statuses...), nil
+}
+
+func (r memoryStatusHistory) ListByProduct(ctx context.Context, productID int) ([]ProductStatusChange, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var result []ProductStatusChange
+	for _, c := range r.s.statuses {
+		if c.ProductID == productID {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryStatusHistory) Append(ctx context.Context, entry ProductStatusChange) (ProductStatusChange, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	entry.ID = assignID(entry.ID, &r.s.nextStatusID)
+	r.s.statuses = append(r.s.statuses, entry)
+	return entry, nil
+}
+
+// Product price history
+
+type memoryPriceHistory struct{ s *MemoryStore }
+
+func (r memoryPriceHistory) List(ctx context.Context) ([]ProductPriceChange, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return append([]ProductPriceChange(nil), r.s.prices...), nil
+}
+
+func (r memoryPriceHistory) ListByProduct(ctx context.Context, productID int) ([]ProductPriceChange, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var result []ProductPriceChange
+	for _, c := range r.s.prices {
+		if c.ProductID == productID {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryPriceHistory) Append(ctx context.Context, entry ProductPriceChange) (ProductPriceChange, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	entry.ID = assignID(entry.ID, &r.s.nextPriceID)
+	r.s.prices = append(r.s.prices, entry)
+	return entry, nil
+}
+
+// Employee role history
+
+type memoryRoleHistory struct{ s *MemoryStore }
+
+func (r memoryRoleHistory) List(ctx context.Context) ([]EmployeeRoleChange, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return append([]EmployeeRoleChange(nil), r.s.roles...), nil
+}
+
+func (r memoryRoleHistory) ListByEmployee(ctx context.Context, employeeID int) ([]EmployeeRoleChange, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var result []EmployeeRoleChange
+	for _, c := range r.s.roles {
+		if c.EmployeeID == employeeID {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryRoleHistory) Append(ctx context.Context, entry EmployeeRoleChange) (EmployeeRoleChange, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	entry.ID = assignID(entry.ID, &r.s.nextRoleID)
+	r.s.roles = append(r.s.roles, entry)
+	return entry, nil
+}
+
+// Products
+
+type memoryProducts struct{ s *MemoryStore }
+
+func (r memoryProducts) Get(ctx context.Context, id int) (Product, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, p := range r.s.products {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return Product{}, ErrNotFound
+}
+
+func (r memoryProducts) List(ctx context.Context) ([]Product, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := make([]Product, len(r.s.products))
+	copy(result, r.s.products)
+	return result, nil
+}
+
+func (r memoryProducts) ListByCategory(ctx context.Context, categoryID int) ([]Product, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var result []Product
+	for _, p := range r.s.products {
+		if p.CategoryID == categoryID {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryProducts) Create(ctx context.Context, product Product) (Product, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if i := r.s.categoryIndex(product.CategoryID); i >= 0 && r.s.categories[i].isDeleted() && !product.isDeleted() {
+		return Product{}, &CategoryNotFoundError{ID: product.CategoryID}
+	}
+	product.ID = assignID(product.ID, &r.s.nextProductID)
+	product.Version = initialVersion(product.Version)
+	r.s.products = append(r.s.products, product)
+	return product, nil
+}
+
+func (r memoryProducts) Update(ctx context.Context, product Product) (Product, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for i, p := range r.s.products {
+		if p.ID == product.ID {
+			if p.Version != product.Version {
+				return Product{}, &VersionConflictError{Entity: "product", ID: product.ID, ExpectedVersion: product.Version, CurrentVersion: p.Version}
+			}
+			product.Version++
+			r.s.products[i] = product
+			return product, nil
+		}
+	}
+	return Product{}, ErrNotFound
+}
+
+// Orders keep private copies of their items, like employees.
+
+type memoryOrders struct{ s *MemoryStore }
+
+func cloneOrder(o Order) Order {
+	o.Items = append([]OrderItem(nil), o.Items...)
+	return o
+}
+
+func (r memoryOrders) Get(ctx context.Context, id int) (Order, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, o := range r.s.orders {
+		if o.ID == id {
+			return cloneOrder(o), nil
+		}
+	}
+	return Order{}, ErrNotFound
+}
+
+func (r memoryOrders) List(ctx context.Context) ([]Order, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := make([]Order, 0, len(r.s.orders))
+	for _, o := range r.s.orders {
+		result = append(result, cloneOrder(o))
+	}
+	return result, nil
+}
+
+func (r memoryOrders) ListByUser(ctx context.Context, userID int) ([]Order, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var result []Order
+	for _, o := range r.s.orders {
+		if o.UserID == userID {
+			result = append(result, cloneOrder(o))
+		}
+	}
+	return result, nil
+}
+
+func (r memoryOrders) Create(ctx context.Context, order Order) (Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.create(order), nil
+}
+
+// create stores order. The caller holds the write lock.
+func (r memoryOrders) create(order Order) Order {
+	order = cloneOrder(order)
+	order.ID = assignID(order.ID, &r.s.nextOrderID)
+	order.Version = initialVersion(order.Version)
+	r.s.orders = append(r.s.orders, order)
+	return cloneOrder(order)
+}
+
+func (r memoryOrders) Place(ctx context.Context, order Order) (Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	// Check every item before taking anything out, so a failed order
+	// changes nothing
+	indexes := make([]int, len(order.Items))
+	for i, item := range order.Items {
+		if item.WidgetID != nil {
+			indexes[i] = r.s.widgetIndex(*item.WidgetID)
+			if indexes[i] < 0 {
+				return Order{}, ErrNotFound
+			}
+			if w := r.s.widgets[indexes[i]]; w.Quantity < item.Quantity {
+				return Order{}, &InsufficientStockError{WidgetID: w.ID, Quantity: w.Quantity, Delta: -item.Quantity}
+			}
+			continue
+		}
+		indexes[i] = r.s.productIndex(*item.ProductID)
+		if indexes[i] < 0 {
+			return Order{}, ErrNotFound
+		}
+		if item.VariantID != nil {
+			v := r.s.variantIndex(*item.VariantID)
+			if v < 0 || r.s.variants[v].ProductID != *item.ProductID {
+				return Order{}, ErrNotFound
+			}
+			if variant := r.s.variants[v]; variant.Stock < item.Quantity {
+				return Order{}, &InsufficientProductStockError{ProductID: variant.ProductID, VariantID: &variant.ID,
+					Stock: variant.Stock, Quantity: item.Quantity}
+			}
+		}
+		if p := r.s.products[indexes[i]]; p.Stock < item.Quantity {
+			return Order{}, &InsufficientProductStockError{ProductID: p.ID, Stock: p.Stock, Quantity: item.Quantity}
+		}
+	}
+	order = r.create(order)
+	for i, item := range order.Items {
+		if item.WidgetID != nil {
+			r.s.moveWidgetStock(indexes[i], orderStockEntry(order, item))
+			continue
+		}
+		if item.VariantID != nil {
+			r.s.moveVariantStock(*item.VariantID, -item.Quantity)
+		}
+		r.s.products[indexes[i]].Stock -= item.Quantity
+		r.s.products[indexes[i]].Version++
+	}
+	return order, nil
+}
+
+func (r memoryOrders) Update(ctx context.Context, order Order) (Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.update(order)
+}
+
+// update replaces the stored order. The caller holds the write lock.
+func (r memoryOrders) update(order Order) (Order, error) {
+	for i, o := range r.s.orders {
+		if o.ID == order.ID {
+			if o.Version != order.Version {
+				return Order{}, &VersionConflictError{Entity: "order", ID: order.ID, ExpectedVersion: order.Version, CurrentVersion: o.Version}
+			}
+			order = cloneOrder(order)
+			order.Version++
+			r.s.orders[i] = order
+			return cloneOrder(order), nil
+		}
+	}
+	return Order{}, ErrNotFound
+}
+
+func (r memoryOrders) Cancel(ctx context.Context, order Order) (Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	order, err := r.update(order)
+	if err != nil {
+		return Order{}, err
+	}
+	for _, item := range order.Items {
+		if item.WidgetID != nil {
+			if i := r.s.widgetIndex(*item.WidgetID); i >= 0 {
+				r.s.moveWidgetStock(i, orderStockEntry(order, item))
+			}
+			continue
+		}
+		if item.VariantID != nil {
+			r.s.moveVariantStock(*item.VariantID, item.Quantity)
+		}
+		if i := r.s.productIndex(*item.ProductID); i >= 0 {
+			r.s.products[i].Stock += item.Quantity
+			r.s.products[i].Version++
+		}
+	}
+	return order, nil
+}
+
+// categoryIndex returns the index of the category with id, or -1. The
+// caller holds the lock.
+func (s *MemoryStore) categoryIndex(id int) int {
+	for i, c := range s.categories {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// productIndex returns the index of the product with id, or -1. The caller
+// holds the lock.
+func (s *MemoryStore) productIndex(id int) int {
+	for i, p := range s.products {
+		if p.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// widgetIndex returns the index of the widget with id, or -1. The caller
+// holds the lock.
+func (s *MemoryStore) widgetIndex(id int) int {
+	for i, w := range s.widgets {
+		if w.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// variantIndex returns the index of the variant with id, or -1. The caller
+// holds the lock.
+func (s *MemoryStore) variantIndex(id int) int {
+	for i, v := range s.variants {
+		if v.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// moveVariantStock adds delta to the stock of the variant with id, if it
+// still exists. The caller holds the write lock and has checked the stock.
+func (s *MemoryStore) moveVariantStock(id, delta int) {
+	if i := s.variantIndex(id); i >= 0 {
+		s.variants[i].Stock += delta
+		s.variants[i].Version++
+	}
+}
+
+// moveWidgetStock applies entry to the widget at index i and appends it to
+// the stock ledger. The caller holds the write lock and has checked the
+// stock.
+func (s *MemoryStore) moveWidgetStock(i int, entry StockEntry) {
+	s.widgets[i].Quantity += entry.Delta
+	s.widgets[i].Version++
+	entry.QuantityAfter = s.widgets[i].Quantity
+	entry.ID = assignID(entry.ID, &s.nextStockID)
+	s.stock = append(s.stock, entry)
+}
+
+// Product variants
+
+type memoryVariants struct{ s *MemoryStore }
+
+func (r memoryVariants) Get(ctx context.Context, id int) (ProductVariant, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	if i := r.s.variantIndex(id); i >= 0 {
+		return r.s.variants[i], nil
+	}
+	return ProductVariant{}, ErrNotFound
+}
+
+func (r memoryVariants) List(ctx context.Context) ([]ProductVariant, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return append([]ProductVariant(nil), r.s.variants...), nil
+}
+
+func (r memoryVariants) ListByProducts(ctx context.Context, productIDs []int) ([]ProductVariant, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	wanted := idSet(productIDs)
+	var result []ProductVariant
+	for _, v := range r.s.variants {
+		if wanted[v.ProductID] {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryVariants) Create(ctx context.Context, variant ProductVariant) (ProductVariant, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	variant.ID = assignID(variant.ID, &r.s.nextVariantID)
+	variant.Version = initialVersion(variant.Version)
+	r.s.variants = append(r.s.variants, variant)
+	return variant, nil
+}
+
+func (r memoryVariants) Update(ctx context.Context, variant ProductVariant) (ProductVariant, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	i := r.s.variantIndex(variant.ID)
+	if i < 0 {
+		return ProductVariant{}, ErrNotFound
+	}
+	if current := r.s.variants[i].Version; current != variant.Version {
+		return ProductVariant{}, &VersionConflictError{Entity: "variant", ID: variant.ID, ExpectedVersion: variant.Version, CurrentVersion: current}
+	}
+	variant.Version++
+	r.s.variants[i] = variant
+	return variant, nil
+}
+
+func (r memoryVariants) Delete(ctx context.Context, id int) (ProductVariant, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	i := r.s.variantIndex(id)
+	if i < 0 {
+		return ProductVariant{}, ErrNotFound
+	}
+	variant := r.s.variants[i]
+	r.s.variants = append(r.s.variants[:i], r.s.variants[i+1:]...)
+	return variant, nil
+}
+
+// Carts
+
+type memoryCarts struct{ s *MemoryStore }
+
+func cloneCart(c Cart) Cart {
+	c.Items = append([]CartItem{}, c.Items...)
+	return c
+}
+
+func (r memoryCarts) Get(ctx context.Context, userID int) (Cart, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, c := range r.s.carts {
+		if c.UserID == userID {
+			return cloneCart(c), nil
+		}
+	}
+	return Cart{}, ErrNotFound
+}
+
+func (r memoryCarts) List(ctx context.Context) ([]Cart, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := make([]Cart, 0, len(r.s.carts))
+	for _, c := range r.s.carts {
+		result = append(result, cloneCart(c))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result, nil
+}
+
+func (r memoryCarts) Put(ctx context.Context, cart Cart) (Cart, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	cart = cloneCart(cart)
+	for i, c := range r.s.carts {
+		if c.UserID == cart.UserID {
+			if c.Version != cart.Version {
+				return Cart{}, &VersionConflictError{Entity: "cart", ID: cart.UserID, ExpectedVersion: cart.Version, CurrentVersion: c.Version}
+			}
+			cart.Version++
+			r.s.carts[i] = cart
+			return cloneCart(cart), nil
+		}
+	}
+	cart.Version++
+	r.s.carts = append(r.s.carts, cart)
+	return cloneCart(cart), nil
+}
+
+// Categories
+
+type memoryCategories struct{ s *MemoryStore }
+
+func (r memoryCategories) Get(ctx context.Context, id int) (Category, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, c := range r.s.categories {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return Category{}, ErrNotFound
+}
+
+func (r memoryCategories) List(ctx context.Context) ([]Category, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := make([]Category, len(r.s.categories))
+	copy(result, r.s.categories)
+	return result, nil
+}
+
+func (r memoryCategories) GetMany(ctx context.Context, ids []int) ([]Category, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	wanted := idSet(ids)
+	var result []Category
+	for _, c := range r.s.categories {
+		if wanted[c.ID] {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryCategories) Create(ctx context.Context, category Category) (Category, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.s.checkLiveParent(category); err != nil {
+		return Category{}, err
+	}
+	category.ID = assignID(category.ID, &r.s.nextCategoryID)
+	category.Version = initialVersion(category.Version)
+	r.s.categories = append(r.s.categories, category)
+	return category, nil
+}
+
+func (r memoryCategories) Update(ctx context.Context, category Category) (Category, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	i := r.s.categoryIndex(category.ID)
+	if i < 0 {
+		return Category{}, ErrNotFound
+	}
+	if c := r.s.categories[i]; c.Version != category.Version {
+		return Category{}, &VersionConflictError{Entity: "category", ID: category.ID, ExpectedVersion: category.Version, CurrentVersion: c.Version}
+	}
+	if err := r.s.checkLiveParent(category); err != nil {
+		return Category{}, err
+	}
+	category.Version++
+	r.s.categories[i] = category
+	return category, nil
+}
+
+func (r memoryCategories) Move(ctx context.Context, id int, parentID *int) (Category, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := checkCategoryMove(r.s.categories, id, parentID); err != nil {
+		return Category{}, err
+	}
+	c := &r.s.categories[r.s.categoryIndex(id)]
+	c.ParentID = parentID
+	c.Version++
+	return *c, nil
+}
+
+func (r memoryCategories) Delete(ctx context.Context, id int, deletedAt time.Time, deletedBy int) (Category, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	products := 0
+	for _, p := range r.s.products {
+		if p.CategoryID == id && !p.isDeleted() {
+			products++
+		}
+	}
+	if err := checkCategoryDelete(r.s.categories, id, products); err != nil {
+		return Category{}, err
+	}
+	c := &r.s.categories[r.s.categoryIndex(id)]
+	c.DeletedAt, c.DeletedBy = &deletedAt, &deletedBy
+	c.Version++
+	return *c, nil
+}
+
+// checkLiveParent returns a *CategoryNotFoundError if category is not
+// deleted but its parent is. A parent that does not exist yet is allowed,
+// so snapshots can be restored in ID order. The caller holds the lock.
+func (s *MemoryStore) checkLiveParent(category Category) error {
+	if category.ParentID == nil || category.isDeleted() {
+		return nil
+	}
+	if i := s.categoryIndex(*category.ParentID); i >= 0 && s.categories[i].isDeleted() {
+		return &CategoryNotFoundError{ID: *category.ParentID}
+	}
+	return nil
+}
+
+// Reviews
+
+type memoryReviews struct{ s *MemoryStore }
+
+func (r memoryReviews) Get(ctx context.Context, id int) (Review, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, rev := range r.s.reviews {
+		if rev.ID == id {
+			return rev, nil
+		}
+	}
+	return Review{}, ErrNotFound
+}
+
+func (r memoryReviews) List(ctx context.Context) ([]Review, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := make([]Review, len(r.s.reviews))
+	copy(result, r.s.reviews)
+	return result, nil
+}
+
+func (r memoryReviews) ListByProduct(ctx context.Context, productID int) ([]Review, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var result []Review
+	for _, rev := range r.s.reviews {
+		if rev.ProductID == productID {
+			result = append(result, rev)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryReviews) ListByUser(ctx context.Context, userID int) ([]Review, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var result []Review
+	for _, rev := range r.s.reviews {
+		if rev.UserID == userID {
+			result = append(result, rev)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryReviews) ListByProducts(ctx context.Context, productIDs []int) ([]Review, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	wanted := idSet(productIDs)
+	var result []Review
+	for _, rev := range r.s.reviews {
+		if wanted[rev.ProductID] {
+			result = append(result, rev)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryReviews) ListByUsers(ctx context.Context, userIDs []int) ([]Review, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	wanted := idSet(userIDs)
+	var result []Review
+	for _, rev := range r.s.reviews {
+		if wanted[rev.UserID] {
+			result = append(result, rev)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryReviews) Create(ctx context.Context, review Review) (Review, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.duplicate(review); err != nil {
+		return Review{}, err
+	}
+	review.ID = assignID(review.ID, &r.s.nextReviewID)
+	review.Status = initialReviewStatus(review.Status)
+	r.s.reviews = append(r.s.reviews, review)
+	return review, updateRatings(r.ratingStore(), nil, review)
+}
+
+func (r memoryReviews) Update(ctx context.Context, review Review) (Review, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for i, rev := range r.s.reviews {
+		if rev.ID == review.ID {
+			if err := r.duplicate(review); err != nil {
+				return Review{}, err
+			}
+			r.s.reviews[i] = review
+			return review, updateRatings(r.ratingStore(), &rev, review)
+		}
+	}
+	return Review{}, ErrNotFound
+}
+
+// duplicate returns a *DuplicateReviewError if review is not deleted and
+// its user has another review of its product that is not deleted either.
+// The caller holds the write lock.
+func (r memoryReviews) duplicate(review Review) error {
+	if review.isDeleted() {
+		return nil
+	}
+	for _, rev := range r.s.reviews {
+		if rev.ID != review.ID && rev.ProductID == review.ProductID && rev.UserID == review.UserID && !rev.isDeleted() {
+			return &DuplicateReviewError{ProductID: review.ProductID, UserID: review.UserID, ReviewID: rev.ID}
+		}
+	}
+	return nil
+}
+
+// ratingStore works on the rating summaries under the write lock.
+func (r memoryReviews) ratingStore() ratingStore {
+	return ratingStore{
+		get: func(productID int) (RatingSummary, error) {
+			return r.s.ratingSummary(productID), nil
+		},
+		put: func(summary RatingSummary) error {
+			r.s.ratings[summary.ProductID] = summary
+			return nil
+		},
+		reviews: func(productID int) ([]Review, error) {
+			var result []Review
+			for _, rev := range r.s.reviews {
+				if rev.ProductID == productID {
+					result = append(result, rev)
+				}
+			}
+			return result, nil
+		},
+	}
+}
+
+// Rating summaries
+
+type memoryRatings struct{ s *MemoryStore }
+
+// ratingSummary returns the summary of a product. The caller must hold the
+// lock.
+func (s *MemoryStore) ratingSummary(productID int) RatingSummary {
+	if summary, ok := s.ratings[productID]; ok {
+		return summary
+	}
+	return RatingSummary{ProductID: productID}
+}
+
+func (r memoryRatings) Get(ctx context.Context, productID int) (RatingSummary, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.ratingSummary(productID), nil
+}
+
+func (r memoryRatings) List(ctx context.Context) ([]RatingSummary, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var result []RatingSummary
+	for _, summary := range r.s.ratings {
+		if summary.Count > 0 {
+			result = append(result, summary)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ProductID < result[j].ProductID })
+	return result, nil
+}
+
+func (r memoryRatings) ListByProducts(ctx context.Context, productIDs []int) ([]RatingSummary, error) {
+	all, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wanted := idSet(productIDs)
+	var result []RatingSummary
+	for _, summary := range all {
+		if wanted[summary.ProductID] {
+			result = append(result, summary)
+		}
+	}
+	return result, nil
+}
+
+// Users
+
+type memoryUsers struct{ s *MemoryStore }
+
+func (r memoryUsers) Get(ctx context.Context, id int) (User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, u := range r.s.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (r memoryUsers) List(ctx context.Context) ([]User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := make([]User, len(r.s.users))
+	copy(result, r.s.users)
+	return result, nil
+}
+
+func (r memoryUsers) GetMany(ctx context.Context, ids []int) ([]User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	wanted := idSet(ids)
+	var result []User
+	for _, u := range r.s.users {
+		if wanted[u.ID] {
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+func (r memoryUsers) Create(ctx context.Context, user User) (User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user.ID = assignID(user.ID, &r.s.nextUserID)
+	r.s.users = append(r.s.users, user)
+	return user, nil
+}
+
+// Employees are stored as private copies so callers can never mutate the
+// stored *Developer or *Manager through a returned pointer.
+
+type memoryEmployees struct{ s *MemoryStore }
+
+func (r memoryEmployees) Get(ctx context.Context, id int) (*Employee, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, emp := range r.s.employees {
+		if emp.ID == id {
+			return cloneEmployee(emp), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r memoryEmployees) List(ctx context.Context) ([]*Employee, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := make([]*Employee, 0, len(r.s.employees))
+	for _, emp := range r.s.employees {
+		result = append(result, cloneEmployee(emp))
+	}
+	return result, nil
+}
+
+func (r memoryEmployees) GetMany(ctx context.Context, ids []int) ([]*Employee, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	wanted := idSet(ids)
+	var result []*Employee
+	for _, emp := range r.s.employees {
+		if wanted[emp.ID] {
+			result = append(result, cloneEmployee(emp))
+		}
+	}
+	return result, nil
+}
+
+func (r memoryEmployees) ListByManagers(ctx context.Context, managerIDs []int) ([]*Employee, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	wanted := idSet(managerIDs)
+	var result []*Employee
+	for _, emp := range r.s.employees {
+		if emp.ManagerID != nil && wanted[*emp.ManagerID] {
+			result = append(result, cloneEmployee(emp))
+		}
+	}
+	return result, nil
+}
+
+func (r memoryEmployees) Create(ctx context.Context, employee *Employee) (*Employee, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored := cloneEmployee(employee)
+	stored.ID = assignID(stored.ID, &r.s.nextEmployeeID)
+	stored.Version = initialVersion(stored.Version)
+	r.s.employees = append(r.s.employees, stored)
+	return cloneEmployee(stored), nil
+}
+
+func (r memoryEmployees) Update(ctx context.Context, employee *Employee) (*Employee, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for i, emp := range r.s.employees {
+		if emp.ID == employee.ID {
+			if emp.Version != employee.Version {
+				return nil, &VersionConflictError{Entity: "employee", ID: emp.ID, ExpectedVersion: employee.Version, CurrentVersion: emp.Version}
+			}
+			stored := cloneEmployee(employee)
+			stored.Version++
+			r.s.employees[i] = stored
+			return cloneEmployee(stored), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r memoryEmployees) AssignManager(ctx context.Context, id int, managerID *int, version int) (*Employee, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := checkManagerAssignment(r.s.employees, id, managerID); err != nil {
+		return nil, err
+	}
+	for i, emp := range r.s.employees {
+		if emp.ID == id {
+			if emp.Version != version {
+				return nil, &VersionConflictError{Entity: "employee", ID: id, ExpectedVersion: version, CurrentVersion: emp.Version}
+			}
+			stored := cloneEmployee(emp)
+			stored.ManagerID = cloneInt(managerID)
+			stored.Version++
+			r.s.employees[i] = stored
+			return cloneEmployee(stored), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r memoryEmployees) ReassignReports(ctx context.Context, fromManagerID int, toManagerID *int) ([]*Employee, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := checkReportsReassignment(r.s.employees, fromManagerID, toManagerID); err != nil {
+		return nil, err
+	}
+	moved := []*Employee{}
+	for i, emp := range r.s.employees {
+		if emp.ManagerID != nil && *emp.ManagerID == fromManagerID {
+			stored := cloneEmployee(emp)
+			stored.ManagerID = cloneInt(toManagerID)
+			stored.Version++
+			r.s.employees[i] = stored
+			moved = append(moved, cloneEmployee(stored))
+		}
+	}
+	return moved, nil
+}
+
+// Audit
+
+type memoryAudit struct{ s *MemoryStore }
+
+func (r memoryAudit) Append(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	entry.ID = assignID(0, &r.s.nextAuditID)
+	r.s.audit = append(r.s.audit, entry)
+	return entry, nil
+}
+
+func (r memoryAudit) List(ctx context.Context, filter AuditFilter, before, limit int) ([]AuditEntry, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var result []AuditEntry
+	for i := len(r.s.audit) - 1; i >= 0 && len(result) < limit; i-- {
+		entry := r.s.audit[i]
+		if (before == 0 || entry.ID < before) && filter.matches(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
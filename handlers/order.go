@@ -0,0 +1,578 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gburgyan/go-quickgraph"
+)
+
+// Orders are placed by authenticated users for ACTIVE products and for
+// widgets. Placing an order takes its items out of stock, and a product that
+// runs out moves to OUT_OF_STOCK; widget stock is recorded in the widget's
+// stock ledger. An order is shipped and then delivered by an admin; until
+// it ships, its customer or an admin can cancel it, which puts the items
+// back into stock. Every status change is sent to the orderStatusUpdates
+// subscribers of the order.
+
+type OrderStatus string
+
+const (
+	OrderStatusPlaced    OrderStatus = "PLACED"
+	OrderStatusShipped   OrderStatus = "SHIPPED"
+	OrderStatusDelivered OrderStatus = "DELIVERED"
+	OrderStatusCancelled OrderStatus = "CANCELLED"
+)
+
+// EnumValues implements the StringEnumValues interface for schema generation
+func (OrderStatus) EnumValues() []string {
+	return []string{"PLACED", "SHIPPED", "DELIVERED", "CANCELLED"}
+}
+
+// orderTransitions lists the statuses an order can move to from each
+// status. DELIVERED and CANCELLED are final.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPlaced:  {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped: {OrderStatusDelivered},
+}
+
+// orderStatusMessages are the messages sent to orderStatusUpdates
+// subscribers when an order enters a status.
+var orderStatusMessages = map[OrderStatus]string{
+	OrderStatusPlaced:    "Order has been placed",
+	OrderStatusShipped:   "Order has been shipped",
+	OrderStatusDelivered: "Order has been delivered",
+	OrderStatusCancelled: "Order has been cancelled",
+}
+
+type Order struct {
+	ID          int
+	UserID      int
+	Items       []OrderItem
+	Total       Money // Sum of the items at the prices they were ordered at
+	Status      OrderStatus
+	PlacedAt    time.Time
+	ShippedAt   *time.Time
+	DeliveredAt *time.Time
+	CancelledAt *time.Time
+	Version     int // Incremented on every update
+
+	// Scope used by the field resolvers below
+	scope *scope `json:"-" graphy:"-"`
+}
+
+type OrderItem struct {
+	ProductID *int // Set for product items
+	VariantID *int // Set for product items of a product with variants
+	WidgetID  *int // Set for widget items
+	Quantity  int
+	UnitPrice Money // Price when the order was placed
+
+	// Scope used by the field resolvers below
+	scope *scope `json:"-" graphy:"-"`
+}
+
+// OrderItemInput names either a product, with its variant if it has
+// variants, or a widget.
+type OrderItemInput struct {
+	ProductID *int `json:"productId"`
+	VariantID *int `json:"variantId"`
+	WidgetID  *int `json:"widgetId"`
+	Quantity  int  `json:"quantity"`
+}
+
+// itemKey identifies the product, with its variant, or the widget of an
+// order or cart item.
+type itemKey struct {
+	productID, variantID, widgetID int
+}
+
+// newItemKey returns the key of the item with productID or widgetID, of
+// which exactly one must be set. variantID can only be set with productID.
+func newItemKey(productID, variantID, widgetID *int) (itemKey, error) {
+	switch {
+	case productID != nil && widgetID == nil:
+		key := itemKey{productID: *productID}
+		if variantID != nil {
+			key.variantID = *variantID
+		}
+		return key, nil
+	case widgetID != nil && productID == nil:
+		if variantID != nil {
+			return itemKey{}, errors.New("variantId can only be given with productId")
+		}
+		return itemKey{widgetID: *widgetID}, nil
+	}
+	return itemKey{}, errors.New("exactly one of productId and widgetId is required")
+}
+
+// ids returns the product, variant and widget ID of the item, leaving the
+// ones that are not set nil.
+func (k itemKey) ids() (productID, variantID, widgetID *int) {
+	if k.widgetID != 0 {
+		return nil, nil, intPtr(k.widgetID)
+	}
+	if k.variantID != 0 {
+		variantID = intPtr(k.variantID)
+	}
+	return intPtr(k.productID), variantID, nil
+}
+
+func (k itemKey) String() string {
+	switch {
+	case k.widgetID != 0:
+		return fmt.Sprintf("widget %d", k.widgetID)
+	case k.variantID != 0:
+		return fmt.Sprintf("variant %d of product %d", k.variantID, k.productID)
+	}
+	return fmt.Sprintf("product %d", k.productID)
+}
+
+// InsufficientProductStockError is returned by OrderRepository.Place when an
+// item asks for more than its product, or its variant, has in stock.
+type InsufficientProductStockError struct {
+	ProductID int
+	VariantID *int // Set if the variant is short of stock
+	Stock     int  // Units in stock
+	Quantity  int  // Units ordered
+}
+
+func (e *InsufficientProductStockError) Error() string {
+	if e.VariantID != nil {
+		return fmt.Sprintf("variant %d of product %d has %d in stock, cannot order %d", *e.VariantID, e.ProductID, e.Stock, e.Quantity)
+	}
+	return fmt.Sprintf("product %d has %d in stock, cannot order %d", e.ProductID, e.Stock, e.Quantity)
+}
+
+// As lets quickgraph report the error with a machine-readable code and the
+// units in stock.
+func (e *InsufficientProductStockError) As(target interface{}) bool {
+	ge, ok := target.(*quickgraph.GraphError)
+	if !ok {
+		return false
+	}
+	*ge = quickgraph.GraphError{
+		Message:    e.Error(),
+		InnerError: e,
+		Extensions: map[string]string{
+			"code":      "INSUFFICIENT_STOCK",
+			"productId": strconv.Itoa(e.ProductID),
+			"stock":     strconv.Itoa(e.Stock),
+		},
+	}
+	if e.VariantID != nil {
+		ge.Extensions["variantId"] = strconv.Itoa(*e.VariantID)
+	}
+	return true
+}
+
+// orderStockEntry returns the stock ledger entry for a widget item of order,
+// taking the item out when the order is placed and putting it back when it
+// is cancelled. It is derived from the order alone, so replaying the order
+// recreates the same entry.
+func orderStockEntry(order Order, item OrderItem) StockEntry {
+	entry := StockEntry{WidgetID: *item.WidgetID, Delta: -item.Quantity, Reason: fmt.Sprintf("order #%d", order.ID),
+		UserID: &order.UserID, Time: order.PlacedAt}
+	if order.Status == OrderStatusCancelled {
+		entry.Delta, entry.Reason = item.Quantity, fmt.Sprintf("order #%d cancelled", order.ID)
+		if order.CancelledAt != nil {
+			entry.Time = *order.CancelledAt
+		}
+	}
+	return entry
+}
+
+// canSeeOrder reports whether user may see an order of userID: its
+// customer and admins can.
+func canSeeOrder(user *User, userID int) bool {
+	return user.ID == userID || user.Role == UserRoleAdmin
+}
+
+// visibleOrder returns the order with id if the user in ctx may see it.
+// Other users' orders are reported as not found.
+func (h *ProductHandlers) visibleOrder(ctx context.Context, id int) (Order, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return Order{}, err
+	}
+	order, err := h.store.Orders().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && !canSeeOrder(user, order.UserID)) {
+		return Order{}, fmt.Errorf("order with id %d not found", id)
+	}
+	return order, err
+}
+
+// GetOrder returns an order of the authenticated user. Admins can get any
+// order.
+func (h *ProductHandlers) GetOrder(ctx context.Context, id int) (*Order, error) {
+	order, err := h.visibleOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	order.bind(newScope(ctx, h.store))
+	return &order, nil
+}
+
+// MyOrders returns a page of the orders of the authenticated user, oldest
+// first.
+func (h *ProductHandlers) MyOrders(ctx context.Context, first *int, after *string, last *int, before *string) (*OrderConnection, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := h.store.Orders().ListByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newOrderConnection(bindOrders(newScope(ctx, h.store), orders), pageArgs(first, after, last, before))
+}
+
+// PlaceOrder orders items for the authenticated user. Items for the same
+// product or widget are combined. Every product must be ACTIVE, and every
+// item must have enough stock.
+func (h *ProductHandlers) PlaceOrder(ctx context.Context, items []OrderItemInput) (*Order, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("an order needs at least one item")
+	}
+
+	order := Order{UserID: user.ID, Status: OrderStatusPlaced, PlacedAt: time.Now().UTC()}
+	index := make(map[itemKey]int)
+	for _, item := range items {
+		key, err := newItemKey(item.ProductID, item.VariantID, item.WidgetID)
+		if err != nil {
+			return nil, err
+		}
+		if item.Quantity < 1 {
+			return nil, fmt.Errorf("quantity of %s must be at least 1", key)
+		}
+		if i, ok := index[key]; ok {
+			order.Items[i].Quantity += item.Quantity
+			continue
+		}
+		index[key] = len(order.Items)
+		productID, variantID, widgetID := key.ids()
+		order.Items = append(order.Items, OrderItem{ProductID: productID, VariantID: variantID, WidgetID: widgetID, Quantity: item.Quantity})
+	}
+	return h.placeOrder(ctx, order)
+}
+
+// placeOrder prices the items of order at the current prices and places it.
+// The total is summed in cents so it carries no rounding error.
+func (h *ProductHandlers) placeOrder(ctx context.Context, order Order) (*Order, error) {
+	total := Money{Currency: storeCurrency}
+	for i, item := range order.Items {
+		key, err := newItemKey(item.ProductID, item.VariantID, item.WidgetID)
+		if err != nil {
+			return nil, err
+		}
+		price, err := h.orderable(ctx, key, item.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		if total, err = total.Add(price.Multiply(float64(item.Quantity))); err != nil {
+			return nil, err
+		}
+		order.Items[i].UnitPrice = price
+	}
+	order.Total = total
+
+	order, err := h.store.Orders().Place(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	h.store.Events().broadcastOrderStatus(ctx, order)
+	h.syncStockStatus(ctx, order)
+	order.bind(newScope(ctx, h.store))
+	return &order, nil
+}
+
+// orderable returns the current unit price of the item with key if quantity
+// of it can be ordered: products must be ACTIVE, products with variants are
+// ordered by variant, and every item must have the quantity in stock.
+// Placing the order checks the stock again.
+func (h *ProductHandlers) orderable(ctx context.Context, key itemKey, quantity int) (Money, error) {
+	if key.widgetID != 0 {
+		widget, err := h.store.Widgets().Get(ctx, key.widgetID)
+		if errors.Is(err, ErrNotFound) || (err == nil && widget.isDeleted()) {
+			return Money{}, fmt.Errorf("widget with id %d not found", key.widgetID)
+		}
+		if err != nil {
+			return Money{}, err
+		}
+		if widget.Quantity < quantity {
+			return Money{}, &InsufficientStockError{WidgetID: widget.ID, Quantity: widget.Quantity, Delta: -quantity}
+		}
+		return widget.Price, nil
+	}
+
+	product, err := h.store.Products().Get(ctx, key.productID)
+	if errors.Is(err, ErrNotFound) || (err == nil && product.isDeleted()) {
+		return Money{}, fmt.Errorf("product with id %d not found", key.productID)
+	}
+	if err != nil {
+		return Money{}, err
+	}
+	if product.Status != ProductStatusActive {
+		return Money{}, fmt.Errorf("product %d is %s and cannot be ordered", product.ID, product.Status)
+	}
+	if key.variantID != 0 {
+		variant, err := h.variantOf(ctx, product.ID, key.variantID)
+		if err != nil {
+			return Money{}, err
+		}
+		if variant.Stock < quantity {
+			return Money{}, &InsufficientProductStockError{ProductID: product.ID, VariantID: &variant.ID, Stock: variant.Stock, Quantity: quantity}
+		}
+		return variant.unitPrice(product), nil
+	}
+	variants, err := h.store.ProductVariants().ListByProducts(ctx, []int{product.ID})
+	if err != nil {
+		return Money{}, err
+	}
+	if len(variants) > 0 {
+		return Money{}, fmt.Errorf("product %d has variants; order one of them with variantId", product.ID)
+	}
+	if product.Stock < quantity {
+		return Money{}, &InsufficientProductStockError{ProductID: product.ID, Stock: product.Stock, Quantity: quantity}
+	}
+	return product.Price, nil
+}
+
+// CancelOrder cancels an order that has not shipped yet and puts its items
+// back into stock. Only its customer and admins can cancel it.
+func (h *ProductHandlers) CancelOrder(ctx context.Context, id int) (*Order, error) {
+	order, err := h.visibleOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOrderTransition(order, OrderStatusCancelled); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	order.Status, order.CancelledAt = OrderStatusCancelled, &now
+	order, err = h.store.Orders().Cancel(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	h.store.Events().broadcastOrderStatus(ctx, order)
+	h.syncStockStatus(ctx, order)
+	order.bind(newScope(ctx, h.store))
+	return &order, nil
+}
+
+// ShipOrder marks an order as shipped. Requires the admin role.
+func (h *ProductHandlers) ShipOrder(ctx context.Context, id int) (*Order, error) {
+	return h.advanceOrder(ctx, id, OrderStatusShipped, func(o *Order, now *time.Time) { o.ShippedAt = now })
+}
+
+// DeliverOrder marks a shipped order as delivered. Requires the admin role.
+func (h *ProductHandlers) DeliverOrder(ctx context.Context, id int) (*Order, error) {
+	return h.advanceOrder(ctx, id, OrderStatusDelivered, func(o *Order, now *time.Time) { o.DeliveredAt = now })
+}
+
+func (h *ProductHandlers) advanceOrder(ctx context.Context, id int, status OrderStatus, stamp func(*Order, *time.Time)) (*Order, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	order, err := h.visibleOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOrderTransition(order, status); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	order.Status = status
+	stamp(&order, &now)
+	order, err = h.store.Orders().Update(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	h.store.Events().broadcastOrderStatus(ctx, order)
+	order.bind(newScope(ctx, h.store))
+	return &order, nil
+}
+
+// checkOrderTransition returns an error unless order can move to status.
+func checkOrderTransition(order Order, status OrderStatus) error {
+	var names []string
+	for _, s := range orderTransitions[order.Status] {
+		if s == status {
+			return nil
+		}
+		names = append(names, string(s))
+	}
+	if len(names) == 0 {
+		names = []string{"none"}
+	}
+	return fmt.Errorf("order %d cannot change from %s to %s; allowed next statuses: %s",
+		order.ID, order.Status, status, strings.Join(names, ", "))
+}
+
+// syncStockStatus updates the status of the products of order to their new
+// stock and tells the productUpdates and widgetUpdates subscribers about
+// the items. Widgets that just dropped to their reorder threshold raise a
+// low-stock alert. The order has already been committed, so a failure is
+// logged rather than failing the request.
+func (h *ProductHandlers) syncStockStatus(ctx context.Context, order Order) {
+	for _, item := range order.Items {
+		if item.WidgetID != nil {
+			widget, err := h.store.Widgets().Get(ctx, *item.WidgetID)
+			if err != nil {
+				log.Printf("Failed to sync stock of widget %d for order %d: %v", *item.WidgetID, order.ID, err)
+				continue
+			}
+			previous := widget.Quantity + item.Quantity
+			if order.Status == OrderStatusCancelled {
+				previous = widget.Quantity - item.Quantity
+			}
+			h.store.Events().BroadcastWidgetUpdate(ctx, widget, "updated")
+			h.store.Events().alertIfLowStock(ctx, lowStock(previous, widget.ReorderThreshold), previous, widget)
+			continue
+		}
+		product, err := h.store.Products().Get(ctx, *item.ProductID)
+		if err == nil {
+			_, err = h.updateStockStatus(ctx, product)
+		}
+		if err != nil {
+			log.Printf("Failed to sync stock status of product %d for order %d: %v", *item.ProductID, order.ID, err)
+		}
+	}
+}
+
+// updateStockStatus moves product to OUT_OF_STOCK if it ran out of stock,
+// or back to ACTIVE if it has stock again and may be published, and
+// broadcasts the product.
+func (h *ProductHandlers) updateStockStatus(ctx context.Context, product Product) (*Product, error) {
+	if product.Stock == 0 && product.Status == ProductStatusActive {
+		return h.changeProductStatus(ctx, product, ProductStatusOutOfStock)
+	}
+	if product.Stock > 0 && product.Status == ProductStatusOutOfStock {
+		category, err := h.productCategory(ctx, product)
+		if err != nil {
+			return nil, err
+		}
+		if checkStatusTransition(product, category, ProductStatusActive) == nil {
+			return h.changeProductStatus(ctx, product, ProductStatusActive)
+		}
+	}
+	product.bind(newScope(ctx, h.store))
+	h.store.Events().BroadcastProductUpdate(ctx, product, "updated")
+	return &product, nil
+}
+
+// broadcastOrderStatus tells the orderStatusUpdates subscribers of order
+// about its current status.
+func (e *Events) broadcastOrderStatus(ctx context.Context, order Order) {
+	e.BroadcastOrderUpdate(ctx, order, orderStatusMessages[order.Status])
+}
+
+// bind attaches sc to the order and its items so their field resolvers
+// work.
+func (o *Order) bind(sc *scope) {
+	o.scope = sc
+	for i := range o.Items {
+		o.Items[i].scope = sc
+	}
+}
+
+// bindOrders attaches sc to each order so its field resolvers work.
+func bindOrders(sc *scope, orders []Order) []Order {
+	for i := range orders {
+		orders[i].bind(sc)
+	}
+	return orders
+}
+
+// Field resolvers
+
+func (o *Order) User() (*User, error) {
+	u, ok, err := o.scope.loaders.users.load(o.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("user with id %d not found", o.UserID)
+	}
+	return &u, nil
+}
+
+// Product returns the ordered product, even if it was deleted since. It is
+// null for widget items.
+func (i *OrderItem) Product() (*Product, error) {
+	return itemProduct(i.scope, i.ProductID)
+}
+
+// Variant returns the ordered variant of the product. It is null for
+// products without variants, widgets, and variants that were removed since.
+func (i *OrderItem) Variant() (*ProductVariant, error) {
+	return itemVariant(i.scope, i.VariantID)
+}
+
+// Widget returns the ordered widget, even if it was deleted since. It is
+// null for product items.
+func (i *OrderItem) Widget() (*Widget, error) {
+	return itemWidget(i.scope, i.WidgetID)
+}
+
+// itemProduct returns the product with id for an order or cart item, or nil
+// if id is.
+func itemProduct(sc *scope, id *int) (*Product, error) {
+	if id == nil {
+		return nil, nil
+	}
+	p, err := sc.store.Products().Get(sc.ctx, *id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("product with id %d not found", *id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.bind(sc)
+	return &p, nil
+}
+
+// itemVariant returns the variant with id for an order or cart item, or nil
+// if id is or the variant was removed.
+func itemVariant(sc *scope, id *int) (*ProductVariant, error) {
+	if id == nil {
+		return nil, nil
+	}
+	v, err := sc.store.ProductVariants().Get(sc.ctx, *id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.scope = sc
+	return &v, nil
+}
+
+// itemWidget returns the widget with id for an order or cart item, or nil if
+// id is.
+func itemWidget(sc *scope, id *int) (*Widget, error) {
+	if id == nil {
+		return nil, nil
+	}
+	w, err := sc.store.Widgets().Get(sc.ctx, *id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("widget with id %d not found", *id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.bind(sc)
+	return &w, nil
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrders(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		admin, customer, other := userContext(t, store, 1), userContext(t, store, 2), userContext(t, store, 3)
+		run := func(ctx context.Context, request string) string {
+			res, _ := graph.ProcessRequest(ctx, request, "")
+			return res
+		}
+
+		for _, tc := range []struct {
+			ctx           context.Context
+			request, want string
+		}{
+			// Ordering all smartphones sells product 4 out
+			{customer, `mutation { PlaceOrder(items: [{productId: 4, quantity: 6}, {productId: 4, quantity: 4}]) { Items { Quantity UnitPrice } Status } }`,
+				`"Items":[{"Quantity":10,"UnitPrice":"699.99 USD"}],"Status":"PLACED"`},
+			{customer, `{ GetProduct(id: 4) { InStock Status Stock } }`,
+				`"InStock":false,"Status":"OUT_OF_STOCK","Stock":0}`},
+			{customer, `mutation { PlaceOrder(items: [{productId: 3, quantity: 1}]) { ID } }`,
+				"product 3 is OUT_OF_STOCK and cannot be ordered"},
+
+			// An order that cannot be filled changes no stock
+			{customer, `mutation { PlaceOrder(items: [{productId: 2, quantity: 1}, {productId: 1, quantity: 30}]) { ID } }`,
+				`"extensions":{"code":"INSUFFICIENT_STOCK","productId":"1","stock":"25"}`},
+			{customer, `{ GetProduct(id: 2) { Stock } }`, `"Stock":100}`},
+			{customer, `mutation { PlaceOrder(items: []) { ID } }`, "an order needs at least one item"},
+
+			// Orders are private to their customer and admins
+			{other, `{ GetOrder(id: 1) { ID } }`, "order with id 1 not found"},
+			{other, `mutation { CancelOrder(id: 1) { ID } }`, "order with id 1 not found"},
+			{admin, `{ GetOrder(id: 1) { Total User { Username } } }`, `"Total":"6999.90 USD","User":{"Username":"john_customer"}`},
+			{customer, `mutation { ShipOrder(id: 1) { ID } }`, "admin role required"},
+
+			// Cancelling puts the smartphones back on sale
+			{customer, `mutation { CancelOrder(id: 1) { Status } }`, `"Status":"CANCELLED"`},
+			{customer, `{ GetProduct(id: 4) { InStock Status Stock } }`,
+				`"InStock":true,"Status":"ACTIVE","Stock":10}`},
+			{customer, `mutation { CancelOrder(id: 1) { ID } }`,
+				"order 1 cannot change from CANCELLED to CANCELLED; allowed next statuses: none"},
+
+			// A second order is shipped and delivered
+			{customer, `mutation { PlaceOrder(items: [{productId: 2, quantity: 2}]) { ID } }`, `"ID":2`},
+			{admin, `mutation { DeliverOrder(id: 2) { ID } }`,
+				"order 2 cannot change from PLACED to DELIVERED; allowed next statuses: SHIPPED, CANCELLED"},
+			{admin, `mutation { ShipOrder(id: 2) { Status } }`, `"Status":"SHIPPED"`},
+			{customer, `mutation { CancelOrder(id: 2) { ID } }`,
+				"order 2 cannot change from SHIPPED to CANCELLED; allowed next statuses: DELIVERED"},
+			{admin, `mutation { DeliverOrder(id: 2) { Status } }`, `"Status":"DELIVERED"`},
+			{customer, `{ GetProduct(id: 2) { Stock } }`, `"Stock":98}`},
+			{customer, `{ MyOrders { edges { node { ID Status } } totalCount } }`,
+				`"edges":[{"node":{"ID":1,"Status":"CANCELLED"}},{"node":{"ID":2,"Status":"DELIVERED"}}],"totalCount":2`},
+			{other, `{ MyOrders { totalCount } }`, `"totalCount":0`},
+		} {
+			if res := run(tc.ctx, tc.request); !strings.Contains(res, tc.want) {
+				t.Errorf("%s: expected %s, got %s", tc.request, tc.want, res)
+			}
+		}
+	})
+}
+
+// failingStatusStore is a Store whose product updates all fail.
+type failingStatusStore struct{ Store }
+
+func (s failingStatusStore) Products() ProductRepository {
+	return failingProductUpdates{s.Store.Products()}
+}
+
+type failingProductUpdates struct{ ProductRepository }
+
+func (failingProductUpdates) Update(ctx context.Context, product Product) (Product, error) {
+	return Product{}, errors.New("disk full")
+}
+
+func TestStockStatusFailureDoesNotFailOrder(t *testing.T) {
+	forEachStore(t, func(t *testing.T, base Store) {
+		ctx := context.Background()
+		h := NewProductHandlers(failingStatusStore{base})
+		customer := userContext(t, base, 2)
+
+		order, err := h.PlaceOrder(customer, []OrderItemInput{{ProductID: intPtr(4), Quantity: 10}})
+		if err != nil {
+			t.Fatalf("expected the placed order to be returned, got %v", err)
+		}
+		if _, err := base.Orders().Get(ctx, order.ID); err != nil {
+			t.Errorf("expected the order to be stored, got %v", err)
+		}
+		if _, err := h.CancelOrder(customer, order.ID); err != nil {
+			t.Errorf("expected the cancelled order to be returned, got %v", err)
+		}
+	})
+}
+
+func TestOrderStatusUpdatesFollowTheOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(WithTenant(context.Background(), "orders"))
+	defer cancel()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	h, subs := NewProductHandlers(store), NewSubscriptionHandlers(store)
+	userCtx := func(id int) context.Context {
+		user, err := store.Users().Get(ctx, id)
+		if err != nil {
+			t.Fatalf("Get user %d failed: %v", id, err)
+		}
+		return context.WithValue(ctx, UserContextKey, &user)
+	}
+	admin, customer, other := userCtx(1), userCtx(2), userCtx(3)
+
+	if _, err := subs.OrderStatusUpdates(ctx, 1); err == nil {
+		t.Error("expected anonymous subscriptions to be rejected")
+	}
+	mine, _ := subs.OrderStatusUpdates(customer, 1)
+	theirs, _ := subs.OrderStatusUpdates(other, 1)
+
+	// Nothing is sent until the order changes
+	select {
+	case update := <-mine:
+		t.Fatalf("expected no update before the order is placed, got %+v", update)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := h.PlaceOrder(customer, []OrderItemInput{{ProductID: intPtr(2), Quantity: 1}}); err != nil {
+		t.Fatalf("PlaceOrder failed: %v", err)
+	}
+	if _, err := h.ShipOrder(admin, 1); err != nil {
+		t.Fatalf("ShipOrder failed: %v", err)
+	}
+	for _, want := range []OrderStatus{OrderStatusPlaced, OrderStatusShipped} {
+		select {
+		case update := <-mine:
+			if update.OrderID != 1 || update.Status != want {
+				t.Errorf("expected order 1 to be %s, got %+v", want, update)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected the customer to receive %s", want)
+		}
+	}
+	select {
+	case update := <-theirs:
+		t.Errorf("expected another customer to receive nothing, got %+v", update)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// List fields return Relay-style connections: a page of edges, each with a
+// node and its cursor, plus the pageInfo and totalCount of the whole list.
+// Pages are selected with first/after or last/before. Cursors are opaque to
+// clients; they name a node by kind and ID, so a cursor stays valid for as
+// long as the list keeps its order, even after the node it names is deleted.
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// PageInput selects a page of a connection.
+type PageInput struct {
+	First  *int    `json:"first"`  // Page size, counted from the start
+	After  *string `json:"after"`  // Only nodes after this cursor
+	Last   *int    `json:"last"`   // Page size, counted from the end
+	Before *string `json:"before"` // Only nodes before this cursor
+}
+
+// pageArgs collects the pagination arguments of a query.
+func pageArgs(first *int, after *string, last *int, before *string) PageInput {
+	return PageInput{First: first, After: after, Last: last, Before: before}
+}
+
+// PageParam is the argument of the connection fields of types, such as
+// Product.Reviews. quickgraph requires every argument of a field resolver
+// to be passed, so instead of first/after/last/before these fields take a
+// single page argument; page: {} selects the first page.
+type PageParam struct {
+	Page PageInput `json:"page"`
+}
+
+// PageInfo tells where a page is in its connection.
+type PageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor"`
+	EndCursor       *string `json:"endCursor"`
+}
+
+// cursorKey is what a cursor encodes: the kind of a node and its ID.
+type cursorKey struct {
+	kind string
+	id   int
+}
+
+func (k cursorKey) encode() string {
+	return base64.URLEncoding.EncodeToString([]byte(k.kind + ":" + strconv.Itoa(k.id)))
+}
+
+// decodeCursor returns the key of cursor, which must name one of kinds.
+func decodeCursor(cursor string, kinds []string) (cursorKey, error) {
+	raw, err := base64.URLEncoding.DecodeString(cursor)
+	if err == nil {
+		if kind, id, ok := strings.Cut(string(raw), ":"); ok {
+			if n, err := strconv.Atoi(id); err == nil && n > 0 && kindRank(kinds, kind) >= 0 {
+				return cursorKey{kind: kind, id: n}, nil
+			}
+		}
+	}
+	return cursorKey{}, fmt.Errorf("invalid cursor %q", cursor)
+}
+
+func kindRank(kinds []string, kind string) int {
+	for i, k := range kinds {
+		if k == kind {
+			return i
+		}
+	}
+	return -1
+}
+
+// page is the part of a list that paginate selected.
+type page[T any] struct {
+	nodes   []T
+	cursors []string
+	info    PageInfo
+	total   int
+}
+
+// paginate selects the page of items described by args. items must be
+// ordered by kind, in the order of kinds, and then by ID, which is the order
+// the stores list entities in; key returns the cursor key of an item.
+func paginate[T any](items []T, args PageInput, kinds []string, key func(T) cursorKey) (page[T], error) {
+	return paginateBy(items, args, kinds, key, func(k cursorKey, item T) (int, error) {
+		return compareKeys(kinds, k, key(item)), nil
+	})
+}
+
+// compareKeys orders cursor keys by kind, in the order of kinds, and then by
+// ID.
+func compareKeys(kinds []string, a, b cursorKey) int {
+	if ra, rb := kindRank(kinds, a.kind), kindRank(kinds, b.kind); ra != rb {
+		return ra - rb
+	}
+	return a.id - b.id
+}
+
+// paginateBy is paginate for items in any order. compare tells whether the
+// node named by a cursor key sorts before (negative), at (zero) or after
+// (positive) item.
+func paginateBy[T any](items []T, args PageInput, kinds []string, key func(T) cursorKey, compare func(cursorKey, T) (int, error)) (page[T], error) {
+	start, end := 0, len(items)
+	if args.After != nil {
+		after, err := decodeCursor(*args.After, kinds)
+		if err != nil {
+			return page[T]{}, err
+		}
+		for ; start < end; start++ {
+			if c, err := compare(after, items[start]); err != nil {
+				return page[T]{}, err
+			} else if c < 0 {
+				break
+			}
+		}
+	}
+	if args.Before != nil {
+		before, err := decodeCursor(*args.Before, kinds)
+		if err != nil {
+			return page[T]{}, err
+		}
+		for ; end > start; end-- {
+			if c, err := compare(before, items[end-1]); err != nil {
+				return page[T]{}, err
+			} else if c > 0 {
+				break
+			}
+		}
+	}
+
+	if args.First != nil {
+		if *args.First < 0 || *args.First > maxPageSize {
+			return page[T]{}, fmt.Errorf("first must be between 0 and %d", maxPageSize)
+		}
+		end = min(end, start+*args.First)
+	}
+	if args.Last != nil {
+		if *args.Last < 0 || *args.Last > maxPageSize {
+			return page[T]{}, fmt.Errorf("last must be between 0 and %d", maxPageSize)
+		}
+		start = max(start, end-*args.Last)
+	}
+	if args.First == nil && args.Last == nil {
+		end = min(end, start+defaultPageSize)
+	}
+
+	p := page[T]{
+		nodes: items[start:end],
+		total: len(items),
+		info:  PageInfo{HasPreviousPage: start > 0, HasNextPage: end < len(items)},
+	}
+	for _, item := range p.nodes {
+		p.cursors = append(p.cursors, key(item).encode())
+	}
+	if len(p.cursors) > 0 {
+		p.info.StartCursor, p.info.EndCursor = &p.cursors[0], &p.cursors[len(p.cursors)-1]
+	}
+	return p, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Connection types. Each list field returns the connection of its node type.
+
+type WidgetConnection struct {
+	Edges      []WidgetEdge `json:"edges"`
+	PageInfo   PageInfo     `json:"pageInfo"`
+	TotalCount int          `json:"totalCount"`
+}
+
+type WidgetEdge struct {
+	Node   Widget `json:"node"`
+	Cursor string `json:"cursor"`
+}
+
+func newWidgetConnection(widgets []Widget, args PageInput) (*WidgetConnection, error) {
+	p, err := paginate(widgets, args, []string{"widget"}, func(w Widget) cursorKey { return cursorKey{"widget", w.ID} })
+	if err != nil {
+		return nil, err
+	}
+	conn := &WidgetConnection{Edges: []WidgetEdge{}, PageInfo: p.info, TotalCount: p.total}
+	for i, w := range p.nodes {
+		conn.Edges = append(conn.Edges, WidgetEdge{Node: w, Cursor: p.cursors[i]})
+	}
+	return conn, nil
+}
+
+type ProductConnection struct {
+	Edges      []ProductEdge `json:"edges"`
+	PageInfo   PageInfo      `json:"pageInfo"`
+	TotalCount int           `json:"totalCount"`
+}
+
+type ProductEdge struct {
+	Node   *Product `json:"node"`
+	Cursor string   `json:"cursor"`
+}
+
+// newProductConnection pages through products, which are sorted by order,
+// or by ID if order is nil.
+func newProductConnection(products []Product, args PageInput, order *productOrder) (*ProductConnection, error) {
+	kinds, key := []string{"product"}, func(p Product) cursorKey { return cursorKey{"product", p.ID} }
+	var p page[Product]
+	var err error
+	if order != nil {
+		p, err = paginateBy(products, args, kinds, key, order.compareCursor)
+	} else {
+		p, err = paginate(products, args, kinds, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	queueProducts(p.nodes)
+	conn := &ProductConnection{Edges: []ProductEdge{}, PageInfo: p.info, TotalCount: p.total}
+	for i := range p.nodes {
+		conn.Edges = append(conn.Edges, ProductEdge{Node: &p.nodes[i], Cursor: p.cursors[i]})
+	}
+	return conn, nil
+}
+
+type ReviewConnection struct {
+	Edges      []ReviewEdge `json:"edges"`
+	PageInfo   PageInfo     `json:"pageInfo"`
+	TotalCount int          `json:"totalCount"`
+}
+
+type ReviewEdge struct {
+	Node   *Review `json:"node"`
+	Cursor string  `json:"cursor"`
+}
+
+func newReviewConnection(reviews []Review, args PageInput) (*ReviewConnection, error) {
+	p, err := paginate(reviews, args, []string{"review"}, func(r Review) cursorKey { return cursorKey{"review", r.ID} })
+	if err != nil {
+		return nil, err
+	}
+	queueReviews(p.nodes)
+	conn := &ReviewConnection{Edges: []ReviewEdge{}, PageInfo: p.info, TotalCount: p.total}
+	for i := range p.nodes {
+		conn.Edges = append(conn.Edges, ReviewEdge{Node: &p.nodes[i], Cursor: p.cursors[i]})
+	}
+	return conn, nil
+}
+
+type OrderConnection struct {
+	Edges      []OrderEdge `json:"edges"`
+	PageInfo   PageInfo    `json:"pageInfo"`
+	TotalCount int         `json:"totalCount"`
+}
+
+type OrderEdge struct {
+	Node   *Order `json:"node"`
+	Cursor string `json:"cursor"`
+}
+
+func newOrderConnection(orders []Order, args PageInput) (*OrderConnection, error) {
+	p, err := paginate(orders, args, []string{"order"}, func(o Order) cursorKey { return cursorKey{"order", o.ID} })
+	if err != nil {
+		return nil, err
+	}
+	conn := &OrderConnection{Edges: []OrderEdge{}, PageInfo: p.info, TotalCount: p.total}
+	for i := range p.nodes {
+		conn.Edges = append(conn.Edges, OrderEdge{Node: &p.nodes[i], Cursor: p.cursors[i]})
+	}
+	return conn, nil
+}
+
+type EmployeeConnection struct {
+	Edges      []EmployeeEdge `json:"edges"`
+	PageInfo   PageInfo       `json:"pageInfo"`
+	TotalCount int            `json:"totalCount"`
+}
+
+type EmployeeEdge struct {
+	Node   *Employee `json:"node"`
+	Cursor string    `json:"cursor"`
+}
+
+func newEmployeeConnection(employees []*Employee, args PageInput) (*EmployeeConnection, error) {
+	p, err := paginate(employees, args, []string{"employee"}, func(e *Employee) cursorKey { return cursorKey{"employee", e.ID} })
+	if err != nil {
+		return nil, err
+	}
+	queueEmployees(p.nodes)
+	conn := &EmployeeConnection{Edges: []EmployeeEdge{}, PageInfo: p.info, TotalCount: p.total}
+	for i, e := range p.nodes {
+		conn.Edges = append(conn.Edges, EmployeeEdge{Node: e, Cursor: p.cursors[i]})
+	}
+	return conn, nil
+}
+
+type ManagerConnection struct {
+	Edges      []ManagerEdge `json:"edges"`
+	PageInfo   PageInfo      `json:"pageInfo"`
+	TotalCount int           `json:"totalCount"`
+}
+
+type ManagerEdge struct {
+	Node   *Manager `json:"node"`
+	Cursor string   `json:"cursor"`
+}
+
+func newManagerConnection(managers []*Manager, args PageInput) (*ManagerConnection, error) {
+	p, err := paginate(managers, args, []string{"employee"}, func(m *Manager) cursorKey { return cursorKey{"employee", m.ID} })
+	if err != nil {
+		return nil, err
+	}
+	queueManagers(p.nodes)
+	conn := &ManagerConnection{Edges: []ManagerEdge{}, PageInfo: p.info, TotalCount: p.total}
+	for i, m := range p.nodes {
+		conn.Edges = append(conn.Edges, ManagerEdge{Node: m, Cursor: p.cursors[i]})
+	}
+	return conn, nil
+}
+
+type SearchResultConnection struct {
+	Edges      []SearchResultEdge `json:"edges"`
+	PageInfo   PageInfo           `json:"pageInfo"`
+	TotalCount int                `json:"totalCount"`
+}
+
+type SearchResultEdge struct {
+	Node   SearchResultUnion `json:"node"`
+	Cursor string            `json:"cursor"`
+}
+
+// searchResultKinds is the order of the search results: widgets, then
+// products, then employees.
+var searchResultKinds = []string{"widget", "product", "employee"}
+
+func newSearchResultConnection(results []SearchResultUnion, args PageInput) (*SearchResultConnection, error) {
+	p, err := paginate(results, args, searchResultKinds, func(r SearchResultUnion) cursorKey {
+		switch {
+		case r.Widget != nil:
+			return cursorKey{"widget", r.Widget.ID}
+		case r.Product != nil:
+			return cursorKey{"product", r.Product.ID}
+		default:
+			return cursorKey{"employee", r.Employee.ID}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn := &SearchResultConnection{Edges: []SearchResultEdge{}, PageInfo: p.info, TotalCount: p.total}
+	for i, r := range p.nodes {
+		if r.Product != nil {
+			queueProducts([]Product{*r.Product})
+		}
+		if r.Employee != nil {
+			queueEmployees([]*Employee{r.Employee})
+		}
+		conn.Edges = append(conn.Edges, SearchResultEdge{Node: r, Cursor: p.cursors[i]})
+	}
+	return conn, nil
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func productIDs(conn *ProductConnection) []int {
+	ids := []int{}
+	for _, edge := range conn.Edges {
+		ids = append(ids, edge.Node.ID)
+	}
+	return ids
+}
+
+func TestProductPagination(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	h := NewProductHandlers(store)
+	getProducts := func(first *int, after *string, last *int, before *string) *ProductConnection {
+		t.Helper()
+		conn, err := h.GetProducts(ctx, nil, nil, nil, first, after, last, before)
+		if err != nil {
+			t.Fatalf("GetProducts failed: %v", err)
+		}
+		return conn
+	}
+
+	first := getProducts(intPtr(2), nil, nil, nil)
+	if got := productIDs(first); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("expected products 1 and 2, got %v", got)
+	}
+	if !first.PageInfo.HasNextPage || first.PageInfo.HasPreviousPage || first.TotalCount != 4 {
+		t.Errorf("unexpected page info %+v, total %d", first.PageInfo, first.TotalCount)
+	}
+
+	second := getProducts(intPtr(2), first.PageInfo.EndCursor, nil, nil)
+	if got := productIDs(second); len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Fatalf("expected products 3 and 4, got %v", got)
+	}
+	if second.PageInfo.HasNextPage || !second.PageInfo.HasPreviousPage {
+		t.Errorf("unexpected page info %+v", second.PageInfo)
+	}
+
+	back := getProducts(nil, nil, intPtr(1), second.PageInfo.EndCursor)
+	if got := productIDs(back); len(got) != 1 || got[0] != 3 {
+		t.Errorf("expected product 3 before product 4, got %v", got)
+	}
+
+	// A cursor stays valid after the node it names is deleted
+	admin, err := store.Users().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get admin failed: %v", err)
+	}
+	if _, err := h.DeleteProduct(context.WithValue(ctx, UserContextKey, &admin), 2); err != nil {
+		t.Fatalf("DeleteProduct failed: %v", err)
+	}
+	after := getProducts(nil, first.PageInfo.EndCursor, nil, nil)
+	if got := productIDs(after); len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Errorf("expected products 3 and 4 after the deleted product, got %v", got)
+	}
+
+	for _, tc := range []struct {
+		first *int
+		after *string
+		want  string
+	}{
+		{first: intPtr(maxPageSize + 1), want: "first must be between 0 and 100"},
+		{after: strPtr("not-a-cursor"), want: `invalid cursor "not-a-cursor"`},
+		{after: strPtr(cursorKey{"widget", 1}.encode()), want: "invalid cursor"},
+	} {
+		if _, err := h.GetProducts(ctx, nil, nil, nil, tc.first, tc.after, nil, nil); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("expected %q, got %v", tc.want, err)
+		}
+	}
+}
+
+func TestSearchPaginatesAcrossKinds(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	h := NewSearchHandlers(store)
+
+	all, err := h.Search(ctx, "o", nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	var walked []string
+	var after *string
+	for {
+		conn, err := h.Search(ctx, "o", nil, intPtr(2), after, nil, nil)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		for _, edge := range conn.Edges {
+			walked = append(walked, edge.Cursor)
+		}
+		if !conn.PageInfo.HasNextPage {
+			break
+		}
+		after = conn.PageInfo.EndCursor
+	}
+	if len(walked) != all.TotalCount || len(walked) < 3 {
+		t.Fatalf("expected to walk all %d results, got %d", all.TotalCount, len(walked))
+	}
+	for i, edge := range all.Edges {
+		if walked[i] != edge.Cursor {
+			t.Errorf("result %d: expected cursor %s, got %s", i, edge.Cursor, walked[i])
+		}
+	}
+}
+
+func TestNestedConnectionsTakeAPageArgument(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	graph := newTestGraph(ctx, store)
+
+	res, err := graph.ProcessRequest(ctx, `{ GetProduct(id: 1) { Reviews(page: {first: 1}) { totalCount pageInfo { hasNextPage } edges { node { ID } } } } }`, "")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if !strings.Contains(res, `"edges":[{"node":{"ID":1}}],"pageInfo":{"hasNextPage":true},"totalCount":2`) {
+		t.Errorf("expected the first of two reviews, got %s", res)
+	}
+}
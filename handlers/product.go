@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gburgyan/go-quickgraph"
-	"sync"
 	"time"
 )
 
@@ -42,16 +41,34 @@ type Product struct {
 	ID          int
 	Name        string
 	Description string
-	Price       float64
+	Price       Money // In the store currency; changed with SetProductPrice
 	Status      ProductStatus
 	CategoryID  int
 	InStock     bool
+	Stock       int        // Units on hand, summed over its variants if it has any
+	CreatedAt   *time.Time // Unset for products created before it was recorded
+	Version     int        // Incremented on every update
+	DeletedAt   *time.Time // Set when the product is soft-deleted
+	DeletedBy   *int       // ID of the deleting user
+
+	// Deprecated: the price as a plain number, filled in by bind. Use Price.
+	PriceFloat *float64 `json:",omitempty" graphy:"PriceFloat,deprecated=Use Price with its currency"`
+
+	// Scope used by the field resolvers below
+	scope *scope `json:"-" graphy:"-"`
 }
 
 type Category struct {
 	ID          int
 	Name        string
-	Description *string // Optional field
+	Description *string    // Optional field
+	ParentID    *int       // Unset for top-level categories
+	Version     int        // Incremented on every update
+	DeletedAt   *time.Time // Set when the category is soft-deleted
+	DeletedBy   *int       // ID of the deleting user
+
+	// Scope used by the field resolvers below
+	scope *scope `json:"-" graphy:"-"`
 }
 
 type Review struct {
@@ -61,6 +78,17 @@ type Review struct {
 	Rating    int
 	Comment   string
 	CreatedAt string
+	UpdatedAt *time.Time   // Set when the author last edited the review
+	Status    ReviewStatus // Only approved reviews are shown and rated
+	// Set by the last ApproveReview or RejectReview
+	ModeratedAt    *time.Time
+	ModeratedBy    *int
+	ModerationNote *string    // Reason given for a rejection
+	DeletedAt      *time.Time // Set when the review is soft-deleted
+	DeletedBy      *int       // ID of the deleting user
+
+	// Scope used by the field resolvers below
+	scope *scope `json:"-" graphy:"-"`
 }
 
 type User struct {
@@ -68,22 +96,18 @@ type User struct {
 	Username string
 	Email    string
 	Role     UserRole
+
+	// Scope used by the field resolvers below
+	scope *scope `json:"-" graphy:"-"`
 }
 
 // Input types
 type ProductInput struct {
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	CategoryID  int     `json:"categoryId"`
-}
-
-type ProductFilter struct {
-	CategoryID *int           `json:"categoryId"`
-	MinPrice   *float64       `json:"minPrice"`
-	MaxPrice   *float64       `json:"maxPrice"`
-	Status     *ProductStatus `json:"status"`
-	InStock    *bool          `json:"inStock"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       Money  `json:"price"`
+	CategoryID  int    `json:"categoryId"`
+	Stock       *int   `json:"stock"` // Units on hand, 0 if left out
 }
 
 type ReviewInput struct {
@@ -91,280 +115,503 @@ type ReviewInput struct {
 	Comment string `json:"comment"`
 }
 
-// Storage
-var (
-	products    []Product
-	categories  []Category
-	reviews     []Review
-	users       []User
-	productsMux sync.RWMutex
-	nextProdID  = 1
-	nextRevID   = 1
-)
-
-func init() {
-	// Initialize sample data
-	categories = []Category{
-		{ID: 1, Name: "Electronics", Description: strPtr("Electronic devices and accessories")},
-		{ID: 2, Name: "Books", Description: strPtr("Physical and digital books")},
-		{ID: 3, Name: "Clothing", Description: nil},
-	}
-
-	products = []Product{
-		{ID: 1, Name: "Laptop", Description: "High-performance laptop", Price: 999.99, Status: ProductStatusActive, CategoryID: 1, InStock: true},
-		{ID: 2, Name: "Go Programming Book", Description: "Learn Go in 30 days", Price: 39.99, Status: ProductStatusActive, CategoryID: 2, InStock: true},
-		{ID: 3, Name: "Vintage T-Shirt", Description: "Retro design", Price: 24.99, Status: ProductStatusOutOfStock, CategoryID: 3, InStock: false},
-		{ID: 4, Name: "Smartphone", Description: "Latest model", Price: 699.99, Status: ProductStatusActive, CategoryID: 1, InStock: true},
-	}
-
-	users = []User{
-		{ID: 1, Username: "admin", Email: "admin@example.com", Role: UserRoleAdmin},
-		{ID: 2, Username: "john_customer", Email: "john@example.com", Role: UserRoleCustomer},
-		{ID: 3, Username: "jane_customer", Email: "jane@example.com", Role: UserRoleCustomer},
-	}
-
-	reviews = []Review{
-		{ID: 1, ProductID: 1, UserID: 2, Rating: 5, Comment: "Excellent laptop!", CreatedAt: "2024-01-15T10:00:00Z"},
-		{ID: 2, ProductID: 1, UserID: 3, Rating: 4, Comment: "Good value for money", CreatedAt: "2024-01-16T14:30:00Z"},
-		{ID: 3, ProductID: 2, UserID: 2, Rating: 5, Comment: "Great book for beginners", CreatedAt: "2024-01-17T09:15:00Z"},
-	}
+// ProductHandlers serves the product catalog queries and mutations from a Store.
+type ProductHandlers struct {
+	store Store
+}
 
-	nextProdID = 5
-	nextRevID = 4
+// NewProductHandlers creates product handlers backed by store.
+func NewProductHandlers(store Store) *ProductHandlers {
+	return &ProductHandlers{store: store}
 }
 
-func RegisterProductHandlers(ctx context.Context, graphy *quickgraph.Graphy) {
+func RegisterProductHandlers(ctx context.Context, graphy *quickgraph.Graphy, store Store) {
+	h := NewProductHandlers(store)
+
 	// Query registrations
-	graphy.RegisterQuery(ctx, "GetProduct", GetProduct, "id")
-	graphy.RegisterQuery(ctx, "GetProducts", GetProducts, "filter")
-	graphy.RegisterQuery(ctx, "GetCategories", GetCategories)
-	
+	graphy.RegisterQuery(ctx, "GetProduct", h.GetProduct, "id")
+	graphy.RegisterQuery(ctx, "GetProducts", h.GetProducts, "filter", "orderBy", "includeDeleted", "first", "after", "last", "before")
+	graphy.RegisterQuery(ctx, "GetCategories", h.GetCategories, "includeDeleted")
+	graphy.RegisterQuery(ctx, "PendingReviews", h.PendingReviews, "first", "after", "last", "before")
+	graphy.RegisterQuery(ctx, "GetOrder", h.GetOrder, "id")
+	graphy.RegisterQuery(ctx, "MyOrders", h.MyOrders, "first", "after", "last", "before")
+	graphy.RegisterQuery(ctx, "Cart", h.Cart)
+	graphy.RegisterQuery(ctx, "RecommendedProducts", h.RecommendedProducts, "first")
+
 	// Mutation registrations
-	graphy.RegisterMutation(ctx, "CreateProduct", CreateProduct, "input")
-	graphy.RegisterMutation(ctx, "UpdateProductStatus", UpdateProductStatus, "id", "status")
-	graphy.RegisterMutation(ctx, "AddProductReview", AddProductReview, "productId", "review")
-	
-	// Note: Methods on Product, Category, Review, and User types will be automatically
+	graphy.RegisterMutation(ctx, "CreateProduct", h.CreateProduct, "input")
+	graphy.RegisterMutation(ctx, "UpdateProductStatus", h.UpdateProductStatus, "id", "status", "version")
+	graphy.RegisterMutation(ctx, "SetProductPrice", h.SetProductPrice, "id", "price", "version")
+	graphy.RegisterMutation(ctx, "AddProductReview", h.AddProductReview, "productId", "review")
+	graphy.RegisterMutation(ctx, "DeleteProduct", h.DeleteProduct, "id")
+	graphy.RegisterMutation(ctx, "RestoreProduct", h.RestoreProduct, "id")
+	graphy.RegisterMutation(ctx, "EditReview", h.EditReview, "id", "review")
+	graphy.RegisterMutation(ctx, "DeleteReview", h.DeleteReview, "id")
+	graphy.RegisterMutation(ctx, "ApproveReview", h.ApproveReview, "id")
+	graphy.RegisterMutation(ctx, "RejectReview", h.RejectReview, "id", "reason")
+	graphy.RegisterMutation(ctx, "RestoreReview", h.RestoreReview, "id")
+	graphy.RegisterMutation(ctx, "ImportProducts", h.ImportProducts, "csv", "mode")
+	graphy.RegisterMutation(ctx, "CreateCategory", h.CreateCategory, "input")
+	graphy.RegisterMutation(ctx, "UpdateCategory", h.UpdateCategory, "id", "input")
+	graphy.RegisterMutation(ctx, "MoveCategory", h.MoveCategory, "id", "parentId")
+	graphy.RegisterMutation(ctx, "DeleteCategory", h.DeleteCategory, "id")
+	graphy.RegisterMutation(ctx, "RestoreCategory", h.RestoreCategory, "id")
+	graphy.RegisterMutation(ctx, "RestockProduct", h.RestockProduct, "id", "quantity", "version")
+	graphy.RegisterMutation(ctx, "AddProductVariant", h.AddProductVariant, "productId", "input")
+	graphy.RegisterMutation(ctx, "UpdateProductVariant", h.UpdateProductVariant, "id", "input", "version")
+	graphy.RegisterMutation(ctx, "RemoveProductVariant", h.RemoveProductVariant, "id")
+	graphy.RegisterMutation(ctx, "PlaceOrder", h.PlaceOrder, "items")
+	graphy.RegisterMutation(ctx, "CancelOrder", h.CancelOrder, "id")
+	graphy.RegisterMutation(ctx, "ShipOrder", h.ShipOrder, "id")
+	graphy.RegisterMutation(ctx, "DeliverOrder", h.DeliverOrder, "id")
+	graphy.RegisterMutation(ctx, "AddToCart", h.AddToCart, "productId", "variantId", "widgetId", "quantity")
+	graphy.RegisterMutation(ctx, "UpdateCartItem", h.UpdateCartItem, "productId", "variantId", "widgetId", "quantity")
+	graphy.RegisterMutation(ctx, "RemoveFromCart", h.RemoveFromCart, "productId", "variantId", "widgetId")
+	graphy.RegisterMutation(ctx, "CheckoutCart", h.CheckoutCart)
+
+	// Note: Methods on Product, Category, Review, User and Order types will be automatically
 	// exposed as fields when those objects are returned from queries
 }
 
 // Query handlers
-func GetProduct(id int) (*Product, error) {
-	productsMux.RLock()
-	defer productsMux.RUnlock()
 
-	for _, p := range products {
-		if p.ID == id {
-			return &p, nil
-		}
+// GetProduct returns a product by ID. Deleted products are only visible to
+// admins.
+func (h *ProductHandlers) GetProduct(ctx context.Context, id int) (*Product, error) {
+	p, err := h.store.Products().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && p.isDeleted() && !canSeeDeleted(ctx)) {
+		return nil, fmt.Errorf("product with id %d not found", id)
 	}
-	return nil, fmt.Errorf("product with id %d not found", id)
+	if err != nil {
+		return nil, err
+	}
+	p.bind(newScope(ctx, h.store))
+	return &p, nil
 }
 
-func GetProducts(filter *ProductFilter) ([]Product, error) {
-	productsMux.RLock()
-	defer productsMux.RUnlock()
+// GetProducts returns a page of the products matching filter, sorted by
+// orderBy or else by ID. Admins can pass includeDeleted to also get the
+// deleted ones.
+func (h *ProductHandlers) GetProducts(ctx context.Context, filter *ProductFilter, orderBy *[]ProductOrder, includeDeleted *bool, first *int, after *string, last *int, before *string) (*ProductConnection, error) {
+	products, order, err := h.listProducts(ctx, filter, orderBy, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	return newProductConnection(products, pageArgs(first, after, last, before), order)
+}
 
-	var result []Product
-	for _, p := range products {
-		if filter != nil {
-			// Apply filters
-			if filter.CategoryID != nil && p.CategoryID != *filter.CategoryID {
-				continue
-			}
-			if filter.MinPrice != nil && p.Price < *filter.MinPrice {
-				continue
-			}
-			if filter.MaxPrice != nil && p.Price > *filter.MaxPrice {
-				continue
-			}
-			if filter.Status != nil && p.Status != *filter.Status {
-				continue
-			}
-			if filter.InStock != nil && p.InStock != *filter.InStock {
-				continue
-			}
+// listProducts returns all products GetProducts pages through, in order.
+func (h *ProductHandlers) listProducts(ctx context.Context, filter *ProductFilter, orderBy *[]ProductOrder, includeDeleted *bool) ([]Product, *productOrder, error) {
+	withDeleted, err := showDeleted(ctx, includeDeleted)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := filter.validate(); err != nil {
+		return nil, nil, err
+	}
+	if filter.usesSubcategories() {
+		categories, err := h.store.Categories().List(ctx)
+		if err != nil {
+			return nil, nil, err
 		}
-		result = append(result, p)
+		filter.resolveSubcategories(newCategoryTree(categories))
+	}
+	if filter.usesRatings() {
+		ratings, err := h.ratingsByProduct(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		filter.resolveRatings(ratings)
+	}
+	all, err := h.store.Products().List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	order, err := h.newProductOrder(ctx, deref(orderBy), all)
+	if err != nil {
+		return nil, nil, err
 	}
-	return result, nil
-}
 
-func GetCategories() ([]Category, error) {
-	productsMux.RLock()
-	defer productsMux.RUnlock()
-	
-	result := make([]Category, len(categories))
-	copy(result, categories)
-	return result, nil
+	var result []Product
+	for _, p := range withoutDeleted(all, withDeleted) {
+		if filter.matches(p) {
+			result = append(result, p)
+		}
+	}
+	order.sort(result)
+	return bindProducts(newScope(ctx, h.store), result), order, nil
 }
 
 // Mutation handlers
-func CreateProduct(input ProductInput) (*Product, error) {
+func (h *ProductHandlers) CreateProduct(ctx context.Context, input ProductInput) (*Product, error) {
 	// Validate category exists
-	categoryExists := false
-	for _, c := range categories {
-		if c.ID == input.CategoryID {
-			categoryExists = true
-			break
-		}
+	if _, err := h.liveCategory(ctx, input.CategoryID); err != nil {
+		return nil, err
 	}
-	if !categoryExists {
-		return nil, fmt.Errorf("category with id %d not found", input.CategoryID)
+	if err := checkPrice(input.Price); err != nil {
+		return nil, err
 	}
 
-	productsMux.Lock()
-	defer productsMux.Unlock()
+	stock := 0
+	if input.Stock != nil {
+		if stock = *input.Stock; stock < 0 {
+			return nil, errors.New("stock cannot be negative")
+		}
+	}
 
-	product := Product{
-		ID:          nextProdID,
+	now := time.Now().UTC()
+	product, err := h.store.Products().Create(ctx, Product{
 		Name:        input.Name,
 		Description: input.Description,
 		Price:       input.Price,
 		Status:      ProductStatusDraft,
 		CategoryID:  input.CategoryID,
 		InStock:     false,
+		Stock:       stock,
+		CreatedAt:   &now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := h.recordStatusChange(ctx, nil, product); err != nil {
+		return nil, err
 	}
-	nextProdID++
+	if err := h.recordPriceChange(ctx, nil, product, now); err != nil {
+		return nil, err
+	}
+	product.bind(newScope(ctx, h.store))
 
-	products = append(products, product)
-	
 	// Broadcast the product creation
-	BroadcastProductUpdate(product, "created")
-	
+	h.store.Events().BroadcastProductUpdate(ctx, product, "created")
+
 	return &product, nil
 }
 
-func UpdateProductStatus(id int, status ProductStatus) (*Product, error) {
-	// Validate status
-	switch status {
-	case ProductStatusDraft, ProductStatusActive, ProductStatusDiscontinued, ProductStatusOutOfStock:
-		// Valid status
-	default:
+// UpdateProductStatus moves a product to another status of its lifecycle
+// and records the change in its status history. version must be the product
+// version the client last read.
+func (h *ProductHandlers) UpdateProductStatus(ctx context.Context, id int, status ProductStatus, version int) (*Product, error) {
+	if !validProductStatus(status) {
 		return nil, fmt.Errorf("invalid product status: %s", status)
 	}
 
-	productsMux.Lock()
-	defer productsMux.Unlock()
-
-	for i, p := range products {
-		if p.ID == id {
-			products[i].Status = status
-			if status == ProductStatusOutOfStock {
-				products[i].InStock = false
-			} else if status == ProductStatusActive {
-				products[i].InStock = true
-			}
-					// Broadcast the product update
-				BroadcastProductUpdate(products[i], "updated")
-				
-			return &products[i], nil
-		}
+	product, err := h.store.Products().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && product.isDeleted()) {
+		return nil, fmt.Errorf("product with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if product.Status == status {
+		return nil, fmt.Errorf("product %d is already %s", id, status)
+	}
+	category, err := h.productCategory(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatusTransition(product, category, status); err != nil {
+		return nil, err
+	}
+
+	product.Version = version
+	return h.changeProductStatus(ctx, product, status)
+}
+
+// RestockProduct adds quantity units to the stock of a product. An
+// OUT_OF_STOCK product becomes ACTIVE again unless a guard prevents it.
+// Products with variants are restocked by variant instead. version must be
+// the product version the client last read. Requires the admin role.
+func (h *ProductHandlers) RestockProduct(ctx context.Context, id int, quantity int, version int) (*Product, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if quantity < 1 {
+		return nil, errors.New("quantity must be at least 1")
 	}
-	return nil, fmt.Errorf("product with id %d not found", id)
+	product, err := h.store.Products().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && product.isDeleted()) {
+		return nil, fmt.Errorf("product with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	variants, err := h.store.ProductVariants().ListByProducts(ctx, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(variants) > 0 {
+		return nil, fmt.Errorf("product %d has variants; restock them with UpdateProductVariant", id)
+	}
+
+	product.Version = version
+	product.Stock += quantity
+	product, err = h.store.Products().Update(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	return h.updateStockStatus(ctx, product)
 }
 
-func AddProductReview(productId int, review ReviewInput) (*Review, error) {
+// AddProductReview adds a review by the authenticated user to a product. It
+// waits for moderation before it is shown, and a user can only review a
+// product once.
+func (h *ProductHandlers) AddProductReview(ctx context.Context, productId int, review ReviewInput) (*Review, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Validate rating
 	if review.Rating < 1 || review.Rating > 5 {
 		return nil, errors.New("rating must be between 1 and 5")
 	}
 
 	// Validate product exists
-	productExists := false
-	for _, p := range products {
-		if p.ID == productId {
-			productExists = true
-			break
-		}
-	}
-	if !productExists {
+	if p, err := h.store.Products().Get(ctx, productId); errors.Is(err, ErrNotFound) || (err == nil && p.isDeleted()) {
 		return nil, fmt.Errorf("product with id %d not found", productId)
+	} else if err != nil {
+		return nil, err
 	}
 
-	productsMux.Lock()
-	defer productsMux.Unlock()
-
-	// In a real app, we'd get the user from context
-	r := Review{
-		ID:        nextRevID,
+	r, err := h.store.Reviews().Create(ctx, Review{
 		ProductID: productId,
-		UserID:    2, // Hardcoded for demo
+		UserID:    user.ID,
 		Rating:    review.Rating,
 		Comment:   review.Comment,
 		CreatedAt: time.Now().Format(time.RFC3339),
+		Status:    ReviewStatusPending,
+	})
+	var duplicate *DuplicateReviewError
+	if errors.As(err, &duplicate) {
+		return nil, fmt.Errorf("you already reviewed product %d in review %d; use EditReview to change it", productId, duplicate.ReviewID)
 	}
-	nextRevID++
-
-	reviews = append(reviews, r)
+	if err != nil {
+		return nil, err
+	}
+	r.scope = newScope(ctx, h.store)
 	return &r, nil
 }
 
+// DeleteProduct soft-deletes a product, recording when and by whom. Only admins
+// may delete products.
+func (h *ProductHandlers) DeleteProduct(ctx context.Context, id int) (*Product, error) {
+	user, err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deletedAt, deletedBy := deletionStamp(user)
+
+	product, err := h.store.Products().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && product.isDeleted()) {
+		return nil, fmt.Errorf("product with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	product.DeletedAt, product.DeletedBy = deletedAt, deletedBy
+	product, err = h.store.Products().Update(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	product.bind(newScope(ctx, h.store))
+
+	h.store.Events().BroadcastProductUpdate(ctx, product, "deleted")
+
+	return &product, nil
+}
+
+// RestoreProduct undoes DeleteProduct. Only admins can restore.
+func (h *ProductHandlers) RestoreProduct(ctx context.Context, id int) (*Product, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	product, err := h.store.Products().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("product with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !product.isDeleted() {
+		return nil, fmt.Errorf("product %d is not deleted", id)
+	}
+
+	product.DeletedAt, product.DeletedBy = nil, nil
+	product, err = h.store.Products().Update(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	product.bind(newScope(ctx, h.store))
+
+	h.store.Events().BroadcastProductUpdate(ctx, product, "restored")
+
+	return &product, nil
+}
+
+// DeleteReview soft-deletes a review. Only its author or an admin can
+// delete it.
+func (h *ProductHandlers) DeleteReview(ctx context.Context, id int) (*Review, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deletedAt, deletedBy := deletionStamp(user)
+
+	review, err := h.store.Reviews().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && review.isDeleted()) {
+		return nil, fmt.Errorf("review with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if review.UserID != user.ID && user.Role != UserRoleAdmin {
+		return nil, errors.New("only the author or an admin can delete a review")
+	}
+
+	review.DeletedAt, review.DeletedBy = deletedAt, deletedBy
+	review, err = h.store.Reviews().Update(ctx, review)
+	if err != nil {
+		return nil, err
+	}
+	review.scope = newScope(ctx, h.store)
+	return &review, nil
+}
+
+// RestoreReview undoes DeleteReview. Only admins can restore.
+func (h *ProductHandlers) RestoreReview(ctx context.Context, id int) (*Review, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	review, err := h.store.Reviews().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("review with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !review.isDeleted() {
+		return nil, fmt.Errorf("review %d is not deleted", id)
+	}
+	review.DeletedAt, review.DeletedBy = nil, nil
+	review, err = h.store.Reviews().Update(ctx, review)
+	var duplicate *DuplicateReviewError
+	if errors.As(err, &duplicate) {
+		return nil, fmt.Errorf("user %d has reviewed product %d again in review %d", duplicate.UserID, duplicate.ProductID, duplicate.ReviewID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	review.scope = newScope(ctx, h.store)
+	return &review, nil
+}
+
+// Example of a mutation that uses context for authorization
+func (h *ProductHandlers) CreateProductWithAuth(ctx context.Context, input ProductInput) (*Product, error) {
+	// Check if user is authenticated and has admin role
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	// Proceed with normal creation
+	return h.CreateProduct(ctx, input)
+}
+
 // Field resolvers
 func (p *Product) Category() (*Category, error) {
-	for _, c := range categories {
-		if c.ID == p.CategoryID {
-			return &c, nil
-		}
+	c, ok, err := p.scope.loaders.categories.load(p.CategoryID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("category with id %d not found", p.CategoryID)
 	}
-	return nil, fmt.Errorf("category with id %d not found", p.CategoryID)
+	return &c, nil
 }
 
-func (p *Product) Reviews() ([]Review, error) {
-	var productReviews []Review
-	for _, r := range reviews {
-		if r.ProductID == p.ID {
-			productReviews = append(productReviews, r)
-		}
+// Reviews returns a page of the approved reviews of the product.
+func (p *Product) Reviews(args PageParam) (*ReviewConnection, error) {
+	productReviews, _, err := p.scope.loaders.productReviews.load(p.ID)
+	if err != nil {
+		return nil, err
 	}
-	return productReviews, nil
+	return newReviewConnection(approvedReviews(productReviews), args.Page)
 }
 
+// AverageRating returns the average rating of the approved reviews, or null
+// without reviews.
 func (p *Product) AverageRating() (*float64, error) {
-	var total, count int
-	for _, r := range reviews {
-		if r.ProductID == p.ID {
-			total += r.Rating
-			count++
-		}
+	summary, err := p.RatingSummary()
+	if err != nil {
+		return nil, err
 	}
-	if count == 0 {
-		return nil, nil // No reviews
-	}
-	avg := float64(total) / float64(count)
-	return &avg, nil
+	return summary.Average(), nil
 }
 
-func (c *Category) Products() ([]Product, error) {
-	var categoryProducts []Product
-	for _, p := range products {
-		if p.CategoryID == c.ID {
-			categoryProducts = append(categoryProducts, p)
-		}
+// RatingSummary returns the rating aggregates of the approved reviews.
+func (p *Product) RatingSummary() (*RatingSummary, error) {
+	summary, _, err := p.scope.loaders.productRatings.load(p.ID)
+	if err != nil {
+		return nil, err
 	}
-	return categoryProducts, nil
+	return &summary, nil
 }
 
 func (r *Review) User() (*User, error) {
-	for _, u := range users {
-		if u.ID == r.UserID {
-			return &u, nil
-		}
+	u, ok, err := r.scope.loaders.users.load(r.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("user with id %d not found", r.UserID)
 	}
-	return nil, fmt.Errorf("user with id %d not found", r.UserID)
+	return &u, nil
 }
 
-func (u *User) Reviews() ([]Review, error) {
-	var userReviews []Review
-	for _, r := range reviews {
-		if r.UserID == u.ID {
-			userReviews = append(userReviews, r)
-		}
+// Reviews returns a page of the reviews of the user. The user and admins
+// also see the reviews that are not approved.
+func (u *User) Reviews(args PageParam) (*ReviewConnection, error) {
+	userReviews, _, err := u.scope.loaders.userReviews.load(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if viewer, err := requireUser(u.scope.ctx); err != nil || (viewer.ID != u.ID && viewer.Role != UserRoleAdmin) {
+		userReviews = approvedReviews(userReviews)
 	}
-	return userReviews, nil
+	return newReviewConnection(userReviews, args.Page)
 }
 
-// Helper function
+// bind attaches sc to the product so its field resolvers work, and fills
+// in PriceFloat.
+func (p *Product) bind(sc *scope) {
+	p.scope = sc
+	price := p.Price.Dollars()
+	p.PriceFloat = &price
+}
+
+// bindProducts attaches sc to each product so its field resolvers work.
+func bindProducts(sc *scope, products []Product) []Product {
+	for i := range products {
+		products[i].bind(sc)
+	}
+	return products
+}
+
+// bindReviews attaches sc to each review so its field resolvers work.
+func bindReviews(sc *scope, reviews []Review) []Review {
+	for i := range reviews {
+		reviews[i].scope = sc
+	}
+	return reviews
+}
+
+// Helper functions
 func strPtr(s string) *string {
 	return &s
-}
\ No newline at end of file
+}
+
+func intPtr(i int) *int {
+	return &i
+}
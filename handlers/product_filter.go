@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProductFilter selects products. A product matches if it meets every
+// condition that is set; And, Or and Not combine nested filters, e.g.
+// {or: [{status: DRAFT}, {not: {inStock: true}}]}. GetProducts and the
+// productUpdates subscription take the same filter, so a client can watch
+// exactly the products it lists. The lists are pointers because quickgraph
+// requires every other input field to be given.
+type ProductFilter struct {
+	CategoryID           *int             `json:"categoryId"`
+	IncludeSubcategories *bool            `json:"includeSubcategories"` // Also match the subcategories of categoryId; GetProducts only
+	MinPrice             *float64         `json:"minPrice"`             // In the store currency
+	MaxPrice             *float64         `json:"maxPrice"`
+	Status               *ProductStatus   `json:"status"`
+	StatusIn             *[]ProductStatus `json:"statusIn"`     // Any of these statuses
+	NameContains         *string          `json:"nameContains"` // Case-insensitive part of the name
+	InStock              *bool            `json:"inStock"`
+	MinRating            *float64         `json:"minRating"` // Lowest average rating, 1 to 5; unrated products never match. GetProducts only
+	And                  *[]ProductFilter `json:"and"`       // Every one of these filters matches
+	Or                   *[]ProductFilter `json:"or"`        // At least one of these filters matches; ignored if empty
+	Not                  *ProductFilter   `json:"not"`       // This filter does not match
+
+	// categoryIDs are categoryId and its subcategories, once resolved
+	categoryIDs map[int]bool `json:"-" graphy:"-"`
+	// ratings are the rating summaries by product ID minRating is checked
+	// against, once resolved
+	ratings map[int]RatingSummary `json:"-" graphy:"-"`
+}
+
+// validate reports the first invalid status in the filter or its nested
+// filters.
+func (f *ProductFilter) validate() error {
+	if f == nil {
+		return nil
+	}
+	statuses := deref(f.StatusIn)
+	if f.Status != nil {
+		statuses = append([]ProductStatus{*f.Status}, statuses...)
+	}
+	for _, status := range statuses {
+		if !validProductStatus(status) {
+			return fmt.Errorf("invalid product status %q, expected one of %s", status, strings.Join(ProductStatus("").EnumValues(), ", "))
+		}
+	}
+	if f.MinRating != nil && (*f.MinRating < 1 || *f.MinRating > 5) {
+		return errors.New("minRating must be between 1 and 5")
+	}
+	for _, nested := range append(append([]ProductFilter{}, deref(f.And)...), deref(f.Or)...) {
+		if err := nested.validate(); err != nil {
+			return err
+		}
+	}
+	return f.Not.validate()
+}
+
+// usesSubcategories reports whether the filter or one of its nested filters
+// sets includeSubcategories.
+func (f *ProductFilter) usesSubcategories() bool {
+	if f == nil {
+		return false
+	}
+	if f.CategoryID != nil && f.IncludeSubcategories != nil && *f.IncludeSubcategories {
+		return true
+	}
+	for _, nested := range append(append([]ProductFilter{}, deref(f.And)...), deref(f.Or)...) {
+		if nested.usesSubcategories() {
+			return true
+		}
+	}
+	return f.Not.usesSubcategories()
+}
+
+// resolveSubcategories looks up the subcategories includeSubcategories asks
+// for in tree, here and in the nested filters.
+func (f *ProductFilter) resolveSubcategories(tree *categoryTree) {
+	if f == nil {
+		return
+	}
+	if f.CategoryID != nil && f.IncludeSubcategories != nil && *f.IncludeSubcategories {
+		f.categoryIDs = idSet(tree.descendants(*f.CategoryID))
+	}
+	for i := range deref(f.And) {
+		(*f.And)[i].resolveSubcategories(tree)
+	}
+	for i := range deref(f.Or) {
+		(*f.Or)[i].resolveSubcategories(tree)
+	}
+	f.Not.resolveSubcategories(tree)
+}
+
+// usesRatings reports whether the filter or one of its nested filters sets
+// minRating.
+func (f *ProductFilter) usesRatings() bool {
+	if f == nil {
+		return false
+	}
+	if f.MinRating != nil {
+		return true
+	}
+	for _, nested := range append(append([]ProductFilter{}, deref(f.And)...), deref(f.Or)...) {
+		if nested.usesRatings() {
+			return true
+		}
+	}
+	return f.Not.usesRatings()
+}
+
+// resolveRatings hands the rating summaries minRating is checked against to
+// the filter and its nested filters.
+func (f *ProductFilter) resolveRatings(ratings map[int]RatingSummary) {
+	if f == nil {
+		return
+	}
+	f.ratings = ratings
+	for i := range deref(f.And) {
+		(*f.And)[i].resolveRatings(ratings)
+	}
+	for i := range deref(f.Or) {
+		(*f.Or)[i].resolveRatings(ratings)
+	}
+	f.Not.resolveRatings(ratings)
+}
+
+// matches reports whether p is selected by the filter. A nil filter matches
+// every product.
+func (f *ProductFilter) matches(p Product) bool {
+	if f == nil {
+		return true
+	}
+	if f.categoryIDs != nil {
+		if !f.categoryIDs[p.CategoryID] {
+			return false
+		}
+	} else if f.CategoryID != nil && p.CategoryID != *f.CategoryID {
+		return false
+	}
+	if f.MinPrice != nil && p.Price.Dollars() < *f.MinPrice {
+		return false
+	}
+	if f.MaxPrice != nil && p.Price.Dollars() > *f.MaxPrice {
+		return false
+	}
+	if f.Status != nil && p.Status != *f.Status {
+		return false
+	}
+	if statuses := deref(f.StatusIn); len(statuses) > 0 && !containsStatus(statuses, p.Status) {
+		return false
+	}
+	if f.NameContains != nil && !strings.Contains(strings.ToLower(p.Name), strings.ToLower(*f.NameContains)) {
+		return false
+	}
+	if f.InStock != nil && p.InStock != *f.InStock {
+		return false
+	}
+	if f.MinRating != nil {
+		if avg := f.ratings[p.ID].Average(); avg == nil || *avg < *f.MinRating {
+			return false
+		}
+	}
+	for _, nested := range deref(f.And) {
+		if !nested.matches(p) {
+			return false
+		}
+	}
+	if or := deref(f.Or); len(or) > 0 {
+		matched := false
+		for _, nested := range or {
+			if nested.matches(p) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return f.Not == nil || !f.Not.matches(p)
+}
+
+// deref returns the list l points to, or nil.
+func deref[T any](l *[]T) []T {
+	if l == nil {
+		return nil
+	}
+	return *l
+}
+
+func containsStatus(statuses []ProductStatus, status ProductStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// ProductSortField is a key GetProducts can sort by.
+type ProductSortField string
+
+const (
+	ProductSortPrice         ProductSortField = "PRICE"
+	ProductSortName          ProductSortField = "NAME"
+	ProductSortAverageRating ProductSortField = "AVERAGE_RATING"
+	ProductSortReviewCount   ProductSortField = "REVIEW_COUNT"
+	ProductSortCreatedAt     ProductSortField = "CREATED_AT"
+)
+
+// EnumValues implements the StringEnumValues interface for schema generation
+func (ProductSortField) EnumValues() []string {
+	return []string{"PRICE", "NAME", "AVERAGE_RATING", "REVIEW_COUNT", "CREATED_AT"}
+}
+
+// SortDirection is the direction of a sort key.
+type SortDirection string
+
+const (
+	SortAscending  SortDirection = "ASC"
+	SortDescending SortDirection = "DESC"
+)
+
+// EnumValues implements the StringEnumValues interface for schema generation
+func (SortDirection) EnumValues() []string {
+	return []string{"ASC", "DESC"}
+}
+
+// ProductOrder is one sort key of GetProducts. Later keys break the ties of
+// earlier ones, and the product ID breaks any remaining ties.
+type ProductOrder struct {
+	Field     ProductSortField `json:"field"`
+	Direction *SortDirection   `json:"direction"` // ASC unless given
+}
+
+func (o ProductOrder) descending() bool {
+	return o.Direction != nil && *o.Direction == SortDescending
+}
+
+// productOrder sorts products by a list of ProductOrder keys.
+type productOrder struct {
+	keys    []ProductOrder
+	ratings map[int]RatingSummary // By product ID
+	// all holds every stored product by ID, so a cursor still has a place
+	// in the order when its product is no longer listed.
+	all map[int]Product
+}
+
+// newProductOrder validates keys and prepares sorting all, the stored
+// products, by them.
+func (h *ProductHandlers) newProductOrder(ctx context.Context, keys []ProductOrder, all []Product) (*productOrder, error) {
+	o := &productOrder{keys: keys, all: make(map[int]Product, len(all))}
+	needRatings := false
+	for _, key := range keys {
+		switch key.Field {
+		case ProductSortPrice, ProductSortName, ProductSortCreatedAt:
+		case ProductSortAverageRating, ProductSortReviewCount:
+			needRatings = true
+		default:
+			return nil, fmt.Errorf("invalid sort field %q, expected one of %s", key.Field, strings.Join(key.Field.EnumValues(), ", "))
+		}
+		if key.Direction != nil && *key.Direction != SortAscending && *key.Direction != SortDescending {
+			return nil, fmt.Errorf("invalid sort direction %q, expected ASC or DESC", *key.Direction)
+		}
+	}
+	for _, p := range all {
+		o.all[p.ID] = p
+	}
+
+	if needRatings {
+		var err error
+		if o.ratings, err = h.ratingsByProduct(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// ratingsByProduct returns the rating summaries of the products with
+// approved reviews by product ID.
+func (h *ProductHandlers) ratingsByProduct(ctx context.Context) (map[int]RatingSummary, error) {
+	summaries, err := h.store.RatingSummaries().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]RatingSummary, len(summaries))
+	for _, s := range summaries {
+		result[s.ProductID] = s
+	}
+	return result, nil
+}
+
+// sort sorts products in place.
+func (o *productOrder) sort(products []Product) {
+	sort.SliceStable(products, func(i, j int) bool {
+		return o.compare(products[i], products[j]) < 0
+	})
+}
+
+// compare orders a before (negative) or after (positive) b.
+func (o *productOrder) compare(a, b Product) int {
+	for _, key := range o.keys {
+		c := 0
+		switch key.Field {
+		case ProductSortPrice:
+			c = compareFloats(float64(a.Price.Amount), float64(b.Price.Amount))
+		case ProductSortName:
+			c = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		case ProductSortReviewCount:
+			c = o.ratings[a.ID].Count - o.ratings[b.ID].Count
+		case ProductSortAverageRating:
+			ra, rb := o.ratings[a.ID].Average(), o.ratings[b.ID].Average()
+			if ra == nil || rb == nil {
+				// Products without reviews come last in either direction
+				if ra != rb {
+					if ra == nil {
+						return 1
+					}
+					return -1
+				}
+				continue
+			}
+			c = compareFloats(*ra, *rb)
+		case ProductSortCreatedAt:
+			c = compareCreated(a.CreatedAt, b.CreatedAt)
+		}
+		if c != 0 {
+			if key.descending() {
+				c = -c
+			}
+			return c
+		}
+	}
+	return a.ID - b.ID
+}
+
+// compareCursor places the product named by k relative to item.
+func (o *productOrder) compareCursor(k cursorKey, item Product) (int, error) {
+	p, ok := o.all[k.id]
+	if !ok {
+		return 0, fmt.Errorf("invalid cursor %q", k.encode())
+	}
+	return o.compare(p, item), nil
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareCreated compares creation times. Products without one are the
+// oldest.
+func compareCreated(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProductFilterMatches(t *testing.T) {
+	laptop := Product{ID: 1, Name: "Laptop", Price: NewMoney(999.99, storeCurrency), Status: ProductStatusActive, CategoryID: 1, InStock: true}
+	shirt := Product{ID: 3, Name: "Vintage T-Shirt", Price: NewMoney(24.99, storeCurrency), Status: ProductStatusOutOfStock, CategoryID: 3}
+
+	for name, tc := range map[string]struct {
+		filter *ProductFilter
+		want   []bool // laptop, shirt
+	}{
+		"nil":          {nil, []bool{true, true}},
+		"nameContains": {&ProductFilter{NameContains: strPtr("SHIRT")}, []bool{false, true}},
+		"statusIn": {&ProductFilter{StatusIn: &[]ProductStatus{ProductStatusDraft, ProductStatusOutOfStock}},
+			[]bool{false, true}},
+		"and": {&ProductFilter{And: &[]ProductFilter{{CategoryID: intPtr(1)}, {NameContains: strPtr("lap")}}},
+			[]bool{true, false}},
+		"or": {&ProductFilter{Or: &[]ProductFilter{{CategoryID: intPtr(2)}, {CategoryID: intPtr(3)}}},
+			[]bool{false, true}},
+		"empty or": {&ProductFilter{Or: &[]ProductFilter{}}, []bool{true, true}},
+		"not":      {&ProductFilter{Not: &ProductFilter{InStock: boolPtr(true)}}, []bool{false, true}},
+		"nested": {&ProductFilter{Or: &[]ProductFilter{
+			{Not: &ProductFilter{StatusIn: &[]ProductStatus{ProductStatusActive}}},
+			{And: &[]ProductFilter{{MaxPrice: floatPtr(100)}}},
+		}}, []bool{false, true}},
+	} {
+		for i, p := range []Product{laptop, shirt} {
+			if got := tc.filter.matches(p); got != tc.want[i] {
+				t.Errorf("%s: expected %v for %s, got %v", name, tc.want[i], p.Name, got)
+			}
+		}
+	}
+
+	invalid := &ProductFilter{Not: &ProductFilter{StatusIn: &[]ProductStatus{"SOLD"}}}
+	if err := invalid.validate(); err == nil || !strings.Contains(err.Error(), `invalid product status "SOLD"`) {
+		t.Errorf("expected nested statuses to be validated, got %v", err)
+	}
+}
+
+func TestGetProductsOrderBy(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		h := NewProductHandlers(store)
+		getProducts := func(orderBy []ProductOrder, first *int, after *string) *ProductConnection {
+			t.Helper()
+			conn, err := h.GetProducts(ctx, nil, &orderBy, nil, first, after, nil, nil)
+			if err != nil {
+				t.Fatalf("GetProducts failed: %v", err)
+			}
+			return conn
+		}
+		desc := SortDescending
+
+		// Ratings: book 5 (1 review), laptop 4.5 (2 reviews), others unrated
+		for _, tc := range []struct {
+			orderBy []ProductOrder
+			want    []int
+		}{
+			{[]ProductOrder{{Field: ProductSortPrice}}, []int{3, 2, 4, 1}},
+			{[]ProductOrder{{Field: ProductSortName, Direction: &desc}}, []int{3, 4, 1, 2}},
+			{[]ProductOrder{{Field: ProductSortCreatedAt, Direction: &desc}}, []int{4, 2, 1, 3}},
+			{[]ProductOrder{{Field: ProductSortAverageRating}}, []int{1, 2, 3, 4}},
+			{[]ProductOrder{{Field: ProductSortAverageRating, Direction: &desc}, {Field: ProductSortPrice}}, []int{2, 1, 3, 4}},
+			{[]ProductOrder{{Field: ProductSortReviewCount, Direction: &desc}, {Field: ProductSortPrice, Direction: &desc}}, []int{1, 2, 4, 3}},
+		} {
+			if got := productIDs(getProducts(tc.orderBy, nil, nil)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("orderBy %+v: expected %v, got %v", tc.orderBy, tc.want, got)
+			}
+		}
+
+		// Cursors follow the sort order
+		byPrice := []ProductOrder{{Field: ProductSortPrice, Direction: &desc}}
+		page := getProducts(byPrice, intPtr(2), nil)
+		if got := productIDs(getProducts(byPrice, intPtr(2), page.PageInfo.EndCursor)); !reflect.DeepEqual(got, []int{2, 3}) {
+			t.Errorf("expected the second page by price to be [2 3], got %v", got)
+		}
+
+		bad := ProductSortField("POPULARITY")
+		if _, err := h.GetProducts(ctx, nil, &[]ProductOrder{{Field: bad}}, nil, nil, nil, nil, nil); err == nil ||
+			!strings.Contains(err.Error(), `invalid sort field "POPULARITY"`) {
+			t.Errorf("expected an invalid sort field to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestProductUpdatesFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewMemoryStore()
+	h := NewSubscriptionHandlers(store)
+
+	if _, err := h.ProductUpdates(ctx, nil, &ProductFilter{Status: productStatusPtr("SOLD")}); err == nil {
+		t.Error("expected an invalid filter to be rejected")
+	}
+	updates, err := h.ProductUpdates(ctx, nil, &ProductFilter{
+		NameContains: strPtr("phone"),
+		Not:          &ProductFilter{Status: productStatusPtr(ProductStatusDraft)},
+	})
+	if err != nil {
+		t.Fatalf("ProductUpdates failed: %v", err)
+	}
+
+	store.Events().BroadcastProductUpdate(ctx, Product{ID: 5, Name: "Phone case", Status: ProductStatusDraft}, "created")
+	store.Events().BroadcastProductUpdate(ctx, Product{ID: 6, Name: "Headphones", Status: ProductStatusActive}, "created")
+	select {
+	case update := <-updates:
+		if update.Product.ID != 6 {
+			t.Errorf("expected only the matching product, got %+v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected an update for the matching product")
+	}
+}
+
+func boolPtr(b bool) *bool                                 { return &b }
+func floatPtr(f float64) *float64                          { return &f }
+func productStatusPtr(status ProductStatus) *ProductStatus { return &status }
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Catalog prices are Money in the store currency. Every change of a product
+// price, including the price it was created with, is recorded as a
+// ProductPriceChange, so PriceAt can tell what a product cost at any time.
+// Products created before the history was recorded cost their oldest
+// recorded price, or their current one, before that.
+
+// storeCurrency is the currency all prices are kept in.
+const storeCurrency = "USD"
+
+// ProductPriceChange is one entry of the price history of a product.
+type ProductPriceChange struct {
+	ID          int       `json:"id"`
+	ProductID   int       `json:"productId"`
+	From        *Money    `json:"from"` // Unset for the price the product was created with
+	To          Money     `json:"to"`
+	UserID      *int      `json:"userId"` // Acting user, if authenticated
+	EffectiveAt time.Time `json:"effectiveAt"`
+}
+
+// PriceAtArgs selects the point in time for Product.PriceAt.
+type PriceAtArgs struct {
+	At time.Time `json:"at"`
+}
+
+// checkPrice returns an error unless price can be a catalog price: it must
+// be in the store currency and not negative.
+func checkPrice(price Money) error {
+	if price.Currency != storeCurrency {
+		return fmt.Errorf("price must be in %s", storeCurrency)
+	}
+	if price.Amount < 0 {
+		return errors.New("price cannot be negative")
+	}
+	return nil
+}
+
+// SetProductPrice changes the price of a product and records the change in
+// its price history. version must be the product version the client last
+// read. Requires the admin role.
+func (h *ProductHandlers) SetProductPrice(ctx context.Context, id int, price Money, version int) (*Product, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := checkPrice(price); err != nil {
+		return nil, err
+	}
+	if price.IsZero() {
+		return nil, errors.New("price must be greater than zero")
+	}
+	product, err := h.store.Products().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && product.isDeleted()) {
+		return nil, fmt.Errorf("product with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if product.Price == price {
+		return nil, fmt.Errorf("product %d already costs %s", id, price)
+	}
+
+	from := product.Price
+	product.Price, product.Version = price, version
+	product, err = h.store.Products().Update(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.recordPriceChange(ctx, &from, product, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	product.bind(newScope(ctx, h.store))
+
+	h.store.Events().BroadcastProductUpdate(ctx, product, "updated")
+
+	return &product, nil
+}
+
+// recordPriceChange appends a history entry for a price change that was
+// already applied to product. from is nil when the product was just created.
+func (h *ProductHandlers) recordPriceChange(ctx context.Context, from *Money, product Product, effectiveAt time.Time) error {
+	entry := ProductPriceChange{ProductID: product.ID, From: from, To: product.Price, EffectiveAt: effectiveAt}
+	if user, ok := ctx.Value(UserContextKey).(*User); ok && user != nil {
+		entry.UserID = &user.ID
+	}
+	_, err := h.store.ProductPriceHistory().Append(ctx, entry)
+	return err
+}
+
+// Field resolvers
+
+// PriceHistory returns the price changes of the product, oldest first.
+func (p *Product) PriceHistory() ([]ProductPriceChange, error) {
+	return p.scope.store.ProductPriceHistory().ListByProduct(p.scope.ctx, p.ID)
+}
+
+// PriceAt returns the price the product had at the given time, or null if
+// the product did not exist yet.
+func (p *Product) PriceAt(args PriceAtArgs) (*Money, error) {
+	if p.CreatedAt != nil && args.At.Before(*p.CreatedAt) {
+		return nil, nil
+	}
+	history, err := p.scope.store.ProductPriceHistory().ListByProduct(p.scope.ctx, p.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk back from the current price, undoing the changes made since
+	price := p.Price
+	for i := len(history) - 1; i >= 0; i-- {
+		change := history[i]
+		if !change.EffectiveAt.After(args.At) {
+			return &change.To, nil
+		}
+		if change.From == nil {
+			return nil, nil
+		}
+		price = *change.From
+	}
+	return &price, nil
+}
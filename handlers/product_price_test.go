@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProductPrices(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		admin, customer := userContext(t, store, 1), userContext(t, store, 2)
+		run := func(ctx context.Context, request string) string {
+			res, _ := graph.ProcessRequest(ctx, request, "")
+			return res
+		}
+
+		for _, tc := range []struct {
+			ctx           context.Context
+			request, want string
+		}{
+			{customer, `mutation { SetProductPrice(id: 1, price: "899.99 USD", version: 1) { ID } }`, "admin role required"},
+			{admin, `mutation { SetProductPrice(id: 1, price: "899.99 EUR", version: 1) { ID } }`, "price must be in USD"},
+			{admin, `mutation { SetProductPrice(id: 1, price: "0.00 USD", version: 1) { ID } }`, "price must be greater than zero"},
+			{admin, `mutation { SetProductPrice(id: 9, price: "899.99 USD", version: 1) { ID } }`, "product with id 9 not found"},
+			{admin, `mutation { SetProductPrice(id: 1, price: "999.99 USD", version: 1) { ID } }`, "product 1 already costs 999.99 USD"},
+			{admin, `mutation { SetProductPrice(id: 1, price: "899.99 USD", version: 1) { price priceFloat version } }`,
+				`"price":"899.99 USD","priceFloat":899.99,"version":2`},
+			{admin, `mutation { SetProductPrice(id: 1, price: "799.99 USD", version: 1) { ID } }`,
+				`"code":"VERSION_CONFLICT","currentVersion":"2","entity":"product"`},
+			{customer, `{ GetProduct(id: 1) { priceHistory { from to userId } } }`,
+				`"priceHistory":[{"from":"999.99 USD","to":"899.99 USD","userId":1}]`},
+
+			// The seeded laptop did not exist in 2020 and cost its old price before the change
+			{customer, `{ GetProduct(id: 1) { priceAt(at: "2020-01-01T00:00:00Z") } }`, `"priceAt":null`},
+			{customer, `{ GetProduct(id: 1) { priceAt(at: "2024-01-01T00:00:00Z") } }`, `"priceAt":"999.99 USD"`},
+			{customer, `{ GetProduct(id: 1) { priceAt(at: "2100-01-01T00:00:00Z") } }`, `"priceAt":"899.99 USD"`},
+
+			// New products start their history with the price they are created with
+			{admin, `mutation { CreateProduct(input: {name: "Mug", description: "Ceramic", price: "12.50 USD", categoryId: 3}) { ID priceHistory { from to userId } } }`,
+				`"ID":5,"priceHistory":[{"from":null,"to":"12.50 USD","userId":1}]`},
+			{admin, `mutation { CreateProduct(input: {name: "Mug", description: "Ceramic", price: "-1.00 USD", categoryId: 3}) { ID } }`,
+				"price cannot be negative"},
+
+			// Plain numbers are still taken as USD
+			{admin, `mutation { CreateWidget(widget: {name: "Gear", price: 2.5, quantity: 1}) { price priceFloat } }`,
+				`"price":"2.50 USD","priceFloat":2.5`},
+			{admin, `mutation { CreateWidget(widget: {name: "Gear", price: "2.50 GBP", quantity: 1}) { id } }`, "price must be in USD"},
+		} {
+			if res := run(tc.ctx, tc.request); !strings.Contains(res, tc.want) {
+				t.Errorf("%s: expected %s, got %s", tc.request, tc.want, res)
+			}
+		}
+
+		// The book was discounted in March and partly raised again in June
+		day := func(s string) time.Time {
+			d, _ := time.Parse("2006-01-02", s)
+			return d
+		}
+		for _, c := range []ProductPriceChange{
+			{ProductID: 2, From: &Money{Amount: 3999, Currency: "USD"}, To: Money{Amount: 2999, Currency: "USD"}, EffectiveAt: day("2024-03-01")},
+			{ProductID: 2, From: &Money{Amount: 2999, Currency: "USD"}, To: Money{Amount: 3499, Currency: "USD"}, EffectiveAt: day("2024-06-01")},
+		} {
+			if _, err := store.ProductPriceHistory().Append(ctx, c); err != nil {
+				t.Fatalf("Append failed: %v", err)
+			}
+		}
+		for at, want := range map[string]string{
+			"2024-02-01T00:00:00Z": "39.99 USD",
+			"2024-03-01T00:00:00Z": "29.99 USD",
+			"2024-04-01T00:00:00Z": "29.99 USD",
+			"2024-07-01T00:00:00Z": "34.99 USD",
+		} {
+			res := run(customer, `{ GetProduct(id: 2) { priceAt(at: "`+at+`") } }`)
+			if !strings.Contains(res, `"priceAt":"`+want+`"`) {
+				t.Errorf("expected the book to cost %s at %s, got %s", want, at, res)
+			}
+		}
+
+		// priceFloat is still there for old clients, marked deprecated
+		schema := graph.SchemaDefinition(ctx)
+		for _, want := range []string{
+			`PriceFloat: Float @deprecated(reason: "Use Price with its currency")`,
+			`PriceFloat: Float @deprecated(reason: "Use price with its currency")`,
+		} {
+			if !strings.Contains(schema, want) {
+				t.Errorf("expected %s in the schema", want)
+			}
+		}
+	})
+}
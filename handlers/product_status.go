@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gburgyan/go-quickgraph"
+)
+
+// Product statuses follow a declared lifecycle. New products start as DRAFT,
+// are published as ACTIVE, can run OUT_OF_STOCK and come back, and end up
+// DISCONTINUED, which is final:
+//
+//	DRAFT        -> ACTIVE, DISCONTINUED
+//	ACTIVE       -> OUT_OF_STOCK, DISCONTINUED
+//	OUT_OF_STOCK -> ACTIVE, DISCONTINUED
+//
+// Entering a status can be guarded: a product only becomes ACTIVE with a
+// price, a category that is not deleted and stock. A product is InStock
+// while it is ACTIVE with stock. Every change, including the initial DRAFT,
+// is recorded as a ProductStatusChange.
+
+// productTransitions lists the statuses a product can move to from each
+// status.
+var productTransitions = map[ProductStatus][]ProductStatus{
+	ProductStatusDraft:        {ProductStatusActive, ProductStatusDiscontinued},
+	ProductStatusActive:       {ProductStatusOutOfStock, ProductStatusDiscontinued},
+	ProductStatusOutOfStock:   {ProductStatusActive, ProductStatusDiscontinued},
+	ProductStatusDiscontinued: nil,
+}
+
+// productStatusGuards are checked before a product enters a status. category
+// is nil if the product's category does not exist.
+var productStatusGuards = map[ProductStatus]func(p Product, category *Category) error{
+	ProductStatusActive: func(p Product, category *Category) error {
+		if p.Price.Amount <= 0 {
+			return errors.New("a price is required")
+		}
+		if category == nil || category.isDeleted() {
+			return fmt.Errorf("category %d does not exist", p.CategoryID)
+		}
+		if p.Stock <= 0 {
+			return errors.New("it has no stock")
+		}
+		return nil
+	},
+}
+
+// ProductStatusChange is one entry of the status history of a product.
+type ProductStatusChange struct {
+	ID        int            `json:"id"`
+	ProductID int            `json:"productId"`
+	From      *ProductStatus `json:"from"` // Unset for the status the product was created with
+	To        ProductStatus  `json:"to"`
+	InStock   bool           `json:"inStock"` // InStock once the change was applied
+	UserID    *int           `json:"userId"`  // Acting user, if authenticated
+	Time      time.Time      `json:"time"`
+}
+
+// ProductTransition is a status a product can move to next. Transitions that
+// are blocked by a guard are listed too, with the reason, so clients can
+// show them disabled.
+type ProductTransition struct {
+	Status  ProductStatus `json:"status"`
+	Allowed bool          `json:"allowed"`
+	Reason  *string       `json:"reason"` // Why the transition is blocked
+}
+
+// StatusTransitionError is returned when a product cannot move to the
+// requested status.
+type StatusTransitionError struct {
+	ProductID int
+	From, To  ProductStatus
+	Reason    string
+	Allowed   []ProductStatus // Statuses the product can move to instead
+}
+
+func (e *StatusTransitionError) Error() string {
+	return fmt.Sprintf("product %d cannot change from %s to %s: %s; allowed next statuses: %s",
+		e.ProductID, e.From, e.To, e.Reason, e.allowedList())
+}
+
+func (e *StatusTransitionError) allowedList() string {
+	if len(e.Allowed) == 0 {
+		return "none"
+	}
+	names := make([]string, len(e.Allowed))
+	for i, s := range e.Allowed {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ", ")
+}
+
+// As lets quickgraph report the error with a machine-readable code and the
+// allowed next statuses.
+func (e *StatusTransitionError) As(target interface{}) bool {
+	ge, ok := target.(*quickgraph.GraphError)
+	if !ok {
+		return false
+	}
+	*ge = quickgraph.GraphError{
+		Message:    e.Error(),
+		InnerError: e,
+		Extensions: map[string]string{
+			"code":    "INVALID_STATUS_TRANSITION",
+			"from":    string(e.From),
+			"to":      string(e.To),
+			"allowed": e.allowedList(),
+		},
+	}
+	return true
+}
+
+// productTransitionsOf returns the statuses p can move to next, with the
+// ones blocked by a guard marked as not allowed.
+func productTransitionsOf(p Product, category *Category) []ProductTransition {
+	transitions := []ProductTransition{}
+	for _, status := range productTransitions[p.Status] {
+		t := ProductTransition{Status: status, Allowed: true}
+		if guard, ok := productStatusGuards[status]; ok {
+			if err := guard(p, category); err != nil {
+				t.Allowed, t.Reason = false, strPtr(err.Error())
+			}
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions
+}
+
+// checkStatusTransition returns a *StatusTransitionError unless p can move
+// to status.
+func checkStatusTransition(p Product, category *Category, status ProductStatus) error {
+	transitionErr := &StatusTransitionError{ProductID: p.ID, From: p.Status, To: status, Reason: "not an allowed transition"}
+	for _, t := range productTransitionsOf(p, category) {
+		if t.Allowed {
+			transitionErr.Allowed = append(transitionErr.Allowed, t.Status)
+		}
+		if t.Status == status {
+			if t.Allowed {
+				return nil
+			}
+			transitionErr.Reason = *t.Reason
+		}
+	}
+	return transitionErr
+}
+
+// setStatus moves p to status and updates InStock.
+func (p *Product) setStatus(status ProductStatus) {
+	p.Status = status
+	p.updateInStock()
+}
+
+// updateInStock derives InStock from the stock of p: only ACTIVE products
+// are sold from stock.
+func (p *Product) updateInStock() {
+	p.InStock = p.Status == ProductStatusActive && p.Stock > 0
+}
+
+// changeProductStatus moves product to status, records the change and
+// broadcasts it. The caller checks the transition.
+func (h *ProductHandlers) changeProductStatus(ctx context.Context, product Product, status ProductStatus) (*Product, error) {
+	from := product.Status
+	product.setStatus(status)
+	product, err := h.store.Products().Update(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.recordStatusChange(ctx, &from, product); err != nil {
+		return nil, err
+	}
+	product.bind(newScope(ctx, h.store))
+
+	// Broadcast the product update
+	h.store.Events().BroadcastProductUpdate(ctx, product, "updated")
+
+	return &product, nil
+}
+
+// recordStatusChange appends a history entry for a status change that was
+// already applied to product. from is nil when the product was just created.
+func (h *ProductHandlers) recordStatusChange(ctx context.Context, from *ProductStatus, product Product) error {
+	entry := ProductStatusChange{ProductID: product.ID, From: from, To: product.Status, InStock: product.InStock, Time: time.Now().UTC()}
+	if user, ok := ctx.Value(UserContextKey).(*User); ok && user != nil {
+		entry.UserID = &user.ID
+	}
+	_, err := h.store.ProductStatusHistory().Append(ctx, entry)
+	return err
+}
+
+// productCategory returns the category of p, or nil if it does not exist.
+func (h *ProductHandlers) productCategory(ctx context.Context, p Product) (*Category, error) {
+	category, err := h.store.Categories().Get(ctx, p.CategoryID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// StatusHistory returns the status changes of the product, oldest first.
+// Products created before the history was recorded start with their first
+// change.
+func (p *Product) StatusHistory() ([]ProductStatusChange, error) {
+	return p.scope.store.ProductStatusHistory().ListByProduct(p.scope.ctx, p.ID)
+}
+
+// AvailableTransitions returns the statuses the product can move to next.
+// Deleted products have none.
+func (p *Product) AvailableTransitions() ([]ProductTransition, error) {
+	if p.isDeleted() {
+		return []ProductTransition{}, nil
+	}
+	c, ok, err := p.scope.loaders.categories.load(p.CategoryID)
+	if err != nil {
+		return nil, err
+	}
+	var category *Category
+	if ok {
+		category = &c
+	}
+	return productTransitionsOf(*p, category), nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestProductStatusLifecycle(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		adminCtx := userContext(t, store, 1)
+		run := func(request string) string {
+			res, _ := graph.ProcessRequest(adminCtx, request, "")
+			return res
+		}
+
+		// Product 5 has no price, so it cannot be published
+		run(`mutation { CreateProduct(input: {name: "Sticker", description: "Free", price: 0, categoryId: 3}) { ID } }`)
+		res := run(`{ GetProduct(id: 5) { availableTransitions { allowed reason status } statusHistory { from inStock to userId } } }`)
+		want := `"availableTransitions":[{"allowed":false,"reason":"a price is required","status":"ACTIVE"},` +
+			`{"allowed":true,"reason":null,"status":"DISCONTINUED"}],` +
+			`"statusHistory":[{"from":null,"inStock":false,"to":"DRAFT","userId":1}]`
+		if !strings.Contains(res, want) {
+			t.Errorf("expected the transitions and history of a new product, got %s", res)
+		}
+		res = run(`mutation { UpdateProductStatus(id: 5, status: "ACTIVE", version: 1) { ID } }`)
+		for _, want := range []string{
+			"product 5 cannot change from DRAFT to ACTIVE: a price is required; allowed next statuses: DISCONTINUED",
+			`"code":"INVALID_STATUS_TRANSITION"`,
+		} {
+			if !strings.Contains(res, want) {
+				t.Errorf("expected %s, got %s", want, res)
+			}
+		}
+
+		// Product 1 runs out of stock and is discontinued for good
+		for _, tc := range []struct{ request, want string }{
+			{`mutation { UpdateProductStatus(id: 1, status: "OUT_OF_STOCK", version: 1) { InStock Status } }`,
+				`"InStock":false,"Status":"OUT_OF_STOCK"`},
+			{`mutation { UpdateProductStatus(id: 1, status: "OUT_OF_STOCK", version: 2) { ID } }`,
+				"product 1 is already OUT_OF_STOCK"},
+			{`mutation { UpdateProductStatus(id: 1, status: "ACTIVE", version: 2) { InStock Status } }`,
+				`"InStock":true,"Status":"ACTIVE"`},
+			{`mutation { UpdateProductStatus(id: 1, status: "DISCONTINUED", version: 3) { Status } }`,
+				`"Status":"DISCONTINUED"`},
+			{`mutation { UpdateProductStatus(id: 1, status: "DRAFT", version: 4) { ID } }`,
+				"product 1 cannot change from DISCONTINUED to DRAFT: not an allowed transition; allowed next statuses: none"},
+			{`{ GetProduct(id: 1) { availableTransitions { status } statusHistory { from to } } }`,
+				`"availableTransitions":[],"statusHistory":[{"from":"ACTIVE","to":"OUT_OF_STOCK"},` +
+					`{"from":"OUT_OF_STOCK","to":"ACTIVE"},{"from":"ACTIVE","to":"DISCONTINUED"}]`},
+		} {
+			if res := run(tc.request); !strings.Contains(res, tc.want) {
+				t.Errorf("%s: expected %s, got %s", tc.request, tc.want, res)
+			}
+		}
+
+		// Products without stock are never ACTIVE or in stock
+		run(`mutation { CreateProduct(input: {name: "Mug", description: "Ceramic", price: 12, categoryId: 3}) { ID } }`)
+		for _, tc := range []struct{ request, want string }{
+			{`mutation { UpdateProductStatus(id: 6, status: "ACTIVE", version: 1) { ID } }`,
+				"product 6 cannot change from DRAFT to ACTIVE: it has no stock; allowed next statuses: DISCONTINUED"},
+			{`mutation { UpdateProductStatus(id: 3, status: "ACTIVE", version: 1) { ID } }`,
+				"product 3 cannot change from OUT_OF_STOCK to ACTIVE: it has no stock; allowed next statuses: DISCONTINUED"},
+			{`{ GetProduct(id: 3) { InStock Status } }`, `"InStock":false,"Status":"OUT_OF_STOCK"`},
+			{`mutation { RestockProduct(id: 6, quantity: 2, version: 1) { InStock Status } }`, `"InStock":false,"Status":"DRAFT"`},
+			{`mutation { UpdateProductStatus(id: 6, status: "ACTIVE", version: 2) { InStock Status } }`, `"InStock":true,"Status":"ACTIVE"`},
+		} {
+			if res := run(tc.request); !strings.Contains(res, tc.want) {
+				t.Errorf("%s: expected %s, got %s", tc.request, tc.want, res)
+			}
+		}
+
+		// A deleted category blocks publishing
+		admin, err := GetCurrentUser(adminCtx)
+		if err != nil {
+			t.Fatalf("GetCurrentUser failed: %v", err)
+		}
+		category, err := store.Categories().Get(ctx, 2)
+		if err != nil {
+			t.Fatalf("Get category failed: %v", err)
+		}
+		category.DeletedAt, category.DeletedBy = deletionStamp(admin)
+		if _, err := store.Categories().Update(ctx, category); err != nil {
+			t.Fatalf("Update category failed: %v", err)
+		}
+		run(`mutation { UpdateProductStatus(id: 2, status: "OUT_OF_STOCK", version: 1) { ID } }`)
+		res = run(`mutation { UpdateProductStatus(id: 2, status: "ACTIVE", version: 2) { ID } }`)
+		if !strings.Contains(res, "product 2 cannot change from OUT_OF_STOCK to ACTIVE: category 2 does not exist; allowed next statuses: DISCONTINUED") {
+			t.Errorf("expected the deleted category to block publishing, got %s", res)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import "time"
+
+// Ratings are aggregated per product as reviews change rather than computed
+// from the reviews on every read. The review repositories keep a
+// RatingSummary per product up to date whenever they create or update a
+// review, which covers new, edited, moderated, deleted and restored reviews
+// alike, and RatingSummaries reads them.
+
+// RatingSummary aggregates the approved reviews of a product.
+type RatingSummary struct {
+	ProductID    int        `json:"productId"`
+	Count        int        `json:"count"`
+	Total        int        `json:"-" graphy:"-"` // Sum of the ratings
+	Stars        [5]int     `json:"-" graphy:"-"` // Number of 1 to 5 star ratings
+	LastReviewAt *time.Time `json:"lastReviewAt"` // When the latest approved review was written or edited
+}
+
+// RatingBucket is the number of reviews with one star rating.
+type RatingBucket struct {
+	Stars int `json:"stars"`
+	Count int `json:"count"`
+}
+
+// Average returns the average rating, or null without reviews.
+func (s RatingSummary) Average() *float64 {
+	if s.Count == 0 {
+		return nil
+	}
+	avg := float64(s.Total) / float64(s.Count)
+	return &avg
+}
+
+// Histogram returns the number of reviews per rating, from 1 to 5 stars.
+func (s RatingSummary) Histogram() []RatingBucket {
+	buckets := make([]RatingBucket, len(s.Stars))
+	for i, count := range s.Stars {
+		buckets[i] = RatingBucket{Stars: i + 1, Count: count}
+	}
+	return buckets
+}
+
+// countsTowardRating reports whether r is part of its product's rating.
+func (r Review) countsTowardRating() bool {
+	return r.isApproved() && !r.isDeleted() && r.Rating >= 1 && r.Rating <= 5
+}
+
+// reviewedAt returns when r was last written: its last edit, or else its
+// creation. It is nil if neither is known.
+func (r Review) reviewedAt() *time.Time {
+	if r.UpdatedAt != nil {
+		return r.UpdatedAt
+	}
+	created, err := time.Parse(time.RFC3339, r.CreatedAt)
+	if err != nil {
+		return nil
+	}
+	return &created
+}
+
+func (s *RatingSummary) add(r Review) {
+	s.Count++
+	s.Total += r.Rating
+	s.Stars[r.Rating-1]++
+	if at := r.reviewedAt(); at != nil && (s.LastReviewAt == nil || at.After(*s.LastReviewAt)) {
+		s.LastReviewAt = at
+	}
+}
+
+// remove takes r out of the summary. It reports whether LastReviewAt may be
+// stale because r was the latest review.
+func (s *RatingSummary) remove(r Review) bool {
+	s.Count--
+	s.Total -= r.Rating
+	s.Stars[r.Rating-1]--
+	if s.Count == 0 {
+		s.LastReviewAt = nil
+		return false
+	}
+	at := r.reviewedAt()
+	return at != nil && s.LastReviewAt != nil && !at.Before(*s.LastReviewAt)
+}
+
+// latestReviewAt returns when the latest of the reviews that count toward a
+// rating was written.
+func latestReviewAt(reviews []Review) *time.Time {
+	var latest *time.Time
+	for _, r := range reviews {
+		if !r.countsTowardRating() {
+			continue
+		}
+		if at := r.reviewedAt(); at != nil && (latest == nil || at.After(*latest)) {
+			latest = at
+		}
+	}
+	return latest
+}
+
+// ratingStore is how updateRatings reads and writes the summaries of a
+// review repository.
+type ratingStore struct {
+	// get returns the summary of a product, empty if it has none yet
+	get func(productID int) (RatingSummary, error)
+	put func(summary RatingSummary) error
+	// reviews lists the stored reviews of a product, including the change
+	// being applied
+	reviews func(productID int) ([]Review, error)
+}
+
+// updateRatings applies a review changing from old to updated to the
+// summaries of their products. old is nil for a new review.
+func updateRatings(rs ratingStore, old *Review, updated Review) error {
+	if old != nil && old.countsTowardRating() {
+		summary, err := rs.get(old.ProductID)
+		if err != nil {
+			return err
+		}
+		if summary.remove(*old) {
+			reviews, err := rs.reviews(old.ProductID)
+			if err != nil {
+				return err
+			}
+			// updated is added back below if it still counts
+			others := reviews[:0:0]
+			for _, r := range reviews {
+				if r.ID != updated.ID {
+					others = append(others, r)
+				}
+			}
+			summary.LastReviewAt = latestReviewAt(others)
+		}
+		if err := rs.put(summary); err != nil {
+			return err
+		}
+	}
+	if !updated.countsTowardRating() {
+		return nil
+	}
+	summary, err := rs.get(updated.ProductID)
+	if err != nil {
+		return err
+	}
+	summary.add(updated)
+	return rs.put(summary)
+}
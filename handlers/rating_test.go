@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRatingSummaries(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		admin, john, jane := userContext(t, store, 1), userContext(t, store, 2), userContext(t, store, 3)
+		run := func(ctx context.Context, request string) string {
+			res, _ := graph.ProcessRequest(ctx, request, "")
+			return res
+		}
+
+		for _, tc := range []struct {
+			ctx           context.Context
+			request, want string
+		}{
+			{john, `{ GetProduct(id: 1) { ratingSummary { count average histogram { stars count } lastReviewAt } } }`,
+				`"ratingSummary":{"average":4.5,"count":2,` +
+					`"histogram":[{"count":0,"stars":1},{"count":0,"stars":2},{"count":0,"stars":3},{"count":1,"stars":4},{"count":1,"stars":5}],` +
+					`"lastReviewAt":"2024-01-16T14:30:00Z"}`},
+			{john, `{ GetProduct(id: 3) { averageRating ratingSummary { count average lastReviewAt } } }`,
+				`"averageRating":null,"ratingSummary":{"average":null,"count":0,"lastReviewAt":null}`},
+
+			// Deleting the latest review falls back to the one before it
+			{jane, `mutation { DeleteReview(id: 2) { ID } }`, `"ID":2`},
+			{john, `{ GetProduct(id: 1) { ratingSummary { count average lastReviewAt } } }`,
+				`"ratingSummary":{"average":5,"count":1,"lastReviewAt":"2024-01-15T10:00:00Z"}`},
+			{admin, `mutation { RestoreReview(id: 2) { ID } }`, `"ID":2`},
+			{john, `{ GetProduct(id: 1) { ratingSummary { count lastReviewAt } } }`,
+				`"ratingSummary":{"count":2,"lastReviewAt":"2024-01-16T14:30:00Z"}`},
+
+			// Edited and new reviews only count once approved
+			{john, `mutation { EditReview(id: 1, review: {rating: 3, comment: "Runs hot"}) { Status } }`, `"Status":"PENDING"`},
+			{john, `{ GetProduct(id: 1) { averageRating ratingSummary { count } } }`, `"averageRating":4,"ratingSummary":{"count":1}`},
+			{admin, `mutation { ApproveReview(id: 1) { Status } }`, `"Status":"APPROVED"`},
+			{john, `{ GetProduct(id: 1) { averageRating ratingSummary { count } } }`, `"averageRating":3.5,"ratingSummary":{"count":2}`},
+			{jane, `mutation { AddProductReview(productId: 4, review: {rating: 2, comment: "Fragile"}) { ID } }`, `"ID":4`},
+			{john, `{ GetProduct(id: 4) { ratingSummary { count } } }`, `"ratingSummary":{"count":0}`},
+			{admin, `mutation { ApproveReview(id: 4) { Status } }`, `"Status":"APPROVED"`},
+			{admin, `mutation { RejectReview(id: 3) { Status } }`, `"Status":"REJECTED"`},
+			{admin, `mutation { ApproveReview(id: 3) { Status } }`, `"Status":"APPROVED"`},
+
+			// Filtering and sorting read the summaries
+			{john, `{ GetProducts(filter: {minRating: 3.5}) { edges { node { name } } } }`,
+				`"edges":[{"node":{"name":"Laptop"}},{"node":{"name":"Go Programming Book"}}]`},
+			{john, `{ GetProducts(filter: {not: {minRating: 3}}) { edges { node { name } } } }`,
+				`"edges":[{"node":{"name":"Vintage T-Shirt"}},{"node":{"name":"Smartphone"}}]`},
+			{john, `{ GetProducts(orderBy: [{field: AVERAGE_RATING, direction: DESC}]) { edges { node { name } } } }`,
+				`"edges":[{"node":{"name":"Go Programming Book"}},{"node":{"name":"Laptop"}},{"node":{"name":"Smartphone"}},{"node":{"name":"Vintage T-Shirt"}}]`},
+			{john, `{ GetProducts(orderBy: [{field: REVIEW_COUNT, direction: DESC}]) { edges { node { name } } } }`,
+				`"edges":[{"node":{"name":"Laptop"}},{"node":{"name":"Go Programming Book"}},{"node":{"name":"Smartphone"}},{"node":{"name":"Vintage T-Shirt"}}]`},
+			{john, `{ GetProducts(filter: {minRating: 6}) { totalCount } }`, "minRating must be between 1 and 5"},
+		} {
+			if res := run(tc.ctx, tc.request); !strings.Contains(res, tc.want) {
+				t.Errorf("%s: expected %s, got %s", tc.request, tc.want, res)
+			}
+		}
+
+		// The maintained summaries match the reviews counted from scratch
+		reviews, err := store.Reviews().List(ctx)
+		if err != nil {
+			t.Fatalf("List reviews failed: %v", err)
+		}
+		want := map[int]RatingSummary{}
+		for _, r := range reviews {
+			if r.countsTowardRating() {
+				s := want[r.ProductID]
+				s.ProductID = r.ProductID
+				s.add(r)
+				want[r.ProductID] = s
+			}
+		}
+		summaries, err := store.RatingSummaries().List(ctx)
+		if err != nil {
+			t.Fatalf("List summaries failed: %v", err)
+		}
+		got := map[int]RatingSummary{}
+		for _, s := range summaries {
+			got[s.ProductID] = s
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected the summaries %+v, got %+v", want, got)
+		}
+	})
+}
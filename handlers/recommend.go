@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Recommendations rank ACTIVE products against a product a customer looks
+// at, or against the products a user reviewed. Scores are plain sums of
+// fixed weights, rounded to three decimals, and ties go to the lower product
+// ID, so the same data always gives the same ranking.
+
+const (
+	// Score of a product in the same category
+	sharedCategoryWeight = 3.0
+	// Score per user with approved reviews of both products
+	coReviewerWeight = 2.0
+	// Score of an equal price. It falls toward 0 as one price becomes a
+	// smaller fraction of the other.
+	priceProximityWeight = 1.0
+
+	defaultRecommendations = 5
+	maxRecommendations     = 50
+)
+
+// ProductRecommendation is a recommended product and why it was picked.
+type ProductRecommendation struct {
+	Product Product  `json:"product"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"` // Strongest first
+}
+
+// RelatedArgs are the arguments of Product.Related. quickgraph requires
+// every argument of a field resolver, so first must be given.
+type RelatedArgs struct {
+	First int `json:"first"` // At most 50
+}
+
+// recommender holds what scoring needs: the candidate products and who
+// reviewed what.
+type recommender struct {
+	candidates []Product            // ACTIVE products that are not deleted, by ID
+	reviewers  map[int]map[int]bool // User IDs with approved reviews, by product ID
+}
+
+func newRecommender(sc *scope) (*recommender, error) {
+	products, err := sc.store.Products().List(sc.ctx)
+	if err != nil {
+		return nil, err
+	}
+	reviews, err := sc.store.Reviews().List(sc.ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := &recommender{reviewers: make(map[int]map[int]bool)}
+	for _, p := range bindProducts(sc, withoutDeleted(products, false)) {
+		if p.Status == ProductStatusActive {
+			r.candidates = append(r.candidates, p)
+		}
+	}
+	sort.Slice(r.candidates, func(i, j int) bool { return r.candidates[i].ID < r.candidates[j].ID })
+	for _, rev := range reviews {
+		if rev.countsTowardRating() {
+			if r.reviewers[rev.ProductID] == nil {
+				r.reviewers[rev.ProductID] = make(map[int]bool)
+			}
+			r.reviewers[rev.ProductID][rev.UserID] = true
+		}
+	}
+	return r, nil
+}
+
+// score rates candidate as a recommendation next to seed.
+func (r *recommender) score(seed, candidate Product) (float64, []string) {
+	var score float64
+	reasons := []string{}
+	if seed.CategoryID == candidate.CategoryID {
+		score += sharedCategoryWeight
+		reasons = append(reasons, "same category")
+	}
+	coReviewers := 0
+	for user := range r.reviewers[seed.ID] {
+		if r.reviewers[candidate.ID][user] {
+			coReviewers++
+		}
+	}
+	if coReviewers > 0 {
+		score += coReviewerWeight * float64(coReviewers)
+		reasons = append(reasons, fmt.Sprintf("reviewed by %d of the same users", coReviewers))
+	}
+	low, high := seed.Price.Amount, candidate.Price.Amount
+	if low > high {
+		low, high = high, low
+	}
+	if low > 0 {
+		proximity := float64(low) / float64(high)
+		score += priceProximityWeight * proximity
+		if proximity >= 0.75 {
+			reasons = append(reasons, "similar price")
+		}
+	}
+	return roundScore(score), reasons
+}
+
+// related ranks the candidates other than seed.
+func (r *recommender) related(seed Product, first int) []ProductRecommendation {
+	var result []ProductRecommendation
+	for _, c := range r.candidates {
+		if c.ID == seed.ID {
+			continue
+		}
+		score, reasons := r.score(seed, c)
+		result = append(result, ProductRecommendation{Product: c, Score: score, Reasons: reasons})
+	}
+	return topRecommendations(result, first)
+}
+
+// reviewedProduct is a product a user reviewed and their rating of it.
+type reviewedProduct struct {
+	product Product
+	rating  int
+}
+
+// forReviews ranks the candidates that were not reviewed by how closely they
+// relate to the reviewed products, which are in product ID order. Each
+// reviewed product counts with its rating less 3, so products like the ones
+// the user disliked sink.
+func (r *recommender) forReviews(reviewed []reviewedProduct, first int) []ProductRecommendation {
+	skip := make(map[int]bool, len(reviewed))
+	for _, seed := range reviewed {
+		skip[seed.product.ID] = true
+	}
+	var result []ProductRecommendation
+	for _, c := range r.candidates {
+		if skip[c.ID] {
+			continue
+		}
+		type contribution struct {
+			name  string
+			score float64
+		}
+		var total float64
+		var liked []contribution
+		for _, seed := range reviewed {
+			score, _ := r.score(seed.product, c)
+			weighted := float64(seed.rating-3) * score
+			total += weighted
+			if weighted > 0 {
+				liked = append(liked, contribution{seed.product.Name, weighted})
+			}
+		}
+		if total = roundScore(total); total <= 0 {
+			continue
+		}
+		sort.Slice(liked, func(i, j int) bool {
+			if liked[i].score != liked[j].score {
+				return liked[i].score > liked[j].score
+			}
+			return liked[i].name < liked[j].name
+		})
+		reasons := make([]string, len(liked))
+		for i, l := range liked {
+			reasons[i] = "similar to " + l.name
+		}
+		result = append(result, ProductRecommendation{Product: c, Score: total, Reasons: reasons})
+	}
+	return topRecommendations(result, first)
+}
+
+// topRecommendations returns the first recommendations by descending score,
+// then by product ID.
+func topRecommendations(recs []ProductRecommendation, first int) []ProductRecommendation {
+	sort.SliceStable(recs, func(i, j int) bool {
+		if recs[i].Score != recs[j].Score {
+			return recs[i].Score > recs[j].Score
+		}
+		return recs[i].Product.ID < recs[j].Product.ID
+	})
+	if len(recs) > first {
+		recs = recs[:first]
+	}
+	if recs == nil {
+		recs = []ProductRecommendation{}
+	}
+	return recs
+}
+
+func roundScore(score float64) float64 {
+	return math.Round(score*1000) / 1000
+}
+
+// recommendationCount checks the first argument of the recommendation
+// fields; RecommendedProducts defaults it to 5.
+func recommendationCount(first *int) (int, error) {
+	if first == nil {
+		return defaultRecommendations, nil
+	}
+	if *first < 1 || *first > maxRecommendations {
+		return 0, fmt.Errorf("first must be between 1 and %d", maxRecommendations)
+	}
+	return *first, nil
+}
+
+// RecommendedProducts recommends ACTIVE products to the authenticated user
+// based on the products they reviewed, leaving those out. Users without
+// reviews get no recommendations.
+func (h *ProductHandlers) RecommendedProducts(ctx context.Context, first *int) ([]ProductRecommendation, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	n, err := recommendationCount(first)
+	if err != nil {
+		return nil, err
+	}
+	reviews, err := h.store.Reviews().ListByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	var reviewed []reviewedProduct
+	for _, rev := range withoutDeleted(reviews, false) {
+		product, err := h.store.Products().Get(ctx, rev.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		reviewed = append(reviewed, reviewedProduct{product, rev.Rating})
+	}
+	sort.Slice(reviewed, func(i, j int) bool { return reviewed[i].product.ID < reviewed[j].product.ID })
+	r, _, err := newScope(ctx, h.store).loaders.recommender.load(struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return r.forReviews(reviewed, n), nil
+}
+
+// Related returns the ACTIVE products most related to this one.
+func (p *Product) Related(args RelatedArgs) ([]ProductRecommendation, error) {
+	n, err := recommendationCount(&args.First)
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := p.scope.loaders.recommender.load(struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return r.related(*p, n), nil
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestRecommendations compares the rankings with testdata/recommendations.golden.
+// Run go test ./handlers -run TestRecommendations -update after a deliberate
+// change of the scoring.
+func TestRecommendations(t *testing.T) {
+	golden := filepath.Join("testdata", "recommendations.golden")
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		admin, john, jane := userContext(t, store, 1), userContext(t, store, 2), userContext(t, store, 3)
+		run := func(ctx context.Context, request string) string {
+			res, _ := graph.ProcessRequest(ctx, request, "")
+			return res
+		}
+
+		// A second book and a draft to rank, and more reviews to share
+		for _, setup := range []struct {
+			ctx     context.Context
+			request string
+		}{
+			{admin, `mutation { CreateProduct(input: {name: "Rust Book", description: "Ownership explained", price: "44.99 USD", categoryId: 2, stock: 3}) { ID } }`},
+			{admin, `mutation { UpdateProductStatus(id: 5, status: "ACTIVE", version: 1) { ID } }`},
+			{admin, `mutation { CreateProduct(input: {name: "Tablet", description: "Not out yet", price: "649.99 USD", categoryId: 1}) { ID } }`},
+			{jane, `mutation { AddProductReview(productId: 2, review: {rating: 2, comment: "Too basic"}) { ID } }`},
+			{jane, `mutation { AddProductReview(productId: 5, review: {rating: 5, comment: "Thorough"}) { ID } }`},
+			{john, `mutation { AddProductReview(productId: 4, review: {rating: 4, comment: "Solid phone"}) { ID } }`},
+			{admin, `mutation { ApproveReview(id: 4) { ID } }`},
+			{admin, `mutation { ApproveReview(id: 5) { ID } }`},
+			{admin, `mutation { ApproveReview(id: 6) { ID } }`},
+		} {
+			if res := run(setup.ctx, setup.request); strings.Contains(res, `"errors"`) {
+				t.Fatalf("%s failed: %s", setup.request, res)
+			}
+		}
+
+		var got bytes.Buffer
+		for _, tc := range []struct {
+			ctx     context.Context
+			request string
+		}{
+			{john, `{ GetProduct(id: 1) { related(first: 10) { product { ID name } score reasons } } }`},
+			{john, `{ GetProduct(id: 2) { related(first: 2) { product { ID name } score reasons } } }`},
+			{john, `{ GetProduct(id: 3) { related(first: 10) { product { ID name } score reasons } } }`},
+			{john, `{ RecommendedProducts { product { ID name } score reasons } }`},
+			{jane, `{ RecommendedProducts(first: 10) { product { ID name } score reasons } }`},
+			{admin, `{ RecommendedProducts { product { ID name } score reasons } }`},
+		} {
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, []byte(run(tc.ctx, tc.request)), "", "  "); err != nil {
+				t.Fatalf("%s: invalid response: %v", tc.request, err)
+			}
+			got.WriteString("# " + tc.request + "\n" + indented.String() + "\n\n")
+		}
+
+		if *updateGolden {
+			if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+				t.Fatalf("writing %s failed: %v", golden, err)
+			}
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", golden, err)
+		}
+		if got.String() != string(want) {
+			t.Errorf("the recommendations differ from %s; got\n%s", golden, got.String())
+		}
+	})
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	graph := newTestGraph(ctx, store)
+	user, err := store.Users().Get(ctx, 2)
+	if err != nil {
+		t.Fatalf("Get user failed: %v", err)
+	}
+	userCtx := context.WithValue(ctx, UserContextKey, &user)
+	for _, tc := range []struct {
+		ctx           context.Context
+		request, want string
+	}{
+		{userCtx, `{ GetProduct(id: 1) { related(first: 0) { score } } }`, "first must be between 1 and 50"},
+		{userCtx, `{ RecommendedProducts(first: 51) { score } }`, "first must be between 1 and 50"},
+		{ctx, `{ RecommendedProducts { score } }`, "authentication required"},
+	} {
+		if res, _ := graph.ProcessRequest(tc.ctx, tc.request, ""); !strings.Contains(res, tc.want) {
+			t.Errorf("%s: expected %s, got %s", tc.request, tc.want, res)
+		}
+	}
+}
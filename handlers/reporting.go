@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gburgyan/go-quickgraph"
+)
+
+// Reporting lines are stored as the ManagerID of each employee; everything
+// else (reports, team sizes, chains of command) is derived from them. Only
+// managers can have reports, and AssignManager and ReassignReports refuse
+// changes that would make anyone their own manager.
+
+// Reports returns the direct reports of the manager that are not terminated.
+func (m *Manager) Reports(args PageParam) (*EmployeeConnection, error) {
+	reports, _, err := m.scope.loaders.reports.load(m.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newEmployeeConnection(withoutDeleted(reports, false), args.Page)
+}
+
+// AllReports returns everyone below the manager that is not terminated,
+// closest first. The reports of terminated employees are still included.
+func (m *Manager) AllReports(args PageParam) (*EmployeeConnection, error) {
+	all, err := m.allReports()
+	if err != nil {
+		return nil, err
+	}
+	return newEmployeeConnection(withoutDeleted(all, false), args.Page)
+}
+
+// TeamSize returns the number of direct reports that are not terminated.
+func (m *Manager) TeamSize() (int, error) {
+	reports, _, err := m.scope.loaders.reports.load(m.ID)
+	if err != nil {
+		return 0, err
+	}
+	return len(withoutDeleted(reports, false)), nil
+}
+
+// allReports returns everyone below m, terminated employees included, level
+// by level. Each level is fetched with one batch.
+func (m *Manager) allReports() ([]*Employee, error) {
+	var result []*Employee
+	seen := map[int]bool{m.ID: true}
+	for level := []int{m.ID}; len(level) > 0; {
+		m.scope.loaders.reports.queue(level...)
+		var next []int
+		for _, id := range level {
+			reports, _, err := m.scope.loaders.reports.load(id)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range reports {
+				if !seen[e.ID] {
+					seen[e.ID] = true
+					result = append(result, e)
+					next = append(next, e.ID)
+				}
+			}
+		}
+		level = next
+	}
+	return result, nil
+}
+
+// Manager returns the manager the employee reports to, or null at the top
+// of the organization. Terminated managers are only visible to admins.
+func (e *Employee) Manager() (*Employee, error) {
+	if e.ManagerID == nil {
+		return nil, nil
+	}
+	mgr, ok, err := e.scope.loaders.employees.load(*e.ManagerID)
+	if err != nil || !ok || (mgr.isDeleted() && !canSeeDeleted(e.scope.ctx)) {
+		return nil, err
+	}
+	return mgr, nil
+}
+
+// ChainOfCommand returns the managers above the employee, from the direct
+// manager up to the top of the organization. Terminated managers are only
+// listed for admins.
+func (e *Employee) ChainOfCommand() ([]*Employee, error) {
+	chain := []*Employee{}
+	seen := map[int]bool{e.ID: true}
+	for next := e.ManagerID; next != nil && !seen[*next]; {
+		seen[*next] = true
+		mgr, ok, err := e.scope.loaders.employees.load(*next)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		if !mgr.isDeleted() || canSeeDeleted(e.scope.ctx) {
+			chain = append(chain, mgr)
+		}
+		next = mgr.ManagerID
+	}
+	return chain, nil
+}
+
+// ReportingCycleError is returned by EmployeeRepository.AssignManager and
+// ReassignReports when the change would make someone report to themselves.
+type ReportingCycleError struct {
+	EmployeeID int  // The employee, or the manager whose reports move
+	ManagerID  int  // The new manager
+	Reports    bool // Set when the reports of EmployeeID move
+}
+
+func (e *ReportingCycleError) Error() string {
+	if e.Reports {
+		return fmt.Sprintf("manager %d reports to %d: moving the reports would create a reporting cycle", e.ManagerID, e.EmployeeID)
+	}
+	return fmt.Sprintf("employee %d cannot report to %d: that would create a reporting cycle", e.EmployeeID, e.ManagerID)
+}
+
+// AssignManager makes managerId the manager of employeeId, or leaves the
+// employee without a manager when managerId is omitted. The manager must be
+// an active Manager that does not report to the employee already. version
+// must be the employee version the client last read. Only admins can change
+// reporting lines.
+func (h *EmployeeHandlers) AssignManager(ctx context.Context, employeeId int, managerId *int, version int) (*Employee, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	emp, err := h.store.Employees().AssignManager(ctx, employeeId, managerId, version)
+	if err != nil {
+		return nil, err
+	}
+	emp.scope = newScope(ctx, h.store)
+
+	h.store.Events().BroadcastEmployeeUpdate(ctx, emp, "updated")
+
+	return emp, nil
+}
+
+// ReassignReports moves every direct report of fromManagerId, terminated
+// ones included, to toManagerId, or leaves them without a manager when
+// toManagerId is omitted. The old manager may already be terminated; that
+// is when its reports usually need a new home. It returns the moved
+// employees. Only admins can change reporting lines.
+func (h *EmployeeHandlers) ReassignReports(ctx context.Context, fromManagerId int, toManagerId *int) ([]*Employee, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	moved, err := h.store.Employees().ReassignReports(ctx, fromManagerId, toManagerId)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range bindEmployees(newScope(ctx, h.store), moved) {
+		h.store.Events().BroadcastEmployeeUpdate(ctx, e, "updated")
+	}
+	return moved, nil
+}
+
+// checkManagerAssignment is the check EmployeeRepository.AssignManager
+// makes against all employees before making managerID the manager of id.
+func checkManagerAssignment(employees []*Employee, id int, managerID *int) error {
+	byID := indexEmployees(employees)
+	if e := byID[id]; e == nil || e.isDeleted() {
+		return fmt.Errorf("employee with id %d not found", id)
+	}
+	if managerID == nil {
+		return nil
+	}
+	if err := checkActiveManager(byID, *managerID); err != nil {
+		return err
+	}
+	if *managerID == id || reportsTo(byID, *managerID, id) {
+		return &ReportingCycleError{EmployeeID: id, ManagerID: *managerID}
+	}
+	return nil
+}
+
+// checkReportsReassignment is the check EmployeeRepository.ReassignReports
+// makes against all employees before moving the reports of fromID to toID.
+func checkReportsReassignment(employees []*Employee, fromID int, toID *int) error {
+	byID := indexEmployees(employees)
+	if from := byID[fromID]; from == nil {
+		return fmt.Errorf("manager with id %d not found", fromID)
+	} else if _, ok := quickgraph.Discover[*Manager](from); !ok {
+		return fmt.Errorf("manager with id %d not found", fromID)
+	}
+	if toID == nil {
+		return nil
+	}
+	if *toID == fromID {
+		return fmt.Errorf("the reports of manager %d cannot be reassigned to the same manager", fromID)
+	}
+	if err := checkActiveManager(byID, *toID); err != nil {
+		return err
+	}
+	if reportsTo(byID, *toID, fromID) {
+		return &ReportingCycleError{EmployeeID: fromID, ManagerID: *toID, Reports: true}
+	}
+	return nil
+}
+
+// checkActiveManager fails unless employee id is a Manager that is not
+// terminated.
+func checkActiveManager(byID map[int]*Employee, id int) error {
+	if emp := byID[id]; emp != nil {
+		if mgr, ok := quickgraph.Discover[*Manager](emp); ok && !mgr.isDeleted() {
+			return nil
+		}
+	}
+	return fmt.Errorf("manager with id %d not found", id)
+}
+
+// activeManager returns the manager with the given ID, which must be a
+// Manager that is not terminated.
+func (h *EmployeeHandlers) activeManager(ctx context.Context, id int) (*Manager, error) {
+	emp, err := h.store.Employees().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("manager with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	mgr, ok := quickgraph.Discover[*Manager](emp)
+	if !ok || mgr.isDeleted() {
+		return nil, fmt.Errorf("manager with id %d not found", id)
+	}
+	return mgr, nil
+}
+
+// indexEmployees returns employees by ID.
+func indexEmployees(employees []*Employee) map[int]*Employee {
+	byID := make(map[int]*Employee, len(employees))
+	for _, e := range employees {
+		byID[e.ID] = e
+	}
+	return byID
+}
+
+// reportsTo reports whether managerID is in the chain of command of
+// employeeID.
+func reportsTo(byID map[int]*Employee, employeeID, managerID int) bool {
+	seen := make(map[int]bool)
+	for e := byID[employeeID]; e != nil && e.ManagerID != nil && !seen[e.ID]; e = byID[*e.ManagerID] {
+		if *e.ManagerID == managerID {
+			return true
+		}
+		seen[e.ID] = true
+	}
+	return false
+}
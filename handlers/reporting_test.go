@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReportingLines(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		admin, john := userContext(t, store, 1), userContext(t, store, 2)
+
+		for _, tc := range []struct {
+			ctx           context.Context
+			request, want string
+		}{
+			// Jane manages John and Bob in the sample data
+			{john, `{ GetEmployee(id: 2) { ... on Manager { TeamSize } } }`, `"TeamSize":2`},
+			{john, `{ GetEmployee(id: 2) { ... on Manager { Reports(page: {}) { edges { node { Name } } } } } }`,
+				`"edges":[{"node":{"Name":"John Doe"}},{"node":{"Name":"Bob Wilson"}}]`},
+			{john, `{ GetEmployee(id: 1) { managerId Manager { Name } } }`, `"Manager":{"Name":"Jane Smith"},"managerId":2`},
+
+			// Carol becomes Jane's manager
+			{admin, `mutation { CreateEmployee(input: {name: "Carol Chief", email: "carol@example.com", salary: 180000, type: MANAGER, department: "Platform", programmingLanguages: []}) { ... on Manager { ID TeamSize } } }`,
+				`"ID":4,"TeamSize":0`},
+			{john, `mutation { AssignManager(employeeId: 2, managerId: 4, version: 1) { managerId } }`, "admin role required"},
+			{admin, `mutation { AssignManager(employeeId: 2, managerId: 4, version: 1) { managerId } }`, `"managerId":4`},
+			{john, `{ GetEmployee(id: 1) { ChainOfCommand { Name } } }`, `"ChainOfCommand":[{"Name":"Jane Smith"},{"Name":"Carol Chief"}]`},
+			{john, `{ GetEmployee(id: 4) { ChainOfCommand { Name } ... on Manager { TeamSize AllReports(page: {}) { edges { node { Name } } } } } }`,
+				`"AllReports":{"edges":[{"node":{"Name":"Jane Smith"}},{"node":{"Name":"John Doe"}},{"node":{"Name":"Bob Wilson"}}]},"ChainOfCommand":[],"TeamSize":1`},
+
+			// Nobody can end up managing themselves
+			{admin, `mutation { AssignManager(employeeId: 4, managerId: 2, version: 1) { ID } }`,
+				"employee 4 cannot report to 2: that would create a reporting cycle"},
+			{admin, `mutation { AssignManager(employeeId: 4, managerId: 4, version: 1) { ID } }`,
+				"employee 4 cannot report to 4: that would create a reporting cycle"},
+			{admin, `mutation { ReassignReports(fromManagerId: 4, toManagerId: 2) { ID } }`,
+				"manager 2 reports to 4: moving the reports would create a reporting cycle"},
+			{admin, `mutation { AssignManager(employeeId: 3, managerId: 1, version: 1) { ID } }`, "manager with id 1 not found"},
+			{admin, `mutation { AssignManager(employeeId: 2, managerId: 4, version: 1) { ID } }`, "modified concurrently"},
+
+			// Jane's team moves to Carol
+			{admin, `mutation { ReassignReports(fromManagerId: 2, toManagerId: 4) { Name managerId } }`,
+				`"ReassignReports":[{"Name":"John Doe","managerId":4},{"Name":"Bob Wilson","managerId":4}]`},
+			{john, `{ GetEmployee(id: 4) { ... on Manager { TeamSize } } }`, `"TeamSize":3`},
+			{john, `{ GetEmployee(id: 2) { ... on Manager { TeamSize } } }`, `"TeamSize":0`},
+
+			// Terminated managers are hidden from non-admins
+			{admin, `mutation { TerminateEmployee(employeeId: 4) { ID } }`, `"ID":4`},
+			{john, `{ GetEmployee(id: 2) { Manager { Name } ChainOfCommand { Name } } }`, `"ChainOfCommand":[],"Manager":null`},
+			{admin, `{ GetEmployee(id: 2) { Manager { Name } } }`, `"Manager":{"Name":"Carol Chief"}`},
+			{admin, `mutation { AssignManager(employeeId: 1, managerId: 4, version: 2) { ID } }`, "manager with id 4 not found"},
+			{admin, `mutation { ReassignReports(fromManagerId: 4) { Name managerId } }`,
+				`"ReassignReports":[{"Name":"John Doe","managerId":null},{"Name":"Jane Smith","managerId":null},{"Name":"Bob Wilson","managerId":null}]`},
+		} {
+			if res, _ := graph.ProcessRequest(tc.ctx, tc.request, ""); !strings.Contains(res, tc.want) {
+				t.Errorf("%s: expected %s, got %s", tc.request, tc.want, res)
+			}
+		}
+	})
+}
+
+func TestConcurrentReportingChangesFormNoCycle(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		h, admin := NewEmployeeHandlers(store), userContext(t, store, 1)
+		carol, err := store.Employees().Create(ctx, &NewManager(0, "Carol Chief", "carol@example.com", 180000, "2020-01-01", "Platform").Employee)
+		if err != nil {
+			t.Fatalf("Create employee failed: %v", err)
+		}
+
+		// Jane and Carol are each made the other's manager at once
+		var wg sync.WaitGroup
+		var assigned atomic.Int32
+		for _, assignment := range [][2]int{{2, carol.ID}, {carol.ID, 2}} {
+			wg.Add(1)
+			go func(id, managerID int) {
+				defer wg.Done()
+				var cycle *ReportingCycleError
+				if _, err := h.AssignManager(admin, id, &managerID, 1); err == nil {
+					assigned.Add(1)
+				} else if !errors.As(err, &cycle) {
+					t.Errorf("AssignManager(%d, %d) failed: %v", id, managerID, err)
+				}
+			}(assignment[0], assignment[1])
+		}
+		wg.Wait()
+		employees, err := store.Employees().List(ctx)
+		if err != nil {
+			t.Fatalf("List employees failed: %v", err)
+		}
+		if byID := indexEmployees(employees); assigned.Load() != 1 || reportsTo(byID, 2, 2) || reportsTo(byID, carol.ID, carol.ID) {
+			t.Errorf("expected exactly one assignment, got %d", assigned.Load())
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Reviews belong to the user who wrote them; a user can have one review per
+// product. New and edited reviews wait in the moderation queue until an
+// admin approves them. Until then only their author and admins can see them,
+// and they do not count toward a product's rating.
+
+type ReviewStatus string
+
+const (
+	ReviewStatusPending  ReviewStatus = "PENDING"
+	ReviewStatusApproved ReviewStatus = "APPROVED"
+	ReviewStatusRejected ReviewStatus = "REJECTED"
+)
+
+// EnumValues implements the StringEnumValues interface for schema generation
+func (ReviewStatus) EnumValues() []string {
+	return []string{"PENDING", "APPROVED", "REJECTED"}
+}
+
+func (r Review) isApproved() bool { return r.Status == ReviewStatusApproved }
+
+// approvedReviews returns the approved reviews of reviews.
+func approvedReviews(reviews []Review) []Review {
+	result := reviews[:0:0]
+	for _, r := range reviews {
+		if r.isApproved() {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// liveReview returns the review with id unless it does not exist or is
+// deleted.
+func (h *ProductHandlers) liveReview(ctx context.Context, id int) (Review, error) {
+	review, err := h.store.Reviews().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && review.isDeleted()) {
+		return Review{}, fmt.Errorf("review with id %d not found", id)
+	}
+	return review, err
+}
+
+// DuplicateReviewError is returned by ReviewRepository.Create and Update
+// when the user already has another review of the product that is not
+// deleted.
+type DuplicateReviewError struct {
+	ProductID int
+	UserID    int
+	ReviewID  int // The review the user already has
+}
+
+func (e *DuplicateReviewError) Error() string {
+	return fmt.Sprintf("user %d already reviewed product %d in review %d", e.UserID, e.ProductID, e.ReviewID)
+}
+
+// EditReview changes the rating and comment of a review. Only its author can
+// edit it, and the edited review goes back to the moderation queue.
+func (h *ProductHandlers) EditReview(ctx context.Context, id int, review ReviewInput) (*Review, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if review.Rating < 1 || review.Rating > 5 {
+		return nil, errors.New("rating must be between 1 and 5")
+	}
+	r, err := h.liveReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if r.UserID != user.ID {
+		return nil, errors.New("only the author can edit a review")
+	}
+
+	now := time.Now().UTC()
+	r.Rating, r.Comment, r.UpdatedAt = review.Rating, review.Comment, &now
+	r.Status, r.ModeratedAt, r.ModeratedBy, r.ModerationNote = ReviewStatusPending, nil, nil, nil
+	r, err = h.store.Reviews().Update(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	r.scope = newScope(ctx, h.store)
+	return &r, nil
+}
+
+// PendingReviews returns a page of the reviews waiting for moderation,
+// oldest first. Requires the admin role.
+func (h *ProductHandlers) PendingReviews(ctx context.Context, first *int, after *string, last *int, before *string) (*ReviewConnection, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	reviews, err := h.store.Reviews().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Review
+	for _, r := range withoutDeleted(reviews, false) {
+		if r.Status == ReviewStatusPending {
+			pending = append(pending, r)
+		}
+	}
+	return newReviewConnection(bindReviews(newScope(ctx, h.store), pending), pageArgs(first, after, last, before))
+}
+
+// ApproveReview publishes a review. Requires the admin role.
+func (h *ProductHandlers) ApproveReview(ctx context.Context, id int) (*Review, error) {
+	return h.moderateReview(ctx, id, ReviewStatusApproved, nil)
+}
+
+// RejectReview hides a review, optionally giving the author a reason.
+// Requires the admin role.
+func (h *ProductHandlers) RejectReview(ctx context.Context, id int, reason *string) (*Review, error) {
+	return h.moderateReview(ctx, id, ReviewStatusRejected, reason)
+}
+
+func (h *ProductHandlers) moderateReview(ctx context.Context, id int, status ReviewStatus, note *string) (*Review, error) {
+	admin, err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := h.liveReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status == status {
+		return nil, fmt.Errorf("review %d is already %s", id, status)
+	}
+
+	now := time.Now().UTC()
+	adminID := admin.ID
+	r.Status, r.ModeratedAt, r.ModeratedBy, r.ModerationNote = status, &now, &adminID, note
+	r, err = h.store.Reviews().Update(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	r.scope = newScope(ctx, h.store)
+	return &r, nil
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReviewModeration(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		adminCtx, customerCtx, otherCtx := userContext(t, store, 1), userContext(t, store, 2), userContext(t, store, 3)
+		run := func(ctx context.Context, request string) string {
+			res, _ := graph.ProcessRequest(ctx, request, "")
+			return res
+		}
+
+		if res := run(ctx, `mutation { AddProductReview(productId: 4, review: {rating: 1, comment: "Bad"}) { ID } }`); !strings.Contains(res, "authentication required") {
+			t.Errorf("expected anonymous reviews to be rejected, got %s", res)
+		}
+		// Review 4 by user 2
+		res := run(customerCtx, `mutation { AddProductReview(productId: 4, review: {rating: 1, comment: "Bad"}) { ID UserID Status } }`)
+		if !strings.Contains(res, `"ID":4,"Status":"PENDING","UserID":2`) {
+			t.Fatalf("expected a pending review by user 2, got %s", res)
+		}
+		for _, tc := range []struct {
+			ctx           context.Context
+			request, want string
+		}{
+			{customerCtx, `mutation { AddProductReview(productId: 4, review: {rating: 2, comment: "Again"}) { ID } }`,
+				"you already reviewed product 4 in review 4"},
+			{otherCtx, `mutation { EditReview(id: 4, review: {rating: 5, comment: "Mine now"}) { ID } }`,
+				"only the author can edit a review"},
+			{customerCtx, `{ PendingReviews { totalCount } }`, "admin role required"},
+			{adminCtx, `mutation { RejectReview(id: 1) { ID } }`, ""},
+			{adminCtx, `mutation { ApproveReview(id: 2) { ID } }`, "review 2 is already APPROVED"},
+		} {
+			if res := run(tc.ctx, tc.request); !strings.Contains(res, tc.want) || (tc.want == "" && strings.Contains(res, "errors")) {
+				t.Errorf("%s: expected %q, got %s", tc.request, tc.want, res)
+			}
+		}
+
+		// Pending and rejected reviews are hidden and do not count
+		res = run(ctx, `{ GetProduct(id: 4) { AverageRating Reviews(page: {}) { totalCount } } }`)
+		if !strings.Contains(res, `"AverageRating":null,"Reviews":{"totalCount":0}`) {
+			t.Errorf("expected the pending review to be hidden, got %s", res)
+		}
+		res = run(ctx, `{ GetProduct(id: 1) { AverageRating } }`)
+		if !strings.Contains(res, `"AverageRating":4`) {
+			t.Errorf("expected only the approved rating of 4 to count, got %s", res)
+		}
+		res = run(adminCtx, `{ PendingReviews { edges { node { ID Rating } } } }`)
+		if !strings.Contains(res, `"edges":[{"node":{"ID":4,"Rating":1}}]`) {
+			t.Errorf("expected review 4 in the moderation queue, got %s", res)
+		}
+		res = run(adminCtx, `mutation { ApproveReview(id: 4) { Status ModeratedBy } }`)
+		if !strings.Contains(res, `"ModeratedBy":1,"Status":"APPROVED"`) {
+			t.Errorf("expected the approval to be recorded, got %s", res)
+		}
+		if res := run(ctx, `{ GetProduct(id: 4) { AverageRating } }`); !strings.Contains(res, `"AverageRating":1`) {
+			t.Errorf("expected the approved review to count, got %s", res)
+		}
+
+		// Only the author and admins see the rejected review 1 of user 2
+		byAuthor := `{ GetProduct(id: 4) { Reviews(page: {}) { edges { node { User { Reviews(page: {}) { totalCount } } } } } } }`
+		for _, tc := range []struct {
+			ctx  context.Context
+			want int
+		}{{ctx, 2}, {otherCtx, 2}, {customerCtx, 3}, {adminCtx, 3}} {
+			if res := run(tc.ctx, byAuthor); !strings.Contains(res, fmt.Sprintf(`"totalCount":%d`, tc.want)) {
+				t.Errorf("expected %d reviews of user 2, got %s", tc.want, res)
+			}
+		}
+
+		// Editing sends the review back to the queue
+		res = run(customerCtx, `mutation { EditReview(id: 4, review: {rating: 3, comment: "Grew on me"}) { Status ModeratedBy } }`)
+		if !strings.Contains(res, `"ModeratedBy":null,"Status":"PENDING"`) {
+			t.Errorf("expected the edited review to wait for moderation, got %s", res)
+		}
+		res = run(adminCtx, `mutation { RejectReview(id: 4, reason: "Off topic") { Status ModerationNote } }`)
+		if !strings.Contains(res, `"ModerationNote":"Off topic","Status":"REJECTED"`) {
+			t.Errorf("expected the rejection reason to be recorded, got %s", res)
+		}
+
+		// Deleting the review allows a new one
+		run(customerCtx, `mutation { DeleteReview(id: 4) { ID } }`)
+		res = run(customerCtx, `mutation { AddProductReview(productId: 4, review: {rating: 4, comment: "Second try"}) { ID } }`)
+		if !strings.Contains(res, `"ID":5`) {
+			t.Errorf("expected a new review after deleting the old one, got %s", res)
+		}
+		if res := run(adminCtx, `mutation { RestoreReview(id: 4) { ID } }`); !strings.Contains(res, "user 2 has reviewed product 4 again in review 5") {
+			t.Errorf("expected restoring a second review to be rejected, got %s", res)
+		}
+	})
+}
+
+func TestConcurrentReviewsOfAProductKeepOne(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		h, customer := NewProductHandlers(store), userContext(t, store, 2)
+		var (
+			wg    sync.WaitGroup
+			added atomic.Int32
+		)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := h.AddProductReview(customer, 4, ReviewInput{Rating: 4, Comment: "Fast"})
+				if err == nil {
+					added.Add(1)
+				} else if !strings.Contains(err.Error(), "you already reviewed product 4") {
+					t.Errorf("AddProductReview failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if added.Load() != 1 {
+			t.Errorf("expected exactly one review to be added, got %d", added.Load())
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"time"
+)
+
+// SeedSampleData fills store with the demo data set used throughout the
+// README and SampleCommands.http. It expects an empty store so the seeded
+// entities receive the IDs the examples refer to.
+func SeedSampleData(ctx context.Context, store Store) error {
+	for _, c := range []Category{
+		{ID: 1, Name: "Electronics", Description: strPtr("Electronic devices and accessories")},
+		{ID: 2, Name: "Books", Description: strPtr("Physical and digital books")},
+		{ID: 3, Name: "Clothing", Description: nil},
+	} {
+		if _, err := store.Categories().Create(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	created := func(s string) *time.Time {
+		t, _ := time.Parse(time.RFC3339, s)
+		return &t
+	}
+	usd := func(dollars float64) Money {
+		return NewMoney(dollars, storeCurrency)
+	}
+	for _, p := range []Product{
+		{ID: 1, Name: "Laptop", Description: "High-performance laptop", Price: usd(999.99), Status: ProductStatusActive, CategoryID: 1, InStock: true, Stock: 25, CreatedAt: created("2023-11-02T09:00:00Z")},
+		{ID: 2, Name: "Go Programming Book", Description: "Learn Go in 30 days", Price: usd(39.99), Status: ProductStatusActive, CategoryID: 2, InStock: true, Stock: 100, CreatedAt: created("2023-12-05T09:00:00Z")},
+		{ID: 3, Name: "Vintage T-Shirt", Description: "Retro design", Price: usd(24.99), Status: ProductStatusOutOfStock, CategoryID: 3, InStock: false, Stock: 0, CreatedAt: created("2023-09-20T09:00:00Z")},
+		{ID: 4, Name: "Smartphone", Description: "Latest model", Price: usd(699.99), Status: ProductStatusActive, CategoryID: 1, InStock: true, Stock: 10, CreatedAt: created("2024-01-10T09:00:00Z")},
+	} {
+		if _, err := store.Products().Create(ctx, p); err != nil {
+			return err
+		}
+	}
+
+	for _, u := range []User{
+		{ID: 1, Username: "admin", Email: "admin@example.com", Role: UserRoleAdmin},
+		{ID: 2, Username: "john_customer", Email: "john@example.com", Role: UserRoleCustomer},
+		{ID: 3, Username: "jane_customer", Email: "jane@example.com", Role: UserRoleCustomer},
+	} {
+		if _, err := store.Users().Create(ctx, u); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range []Review{
+		{ID: 1, ProductID: 1, UserID: 2, Rating: 5, Comment: "Excellent laptop!", CreatedAt: "2024-01-15T10:00:00Z"},
+		{ID: 2, ProductID: 1, UserID: 3, Rating: 4, Comment: "Good value for money", CreatedAt: "2024-01-16T14:30:00Z"},
+		{ID: 3, ProductID: 2, UserID: 2, Rating: 5, Comment: "Great book for beginners", CreatedAt: "2024-01-17T09:15:00Z"},
+	} {
+		if _, err := store.Reviews().Create(ctx, r); err != nil {
+			return err
+		}
+	}
+
+	if _, err := store.Widgets().Create(ctx, Widget{ID: 1, Name: "Widget 1", Price: usd(1.00), Quantity: 10, ReorderThreshold: intPtr(3)}); err != nil {
+		return err
+	}
+
+	github := "johndoe"
+	john := NewDeveloper(1, "John Doe", "john@example.com", 120000, "2020-01-15", []string{"Go", "Python", "JavaScript"}, &github)
+	bob := NewDeveloper(3, "Bob Wilson", "bob@example.com", 110000, "2021-03-20", []string{"Go", "Rust"}, nil)
+	john.ManagerID, bob.ManagerID = intPtr(2), intPtr(2) // Both report to Jane
+	for _, emp := range []*Employee{
+		&john.Employee,
+		&NewManager(2, "Jane Smith", "jane@example.com", 150000, "2019-06-01", "Engineering").Employee,
+		&bob.Employee,
+	} {
+		if _, err := store.Employees().Create(ctx, emp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SeedSampleDataIfEmpty seeds store unless it already holds data, which is
+// the case for a persistent store on every start after the first.
+func SeedSampleDataIfEmpty(ctx context.Context, store Store) error {
+	empty, err := storeIsEmpty(ctx, store)
+	if err != nil || !empty {
+		return err
+	}
+	return SeedSampleData(ctx, store)
+}
+
+// storeIsEmpty reports whether store holds no entities at all.
+func storeIsEmpty(ctx context.Context, store Store) (bool, error) {
+	snap, err := store.Snapshot(ctx)
+	if err != nil {
+		return false, err
+	}
+	empty := len(snap.Categories) == 0 && len(snap.Products) == 0 && len(snap.Users) == 0 &&
+		len(snap.Reviews) == 0 && len(snap.Widgets) == 0 && len(snap.Employees) == 0
+	return empty, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/url"
 	"reflect"
 	"strconv"
@@ -32,6 +33,9 @@ type Money struct {
 
 // UnmarshalJSON implements custom JSON unmarshaling for Money
 // It supports both string format ("123.45 USD") and struct format ({"amount": 12345, "currency": "USD"})
+// A plain number is an amount in the store currency, which is how prices were
+// written before they became Money, so older snapshots, event logs and
+// fixtures still load.
 func (m *Money) UnmarshalJSON(data []byte) error {
 	// First try to unmarshal as a string (scalar format)
 	var str string
@@ -44,6 +48,12 @@ func (m *Money) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 
+	var amount float64
+	if err := json.Unmarshal(data, &amount); err == nil {
+		*m = NewMoney(amount, storeCurrency)
+		return nil
+	}
+
 	// If string parsing fails, try struct format
 	type moneyAlias Money
 	var alias moneyAlias
@@ -167,7 +177,7 @@ func RegisterScalarHandlers(ctx context.Context, graph *quickgraph.Graphy) error
 	if err := graph.RegisterScalar(ctx, quickgraph.ScalarDefinition{
 		Name:        "Money",
 		GoType:      reflect.TypeOf(Money{}),
-		Description: "Monetary amount with currency (e.g., '123.45 USD')",
+		Description: "Monetary amount with currency (e.g., '123.45 USD'); a plain number is an amount in USD",
 		Serialize: func(value interface{}) (interface{}, error) {
 			switch v := value.(type) {
 			case Money:
@@ -182,8 +192,17 @@ func RegisterScalarHandlers(ctx context.Context, graph *quickgraph.Graphy) error
 			}
 		},
 		ParseValue: func(value interface{}) (interface{}, error) {
-			if str, ok := value.(string); ok {
-				return ParseMoney(str)
+			// Numbers are still accepted for the inputs that took a plain
+			// price before they became Money
+			switch v := value.(type) {
+			case string:
+				return ParseMoney(v)
+			case float64:
+				return NewMoney(v, storeCurrency), nil
+			case int64:
+				return NewMoney(float64(v), storeCurrency), nil
+			case int:
+				return NewMoney(float64(v), storeCurrency), nil
 			}
 			return nil, fmt.Errorf("expected string for Money (e.g., '123.45 USD'), got %T", value)
 		},
@@ -363,8 +382,8 @@ func ParseMoney(s string) (Money, error) {
 		return Money{}, fmt.Errorf("invalid amount in money string '%s': %v", s, err)
 	}
 
-	// Convert to cents
-	cents := int64(amount * 100)
+	// Convert to cents, rounding away the float error in amounts like 0.29
+	cents := int64(math.Round(amount * 100))
 
 	// Validate currency (basic check for 3-letter codes)
 	if len(currency) != 3 {
@@ -377,21 +396,21 @@ func ParseMoney(s string) (Money, error) {
 // NewMoney creates a new Money value from dollars and currency
 func NewMoney(dollars float64, currency string) Money {
 	return Money{
-		Amount:   int64(dollars * 100),
+		Amount:   int64(math.Round(dollars * 100)),
 		Currency: strings.ToUpper(currency),
 	}
 }
 
 // Sample functions demonstrating custom scalar usage
 
-// GetEmployeeByID demonstrates EmployeeID scalar usage
-func GetEmployeeByIDScalar(id EmployeeID) (*Employee, error) {
+// GetEmployeeByIDScalar demonstrates EmployeeID scalar usage
+func (h *EmployeeHandlers) GetEmployeeByIDScalar(ctx context.Context, id EmployeeID) (*Employee, error) {
 	// Convert EmployeeID to int for lookup
 	idInt, err := strconv.Atoi(string(id))
 	if err != nil {
 		return nil, fmt.Errorf("invalid employee ID format: %v", err)
 	}
-	return GetEmployee(idInt)
+	return h.GetEmployee(ctx, idInt)
 }
 
 // GetCurrentDateTime demonstrates DateTime scalar usage
@@ -501,9 +520,9 @@ func ProcessJSONMetadata(metadata map[string]interface{}) map[string]interface{}
 }
 
 // RegisterScalarDemoHandlers registers additional demo functions that use custom scalars
-func RegisterScalarDemoHandlers(ctx context.Context, graph *quickgraph.Graphy) {
+func RegisterScalarDemoHandlers(ctx context.Context, graph *quickgraph.Graphy, store Store) {
 	// Query functions demonstrating scalar usage
-	graph.RegisterQuery(ctx, "getEmployeeByIDScalar", GetEmployeeByIDScalar, "id")
+	graph.RegisterQuery(ctx, "getEmployeeByIDScalar", NewEmployeeHandlers(store).GetEmployeeByIDScalar, "id")
 	graph.RegisterQuery(ctx, "getCurrentDateTime", GetCurrentDateTime)
 	graph.RegisterQuery(ctx, "getServerStartTime", GetServerStartTime)
 	graph.RegisterQuery(ctx, "validateEmail", ValidateEmail, "email")
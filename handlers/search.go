@@ -6,10 +6,21 @@ import (
 	"strings"
 )
 
+// SearchHandlers serves the cross-entity search from a Store.
+type SearchHandlers struct {
+	store Store
+}
+
+// NewSearchHandlers creates search handlers backed by store.
+func NewSearchHandlers(store Store) *SearchHandlers {
+	return &SearchHandlers{store: store}
+}
+
+func RegisterSearchHandlers(ctx context.Context, graphy *quickgraph.Graphy, store Store) {
+	h := NewSearchHandlers(store)
 
-func RegisterSearchHandlers(ctx context.Context, graphy *quickgraph.Graphy) {
 	// Register the search query
-	graphy.RegisterQuery(ctx, "Search", Search, "query")
+	graphy.RegisterQuery(ctx, "Search", h.Search, "query", "includeDeleted", "first", "after", "last", "before")
 }
 
 // SearchResultUnion explicitly defines the union type
@@ -20,56 +31,73 @@ type SearchResultUnion struct {
 }
 
 // Search demonstrates union types by returning different types based on search
-// Returns SearchResultUnion which explicitly defines possible types
-func Search(query string) ([]SearchResultUnion, error) {
+// Returns a page of SearchResultUnion, which explicitly defines possible types.
+// Deleted entities are skipped unless an admin passes includeDeleted.
+func (h *SearchHandlers) Search(ctx context.Context, query string, includeDeleted *bool, first *int, after *string, last *int, before *string) (*SearchResultConnection, error) {
+	results, err := h.search(ctx, query, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	return newSearchResultConnection(results, pageArgs(first, after, last, before))
+}
+
+// search returns all results Search pages through: the matching widgets,
+// then products, then employees.
+func (h *SearchHandlers) search(ctx context.Context, query string, includeDeleted *bool) ([]SearchResultUnion, error) {
+	withDeleted, err := showDeleted(ctx, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
 	query = strings.ToLower(query)
 	var results []SearchResultUnion
 
 	// Search widgets
-	widgetsMux.RLock()
-	for _, w := range widgets {
+	widgets, err := h.store.Widgets().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range bindWidgets(newScope(ctx, h.store), withoutDeleted(widgets, withDeleted)) {
 		if strings.Contains(strings.ToLower(w.Name), query) {
-			// Create a copy to avoid data races
 			widget := w
 			results = append(results, SearchResultUnion{Widget: &widget})
 		}
 	}
-	widgetsMux.RUnlock()
 
-	// Search products  
-	productsMux.RLock()
-	for _, p := range products {
+	// Search products
+	products, err := h.store.Products().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range bindProducts(newScope(ctx, h.store), withoutDeleted(products, withDeleted)) {
 		if strings.Contains(strings.ToLower(p.Name), query) ||
 			strings.Contains(strings.ToLower(p.Description), query) {
-			// Create a copy to avoid data races
 			product := p
 			results = append(results, SearchResultUnion{Product: &product})
 		}
 	}
-	productsMux.RUnlock()
 
 	// Search employees
-	employeeMux.RLock()
-	for _, emp := range employees {
-		switch e := emp.(type) {
+	employees, err := h.store.Employees().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, emp := range bindEmployees(newScope(ctx, h.store), withoutDeleted(employees, withDeleted)) {
+		switch e := emp.ActualType().(type) {
 		case *Developer:
 			if strings.Contains(strings.ToLower(e.Name), query) ||
 				strings.Contains(strings.ToLower(e.Email), query) {
 				// Return the base Employee type
-				employee := e.Employee
-				results = append(results, SearchResultUnion{Employee: &employee})
+				results = append(results, SearchResultUnion{Employee: emp})
 			}
 		case *Manager:
 			if strings.Contains(strings.ToLower(e.Name), query) ||
 				strings.Contains(strings.ToLower(e.Email), query) ||
 				strings.Contains(strings.ToLower(e.Department), query) {
 				// Return the base Employee type
-				employee := e.Employee
-				results = append(results, SearchResultUnion{Employee: &employee})
+				results = append(results, SearchResultUnion{Employee: emp})
 			}
 		}
 	}
-	employeeMux.RUnlock()
 
 	return results, nil
 }
@@ -87,29 +115,35 @@ func (Developer) IsSearchResult() {}
 func (Manager) IsSearchResult()   {}
 
 // SearchV2 using the interface approach
-func SearchV2(query string) ([]SearchResult, error) {
+func (h *SearchHandlers) SearchV2(ctx context.Context, query string) ([]SearchResult, error) {
 	query = strings.ToLower(query)
 	var results []SearchResult
 
 	// Search widgets
-	for _, w := range widgets {
+	widgets, err := h.store.Widgets().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range bindWidgets(newScope(ctx, h.store), withoutDeleted(widgets, false)) {
 		if strings.Contains(strings.ToLower(w.Name), query) {
-			widget := w
-			results = append(results, widget)
+			results = append(results, w)
 		}
 	}
 
 	// Search products
-	for _, p := range products {
+	products, err := h.store.Products().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range bindProducts(newScope(ctx, h.store), withoutDeleted(products, false)) {
 		if strings.Contains(strings.ToLower(p.Name), query) ||
 			strings.Contains(strings.ToLower(p.Description), query) {
-			product := p
-			results = append(results, product)
+			results = append(results, p)
 		}
 	}
 
-	// Note: This approach works but the first approach with []interface{} 
+	// Note: This approach works but the first approach with []interface{}
 	// is more flexible and idiomatic for go-quickgraph
 
 	return results, nil
-}
\ No newline at end of file
+}
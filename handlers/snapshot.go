@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gburgyan/go-quickgraph"
+)
+
+// snapshotFormatVersion is bumped whenever the Snapshot layout changes in a
+// way older readers cannot handle. Version 2 writes prices as Money; version
+// 1 snapshots, with plain number prices, can still be restored.
+const snapshotFormatVersion = 2
+
+// SnapshotFileName is the name of the snapshot file inside a data directory.
+const SnapshotFileName = "snapshot.json"
+
+// Snapshot is a point-in-time copy of everything held by a Store.
+type Snapshot struct {
+	FormatVersion int                   `json:"formatVersion"`
+	TakenAt       time.Time             `json:"takenAt"`
+	Categories    []Category            `json:"categories"`
+	Products      []Product             `json:"products"`
+	Variants      []ProductVariant      `json:"productVariants,omitempty"`
+	Users         []User                `json:"users"`
+	Reviews       []Review              `json:"reviews"`
+	Widgets       []Widget              `json:"widgets"`
+	Employees     []EmployeeRecord      `json:"employees"`
+	StockLedger   []StockEntry          `json:"stockLedger,omitempty"`
+	StatusHistory []ProductStatusChange `json:"productStatusHistory,omitempty"`
+	PriceHistory  []ProductPriceChange  `json:"productPriceHistory,omitempty"`
+	RoleHistory   []EmployeeRoleChange  `json:"employeeRoleHistory,omitempty"`
+	Orders        []Order               `json:"orders,omitempty"`
+	Carts         []Cart                `json:"carts,omitempty"`
+}
+
+// EmployeeRecord is the flat, serializable form of a Developer or Manager.
+// The Type field selects which of the type-specific fields apply.
+type EmployeeRecord struct {
+	Type                 EmployeeType `json:"type"`
+	ID                   int          `json:"id"`
+	Name                 string       `json:"name"`
+	Email                string       `json:"email"`
+	Salary               float64      `json:"salary"`
+	HireDate             string       `json:"hireDate"`
+	Version              int          `json:"version,omitempty"`
+	DeletedAt            *time.Time   `json:"deletedAt,omitempty"`
+	DeletedBy            *int         `json:"deletedBy,omitempty"`
+	ManagerID            *int         `json:"managerId,omitempty"`
+	ProgrammingLanguages []string     `json:"programmingLanguages,omitempty"`
+	GithubUsername       *string      `json:"githubUsername,omitempty"`
+	Department           string       `json:"department,omitempty"`
+}
+
+// newEmployeeRecord flattens employee into its serializable form.
+func newEmployeeRecord(employee *Employee) (EmployeeRecord, error) {
+	switch e := employee.ActualType().(type) {
+	case *Developer:
+		return EmployeeRecord{
+			Type: EmployeeTypeDeveloper, ID: e.ID, Name: e.Name, Email: e.Email, Salary: e.Salary, HireDate: e.HireDate, Version: e.Version,
+			DeletedAt: e.DeletedAt, DeletedBy: e.DeletedBy, ManagerID: e.ManagerID, ProgrammingLanguages: e.ProgrammingLanguages, GithubUsername: e.GithubUsername,
+		}, nil
+	case *Manager:
+		return EmployeeRecord{
+			Type: EmployeeTypeManager, ID: e.ID, Name: e.Name, Email: e.Email, Salary: e.Salary, HireDate: e.HireDate, Version: e.Version,
+			DeletedAt: e.DeletedAt, DeletedBy: e.DeletedBy, ManagerID: e.ManagerID, Department: e.Department,
+		}, nil
+	default:
+		return EmployeeRecord{}, fmt.Errorf("employee %d must be a Developer or a Manager", employee.ID)
+	}
+}
+
+// Employee rebuilds the Developer or Manager described by the record.
+func (r EmployeeRecord) Employee() (*Employee, error) {
+	var emp *Employee
+	switch r.Type {
+	case EmployeeTypeDeveloper:
+		emp = &NewDeveloper(r.ID, r.Name, r.Email, r.Salary, r.HireDate, r.ProgrammingLanguages, r.GithubUsername).Employee
+	case EmployeeTypeManager:
+		emp = &NewManager(r.ID, r.Name, r.Email, r.Salary, r.HireDate, r.Department).Employee
+	default:
+		return nil, fmt.Errorf("employee %d has unknown type %q", r.ID, r.Type)
+	}
+	emp.Version, emp.ManagerID = r.Version, r.ManagerID
+	emp.DeletedAt, emp.DeletedBy = r.DeletedAt, r.DeletedBy
+	return emp, nil
+}
+
+// snapshotFrom assembles a Snapshot from the repositories of store. Store
+// implementations call it while holding whatever lock or transaction makes
+// the reads consistent.
+func snapshotFrom(ctx context.Context, store repositories) (*Snapshot, error) {
+	snap := &Snapshot{FormatVersion: snapshotFormatVersion, TakenAt: time.Now().UTC()}
+	var err error
+	if snap.Categories, err = store.Categories().List(ctx); err != nil {
+		return nil, err
+	}
+	if snap.Products, err = store.Products().List(ctx); err != nil {
+		return nil, err
+	}
+	if snap.Variants, err = store.ProductVariants().List(ctx); err != nil {
+		return nil, err
+	}
+	if snap.Users, err = store.Users().List(ctx); err != nil {
+		return nil, err
+	}
+	if snap.Reviews, err = store.Reviews().List(ctx); err != nil {
+		return nil, err
+	}
+	if snap.Widgets, err = store.Widgets().List(ctx); err != nil {
+		return nil, err
+	}
+	employees, err := store.Employees().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, emp := range employees {
+		record, err := newEmployeeRecord(emp)
+		if err != nil {
+			return nil, err
+		}
+		snap.Employees = append(snap.Employees, record)
+	}
+	if snap.StockLedger, err = store.StockLedger().List(ctx); err != nil {
+		return nil, err
+	}
+	if snap.StatusHistory, err = store.ProductStatusHistory().List(ctx); err != nil {
+		return nil, err
+	}
+	if snap.PriceHistory, err = store.ProductPriceHistory().List(ctx); err != nil {
+		return nil, err
+	}
+	if snap.RoleHistory, err = store.EmployeeRoleHistory().List(ctx); err != nil {
+		return nil, err
+	}
+	if snap.Orders, err = store.Orders().List(ctx); err != nil {
+		return nil, err
+	}
+	if snap.Carts, err = store.Carts().List(ctx); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// restoreInto creates every entity of snap in store, parents before
+// children. The store must be empty.
+func restoreInto(ctx context.Context, store repositories, snap *Snapshot) error {
+	if snap.FormatVersion < 1 || snap.FormatVersion > snapshotFormatVersion {
+		return fmt.Errorf("unsupported snapshot format version %d", snap.FormatVersion)
+	}
+	for _, c := range snap.Categories {
+		if _, err := store.Categories().Create(ctx, c); err != nil {
+			return fmt.Errorf("failed to restore category %d: %w", c.ID, err)
+		}
+	}
+	for _, p := range snap.Products {
+		if _, err := store.Products().Create(ctx, p); err != nil {
+			return fmt.Errorf("failed to restore product %d: %w", p.ID, err)
+		}
+	}
+	for _, v := range snap.Variants {
+		if _, err := store.ProductVariants().Create(ctx, v); err != nil {
+			return fmt.Errorf("failed to restore product variant %d: %w", v.ID, err)
+		}
+	}
+	for _, c := range snap.StatusHistory {
+		if _, err := store.ProductStatusHistory().Append(ctx, c); err != nil {
+			return fmt.Errorf("failed to restore product status change %d: %w", c.ID, err)
+		}
+	}
+	for _, c := range snap.PriceHistory {
+		if _, err := store.ProductPriceHistory().Append(ctx, c); err != nil {
+			return fmt.Errorf("failed to restore product price change %d: %w", c.ID, err)
+		}
+	}
+	for _, u := range snap.Users {
+		if _, err := store.Users().Create(ctx, u); err != nil {
+			return fmt.Errorf("failed to restore user %d: %w", u.ID, err)
+		}
+	}
+	for _, r := range snap.Reviews {
+		if _, err := store.Reviews().Create(ctx, r); err != nil {
+			return fmt.Errorf("failed to restore review %d: %w", r.ID, err)
+		}
+	}
+	for _, o := range snap.Orders {
+		if _, err := store.Orders().Create(ctx, o); err != nil {
+			return fmt.Errorf("failed to restore order %d: %w", o.ID, err)
+		}
+	}
+	for _, c := range snap.Carts {
+		c.Version-- // Put counts it up again
+		if _, err := store.Carts().Put(ctx, c); err != nil {
+			return fmt.Errorf("failed to restore the cart of user %d: %w", c.UserID, err)
+		}
+	}
+	for _, w := range snap.Widgets {
+		if _, err := store.Widgets().Create(ctx, w); err != nil {
+			return fmt.Errorf("failed to restore widget %d: %w", w.ID, err)
+		}
+	}
+	for _, e := range snap.StockLedger {
+		if _, err := store.StockLedger().Append(ctx, e); err != nil {
+			return fmt.Errorf("failed to restore stock entry %d: %w", e.ID, err)
+		}
+	}
+	for _, record := range snap.Employees {
+		emp, err := record.Employee()
+		if err != nil {
+			return err
+		}
+		if _, err := store.Employees().Create(ctx, emp); err != nil {
+			return fmt.Errorf("failed to restore employee %d: %w", record.ID, err)
+		}
+	}
+	for _, c := range snap.RoleHistory {
+		if _, err := store.EmployeeRoleHistory().Append(ctx, c); err != nil {
+			return fmt.Errorf("failed to restore employee role change %d: %w", c.ID, err)
+		}
+	}
+	return nil
+}
+
+// WriteSnapshotFile writes snap to path atomically: the data goes to a
+// temporary file in the same directory which is then renamed over path, so a
+// crash never leaves a half-written snapshot behind.
+func WriteSnapshotFile(path string, snap *Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename succeeded
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadSnapshotFile reads a snapshot written by WriteSnapshotFile.
+func ReadSnapshotFile(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	return &snap, nil
+}
+
+// SaveSnapshot writes the current contents of store to the snapshot file in
+// dataDir.
+func SaveSnapshot(ctx context.Context, store Store, dataDir string) (*Snapshot, error) {
+	snap, err := store.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteSnapshotFile(filepath.Join(dataDir, SnapshotFileName), snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// LoadSnapshot replaces the contents of store with the snapshot file in
+// dataDir. It returns os.ErrNotExist (wrapped) when there is no snapshot yet.
+func LoadSnapshot(ctx context.Context, store Store, dataDir string) (*Snapshot, error) {
+	snap, err := ReadSnapshotFile(filepath.Join(dataDir, SnapshotFileName))
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Restore(ctx, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// LoadSnapshotIfEmpty is LoadSnapshot for stores that hold no data yet. A
+// store that already holds data, such as a SQLite database that outlived
+// the last snapshot, is assumed to be more recent and left alone; nil is
+// returned then.
+func LoadSnapshotIfEmpty(ctx context.Context, store Store, dataDir string) (*Snapshot, error) {
+	empty, err := storeIsEmpty(ctx, store)
+	if err != nil || !empty {
+		return nil, err
+	}
+	return LoadSnapshot(ctx, store, dataDir)
+}
+
+// SnapshotInfo describes a snapshot that was saved or restored.
+type SnapshotInfo struct {
+	Path       string
+	TakenAt    time.Time
+	Categories int
+	Products   int
+	Users      int
+	Reviews    int
+	Widgets    int
+	Employees  int
+}
+
+func newSnapshotInfo(path string, snap *Snapshot) SnapshotInfo {
+	return SnapshotInfo{
+		Path:       path,
+		TakenAt:    snap.TakenAt,
+		Categories: len(snap.Categories),
+		Products:   len(snap.Products),
+		Users:      len(snap.Users),
+		Reviews:    len(snap.Reviews),
+		Widgets:    len(snap.Widgets),
+		Employees:  len(snap.Employees),
+	}
+}
+
+// SnapshotHandlers serves the admin snapshot mutations for a Store and the
+// data directory its snapshots live in. Tenants other than DefaultTenant
+// keep their snapshots in their TenantDir of the data directory.
+type SnapshotHandlers struct {
+	store   Store
+	dataDir string
+}
+
+// NewSnapshotHandlers creates snapshot handlers for store and dataDir.
+func NewSnapshotHandlers(store Store, dataDir string) *SnapshotHandlers {
+	return &SnapshotHandlers{store: store, dataDir: dataDir}
+}
+
+func RegisterSnapshotHandlers(ctx context.Context, graphy *quickgraph.Graphy, store Store, dataDir string) {
+	h := NewSnapshotHandlers(store, dataDir)
+	graphy.RegisterMutation(ctx, "SaveSnapshot", h.SaveSnapshot)
+	graphy.RegisterMutation(ctx, "RestoreSnapshot", h.RestoreSnapshot)
+}
+
+// SaveSnapshot writes the current server state to the data directory.
+// Requires the admin role.
+func (h *SnapshotHandlers) SaveSnapshot(ctx context.Context) (SnapshotInfo, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return SnapshotInfo{}, err
+	}
+	dataDir := h.dir(ctx)
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	snap, err := SaveSnapshot(ctx, h.store, dataDir)
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return newSnapshotInfo(filepath.Join(dataDir, SnapshotFileName), snap), nil
+}
+
+// RestoreSnapshot rolls the server state back to the last saved snapshot.
+// Requires the admin role.
+func (h *SnapshotHandlers) RestoreSnapshot(ctx context.Context) (SnapshotInfo, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return SnapshotInfo{}, err
+	}
+	dataDir := h.dir(ctx)
+	snap, err := LoadSnapshot(ctx, h.store, dataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return SnapshotInfo{}, errors.New("no snapshot has been saved yet")
+	}
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+	return newSnapshotInfo(filepath.Join(dataDir, SnapshotFileName), snap), nil
+}
+
+// dir returns the data directory of the tenant in ctx.
+func (h *SnapshotHandlers) dir(ctx context.Context) string {
+	return TenantDir(h.dataDir, TenantFromContext(ctx))
+}
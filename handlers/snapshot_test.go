@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gburgyan/go-quickgraph"
+)
+
+func TestSnapshotRoundTripAcrossStores(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemoryStore()
+	if err := SeedSampleData(ctx, source); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	if _, err := SaveSnapshot(ctx, source, dir); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+
+	for name, target := range map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": openTestSQLiteStore(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadSnapshot(ctx, target, dir); err != nil {
+				t.Fatalf("LoadSnapshot failed: %v", err)
+			}
+			graph := newTestGraph(ctx, target)
+			res, err := graph.ProcessRequest(ctx, `{ GetManagers { edges { node { Name Department } } } GetProduct(id: 1) { Name AverageRating } }`, "")
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			for _, want := range []string{`"Jane Smith"`, `"Engineering"`, `"Laptop"`, `4.5`} {
+				if !strings.Contains(res, want) {
+					t.Errorf("expected %s in response, got %s", want, res)
+				}
+			}
+
+			// New entities must not collide with restored IDs
+			w, err := target.Widgets().Create(ctx, Widget{Name: "After restore"})
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			if w.ID != 2 {
+				t.Errorf("expected new widget to get ID 2, got %d", w.ID)
+			}
+		})
+	}
+}
+
+func TestLoadSnapshotIfEmptyKeepsExistingData(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := NewMemoryStore()
+	if err := SeedSampleData(ctx, source); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	if _, err := SaveSnapshot(ctx, source, dir); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+
+	// A database written after the snapshot keeps its newer data
+	store := openTestSQLiteStore(t)
+	if _, err := store.Widgets().Create(ctx, Widget{Name: "After the snapshot"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if snap, err := LoadSnapshotIfEmpty(ctx, store, dir); err != nil || snap != nil {
+		t.Fatalf("expected the populated store to be left alone, got %v, %v", snap, err)
+	}
+	widgets, err := store.Widgets().List(ctx)
+	if err != nil || len(widgets) != 1 || widgets[0].Name != "After the snapshot" {
+		t.Errorf("expected only the newer widget, got %+v, %v", widgets, err)
+	}
+
+	empty := openTestSQLiteStore(t)
+	if snap, err := LoadSnapshotIfEmpty(ctx, empty, dir); err != nil || snap == nil {
+		t.Fatalf("expected the snapshot to be restored into the empty store, got %v, %v", snap, err)
+	}
+}
+
+func TestRestoreSnapshotRollsBackChanges(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	graph := newTestGraph(ctx, store)
+	RegisterSnapshotHandlers(ctx, graph, store, t.TempDir())
+
+	admin, err := store.Users().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get admin failed: %v", err)
+	}
+	adminCtx := context.WithValue(ctx, UserContextKey, &admin)
+
+	if _, err := graph.ProcessRequest(adminCtx, `mutation { SaveSnapshot { Widgets } }`, ""); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	if _, err := graph.ProcessRequest(ctx, `mutation { CreateWidget(widget: {name: "Temporary", price: 1, quantity: 1}) { id } }`, ""); err != nil {
+		t.Fatalf("CreateWidget failed: %v", err)
+	}
+	if _, err := graph.ProcessRequest(adminCtx, `mutation { RestoreSnapshot { Widgets } }`, ""); err != nil {
+		t.Fatalf("RestoreSnapshot failed: %v", err)
+	}
+
+	widgets, err := store.Widgets().List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(widgets) != 1 {
+		t.Errorf("expected the restore to drop the new widget, got %v", widgets)
+	}
+}
+
+func TestSnapshotMutationsRequireAdmin(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	graph := &quickgraph.Graphy{}
+	RegisterSnapshotHandlers(ctx, graph, store, t.TempDir())
+
+	customer, err := store.Users().Get(ctx, 2)
+	if err != nil {
+		t.Fatalf("Get customer failed: %v", err)
+	}
+	customerCtx := context.WithValue(ctx, UserContextKey, &customer)
+
+	res, _ := graph.ProcessRequest(customerCtx, `mutation { SaveSnapshot { Path } }`, "")
+	if !strings.Contains(res, "admin role required") {
+		t.Errorf("expected admin role error, got %s", res)
+	}
+}
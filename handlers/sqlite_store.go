@@ -0,0 +1,1747 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver, registers "sqlite"
+)
+
+// sqliteMigrations are applied in order on startup. Each entry is one schema
+// version; never edit an entry that has shipped, append a new one instead.
+var sqliteMigrations = []string{
+	// 1: initial schema
+	`CREATE TABLE categories (
+		id          INTEGER PRIMARY KEY,
+		name        TEXT NOT NULL,
+		description TEXT
+	);
+	CREATE TABLE products (
+		id          INTEGER PRIMARY KEY,
+		name        TEXT NOT NULL,
+		description TEXT NOT NULL,
+		price       REAL NOT NULL,
+		status      TEXT NOT NULL,
+		category_id INTEGER NOT NULL REFERENCES categories(id),
+		in_stock    INTEGER NOT NULL
+	);
+	CREATE INDEX products_category_id ON products(category_id);
+	CREATE TABLE users (
+		id       INTEGER PRIMARY KEY,
+		username TEXT NOT NULL,
+		email    TEXT NOT NULL,
+		role     TEXT NOT NULL
+	);
+	CREATE TABLE reviews (
+		id         INTEGER PRIMARY KEY,
+		product_id INTEGER NOT NULL REFERENCES products(id),
+		user_id    INTEGER NOT NULL REFERENCES users(id),
+		rating     INTEGER NOT NULL,
+		comment    TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	);
+	CREATE INDEX reviews_product_id ON reviews(product_id);
+	CREATE INDEX reviews_user_id ON reviews(user_id);
+	CREATE TABLE widgets (
+		id       INTEGER PRIMARY KEY,
+		name     TEXT NOT NULL,
+		price    REAL NOT NULL,
+		quantity INTEGER NOT NULL
+	);
+	CREATE TABLE employees (
+		id                    INTEGER PRIMARY KEY,
+		type                  TEXT NOT NULL,
+		name                  TEXT NOT NULL,
+		email                 TEXT NOT NULL,
+		salary                REAL NOT NULL,
+		hire_date             TEXT NOT NULL,
+		programming_languages TEXT,
+		github_username       TEXT,
+		department            TEXT,
+		team_size             INTEGER
+	);
+	CREATE INDEX employees_type ON employees(type);`,
+
+	// 2: optimistic concurrency versions
+	`ALTER TABLE widgets ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE products ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE employees ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+
+	// 3: soft deletes
+	`ALTER TABLE widgets ADD COLUMN deleted_at TEXT;
+	ALTER TABLE widgets ADD COLUMN deleted_by INTEGER;
+	ALTER TABLE products ADD COLUMN deleted_at TEXT;
+	ALTER TABLE products ADD COLUMN deleted_by INTEGER;
+	ALTER TABLE reviews ADD COLUMN deleted_at TEXT;
+	ALTER TABLE reviews ADD COLUMN deleted_by INTEGER;
+	ALTER TABLE employees ADD COLUMN deleted_at TEXT;
+	ALTER TABLE employees ADD COLUMN deleted_by INTEGER;`,
+
+	// 4: audit log
+	`CREATE TABLE audit_log (
+		id        INTEGER PRIMARY KEY,
+		time      INTEGER NOT NULL,
+		user_id   INTEGER,
+		operation TEXT NOT NULL,
+		variables TEXT,
+		entity    TEXT NOT NULL,
+		entity_id INTEGER NOT NULL,
+		action    TEXT NOT NULL,
+		changes   TEXT NOT NULL
+	);
+	CREATE INDEX audit_log_entity ON audit_log(entity, entity_id);
+	CREATE INDEX audit_log_user_id ON audit_log(user_id);
+	CREATE INDEX audit_log_time ON audit_log(time);`,
+
+	// 5: stock ledger and reorder thresholds
+	`ALTER TABLE widgets ADD COLUMN reorder_threshold INTEGER;
+	CREATE TABLE stock_ledger (
+		id             INTEGER PRIMARY KEY,
+		widget_id      INTEGER NOT NULL REFERENCES widgets(id),
+		delta          INTEGER NOT NULL,
+		quantity_after INTEGER NOT NULL,
+		reason         TEXT NOT NULL,
+		user_id        INTEGER,
+		time           INTEGER NOT NULL
+	);
+	CREATE INDEX stock_ledger_widget_id ON stock_ledger(widget_id);`,
+
+	// 6: product creation times
+	`ALTER TABLE products ADD COLUMN created_at TEXT;`,
+
+	// 7: category hierarchy and soft-deleted categories. parent_id has no
+	// foreign key so that snapshots can be restored in ID order.
+	`ALTER TABLE categories ADD COLUMN parent_id INTEGER;
+	ALTER TABLE categories ADD COLUMN deleted_at TEXT;
+	ALTER TABLE categories ADD COLUMN deleted_by INTEGER;`,
+
+	// 8: review moderation
+	`ALTER TABLE reviews ADD COLUMN status TEXT NOT NULL DEFAULT 'APPROVED';
+	ALTER TABLE reviews ADD COLUMN updated_at TEXT;
+	ALTER TABLE reviews ADD COLUMN moderated_at TEXT;
+	ALTER TABLE reviews ADD COLUMN moderated_by INTEGER;
+	ALTER TABLE reviews ADD COLUMN moderation_note TEXT;`,
+
+	// 9: product status history
+	`CREATE TABLE product_status_history (
+		id          INTEGER PRIMARY KEY,
+		product_id  INTEGER NOT NULL REFERENCES products(id),
+		from_status TEXT,
+		to_status   TEXT NOT NULL,
+		in_stock    INTEGER NOT NULL,
+		user_id     INTEGER,
+		time        INTEGER NOT NULL
+	);
+	CREATE INDEX product_status_history_product_id ON product_status_history(product_id);`,
+
+	// 10: product stock and orders. Order items are stored as JSON.
+	`ALTER TABLE products ADD COLUMN stock INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE orders (
+		id           INTEGER PRIMARY KEY,
+		user_id      INTEGER NOT NULL REFERENCES users(id),
+		items        TEXT NOT NULL,
+		total        REAL NOT NULL,
+		status       TEXT NOT NULL,
+		placed_at    TEXT NOT NULL,
+		shipped_at   TEXT,
+		delivered_at TEXT,
+		cancelled_at TEXT,
+		version      INTEGER NOT NULL
+	);
+	CREATE INDEX orders_user_id ON orders(user_id);`,
+	// 11: carts, one per user. Cart items are stored as JSON.
+	`CREATE TABLE carts (
+		user_id    INTEGER PRIMARY KEY REFERENCES users(id),
+		items      TEXT NOT NULL,
+		updated_at TEXT
+	);`,
+	// 12: product variants. Price overrides are stored as "123.45 USD".
+	`CREATE TABLE product_variants (
+		id             INTEGER PRIMARY KEY,
+		product_id     INTEGER NOT NULL REFERENCES products(id),
+		sku            TEXT NOT NULL UNIQUE,
+		color          TEXT,
+		size           TEXT,
+		price_override TEXT,
+		stock          INTEGER NOT NULL,
+		version        INTEGER NOT NULL
+	);
+	CREATE INDEX product_variants_product_id ON product_variants(product_id);`,
+	// 13: prices as Money, in whole cents plus currency, and the price
+	// history of products
+	`ALTER TABLE products ADD COLUMN price_amount INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE products ADD COLUMN price_currency TEXT NOT NULL DEFAULT 'USD';
+	UPDATE products SET price_amount = CAST(ROUND(price * 100) AS INTEGER);
+	ALTER TABLE products DROP COLUMN price;
+	ALTER TABLE widgets ADD COLUMN price_amount INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE widgets ADD COLUMN price_currency TEXT NOT NULL DEFAULT 'USD';
+	UPDATE widgets SET price_amount = CAST(ROUND(price * 100) AS INTEGER);
+	ALTER TABLE widgets DROP COLUMN price;
+	CREATE TABLE product_price_history (
+		id            INTEGER PRIMARY KEY,
+		product_id    INTEGER NOT NULL REFERENCES products(id),
+		from_amount   INTEGER,
+		from_currency TEXT,
+		to_amount     INTEGER NOT NULL,
+		to_currency   TEXT NOT NULL,
+		user_id       INTEGER,
+		effective_at  INTEGER NOT NULL
+	);
+	CREATE INDEX product_price_history_product_id ON product_price_history(product_id);`,
+	// 14: rating summaries, filled in from the approved reviews so far
+	`CREATE TABLE product_ratings (
+		product_id     INTEGER PRIMARY KEY REFERENCES products(id),
+		review_count   INTEGER NOT NULL,
+		rating_total   INTEGER NOT NULL,
+		stars_1        INTEGER NOT NULL,
+		stars_2        INTEGER NOT NULL,
+		stars_3        INTEGER NOT NULL,
+		stars_4        INTEGER NOT NULL,
+		stars_5        INTEGER NOT NULL,
+		last_review_at TEXT
+	);
+	INSERT INTO product_ratings
+	SELECT product_id, COUNT(*), SUM(rating),
+		SUM(rating = 1), SUM(rating = 2), SUM(rating = 3), SUM(rating = 4), SUM(rating = 5),
+		(SELECT NULLIF(COALESCE(latest.updated_at, latest.created_at), '') FROM reviews latest
+			WHERE latest.product_id = reviews.product_id AND latest.status = 'APPROVED' AND latest.deleted_at IS NULL
+			ORDER BY julianday(COALESCE(latest.updated_at, latest.created_at)) DESC LIMIT 1)
+	FROM reviews
+	WHERE status = 'APPROVED' AND deleted_at IS NULL AND rating BETWEEN 1 AND 5
+	GROUP BY product_id;`,
+	// 15: reporting lines. manager_id has no foreign key so that restores
+	// can insert employees before their managers; team sizes are counted
+	// from it instead of stored.
+	`ALTER TABLE employees ADD COLUMN manager_id INTEGER;
+	CREATE INDEX employees_manager_id ON employees(manager_id);
+	ALTER TABLE employees DROP COLUMN team_size;`,
+	// 16: employee role history
+	`CREATE TABLE employee_role_history (
+		id              INTEGER PRIMARY KEY,
+		employee_id     INTEGER NOT NULL REFERENCES employees(id),
+		from_type       TEXT,
+		to_type         TEXT NOT NULL,
+		from_department TEXT,
+		to_department   TEXT,
+		user_id         INTEGER,
+		changed_at      INTEGER NOT NULL
+	);
+	CREATE INDEX employee_role_history_employee_id ON employee_role_history(employee_id);`,
+	// 17: order totals as Money. The unit prices inside the items JSON are
+	// read as amounts in the store currency until the order is next saved.
+	`ALTER TABLE orders ADD COLUMN total_amount INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE orders ADD COLUMN total_currency TEXT NOT NULL DEFAULT 'USD';
+	UPDATE orders SET total_amount = CAST(ROUND(total * 100) AS INTEGER);
+	ALTER TABLE orders DROP COLUMN total;`,
+	// 18: cart versions, so concurrent changes to a cart cannot overwrite
+	// each other.
+	`ALTER TABLE carts ADD COLUMN version INTEGER NOT NULL DEFAULT 0;`,
+	// 19: one review per user and product that is not deleted. Reviews
+	// that raced past the check before are deleted, keeping the first, and
+	// the rating summaries are filled in again without them.
+	`UPDATE reviews SET deleted_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+	WHERE deleted_at IS NULL AND id NOT IN (
+		SELECT MIN(id) FROM reviews WHERE deleted_at IS NULL GROUP BY product_id, user_id);
+	CREATE UNIQUE INDEX reviews_product_id_user_id ON reviews(product_id, user_id) WHERE deleted_at IS NULL;
+	DELETE FROM product_ratings;
+	INSERT INTO product_ratings
+	SELECT product_id, COUNT(*), SUM(rating),
+		SUM(rating = 1), SUM(rating = 2), SUM(rating = 3), SUM(rating = 4), SUM(rating = 5),
+		(SELECT NULLIF(COALESCE(latest.updated_at, latest.created_at), '') FROM reviews latest
+			WHERE latest.product_id = reviews.product_id AND latest.status = 'APPROVED' AND latest.deleted_at IS NULL
+			ORDER BY julianday(COALESCE(latest.updated_at, latest.created_at)) DESC LIMIT 1)
+	FROM reviews
+	WHERE status = 'APPROVED' AND deleted_at IS NULL AND rating BETWEEN 1 AND 5
+	GROUP BY product_id;`,
+	// 20: the GraphQL document behind each audit entry. Older entries keep
+	// the top-level fields they recorded as their operation.
+	`ALTER TABLE audit_log ADD COLUMN query TEXT NOT NULL DEFAULT '';`,
+	// 21: category versions, so concurrent changes to a category cannot
+	// overwrite each other.
+	`ALTER TABLE categories ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+}
+
+// SQLiteStore is a Store backed by an embedded SQLite database. It uses a
+// pure-Go driver, so no cgo toolchain is required.
+type SQLiteStore struct {
+	db     *sql.DB
+	events *Events
+}
+
+// OpenSQLiteStore opens (or creates) the SQLite database at path and applies
+// any pending schema migrations. Use ":memory:" for a throwaway database.
+func OpenSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", path, err)
+	}
+	// A single connection keeps writes serialized and lets ":memory:"
+	// databases survive between calls.
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStore{db: db, events: NewEvents()}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenStore returns the SQLite store at dbPath, or a new in-memory store when
+// dbPath is empty. The returned function releases the store.
+func OpenStore(ctx context.Context, dbPath string) (Store, func() error, error) {
+	if dbPath == "" {
+		return NewMemoryStore(), func() error { return nil }, nil
+	}
+
+	store, err := OpenSQLiteStore(ctx, dbPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, store.Close, nil
+}
+
+// Close releases the underlying database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := current; i < len(sqliteMigrations); i++ {
+		version := i + 1
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+				version, time.Now().UTC().Format(time.RFC3339))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// inConnTx runs fn inside a transaction on db, or directly if db already is
+// a transaction.
+func inConnTx(ctx context.Context, db sqlConn, fn func(db sqlConn) error) error {
+	sqlDB, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlConn is the subset of *sql.DB and *sql.Tx the repositories need, so the
+// same repository code runs both standalone and inside a transaction.
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (s *SQLiteStore) Widgets() WidgetRepository          { return sqliteWidgets{s.db} }
+func (s *SQLiteStore) Products() ProductRepository        { return sqliteProducts{s.db} }
+func (s *SQLiteStore) Categories() CategoryRepository     { return sqliteCategories{s.db} }
+func (s *SQLiteStore) Reviews() ReviewRepository          { return sqliteReviews{s.db} }
+func (s *SQLiteStore) Users() UserRepository              { return sqliteUsers{s.db} }
+func (s *SQLiteStore) Employees() EmployeeRepository      { return sqliteEmployees{s.db} }
+func (s *SQLiteStore) StockLedger() StockLedgerRepository { return sqliteStockLedger{s.db} }
+func (s *SQLiteStore) Audit() AuditRepository             { return sqliteAudit{s.db} }
+func (s *SQLiteStore) ProductStatusHistory() ProductStatusHistoryRepository {
+	return sqliteStatusHistory{s.db}
+}
+func (s *SQLiteStore) Orders() OrderRepository { return sqliteOrders{s.db} }
+func (s *SQLiteStore) Carts() CartRepository   { return sqliteCarts{s.db} }
+func (s *SQLiteStore) ProductVariants() ProductVariantRepository {
+	return sqliteVariants{s.db}
+}
+func (s *SQLiteStore) ProductPriceHistory() ProductPriceHistoryRepository {
+	return sqlitePriceHistory{s.db}
+}
+func (s *SQLiteStore) EmployeeRoleHistory() EmployeeRoleHistoryRepository {
+	return sqliteRoleHistory{s.db}
+}
+func (s *SQLiteStore) RatingSummaries() RatingSummaryRepository {
+	return sqliteRatings{s.db}
+}
+func (s *SQLiteStore) Events() *Events { return s.events }
+
+// Snapshot reads every table inside one transaction.
+func (s *SQLiteStore) Snapshot(ctx context.Context) (*Snapshot, error) {
+	var snap *Snapshot
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		snap, err = snapshotFrom(ctx, sqliteRepositories{tx})
+		return err
+	})
+	return snap, err
+}
+
+// Restore empties every table except the audit log and loads snapshot
+// inside one transaction.
+func (s *SQLiteStore) Restore(ctx context.Context, snapshot *Snapshot) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		// Children before parents so foreign keys stay satisfied
+		for _, table := range []string{"product_ratings", "reviews", "product_status_history", "product_price_history", "carts", "orders", "product_variants", "products", "categories", "users", "stock_ledger", "widgets", "employee_role_history", "employees"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table); err != nil {
+				return err
+			}
+		}
+		return restoreInto(ctx, sqliteRepositories{tx}, snapshot)
+	})
+}
+
+// sqliteRepositories exposes the repositories over a single connection or
+// transaction.
+type sqliteRepositories struct{ db sqlConn }
+
+func (r sqliteRepositories) Widgets() WidgetRepository          { return sqliteWidgets{r.db} }
+func (r sqliteRepositories) Products() ProductRepository        { return sqliteProducts{r.db} }
+func (r sqliteRepositories) Categories() CategoryRepository     { return sqliteCategories{r.db} }
+func (r sqliteRepositories) Reviews() ReviewRepository          { return sqliteReviews{r.db} }
+func (r sqliteRepositories) Users() UserRepository              { return sqliteUsers{r.db} }
+func (r sqliteRepositories) Employees() EmployeeRepository      { return sqliteEmployees{r.db} }
+func (r sqliteRepositories) StockLedger() StockLedgerRepository { return sqliteStockLedger{r.db} }
+func (r sqliteRepositories) ProductStatusHistory() ProductStatusHistoryRepository {
+	return sqliteStatusHistory{r.db}
+}
+func (r sqliteRepositories) Orders() OrderRepository { return sqliteOrders{r.db} }
+func (r sqliteRepositories) Carts() CartRepository   { return sqliteCarts{r.db} }
+func (r sqliteRepositories) ProductVariants() ProductVariantRepository {
+	return sqliteVariants{r.db}
+}
+func (r sqliteRepositories) ProductPriceHistory() ProductPriceHistoryRepository {
+	return sqlitePriceHistory{r.db}
+}
+func (r sqliteRepositories) EmployeeRoleHistory() EmployeeRoleHistoryRepository {
+	return sqliteRoleHistory{r.db}
+}
+func (r sqliteRepositories) RatingSummaries() RatingSummaryRepository {
+	return sqliteRatings{r.db}
+}
+
+// nullableID maps a zero ID to NULL so SQLite assigns the next rowid.
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// insertedID returns the ID SQLite used for an insert.
+func insertedID(res sql.Result) (int, error) {
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// expectUpdated turns an UPDATE that touched no rows into ErrNotFound.
+func expectUpdated(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// versionedUpdate runs an UPDATE that is guarded by the entity's expected
+// version and turns a miss into ErrNotFound or a *VersionConflictError.
+func versionedUpdate(ctx context.Context, db sqlConn, table, entity string, id, expected int, query string, args ...interface{}) error {
+	err := expectUpdated(db.ExecContext(ctx, query, args...))
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	current, err := queryOne(ctx, db, func(rows *sql.Rows) (int, error) {
+		var v int
+		err := rows.Scan(&v)
+		return v, err
+	}, `SELECT version FROM `+table+` WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return &VersionConflictError{Entity: entity, ID: id, ExpectedVersion: expected, CurrentVersion: current}
+}
+
+// timeValue stores an optional time as RFC 3339 text, or NULL.
+func timeValue(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// nullTime scans a column written with timeValue back into *t.
+type nullTime struct{ t **time.Time }
+
+func (n nullTime) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		*n.t = nil
+		return nil
+	case time.Time:
+		v = v.UTC()
+		*n.t = &v
+		return nil
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into a time", src)
+	}
+	t, err := time.Parse(time.RFC3339Nano, text)
+	if err != nil {
+		return err
+	}
+	*n.t = &t
+	return nil
+}
+
+// queryAll runs query and collects one value per row using scan.
+func queryAll[T any](ctx context.Context, db sqlConn, scan func(*sql.Rows) (T, error), query string, args ...interface{}) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []T
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, rows.Err()
+}
+
+// inList returns the placeholders and arguments of an IN (...) list of ids.
+// ids must not be empty.
+func inList(ids []int) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
+
+// queryOne runs query and returns its single row, or ErrNotFound.
+func queryOne[T any](ctx context.Context, db sqlConn, scan func(*sql.Rows) (T, error), query string, args ...interface{}) (T, error) {
+	all, err := queryAll(ctx, db, scan, query, args...)
+	if err != nil || len(all) == 0 {
+		var zero T
+		if err == nil {
+			err = ErrNotFound
+		}
+		return zero, err
+	}
+	return all[0], nil
+}
+
+// Widgets
+
+type sqliteWidgets struct{ db sqlConn }
+
+const widgetColumns = `id, name, price_amount, price_currency, quantity, version, deleted_at, deleted_by, reorder_threshold`
+
+func scanWidget(rows *sql.Rows) (Widget, error) {
+	var w Widget
+	err := rows.Scan(&w.ID, &w.Name, &w.Price.Amount, &w.Price.Currency, &w.Quantity, &w.Version, nullTime{&w.DeletedAt}, &w.DeletedBy, &w.ReorderThreshold)
+	return w, err
+}
+
+func (r sqliteWidgets) Get(ctx context.Context, id int) (Widget, error) {
+	return queryOne(ctx, r.db, scanWidget, `SELECT `+widgetColumns+` FROM widgets WHERE id = ?`, id)
+}
+
+func (r sqliteWidgets) List(ctx context.Context) ([]Widget, error) {
+	return queryAll(ctx, r.db, scanWidget, `SELECT `+widgetColumns+` FROM widgets ORDER BY id`)
+}
+
+func (r sqliteWidgets) Create(ctx context.Context, widget Widget) (Widget, error) {
+	widget.Version = initialVersion(widget.Version)
+	res, err := r.db.ExecContext(ctx, `INSERT INTO widgets (`+widgetColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(widget.ID), widget.Name, widget.Price.Amount, widget.Price.Currency, widget.Quantity, widget.Version, timeValue(widget.DeletedAt), widget.DeletedBy,
+		widget.ReorderThreshold)
+	if err != nil {
+		return Widget{}, err
+	}
+	widget.ID, err = insertedID(res)
+	return widget, err
+}
+
+func (r sqliteWidgets) Update(ctx context.Context, widget Widget) (Widget, error) {
+	err := versionedUpdate(ctx, r.db, "widgets", "widget", widget.ID, widget.Version,
+		`UPDATE widgets SET name = ?, price_amount = ?, price_currency = ?, quantity = ?, deleted_at = ?, deleted_by = ?,
+		reorder_threshold = ?, version = version + 1 WHERE id = ? AND version = ?`,
+		widget.Name, widget.Price.Amount, widget.Price.Currency, widget.Quantity, timeValue(widget.DeletedAt), widget.DeletedBy, widget.ReorderThreshold,
+		widget.ID, widget.Version)
+	if err != nil {
+		return Widget{}, err
+	}
+	widget.Version++
+	return widget, nil
+}
+
+func (r sqliteWidgets) AdjustStock(ctx context.Context, entry StockEntry) (Widget, StockEntry, error) {
+	var widget Widget
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		// The guard makes the check and the change a single atomic step
+		err := expectUpdated(db.ExecContext(ctx,
+			`UPDATE widgets SET quantity = quantity + ?, version = version + 1 WHERE id = ? AND quantity + ? >= 0`,
+			entry.Delta, entry.WidgetID, entry.Delta))
+		if errors.Is(err, ErrNotFound) {
+			current, getErr := sqliteWidgets{db}.Get(ctx, entry.WidgetID)
+			if getErr != nil {
+				return getErr
+			}
+			return &InsufficientStockError{WidgetID: current.ID, Quantity: current.Quantity, Delta: entry.Delta}
+		}
+		if err != nil {
+			return err
+		}
+
+		if widget, err = (sqliteWidgets{db}).Get(ctx, entry.WidgetID); err != nil {
+			return err
+		}
+		entry.QuantityAfter = widget.Quantity
+		entry, err = sqliteStockLedger{db}.Append(ctx, entry)
+		return err
+	})
+	if err != nil {
+		return Widget{}, StockEntry{}, err
+	}
+	return widget, entry, nil
+}
+
+// Stock ledger entries store their time as Unix nanoseconds, like audit
+// entries.
+
+type sqliteStockLedger struct{ db sqlConn }
+
+const stockColumns = `id, widget_id, delta, quantity_after, reason, user_id, time`
+
+func scanStockEntry(rows *sql.Rows) (StockEntry, error) {
+	var (
+		e     StockEntry
+		nanos int64
+	)
+	err := rows.Scan(&e.ID, &e.WidgetID, &e.Delta, &e.QuantityAfter, &e.Reason, &e.UserID, &nanos)
+	e.Time = time.Unix(0, nanos).UTC()
+	return e, err
+}
+
+func (r sqliteStockLedger) List(ctx context.Context) ([]StockEntry, error) {
+	return queryAll(ctx, r.db, scanStockEntry, `SELECT `+stockColumns+` FROM stock_ledger ORDER BY id`)
+}
+
+func (r sqliteStockLedger) ListByWidget(ctx context.Context, widgetID int) ([]StockEntry, error) {
+	return queryAll(ctx, r.db, scanStockEntry, `SELECT `+stockColumns+` FROM stock_ledger WHERE widget_id = ? ORDER BY id`, widgetID)
+}
+
+func (r sqliteStockLedger) Append(ctx context.Context, entry StockEntry) (StockEntry, error) {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO stock_ledger (`+stockColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(entry.ID), entry.WidgetID, entry.Delta, entry.QuantityAfter, entry.Reason, entry.UserID, entry.Time.UnixNano())
+	if err != nil {
+		return StockEntry{}, err
+	}
+	entry.ID, err = insertedID(res)
+	return entry, err
+}
+
+// Product status history
+
+type sqliteStatusHistory struct{ db sqlConn }
+
+const statusColumns = `id, product_id, from_status, to_status, in_stock, user_id, time`
+
+func scanStatusChange(rows *sql.Rows) (ProductStatusChange, error) {
+	var (
+		c     ProductStatusChange
+		nanos int64
+	)
+	err := rows.Scan(&c.ID, &c.ProductID, &c.From, &c.To, &c.InStock, &c.UserID, &nanos)
+	c.Time = time.Unix(0, nanos).UTC()
+	return c, err
+}
+
+func (r sqliteStatusHistory) List(ctx context.Context) ([]ProductStatusChange, error) {
+	return queryAll(ctx, r.db, scanStatusChange, `SELECT `+statusColumns+` FROM product_status_history ORDER BY id`)
+}
+
+func (r sqliteStatusHistory) ListByProduct(ctx context.Context, productID int) ([]ProductStatusChange, error) {
+	return queryAll(ctx, r.db, scanStatusChange, `SELECT `+statusColumns+` FROM product_status_history WHERE product_id = ? ORDER BY id`, productID)
+}
+
+func (r sqliteStatusHistory) Append(ctx context.Context, entry ProductStatusChange) (ProductStatusChange, error) {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO product_status_history (`+statusColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(entry.ID), entry.ProductID, entry.From, entry.To, entry.InStock, entry.UserID, entry.Time.UnixNano())
+	if err != nil {
+		return ProductStatusChange{}, err
+	}
+	entry.ID, err = insertedID(res)
+	return entry, err
+}
+
+// Product price history
+
+type sqlitePriceHistory struct{ db sqlConn }
+
+const priceColumns = `id, product_id, from_amount, from_currency, to_amount, to_currency, user_id, effective_at`
+
+func scanPriceChange(rows *sql.Rows) (ProductPriceChange, error) {
+	var (
+		c            ProductPriceChange
+		fromAmount   *int64
+		fromCurrency *string
+		nanos        int64
+	)
+	err := rows.Scan(&c.ID, &c.ProductID, &fromAmount, &fromCurrency, &c.To.Amount, &c.To.Currency, &c.UserID, &nanos)
+	if fromAmount != nil && fromCurrency != nil {
+		c.From = &Money{Amount: *fromAmount, Currency: *fromCurrency}
+	}
+	c.EffectiveAt = time.Unix(0, nanos).UTC()
+	return c, err
+}
+
+func (r sqlitePriceHistory) List(ctx context.Context) ([]ProductPriceChange, error) {
+	return queryAll(ctx, r.db, scanPriceChange, `SELECT `+priceColumns+` FROM product_price_history ORDER BY id`)
+}
+
+func (r sqlitePriceHistory) ListByProduct(ctx context.Context, productID int) ([]ProductPriceChange, error) {
+	return queryAll(ctx, r.db, scanPriceChange, `SELECT `+priceColumns+` FROM product_price_history WHERE product_id = ? ORDER BY id`, productID)
+}
+
+func (r sqlitePriceHistory) Append(ctx context.Context, entry ProductPriceChange) (ProductPriceChange, error) {
+	var fromAmount, fromCurrency interface{}
+	if entry.From != nil {
+		fromAmount, fromCurrency = entry.From.Amount, entry.From.Currency
+	}
+	res, err := r.db.ExecContext(ctx, `INSERT INTO product_price_history (`+priceColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(entry.ID), entry.ProductID, fromAmount, fromCurrency, entry.To.Amount, entry.To.Currency, entry.UserID,
+		entry.EffectiveAt.UnixNano())
+	if err != nil {
+		return ProductPriceChange{}, err
+	}
+	entry.ID, err = insertedID(res)
+	return entry, err
+}
+
+// Employee role history
+
+type sqliteRoleHistory struct{ db sqlConn }
+
+const roleColumns = `id, employee_id, from_type, to_type, from_department, to_department, user_id, changed_at`
+
+func scanRoleChange(rows *sql.Rows) (EmployeeRoleChange, error) {
+	var (
+		c     EmployeeRoleChange
+		nanos int64
+	)
+	err := rows.Scan(&c.ID, &c.EmployeeID, &c.FromType, &c.ToType, &c.FromDepartment, &c.ToDepartment, &c.UserID, &nanos)
+	c.ChangedAt = time.Unix(0, nanos).UTC()
+	return c, err
+}
+
+func (r sqliteRoleHistory) List(ctx context.Context) ([]EmployeeRoleChange, error) {
+	return queryAll(ctx, r.db, scanRoleChange, `SELECT `+roleColumns+` FROM employee_role_history ORDER BY id`)
+}
+
+func (r sqliteRoleHistory) ListByEmployee(ctx context.Context, employeeID int) ([]EmployeeRoleChange, error) {
+	return queryAll(ctx, r.db, scanRoleChange, `SELECT `+roleColumns+` FROM employee_role_history WHERE employee_id = ? ORDER BY id`, employeeID)
+}
+
+func (r sqliteRoleHistory) Append(ctx context.Context, entry EmployeeRoleChange) (EmployeeRoleChange, error) {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO employee_role_history (`+roleColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(entry.ID), entry.EmployeeID, entry.FromType, entry.ToType, entry.FromDepartment, entry.ToDepartment, entry.UserID,
+		entry.ChangedAt.UnixNano())
+	if err != nil {
+		return EmployeeRoleChange{}, err
+	}
+	entry.ID, err = insertedID(res)
+	return entry, err
+}
+
+// Products
+
+type sqliteProducts struct{ db sqlConn }
+
+const productColumns = `id, name, description, price_amount, price_currency, status, category_id, in_stock, stock, version, deleted_at, deleted_by, created_at`
+
+func scanProduct(rows *sql.Rows) (Product, error) {
+	var p Product
+	err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price.Amount, &p.Price.Currency, &p.Status, &p.CategoryID, &p.InStock, &p.Stock, &p.Version,
+		nullTime{&p.DeletedAt}, &p.DeletedBy, nullTime{&p.CreatedAt})
+	return p, err
+}
+
+func (r sqliteProducts) Get(ctx context.Context, id int) (Product, error) {
+	return queryOne(ctx, r.db, scanProduct, `SELECT `+productColumns+` FROM products WHERE id = ?`, id)
+}
+
+func (r sqliteProducts) List(ctx context.Context) ([]Product, error) {
+	return queryAll(ctx, r.db, scanProduct, `SELECT `+productColumns+` FROM products ORDER BY id`)
+}
+
+func (r sqliteProducts) ListByCategory(ctx context.Context, categoryID int) ([]Product, error) {
+	return queryAll(ctx, r.db, scanProduct, `SELECT `+productColumns+` FROM products WHERE category_id = ? ORDER BY id`, categoryID)
+}
+
+func (r sqliteProducts) Create(ctx context.Context, product Product) (Product, error) {
+	product.Version = initialVersion(product.Version)
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		if !product.isDeleted() {
+			deleted, err := categoryDeleted(ctx, db, product.CategoryID)
+			if err != nil {
+				return err
+			}
+			if deleted {
+				return &CategoryNotFoundError{ID: product.CategoryID}
+			}
+		}
+		res, err := db.ExecContext(ctx, `INSERT INTO products (`+productColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			nullableID(product.ID), product.Name, product.Description, product.Price.Amount, product.Price.Currency, product.Status, product.CategoryID, product.InStock,
+			product.Stock, product.Version, timeValue(product.DeletedAt), product.DeletedBy, timeValue(product.CreatedAt))
+		if err != nil {
+			return err
+		}
+		product.ID, err = insertedID(res)
+		return err
+	})
+	if err != nil {
+		return Product{}, err
+	}
+	return product, nil
+}
+
+func (r sqliteProducts) Update(ctx context.Context, product Product) (Product, error) {
+	err := versionedUpdate(ctx, r.db, "products", "product", product.ID, product.Version,
+		`UPDATE products SET name = ?, description = ?, price_amount = ?, price_currency = ?, status = ?, category_id = ?,
+		in_stock = ?, stock = ?, deleted_at = ?, deleted_by = ?, version = version + 1 WHERE id = ? AND version = ?`,
+		product.Name, product.Description, product.Price.Amount, product.Price.Currency, product.Status, product.CategoryID, product.InStock, product.Stock,
+		timeValue(product.DeletedAt), product.DeletedBy, product.ID, product.Version)
+	if err != nil {
+		return Product{}, err
+	}
+	product.Version++
+	return product, nil
+}
+
+// Orders
+
+type sqliteOrders struct{ db sqlConn }
+
+const orderColumns = `id, user_id, items, total_amount, total_currency, status, placed_at, shipped_at, delivered_at, cancelled_at, version`
+
+func scanOrder(rows *sql.Rows) (Order, error) {
+	var (
+		o        Order
+		items    string
+		placedAt *time.Time
+	)
+	if err := rows.Scan(&o.ID, &o.UserID, &items, &o.Total.Amount, &o.Total.Currency, &o.Status, nullTime{&placedAt},
+		nullTime{&o.ShippedAt}, nullTime{&o.DeliveredAt}, nullTime{&o.CancelledAt}, &o.Version); err != nil {
+		return Order{}, err
+	}
+	if placedAt != nil {
+		o.PlacedAt = *placedAt
+	}
+	if err := json.Unmarshal([]byte(items), &o.Items); err != nil {
+		return Order{}, fmt.Errorf("order %d has invalid items: %w", o.ID, err)
+	}
+	return o, nil
+}
+
+func (r sqliteOrders) Get(ctx context.Context, id int) (Order, error) {
+	return queryOne(ctx, r.db, scanOrder, `SELECT `+orderColumns+` FROM orders WHERE id = ?`, id)
+}
+
+func (r sqliteOrders) List(ctx context.Context) ([]Order, error) {
+	return queryAll(ctx, r.db, scanOrder, `SELECT `+orderColumns+` FROM orders ORDER BY id`)
+}
+
+func (r sqliteOrders) ListByUser(ctx context.Context, userID int) ([]Order, error) {
+	return queryAll(ctx, r.db, scanOrder, `SELECT `+orderColumns+` FROM orders WHERE user_id = ? ORDER BY id`, userID)
+}
+
+func (r sqliteOrders) Create(ctx context.Context, order Order) (Order, error) {
+	items, err := json.Marshal(order.Items)
+	if err != nil {
+		return Order{}, err
+	}
+	order.Version = initialVersion(order.Version)
+	res, err := r.db.ExecContext(ctx, `INSERT INTO orders (`+orderColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(order.ID), order.UserID, string(items), order.Total.Amount, order.Total.Currency, order.Status, timeValue(&order.PlacedAt),
+		timeValue(order.ShippedAt), timeValue(order.DeliveredAt), timeValue(order.CancelledAt), order.Version)
+	if err != nil {
+		return Order{}, err
+	}
+	order.ID, err = insertedID(res)
+	return order, err
+}
+
+func (r sqliteOrders) Place(ctx context.Context, order Order) (Order, error) {
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		// The order is created first so the widget ledger entries can name
+		// it; a failed item rolls it back
+		var err error
+		if order, err = (sqliteOrders{db}).Create(ctx, order); err != nil {
+			return err
+		}
+		for _, item := range order.Items {
+			if item.WidgetID != nil {
+				if _, _, err := (sqliteWidgets{db}).AdjustStock(ctx, orderStockEntry(order, item)); err != nil {
+					return err
+				}
+				continue
+			}
+			// The guards make the checks and the changes single atomic steps
+			if item.VariantID != nil {
+				err := expectUpdated(db.ExecContext(ctx,
+					`UPDATE product_variants SET stock = stock - ?, version = version + 1 WHERE id = ? AND product_id = ? AND stock >= ?`,
+					item.Quantity, *item.VariantID, *item.ProductID, item.Quantity))
+				if errors.Is(err, ErrNotFound) {
+					current, getErr := sqliteVariants{db}.Get(ctx, *item.VariantID)
+					if errors.Is(getErr, ErrNotFound) || (getErr == nil && current.ProductID != *item.ProductID) {
+						return fmt.Errorf("product %d has no variant %d", *item.ProductID, *item.VariantID)
+					}
+					if getErr != nil {
+						return getErr
+					}
+					return &InsufficientProductStockError{ProductID: current.ProductID, VariantID: &current.ID, Stock: current.Stock, Quantity: item.Quantity}
+				}
+				if err != nil {
+					return err
+				}
+			}
+			err := expectUpdated(db.ExecContext(ctx,
+				`UPDATE products SET stock = stock - ?, version = version + 1 WHERE id = ? AND stock >= ?`,
+				item.Quantity, *item.ProductID, item.Quantity))
+			if errors.Is(err, ErrNotFound) {
+				current, getErr := sqliteProducts{db}.Get(ctx, *item.ProductID)
+				if getErr != nil {
+					return getErr
+				}
+				return &InsufficientProductStockError{ProductID: current.ID, Stock: current.Stock, Quantity: item.Quantity}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Order{}, err
+	}
+	return order, nil
+}
+
+func (r sqliteOrders) Update(ctx context.Context, order Order) (Order, error) {
+	items, err := json.Marshal(order.Items)
+	if err != nil {
+		return Order{}, err
+	}
+	err = versionedUpdate(ctx, r.db, "orders", "order", order.ID, order.Version,
+		`UPDATE orders SET user_id = ?, items = ?, total_amount = ?, total_currency = ?, status = ?, placed_at = ?, shipped_at = ?, delivered_at = ?,
+		cancelled_at = ?, version = version + 1 WHERE id = ? AND version = ?`,
+		order.UserID, string(items), order.Total.Amount, order.Total.Currency, order.Status, timeValue(&order.PlacedAt), timeValue(order.ShippedAt),
+		timeValue(order.DeliveredAt), timeValue(order.CancelledAt), order.ID, order.Version)
+	if err != nil {
+		return Order{}, err
+	}
+	order.Version++
+	return order, nil
+}
+
+func (r sqliteOrders) Cancel(ctx context.Context, order Order) (Order, error) {
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		var err error
+		if order, err = (sqliteOrders{db}).Update(ctx, order); err != nil {
+			return err
+		}
+		for _, item := range order.Items {
+			if item.WidgetID != nil {
+				if _, _, err := (sqliteWidgets{db}).AdjustStock(ctx, orderStockEntry(order, item)); err != nil {
+					return err
+				}
+				continue
+			}
+			if item.VariantID != nil {
+				if _, err := db.ExecContext(ctx, `UPDATE product_variants SET stock = stock + ?, version = version + 1 WHERE id = ?`,
+					item.Quantity, *item.VariantID); err != nil {
+					return err
+				}
+			}
+			if _, err := db.ExecContext(ctx, `UPDATE products SET stock = stock + ?, version = version + 1 WHERE id = ?`,
+				item.Quantity, *item.ProductID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Order{}, err
+	}
+	return order, nil
+}
+
+// Product variants
+
+type sqliteVariants struct{ db sqlConn }
+
+const variantColumns = `id, product_id, sku, color, size, price_override, stock, version`
+
+func scanVariant(rows *sql.Rows) (ProductVariant, error) {
+	var (
+		v     ProductVariant
+		price *string
+	)
+	if err := rows.Scan(&v.ID, &v.ProductID, &v.SKU, &v.Color, &v.Size, &price, &v.Stock, &v.Version); err != nil {
+		return ProductVariant{}, err
+	}
+	if price != nil {
+		m, err := ParseMoney(*price)
+		if err != nil {
+			return ProductVariant{}, fmt.Errorf("variant %d has an invalid price override: %w", v.ID, err)
+		}
+		v.PriceOverride = &m
+	}
+	return v, nil
+}
+
+// moneyValue returns m as stored in the database, or nil if m is unset.
+func moneyValue(m *Money) interface{} {
+	if m == nil {
+		return nil
+	}
+	return m.String()
+}
+
+func (r sqliteVariants) Get(ctx context.Context, id int) (ProductVariant, error) {
+	return queryOne(ctx, r.db, scanVariant, `SELECT `+variantColumns+` FROM product_variants WHERE id = ?`, id)
+}
+
+func (r sqliteVariants) List(ctx context.Context) ([]ProductVariant, error) {
+	return queryAll(ctx, r.db, scanVariant, `SELECT `+variantColumns+` FROM product_variants ORDER BY id`)
+}
+
+func (r sqliteVariants) ListByProducts(ctx context.Context, productIDs []int) ([]ProductVariant, error) {
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inList(productIDs)
+	return queryAll(ctx, r.db, scanVariant, `SELECT `+variantColumns+` FROM product_variants WHERE product_id IN (`+placeholders+`) ORDER BY id`, args...)
+}
+
+func (r sqliteVariants) Create(ctx context.Context, variant ProductVariant) (ProductVariant, error) {
+	variant.Version = initialVersion(variant.Version)
+	res, err := r.db.ExecContext(ctx, `INSERT INTO product_variants (`+variantColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(variant.ID), variant.ProductID, variant.SKU, variant.Color, variant.Size, moneyValue(variant.PriceOverride),
+		variant.Stock, variant.Version)
+	if err != nil {
+		return ProductVariant{}, err
+	}
+	variant.ID, err = insertedID(res)
+	return variant, err
+}
+
+func (r sqliteVariants) Update(ctx context.Context, variant ProductVariant) (ProductVariant, error) {
+	err := versionedUpdate(ctx, r.db, "product_variants", "variant", variant.ID, variant.Version,
+		`UPDATE product_variants SET product_id = ?, sku = ?, color = ?, size = ?, price_override = ?, stock = ?,
+		version = version + 1 WHERE id = ? AND version = ?`,
+		variant.ProductID, variant.SKU, variant.Color, variant.Size, moneyValue(variant.PriceOverride), variant.Stock,
+		variant.ID, variant.Version)
+	if err != nil {
+		return ProductVariant{}, err
+	}
+	variant.Version++
+	return variant, nil
+}
+
+func (r sqliteVariants) Delete(ctx context.Context, id int) (ProductVariant, error) {
+	var variant ProductVariant
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		var err error
+		if variant, err = (sqliteVariants{db}).Get(ctx, id); err != nil {
+			return err
+		}
+		_, err = db.ExecContext(ctx, `DELETE FROM product_variants WHERE id = ?`, id)
+		return err
+	})
+	if err != nil {
+		return ProductVariant{}, err
+	}
+	return variant, nil
+}
+
+// Carts
+
+type sqliteCarts struct{ db sqlConn }
+
+const cartColumns = `user_id, items, updated_at, version`
+
+func scanCart(rows *sql.Rows) (Cart, error) {
+	var (
+		c     Cart
+		items string
+	)
+	if err := rows.Scan(&c.UserID, &items, nullTime{&c.UpdatedAt}, &c.Version); err != nil {
+		return Cart{}, err
+	}
+	if err := json.Unmarshal([]byte(items), &c.Items); err != nil {
+		return Cart{}, fmt.Errorf("cart of user %d has invalid items: %w", c.UserID, err)
+	}
+	return c, nil
+}
+
+func (r sqliteCarts) Get(ctx context.Context, userID int) (Cart, error) {
+	return queryOne(ctx, r.db, scanCart, `SELECT `+cartColumns+` FROM carts WHERE user_id = ?`, userID)
+}
+
+func (r sqliteCarts) List(ctx context.Context) ([]Cart, error) {
+	return queryAll(ctx, r.db, scanCart, `SELECT `+cartColumns+` FROM carts ORDER BY user_id`)
+}
+
+func (r sqliteCarts) Put(ctx context.Context, cart Cart) (Cart, error) {
+	if cart.Items == nil {
+		cart.Items = []CartItem{}
+	}
+	items, err := json.Marshal(cart.Items)
+	if err != nil {
+		return Cart{}, err
+	}
+	err = expectUpdated(r.db.ExecContext(ctx, `UPDATE carts SET items = ?, updated_at = ?, version = version + 1
+		WHERE user_id = ? AND version = ?`, string(items), timeValue(cart.UpdatedAt), cart.UserID, cart.Version))
+	if errors.Is(err, ErrNotFound) {
+		err = expectUpdated(r.db.ExecContext(ctx, `INSERT INTO carts (`+cartColumns+`) VALUES (?, ?, ?, ?)
+			ON CONFLICT(user_id) DO NOTHING`, cart.UserID, string(items), timeValue(cart.UpdatedAt), cart.Version+1))
+	}
+	if errors.Is(err, ErrNotFound) {
+		current, err := r.Get(ctx, cart.UserID)
+		if err != nil {
+			return Cart{}, err
+		}
+		return Cart{}, &VersionConflictError{Entity: "cart", ID: cart.UserID, ExpectedVersion: cart.Version, CurrentVersion: current.Version}
+	}
+	if err != nil {
+		return Cart{}, err
+	}
+	cart.Version++
+	return cart, nil
+}
+
+// Categories
+
+type sqliteCategories struct{ db sqlConn }
+
+const categoryColumns = `id, name, description, parent_id, deleted_at, deleted_by, version`
+
+func scanCategory(rows *sql.Rows) (Category, error) {
+	var c Category
+	err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.ParentID, nullTime{&c.DeletedAt}, &c.DeletedBy, &c.Version)
+	return c, err
+}
+
+func (r sqliteCategories) Get(ctx context.Context, id int) (Category, error) {
+	return queryOne(ctx, r.db, scanCategory, `SELECT `+categoryColumns+` FROM categories WHERE id = ?`, id)
+}
+
+func (r sqliteCategories) List(ctx context.Context) ([]Category, error) {
+	return queryAll(ctx, r.db, scanCategory, `SELECT `+categoryColumns+` FROM categories ORDER BY id`)
+}
+
+func (r sqliteCategories) GetMany(ctx context.Context, ids []int) ([]Category, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inList(ids)
+	return queryAll(ctx, r.db, scanCategory, `SELECT `+categoryColumns+` FROM categories WHERE id IN (`+placeholders+`) ORDER BY id`, args...)
+}
+
+func (r sqliteCategories) Create(ctx context.Context, category Category) (Category, error) {
+	category.Version = initialVersion(category.Version)
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		if err := checkLiveParent(ctx, db, category); err != nil {
+			return err
+		}
+		res, err := db.ExecContext(ctx, `INSERT INTO categories (`+categoryColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			nullableID(category.ID), category.Name, category.Description, category.ParentID,
+			timeValue(category.DeletedAt), category.DeletedBy, category.Version)
+		if err != nil {
+			return err
+		}
+		category.ID, err = insertedID(res)
+		return err
+	})
+	if err != nil {
+		return Category{}, err
+	}
+	return category, nil
+}
+
+func (r sqliteCategories) Update(ctx context.Context, category Category) (Category, error) {
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		if err := checkLiveParent(ctx, db, category); err != nil {
+			return err
+		}
+		return versionedUpdate(ctx, db, "categories", "category", category.ID, category.Version,
+			`UPDATE categories SET name = ?, description = ?, parent_id = ?, deleted_at = ?, deleted_by = ?, version = version + 1
+			WHERE id = ? AND version = ?`,
+			category.Name, category.Description, category.ParentID, timeValue(category.DeletedAt), category.DeletedBy,
+			category.ID, category.Version)
+	})
+	if err != nil {
+		return Category{}, err
+	}
+	category.Version++
+	return category, nil
+}
+
+func (r sqliteCategories) Move(ctx context.Context, id int, parentID *int) (Category, error) {
+	var category Category
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		categories, err := sqliteCategories{db}.List(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkCategoryMove(categories, id, parentID); err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE categories SET parent_id = ?, version = version + 1 WHERE id = ?`, parentID, id); err != nil {
+			return err
+		}
+		category, err = sqliteCategories{db}.Get(ctx, id)
+		return err
+	})
+	return category, err
+}
+
+func (r sqliteCategories) Delete(ctx context.Context, id int, deletedAt time.Time, deletedBy int) (Category, error) {
+	var category Category
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		categories, err := sqliteCategories{db}.List(ctx)
+		if err != nil {
+			return err
+		}
+		products, err := queryOne(ctx, db, func(rows *sql.Rows) (int, error) {
+			var n int
+			err := rows.Scan(&n)
+			return n, err
+		}, `SELECT COUNT(*) FROM products WHERE category_id = ? AND deleted_at IS NULL`, id)
+		if err != nil {
+			return err
+		}
+		if err := checkCategoryDelete(categories, id, products); err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE categories SET deleted_at = ?, deleted_by = ?, version = version + 1 WHERE id = ?`,
+			timeValue(&deletedAt), deletedBy, id); err != nil {
+			return err
+		}
+		category, err = sqliteCategories{db}.Get(ctx, id)
+		return err
+	})
+	return category, err
+}
+
+// categoryDeleted reports whether category id exists and is deleted.
+func categoryDeleted(ctx context.Context, db sqlConn, id int) (bool, error) {
+	c, err := sqliteCategories{db}.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil && c.isDeleted(), err
+}
+
+// checkLiveParent returns a *CategoryNotFoundError if category is not
+// deleted but its parent is. A parent that does not exist yet is allowed,
+// so snapshots can be restored in ID order.
+func checkLiveParent(ctx context.Context, db sqlConn, category Category) error {
+	if category.ParentID == nil || category.isDeleted() {
+		return nil
+	}
+	deleted, err := categoryDeleted(ctx, db, *category.ParentID)
+	if err == nil && deleted {
+		err = &CategoryNotFoundError{ID: *category.ParentID}
+	}
+	return err
+}
+
+// Reviews
+
+type sqliteReviews struct{ db sqlConn }
+
+const reviewColumns = `id, product_id, user_id, rating, comment, created_at, deleted_at, deleted_by,
+	status, updated_at, moderated_at, moderated_by, moderation_note`
+
+func scanReview(rows *sql.Rows) (Review, error) {
+	var r Review
+	err := rows.Scan(&r.ID, &r.ProductID, &r.UserID, &r.Rating, &r.Comment, &r.CreatedAt, nullTime{&r.DeletedAt}, &r.DeletedBy,
+		&r.Status, nullTime{&r.UpdatedAt}, nullTime{&r.ModeratedAt}, &r.ModeratedBy, &r.ModerationNote)
+	return r, err
+}
+
+func (r sqliteReviews) Get(ctx context.Context, id int) (Review, error) {
+	return queryOne(ctx, r.db, scanReview, `SELECT `+reviewColumns+` FROM reviews WHERE id = ?`, id)
+}
+
+func (r sqliteReviews) List(ctx context.Context) ([]Review, error) {
+	return queryAll(ctx, r.db, scanReview, `SELECT `+reviewColumns+` FROM reviews ORDER BY id`)
+}
+
+func (r sqliteReviews) ListByProduct(ctx context.Context, productID int) ([]Review, error) {
+	return queryAll(ctx, r.db, scanReview, `SELECT `+reviewColumns+` FROM reviews WHERE product_id = ? ORDER BY id`, productID)
+}
+
+func (r sqliteReviews) ListByUser(ctx context.Context, userID int) ([]Review, error) {
+	return queryAll(ctx, r.db, scanReview, `SELECT `+reviewColumns+` FROM reviews WHERE user_id = ? ORDER BY id`, userID)
+}
+
+func (r sqliteReviews) ListByProducts(ctx context.Context, productIDs []int) ([]Review, error) {
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inList(productIDs)
+	return queryAll(ctx, r.db, scanReview, `SELECT `+reviewColumns+` FROM reviews WHERE product_id IN (`+placeholders+`) ORDER BY id`, args...)
+}
+
+func (r sqliteReviews) ListByUsers(ctx context.Context, userIDs []int) ([]Review, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inList(userIDs)
+	return queryAll(ctx, r.db, scanReview, `SELECT `+reviewColumns+` FROM reviews WHERE user_id IN (`+placeholders+`) ORDER BY id`, args...)
+}
+
+func (r sqliteReviews) Create(ctx context.Context, review Review) (Review, error) {
+	review.Status = initialReviewStatus(review.Status)
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		if err := duplicateReview(ctx, db, review); err != nil {
+			return err
+		}
+		res, err := db.ExecContext(ctx, `INSERT INTO reviews (`+reviewColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			nullableID(review.ID), review.ProductID, review.UserID, review.Rating, review.Comment, review.CreatedAt,
+			timeValue(review.DeletedAt), review.DeletedBy,
+			review.Status, timeValue(review.UpdatedAt), timeValue(review.ModeratedAt), review.ModeratedBy, review.ModerationNote)
+		if err != nil {
+			return err
+		}
+		if review.ID, err = insertedID(res); err != nil {
+			return err
+		}
+		return updateRatings(sqliteRatings{db}.ratingStore(ctx), nil, review)
+	})
+	if err != nil {
+		return Review{}, err
+	}
+	return review, nil
+}
+
+func (r sqliteReviews) Update(ctx context.Context, review Review) (Review, error) {
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		old, err := sqliteReviews{db}.Get(ctx, review.ID)
+		if err != nil {
+			return err
+		}
+		if err := duplicateReview(ctx, db, review); err != nil {
+			return err
+		}
+		err = expectUpdated(db.ExecContext(ctx,
+			`UPDATE reviews SET product_id = ?, user_id = ?, rating = ?, comment = ?, created_at = ?, deleted_at = ?, deleted_by = ?,
+			status = ?, updated_at = ?, moderated_at = ?, moderated_by = ?, moderation_note = ? WHERE id = ?`,
+			review.ProductID, review.UserID, review.Rating, review.Comment, review.CreatedAt,
+			timeValue(review.DeletedAt), review.DeletedBy,
+			review.Status, timeValue(review.UpdatedAt), timeValue(review.ModeratedAt), review.ModeratedBy, review.ModerationNote, review.ID))
+		if err != nil {
+			return err
+		}
+		return updateRatings(sqliteRatings{db}.ratingStore(ctx), &old, review)
+	})
+	if err != nil {
+		return Review{}, err
+	}
+	return review, nil
+}
+
+// duplicateReview returns a *DuplicateReviewError if review is not deleted
+// and its user has another review of its product that is not deleted
+// either. The unique index on reviews backs it up.
+func duplicateReview(ctx context.Context, db sqlConn, review Review) error {
+	if review.isDeleted() {
+		return nil
+	}
+	id, err := queryOne(ctx, db, func(rows *sql.Rows) (int, error) {
+		var id int
+		err := rows.Scan(&id)
+		return id, err
+	}, `SELECT id FROM reviews WHERE product_id = ? AND user_id = ? AND deleted_at IS NULL AND id != ? LIMIT 1`,
+		review.ProductID, review.UserID, review.ID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return &DuplicateReviewError{ProductID: review.ProductID, UserID: review.UserID, ReviewID: id}
+}
+
+// Rating summaries
+
+type sqliteRatings struct{ db sqlConn }
+
+const ratingColumns = `product_id, review_count, rating_total, stars_1, stars_2, stars_3, stars_4, stars_5, last_review_at`
+
+func scanRating(rows *sql.Rows) (RatingSummary, error) {
+	var s RatingSummary
+	err := rows.Scan(&s.ProductID, &s.Count, &s.Total, &s.Stars[0], &s.Stars[1], &s.Stars[2], &s.Stars[3], &s.Stars[4],
+		nullTime{&s.LastReviewAt})
+	return s, err
+}
+
+func (r sqliteRatings) Get(ctx context.Context, productID int) (RatingSummary, error) {
+	summary, err := queryOne(ctx, r.db, scanRating, `SELECT `+ratingColumns+` FROM product_ratings WHERE product_id = ?`, productID)
+	if errors.Is(err, ErrNotFound) {
+		return RatingSummary{ProductID: productID}, nil
+	}
+	return summary, err
+}
+
+func (r sqliteRatings) List(ctx context.Context) ([]RatingSummary, error) {
+	return queryAll(ctx, r.db, scanRating, `SELECT `+ratingColumns+` FROM product_ratings WHERE review_count > 0 ORDER BY product_id`)
+}
+
+func (r sqliteRatings) ListByProducts(ctx context.Context, productIDs []int) ([]RatingSummary, error) {
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inList(productIDs)
+	return queryAll(ctx, r.db, scanRating, `SELECT `+ratingColumns+` FROM product_ratings
+		WHERE review_count > 0 AND product_id IN (`+placeholders+`) ORDER BY product_id`, args...)
+}
+
+// ratingStore works on the summaries over db, which the review
+// repositories call with their transaction.
+func (r sqliteRatings) ratingStore(ctx context.Context) ratingStore {
+	return ratingStore{
+		get: func(productID int) (RatingSummary, error) { return r.Get(ctx, productID) },
+		put: func(s RatingSummary) error {
+			_, err := r.db.ExecContext(ctx, `INSERT OR REPLACE INTO product_ratings (`+ratingColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				s.ProductID, s.Count, s.Total, s.Stars[0], s.Stars[1], s.Stars[2], s.Stars[3], s.Stars[4], timeValue(s.LastReviewAt))
+			return err
+		},
+		reviews: func(productID int) ([]Review, error) { return sqliteReviews{r.db}.ListByProduct(ctx, productID) },
+	}
+}
+
+// Users
+
+type sqliteUsers struct{ db sqlConn }
+
+const userColumns = `id, username, email, role`
+
+func scanUser(rows *sql.Rows) (User, error) {
+	var u User
+	err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Role)
+	return u, err
+}
+
+func (r sqliteUsers) Get(ctx context.Context, id int) (User, error) {
+	return queryOne(ctx, r.db, scanUser, `SELECT `+userColumns+` FROM users WHERE id = ?`, id)
+}
+
+func (r sqliteUsers) List(ctx context.Context) ([]User, error) {
+	return queryAll(ctx, r.db, scanUser, `SELECT `+userColumns+` FROM users ORDER BY id`)
+}
+
+func (r sqliteUsers) GetMany(ctx context.Context, ids []int) ([]User, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inList(ids)
+	return queryAll(ctx, r.db, scanUser, `SELECT `+userColumns+` FROM users WHERE id IN (`+placeholders+`) ORDER BY id`, args...)
+}
+
+func (r sqliteUsers) Create(ctx context.Context, user User) (User, error) {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?)`,
+		nullableID(user.ID), user.Username, user.Email, user.Role)
+	if err != nil {
+		return User{}, err
+	}
+	user.ID, err = insertedID(res)
+	return user, err
+}
+
+// Employees are stored in a single table; the type column decides whether a
+// row is rebuilt as a *Developer or a *Manager.
+
+type sqliteEmployees struct{ db sqlConn }
+
+const employeeColumns = `id, type, name, email, salary, hire_date, programming_languages, github_username, department, manager_id, deleted_at, deleted_by, version`
+
+func scanEmployee(rows *sql.Rows) (*Employee, error) {
+	var (
+		r          EmployeeRecord
+		languages  sql.NullString
+		github     sql.NullString
+		department sql.NullString
+	)
+	if err := rows.Scan(&r.ID, &r.Type, &r.Name, &r.Email, &r.Salary, &r.HireDate, &languages, &github, &department, &r.ManagerID,
+		nullTime{&r.DeletedAt}, &r.DeletedBy, &r.Version); err != nil {
+		return nil, err
+	}
+
+	if languages.Valid {
+		if err := json.Unmarshal([]byte(languages.String), &r.ProgrammingLanguages); err != nil {
+			return nil, fmt.Errorf("employee %d has invalid programming languages: %w", r.ID, err)
+		}
+	}
+	if github.Valid {
+		r.GithubUsername = &github.String
+	}
+	r.Department = department.String
+	return r.Employee()
+}
+
+// employeeValues returns the column values for employee in employeeColumns order.
+func employeeValues(employee *Employee) ([]interface{}, error) {
+	switch e := employee.ActualType().(type) {
+	case *Developer:
+		languages, err := json.Marshal(e.ProgrammingLanguages)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{nullableID(e.ID), EmployeeTypeDeveloper, e.Name, e.Email, e.Salary, e.HireDate,
+			string(languages), e.GithubUsername, nil, e.ManagerID, timeValue(e.DeletedAt), e.DeletedBy, initialVersion(e.Version)}, nil
+	case *Manager:
+		return []interface{}{nullableID(e.ID), EmployeeTypeManager, e.Name, e.Email, e.Salary, e.HireDate,
+			nil, nil, e.Department, e.ManagerID, timeValue(e.DeletedAt), e.DeletedBy, initialVersion(e.Version)}, nil
+	default:
+		return nil, errors.New("employee must be a Developer or a Manager")
+	}
+}
+
+func (r sqliteEmployees) Get(ctx context.Context, id int) (*Employee, error) {
+	return queryOne(ctx, r.db, scanEmployee, `SELECT `+employeeColumns+` FROM employees WHERE id = ?`, id)
+}
+
+func (r sqliteEmployees) List(ctx context.Context) ([]*Employee, error) {
+	return queryAll(ctx, r.db, scanEmployee, `SELECT `+employeeColumns+` FROM employees ORDER BY id`)
+}
+
+func (r sqliteEmployees) GetMany(ctx context.Context, ids []int) ([]*Employee, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inList(ids)
+	return queryAll(ctx, r.db, scanEmployee, `SELECT `+employeeColumns+` FROM employees WHERE id IN (`+placeholders+`) ORDER BY id`, args...)
+}
+
+func (r sqliteEmployees) ListByManagers(ctx context.Context, managerIDs []int) ([]*Employee, error) {
+	if len(managerIDs) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inList(managerIDs)
+	return queryAll(ctx, r.db, scanEmployee, `SELECT `+employeeColumns+` FROM employees WHERE manager_id IN (`+placeholders+`) ORDER BY id`, args...)
+}
+
+func (r sqliteEmployees) Create(ctx context.Context, employee *Employee) (*Employee, error) {
+	values, err := employeeValues(employee)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.db.ExecContext(ctx, `INSERT INTO employees (`+employeeColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, values...)
+	if err != nil {
+		return nil, err
+	}
+	id, err := insertedID(res)
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, id)
+}
+
+func (r sqliteEmployees) Update(ctx context.Context, employee *Employee) (*Employee, error) {
+	values, err := employeeValues(employee)
+	if err != nil {
+		return nil, err
+	}
+	// Drop the leading ID and trailing version; both go to the WHERE clause
+	args := append(values[1:len(values)-1], employee.ID, employee.Version)
+	err = versionedUpdate(ctx, r.db, "employees", "employee", employee.ID, employee.Version,
+		`UPDATE employees SET type = ?, name = ?, email = ?, salary = ?, hire_date = ?, programming_languages = ?,
+		github_username = ?, department = ?, manager_id = ?, deleted_at = ?, deleted_by = ?, version = version + 1
+		WHERE id = ? AND version = ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, employee.ID)
+}
+
+func (r sqliteEmployees) AssignManager(ctx context.Context, id int, managerID *int, version int) (*Employee, error) {
+	var emp *Employee
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		employees, err := sqliteEmployees{db}.List(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkManagerAssignment(employees, id, managerID); err != nil {
+			return err
+		}
+		err = versionedUpdate(ctx, db, "employees", "employee", id, version,
+			`UPDATE employees SET manager_id = ?, version = version + 1 WHERE id = ? AND version = ?`, managerID, id, version)
+		if err != nil {
+			return err
+		}
+		emp, err = sqliteEmployees{db}.Get(ctx, id)
+		return err
+	})
+	return emp, err
+}
+
+func (r sqliteEmployees) ReassignReports(ctx context.Context, fromManagerID int, toManagerID *int) ([]*Employee, error) {
+	moved := []*Employee{}
+	err := inConnTx(ctx, r.db, func(db sqlConn) error {
+		employees, err := sqliteEmployees{db}.List(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkReportsReassignment(employees, fromManagerID, toManagerID); err != nil {
+			return err
+		}
+		var ids []int
+		for _, e := range employees {
+			if e.ManagerID != nil && *e.ManagerID == fromManagerID {
+				ids = append(ids, e.ID)
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE employees SET manager_id = ?, version = version + 1 WHERE manager_id = ?`,
+			toManagerID, fromManagerID); err != nil {
+			return err
+		}
+		moved, err = sqliteEmployees{db}.GetMany(ctx, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return moved, nil
+}
+
+// Audit entries store their time as Unix nanoseconds so that range filters
+// compare numbers, and their changes as a JSON array.
+
+type sqliteAudit struct{ db sqlConn }
+
+const auditColumns = `id, time, user_id, operation, query, variables, entity, entity_id, action, changes`
+
+func scanAuditEntry(rows *sql.Rows) (AuditEntry, error) {
+	var (
+		e       AuditEntry
+		nanos   int64
+		changes string
+	)
+	if err := rows.Scan(&e.ID, &nanos, &e.UserID, &e.Operation, &e.Query, &e.Variables, &e.Entity, &e.EntityID, &e.Action, &changes); err != nil {
+		return AuditEntry{}, err
+	}
+	e.Time = time.Unix(0, nanos).UTC()
+	if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+		return AuditEntry{}, fmt.Errorf("audit entry %d has invalid changes: %w", e.ID, err)
+	}
+	return e, nil
+}
+
+func (r sqliteAudit) Append(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	res, err := r.db.ExecContext(ctx, `INSERT INTO audit_log (`+auditColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nil, entry.Time.UnixNano(), entry.UserID, entry.Operation, entry.Query, entry.Variables, entry.Entity, entry.EntityID, entry.Action,
+		string(changes))
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	entry.ID, err = insertedID(res)
+	return entry, err
+}
+
+func (r sqliteAudit) List(ctx context.Context, filter AuditFilter, before, limit int) ([]AuditEntry, error) {
+	query := `SELECT ` + auditColumns + ` FROM audit_log WHERE 1 = 1`
+	var args []interface{}
+	if before != 0 {
+		query += ` AND id < ?`
+		args = append(args, before)
+	}
+	if filter.Entity != nil {
+		query += ` AND entity = ?`
+		args = append(args, *filter.Entity)
+	}
+	if filter.EntityID != nil {
+		query += ` AND entity_id = ?`
+		args = append(args, *filter.EntityID)
+	}
+	if filter.UserID != nil {
+		query += ` AND user_id = ?`
+		args = append(args, *filter.UserID)
+	}
+	if filter.Since != nil {
+		query += ` AND time >= ?`
+		args = append(args, filter.Since.UnixNano())
+	}
+	if filter.Until != nil {
+		query += ` AND time < ?`
+		args = append(args, filter.Until.UnixNano())
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+	return queryAll(ctx, r.db, scanAuditEntry, query, args...)
+}
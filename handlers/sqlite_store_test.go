@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gburgyan/go-quickgraph"
+)
+
+func openTestSQLiteStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	store, err := OpenSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteStoreServesSampleQueries(t *testing.T) {
+	ctx := context.Background()
+	store := openTestSQLiteStore(t)
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	graph := newTestGraph(ctx, store)
+
+	res, err := graph.ProcessRequest(ctx, `{ GetProduct(id: 1) { Name Category { Name } Reviews(page: {}) { edges { node { User { Username } } } } AverageRating } }`, "")
+	if err != nil {
+		t.Fatalf("GetProduct failed: %v", err)
+	}
+	for _, want := range []string{`"Electronics"`, `"john_customer"`, `4.5`} {
+		if !strings.Contains(res, want) {
+			t.Errorf("expected %s in response, got %s", want, res)
+		}
+	}
+
+	res, err = graph.ProcessRequest(ctx, `{ GetManagers { edges { node { Name Reports(page: {}) { edges { node { Name } } } } } } }`, "")
+	if err != nil {
+		t.Fatalf("GetManagers failed: %v", err)
+	}
+	if !strings.Contains(res, `"Bob Wilson"`) {
+		t.Errorf("expected developers as reports, got %s", res)
+	}
+}
+
+func TestSQLiteStorePersistsAcrossReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "persist.db")
+
+	store, err := OpenSQLiteStore(ctx, path)
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore failed: %v", err)
+	}
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	admin, err := store.Users().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get admin failed: %v", err)
+	}
+	if _, err := NewEmployeeHandlers(store).PromoteToManager(context.WithValue(ctx, UserContextKey, &admin), 1, "Platform", 1); err != nil {
+		t.Fatalf("PromoteToManager failed: %v", err)
+	}
+	store.Close()
+
+	// Reopening runs the migrations again, which must be a no-op
+	store, err = OpenSQLiteStore(ctx, path)
+	if err != nil {
+		t.Fatalf("reopening failed: %v", err)
+	}
+	defer store.Close()
+
+	if err := SeedSampleDataIfEmpty(ctx, store); err != nil {
+		t.Fatalf("SeedSampleDataIfEmpty failed: %v", err)
+	}
+	categories, _ := store.Categories().List(ctx)
+	if len(categories) != 3 {
+		t.Errorf("expected the seed to run only once, got %d categories", len(categories))
+	}
+
+	emp, err := store.Employees().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	mgr, ok := quickgraph.Discover[*Manager](emp)
+	if !ok || mgr.Department != "Platform" {
+		t.Errorf("expected employee 1 to be a Platform manager, got %#v", emp.ActualType())
+	}
+}
+
+func TestSQLiteMigratesOrderTotalsToMoney(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "orders.db")
+
+	// A database from before order totals were Money
+	all := sqliteMigrations
+	sqliteMigrations = all[:16]
+	store, err := OpenSQLiteStore(ctx, path)
+	sqliteMigrations = all
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore failed: %v", err)
+	}
+	// Raw SQL, since the repositories expect the current schema
+	if _, err := store.db.ExecContext(ctx, `INSERT INTO users (id, username, email, role) VALUES (2, 'john_customer', 'john@example.com', 'customer')`); err != nil {
+		t.Fatalf("inserting a user failed: %v", err)
+	}
+	if _, err := store.db.ExecContext(ctx, `INSERT INTO orders (user_id, items, total, status, placed_at, version)
+		VALUES (2, '[{"ProductID":4,"Quantity":3,"UnitPrice":699.99}]', 2099.97, 'PLACED', '2024-01-01T00:00:00Z', 1)`); err != nil {
+		t.Fatalf("inserting an old order failed: %v", err)
+	}
+	store.Close()
+
+	store, err = OpenSQLiteStore(ctx, path)
+	if err != nil {
+		t.Fatalf("migrating failed: %v", err)
+	}
+	defer store.Close()
+	order, err := store.Orders().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get order failed: %v", err)
+	}
+	want := Money{Amount: 209997, Currency: "USD"}
+	if order.Total != want || order.Items[0].UnitPrice != (Money{Amount: 69999, Currency: "USD"}) {
+		t.Errorf("expected a total of %v at 699.99 USD each, got %v at %v", want, order.Total, order.Items[0].UnitPrice)
+	}
+}
+
+func TestSQLiteMigratesDuplicateReviews(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "reviews.db")
+
+	// A database from before reviews were unique per user and product
+	all := sqliteMigrations
+	sqliteMigrations = all[:18]
+	store, err := OpenSQLiteStore(ctx, path)
+	sqliteMigrations = all
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore failed: %v", err)
+	}
+	// Raw SQL, since the repositories expect the current schema. User 2
+	// reviews product 1 twice.
+	for _, insert := range []string{
+		`INSERT INTO categories (id, name) VALUES (1, 'Electronics')`,
+		`INSERT INTO products (id, name, description, price_amount, status, category_id, in_stock) VALUES (1, 'Laptop', '', 99999, 'ACTIVE', 1, 1)`,
+		`INSERT INTO users (id, username, email, role) VALUES (2, 'john_customer', 'john@example.com', 'customer'), (3, 'jane_customer', 'jane@example.com', 'customer')`,
+		`INSERT INTO reviews (product_id, user_id, rating, comment, created_at) VALUES
+			(1, 2, 5, 'Excellent', '2024-01-15T10:00:00Z'),
+			(1, 3, 4, 'Good', '2024-01-16T14:30:00Z'),
+			(1, 2, 1, 'Broke', '2024-02-01T00:00:00Z')`,
+	} {
+		if _, err := store.db.ExecContext(ctx, insert); err != nil {
+			t.Fatalf("%s failed: %v", insert, err)
+		}
+	}
+	store.Close()
+
+	store, err = OpenSQLiteStore(ctx, path)
+	if err != nil {
+		t.Fatalf("migrating failed: %v", err)
+	}
+	defer store.Close()
+	if review, err := store.Reviews().Get(ctx, 3); err != nil || !review.isDeleted() {
+		t.Errorf("expected the later review to be deleted, got %+v, %v", review, err)
+	}
+	if summary, err := store.RatingSummaries().Get(ctx, 1); err != nil || summary.Count != 2 || summary.Total != 9 {
+		t.Errorf("expected the deleted review to leave the rating, got %+v, %v", summary, err)
+	}
+	_, err = store.Reviews().Create(ctx, Review{ProductID: 1, UserID: 2, Rating: 3})
+	var duplicate *DuplicateReviewError
+	if !errors.As(err, &duplicate) || duplicate.ReviewID != 1 {
+		t.Errorf("expected a duplicate of review 1, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gburgyan/go-quickgraph"
+)
+
+// Widget stock is tracked in a ledger: every change to a widget's quantity
+// appends a StockEntry saying by how much, why and who changed it, so the
+// history of a widget can be reconstructed. AdjustWidgetStock is the way to
+// move stock; UpdateWidget still accepts a quantity and records the
+// difference as a ledger entry.
+
+// StockEntry is one change to the quantity of a widget.
+type StockEntry struct {
+	ID            int       `json:"id"`
+	WidgetID      int       `json:"widgetId"`
+	Delta         int       `json:"delta"`         // Negative when stock was taken out
+	QuantityAfter int       `json:"quantityAfter"` // Quantity once the change was applied
+	Reason        string    `json:"reason"`
+	UserID        *int      `json:"userId"` // Acting user, if authenticated
+	Time          time.Time `json:"time"`
+}
+
+// InsufficientStockError is returned by WidgetRepository.AdjustStock when
+// taking out more stock than a widget has.
+type InsufficientStockError struct {
+	WidgetID int
+	Quantity int // Quantity in stock
+	Delta    int // Requested change
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("widget %d has %d in stock, cannot remove %d", e.WidgetID, e.Quantity, -e.Delta)
+}
+
+// As lets quickgraph report the error with a machine-readable code and the
+// quantity in stock.
+func (e *InsufficientStockError) As(target interface{}) bool {
+	ge, ok := target.(*quickgraph.GraphError)
+	if !ok {
+		return false
+	}
+	*ge = quickgraph.GraphError{
+		Message:    e.Error(),
+		InnerError: e,
+		Extensions: map[string]string{
+			"code":     "INSUFFICIENT_STOCK",
+			"widgetId": strconv.Itoa(e.WidgetID),
+			"quantity": strconv.Itoa(e.Quantity),
+		},
+	}
+	return true
+}
+
+// LowStockAlert is sent when a widget drops to or below its reorder
+// threshold.
+type LowStockAlert struct {
+	Widget           Widget    `json:"widget"`
+	Quantity         int       `json:"quantity"`
+	PreviousQuantity int       `json:"previousQuantity"`
+	Threshold        int       `json:"threshold"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+// lowStock reports whether quantity is at or below threshold. Widgets
+// without a threshold are never low on stock.
+func lowStock(quantity int, threshold *int) bool {
+	return threshold != nil && quantity <= *threshold
+}
+
+// alertIfLowStock broadcasts a LowStockAlert if widget has just crossed its
+// reorder threshold, i.e. it was not low on stock before the change.
+func (e *Events) alertIfLowStock(ctx context.Context, wasLow bool, previousQuantity int, widget Widget) {
+	if wasLow || !lowStock(widget.Quantity, widget.ReorderThreshold) {
+		return
+	}
+	e.BroadcastLowStockAlert(ctx, LowStockAlert{
+		Widget:           widget,
+		Quantity:         widget.Quantity,
+		PreviousQuantity: previousQuantity,
+		Threshold:        *widget.ReorderThreshold,
+		Timestamp:        time.Now(),
+	})
+}
+
+// newStockEntry returns a ledger entry for a change made by the user in ctx.
+func newStockEntry(ctx context.Context, widgetID, delta int, reason string) StockEntry {
+	entry := StockEntry{WidgetID: widgetID, Delta: delta, Reason: reason, Time: time.Now().UTC()}
+	if user, ok := ctx.Value(UserContextKey).(*User); ok && user != nil {
+		entry.UserID = &user.ID
+	}
+	return entry
+}
+
+// recordStockChange appends a ledger entry for a quantity change that was
+// already applied to widget, and alerts if it crossed its threshold.
+func (h *WidgetHandlers) recordStockChange(ctx context.Context, before, widget Widget, reason string) error {
+	if widget.Quantity != before.Quantity {
+		entry := newStockEntry(ctx, widget.ID, widget.Quantity-before.Quantity, reason)
+		entry.QuantityAfter = widget.Quantity
+		if _, err := h.store.StockLedger().Append(ctx, entry); err != nil {
+			return err
+		}
+	}
+	h.store.Events().alertIfLowStock(ctx, lowStock(before.Quantity, before.ReorderThreshold), before.Quantity, widget)
+	return nil
+}
+
+// AdjustWidgetStock adds delta, which is negative to take stock out, to the
+// quantity of a widget and records the change in its stock ledger. It fails
+// without changing anything if the quantity would become negative.
+// Requires an authenticated user.
+func (h *WidgetHandlers) AdjustWidgetStock(ctx context.Context, widgetId int, delta int, reason string) (Widget, error) {
+	if _, err := requireUser(ctx); err != nil {
+		return Widget{}, err
+	}
+	if delta == 0 {
+		return Widget{}, errors.New("delta must not be zero")
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return Widget{}, errors.New("reason is required")
+	}
+
+	stored, err := h.store.Widgets().Get(ctx, widgetId)
+	if errors.Is(err, ErrNotFound) || (err == nil && stored.isDeleted()) {
+		return Widget{}, errors.New("widget not found")
+	}
+	if err != nil {
+		return Widget{}, err
+	}
+
+	widget, entry, err := h.store.Widgets().AdjustStock(ctx, newStockEntry(ctx, widgetId, delta, reason))
+	if errors.Is(err, ErrNotFound) {
+		return Widget{}, errors.New("widget not found")
+	}
+	if err != nil {
+		return Widget{}, err
+	}
+
+	h.store.Events().BroadcastWidgetUpdate(ctx, widget, "updated")
+	// The ledger entry tells the quantity the adjustment was applied to,
+	// even if another adjustment got in after stored was read.
+	previous := entry.QuantityAfter - entry.Delta
+	h.store.Events().alertIfLowStock(ctx, lowStock(previous, widget.ReorderThreshold), previous, widget)
+
+	widget.bind(newScope(ctx, h.store))
+	return widget, nil
+}
+
+// SetWidgetReorderThreshold sets the quantity at or below which a widget
+// needs reordering and lowStockAlerts fires. A null threshold disables
+// alerts for the widget. Requires an authenticated user.
+func (h *WidgetHandlers) SetWidgetReorderThreshold(ctx context.Context, widgetId int, threshold *int) (Widget, error) {
+	if _, err := requireUser(ctx); err != nil {
+		return Widget{}, err
+	}
+	if threshold != nil && *threshold < 0 {
+		return Widget{}, errors.New("threshold cannot be negative")
+	}
+
+	widget, err := h.store.Widgets().Get(ctx, widgetId)
+	if errors.Is(err, ErrNotFound) || (err == nil && widget.isDeleted()) {
+		return Widget{}, errors.New("widget not found")
+	}
+	if err != nil {
+		return Widget{}, err
+	}
+
+	wasLow := lowStock(widget.Quantity, widget.ReorderThreshold)
+	widget.ReorderThreshold = threshold
+	widget, err = h.store.Widgets().Update(ctx, widget)
+	if err != nil {
+		return Widget{}, err
+	}
+
+	h.store.Events().BroadcastWidgetUpdate(ctx, widget, "updated")
+	h.store.Events().alertIfLowStock(ctx, wasLow, widget.Quantity, widget)
+
+	widget.bind(newScope(ctx, h.store))
+	return widget, nil
+}
+
+// StockHistory returns the stock ledger of the widget, oldest first.
+func (w *Widget) StockHistory() ([]StockEntry, error) {
+	if w.scope == nil {
+		return nil, errors.New("stock history is not available here")
+	}
+	return w.scope.store.StockLedger().ListByWidget(w.scope.ctx, w.ID)
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdjustWidgetStock(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		customerCtx := userContext(t, store, 2)
+
+		// GraphQL literals cannot be negative, so deltas go in variables
+		adjust := `mutation Adjust($delta: Int!) {
+			AdjustWidgetStock(widgetId: 1, delta: $delta, reason: "sold") { quantity version }
+		}`
+		run := func(ctx context.Context, request, variables string) string {
+			res, _ := graph.ProcessRequest(ctx, request, variables)
+			return res
+		}
+
+		if res := run(ctx, adjust, `{"delta": -4}`); !strings.Contains(res, "authentication required") {
+			t.Errorf("expected anonymous adjustments to be rejected, got %s", res)
+		}
+		if res := run(customerCtx, adjust, `{"delta": -4}`); !strings.Contains(res, `"quantity":6,"version":2`) {
+			t.Fatalf("expected 4 to be taken out, got %s", res)
+		}
+		res := run(customerCtx, adjust, `{"delta": -7}`)
+		if !strings.Contains(res, "widget 1 has 6 in stock, cannot remove 7") || !strings.Contains(res, `"code":"INSUFFICIENT_STOCK"`) {
+			t.Errorf("expected going negative to be rejected, got %s", res)
+		}
+
+		run(customerCtx, `mutation { UpdateWidget(widget: {id: 1, name: "Widget 1", price: 1, quantity: 8, version: 2}) { id } }`, "")
+		res = run(customerCtx, `{ GetWidget(id: 1) { quantity stockHistory { delta quantityAfter reason userId } } }`, "")
+		want := `"quantity":8,"stockHistory":[` +
+			`{"delta":-4,"quantityAfter":6,"reason":"sold","userId":2},` +
+			`{"delta":2,"quantityAfter":8,"reason":"set by UpdateWidget","userId":2}]`
+		if !strings.Contains(res, want) {
+			t.Errorf("expected the ledger %s, got %s", want, res)
+		}
+
+		// Widgets found by Search resolve their ledger too
+		RegisterSearchHandlers(ctx, graph, store)
+		res = run(ctx, `{ Search(query: "widget 1") { edges { node { ... on Widget { stockHistory { delta } } } } } }`, "")
+		if !strings.Contains(res, `"stockHistory":[{"delta":-4},{"delta":2}]`) {
+			t.Errorf("expected the ledger of the searched widget, got %s", res)
+		}
+	})
+}
+
+func TestConcurrentStockAdjustmentsNeverGoNegative(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+
+		// Widget 1 starts with 10 in stock
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		succeeded, insufficient := 0, 0
+		for i := 0; i < 15; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := store.Widgets().AdjustStock(ctx, StockEntry{WidgetID: 1, Delta: -1, Reason: "sold", Time: time.Now().UTC()})
+				var stockErr *InsufficientStockError
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err == nil:
+					succeeded++
+				case errors.As(err, &stockErr):
+					insufficient++
+				default:
+					t.Errorf("AdjustStock failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		widget, err := store.Widgets().Get(ctx, 1)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		ledger, err := store.StockLedger().ListByWidget(ctx, 1)
+		if err != nil {
+			t.Fatalf("ListByWidget failed: %v", err)
+		}
+		if succeeded != 10 || insufficient != 5 || widget.Quantity != 0 || len(ledger) != 10 {
+			t.Errorf("expected 10 adjustments to succeed down to 0, got %d ok, %d rejected, quantity %d, %d entries",
+				succeeded, insufficient, widget.Quantity, len(ledger))
+		}
+	})
+}
+
+func TestLowStockAlertsFireOnCrossingThreshold(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	graph := newTestGraph(ctx, store)
+
+	customer, err := store.Users().Get(ctx, 2)
+	if err != nil {
+		t.Fatalf("Get customer failed: %v", err)
+	}
+	customerCtx := context.WithValue(WithTenant(ctx, "stock-test"), UserContextKey, &customer)
+	alerts := NewSubscriptionHandlers(store).LowStockAlerts(WithTenant(ctx, "stock-test"), nil)
+
+	adjust := func(delta string) {
+		res, err := graph.ProcessRequest(customerCtx, `mutation Adjust($delta: Int!) {
+			AdjustWidgetStock(widgetId: 1, delta: $delta, reason: "sold") { id }
+		}`, `{"delta": `+delta+`}`)
+		if err != nil {
+			t.Fatalf("AdjustWidgetStock failed: %v %s", err, res)
+		}
+	}
+	expectAlert := func(want bool) *LowStockAlert {
+		select {
+		case alert := <-alerts:
+			if !want {
+				t.Errorf("unexpected alert %+v", alert)
+			}
+			return &alert
+		case <-time.After(50 * time.Millisecond):
+			if want {
+				t.Fatal("expected a low-stock alert")
+			}
+			return nil
+		}
+	}
+
+	// Widget 1 starts with 10 in stock and a reorder threshold of 3
+	adjust("-6")
+	expectAlert(false)
+	adjust("-1")
+	if alert := expectAlert(true); alert.Quantity != 3 || alert.PreviousQuantity != 4 || alert.Threshold != 3 {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+	adjust("-1") // Still below the threshold
+	expectAlert(false)
+	adjust("5")
+	adjust("-4") // Crosses again after recovering
+	expectAlert(true)
+
+	// Raising the threshold above the quantity also crosses it
+	adjust("10")
+	if _, err := graph.ProcessRequest(customerCtx, `mutation { SetWidgetReorderThreshold(widgetId: 1, threshold: 20) { id } }`, ""); err != nil {
+		t.Fatalf("SetWidgetReorderThreshold failed: %v", err)
+	}
+	expectAlert(true)
+}
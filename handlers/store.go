@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gburgyan/go-quickgraph"
+)
+
+// ErrNotFound is returned by repositories when no entity has the requested ID.
+var ErrNotFound = errors.New("not found")
+
+// VersionConflictError is returned by repository updates when the version
+// of the entity passed in no longer matches the stored version, i.e. someone
+// else updated it first. Clients should reload and retry.
+type VersionConflictError struct {
+	Entity          string
+	ID              int
+	ExpectedVersion int
+	CurrentVersion  int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s %d was modified concurrently: expected version %d but current version is %d",
+		e.Entity, e.ID, e.ExpectedVersion, e.CurrentVersion)
+}
+
+// As lets quickgraph report the conflict as a GraphQL error whose extensions
+// carry a machine-readable code and the current version.
+func (e *VersionConflictError) As(target interface{}) bool {
+	ge, ok := target.(*quickgraph.GraphError)
+	if !ok {
+		return false
+	}
+	*ge = quickgraph.GraphError{
+		Message:    e.Error(),
+		InnerError: e,
+		Extensions: map[string]string{
+			"code":           "VERSION_CONFLICT",
+			"entity":         e.Entity,
+			"id":             strconv.Itoa(e.ID),
+			"currentVersion": strconv.Itoa(e.CurrentVersion),
+		},
+	}
+	return true
+}
+
+// Store groups the per-entity repositories that back the GraphQL handlers.
+// Each Register*Handlers function receives a Store, so several independent
+// quickgraph.Graphy instances can run side by side in one process.
+// MemoryStore is the default implementation.
+type Store interface {
+	Widgets() WidgetRepository
+	Products() ProductRepository
+	Categories() CategoryRepository
+	Reviews() ReviewRepository
+	Users() UserRepository
+	Employees() EmployeeRepository
+	StockLedger() StockLedgerRepository
+	ProductStatusHistory() ProductStatusHistoryRepository
+	Orders() OrderRepository
+	Carts() CartRepository
+	ProductVariants() ProductVariantRepository
+	ProductPriceHistory() ProductPriceHistoryRepository
+	EmployeeRoleHistory() EmployeeRoleHistoryRepository
+	// RatingSummaries are kept up to date by Reviews and not part of
+	// snapshots.
+	RatingSummaries() RatingSummaryRepository
+	// Audit holds the audit log. It is not part of snapshots.
+	Audit() AuditRepository
+	// Events delivers the changes made through the handlers to the
+	// subscriptions of the store.
+	Events() *Events
+
+	// Snapshot returns a consistent copy of everything in the store.
+	Snapshot(ctx context.Context) (*Snapshot, error)
+	// Restore atomically replaces everything in the store with snapshot.
+	Restore(ctx context.Context, snapshot *Snapshot) error
+}
+
+// repositories is the part of Store that snapshotting is built on, so a
+// Store can snapshot a locked copy or an open transaction of itself.
+type repositories interface {
+	Widgets() WidgetRepository
+	Products() ProductRepository
+	Categories() CategoryRepository
+	Reviews() ReviewRepository
+	Users() UserRepository
+	Employees() EmployeeRepository
+	StockLedger() StockLedgerRepository
+	ProductStatusHistory() ProductStatusHistoryRepository
+	Orders() OrderRepository
+	Carts() CartRepository
+	ProductVariants() ProductVariantRepository
+	ProductPriceHistory() ProductPriceHistoryRepository
+	EmployeeRoleHistory() EmployeeRoleHistoryRepository
+}
+
+// initialReviewStatus is the status a newly created review gets unless it
+// already carries one. Reviews from before moderation count as approved.
+func initialReviewStatus(status ReviewStatus) ReviewStatus {
+	if status == "" {
+		return ReviewStatusApproved
+	}
+	return status
+}
+
+// initialVersion is the version a newly created entity gets unless it
+// already carries one, e.g. when restored from a snapshot.
+func initialVersion(version int) int {
+	if version == 0 {
+		return 1
+	}
+	return version
+}
+
+// WidgetRepository persists widgets.
+type WidgetRepository interface {
+	Get(ctx context.Context, id int) (Widget, error)
+	List(ctx context.Context) ([]Widget, error)
+	// Create stores a new widget. A zero ID is replaced with the next free ID
+	// and a zero version with 1.
+	Create(ctx context.Context, widget Widget) (Widget, error)
+	// Update replaces the stored widget if its version still equals
+	// widget.Version and returns it with the incremented version. Otherwise it
+	// returns a *VersionConflictError.
+	Update(ctx context.Context, widget Widget) (Widget, error)
+	// AdjustStock atomically adds entry.Delta to the quantity of widget
+	// entry.WidgetID, increments its version and appends entry, completed
+	// with the resulting quantity, to the stock ledger. If the quantity would
+	// become negative nothing changes and an *InsufficientStockError is
+	// returned. A zero entry ID is replaced with the next free ID.
+	AdjustStock(ctx context.Context, entry StockEntry) (Widget, StockEntry, error)
+}
+
+// ProductRepository persists products.
+type ProductRepository interface {
+	Get(ctx context.Context, id int) (Product, error)
+	List(ctx context.Context) ([]Product, error)
+	ListByCategory(ctx context.Context, categoryID int) ([]Product, error)
+	// Create stores a new product. A zero ID is replaced with the next free ID
+	// and a zero version with 1. A product that is not deleted cannot be
+	// created in a deleted category; Create returns a *CategoryNotFoundError
+	// instead.
+	Create(ctx context.Context, product Product) (Product, error)
+	// Update replaces the stored product if its version still equals
+	// product.Version and returns it with the incremented version. Otherwise it
+	// returns a *VersionConflictError.
+	Update(ctx context.Context, product Product) (Product, error)
+}
+
+// CategoryRepository persists product categories.
+type CategoryRepository interface {
+	Get(ctx context.Context, id int) (Category, error)
+	List(ctx context.Context) ([]Category, error)
+	// GetMany returns the categories with the given IDs, ordered by ID.
+	// Unknown IDs are skipped.
+	GetMany(ctx context.Context, ids []int) ([]Category, error)
+	// Create stores a new category. A zero ID is replaced with the next free ID
+	// and a zero version with 1. A category that is not deleted cannot be
+	// created below a deleted parent; Create returns a
+	// *CategoryNotFoundError instead.
+	Create(ctx context.Context, category Category) (Category, error)
+	// Update replaces the stored category if its version still equals
+	// category.Version and returns it with the incremented version. Otherwise
+	// it returns a *VersionConflictError. Like Create, it refuses to keep a
+	// category that is not deleted below a deleted parent.
+	Update(ctx context.Context, category Category) (Category, error)
+	// Move makes parentID the parent of category id, or makes it a top-level
+	// category if parentID is nil, and increments its version. In the same
+	// step it checks that both categories exist and are not deleted,
+	// returning a *CategoryNotFoundError otherwise, and that parentID is
+	// neither id nor below it, returning a *CategoryCycleError otherwise.
+	Move(ctx context.Context, id int, parentID *int) (Category, error)
+	// Delete soft-deletes category id, stamped with deletedAt and deletedBy,
+	// and increments its version. In the same step it checks that the
+	// category exists and is not deleted, returning a *CategoryNotFoundError
+	// otherwise, and that none of its subcategories and products are left,
+	// returning a *CategoryNotEmptyError otherwise.
+	Delete(ctx context.Context, id int, deletedAt time.Time, deletedBy int) (Category, error)
+}
+
+// ReviewRepository persists product reviews.
+type ReviewRepository interface {
+	Get(ctx context.Context, id int) (Review, error)
+	List(ctx context.Context) ([]Review, error)
+	ListByProduct(ctx context.Context, productID int) ([]Review, error)
+	ListByUser(ctx context.Context, userID int) ([]Review, error)
+	// ListByProducts returns the reviews of several products at once,
+	// ordered by ID.
+	ListByProducts(ctx context.Context, productIDs []int) ([]Review, error)
+	// ListByUsers returns the reviews of several users at once, ordered by ID.
+	ListByUsers(ctx context.Context, userIDs []int) ([]Review, error)
+	// Create stores a new review. A zero ID is replaced with the next free ID
+	// and an empty status with APPROVED. The rating summary of its product is
+	// updated with it. A user can have one review per product that is not
+	// deleted; Create returns a *DuplicateReviewError for a second one.
+	Create(ctx context.Context, review Review) (Review, error)
+	// Update replaces the stored review with the same ID and updates the
+	// rating summaries of the products involved with it. Like Create, it
+	// returns a *DuplicateReviewError rather than leave a user with two
+	// reviews of a product that are not deleted.
+	Update(ctx context.Context, review Review) (Review, error)
+}
+
+// RatingSummaryRepository reads the rating summaries ReviewRepository
+// maintains.
+type RatingSummaryRepository interface {
+	// Get returns the summary of a product; it is empty if the product has
+	// no approved reviews.
+	Get(ctx context.Context, productID int) (RatingSummary, error)
+	// List returns the summaries of the products with approved reviews,
+	// ordered by product ID.
+	List(ctx context.Context) ([]RatingSummary, error)
+	// ListByProducts returns the summaries of those of productIDs with
+	// approved reviews, ordered by product ID.
+	ListByProducts(ctx context.Context, productIDs []int) ([]RatingSummary, error)
+}
+
+// UserRepository persists users.
+type UserRepository interface {
+	Get(ctx context.Context, id int) (User, error)
+	List(ctx context.Context) ([]User, error)
+	// GetMany returns the users with the given IDs, ordered by ID. Unknown
+	// IDs are skipped.
+	GetMany(ctx context.Context, ids []int) ([]User, error)
+	// Create stores a new user. A zero ID is replaced with the next free ID.
+	Create(ctx context.Context, user User) (User, error)
+}
+
+// EmployeeRepository persists employees. Employees are exchanged as
+// *Employee values whose actual type (*Developer or *Manager) is
+// discoverable with quickgraph.Discover.
+type EmployeeRepository interface {
+	Get(ctx context.Context, id int) (*Employee, error)
+	List(ctx context.Context) ([]*Employee, error)
+	// GetMany returns the employees with the given IDs, ordered by ID.
+	// Unknown IDs are skipped.
+	GetMany(ctx context.Context, ids []int) ([]*Employee, error)
+	// ListByManagers returns the employees reporting directly to any of the
+	// given managers, terminated ones included, ordered by ID.
+	ListByManagers(ctx context.Context, managerIDs []int) ([]*Employee, error)
+	// Create stores a new employee. A zero ID is replaced with the next free ID
+	// and a zero version with 1.
+	Create(ctx context.Context, employee *Employee) (*Employee, error)
+	// Update replaces the stored employee with the same ID. The replacement
+	// may have a different actual type, e.g. when a developer is promoted.
+	// Versions are checked and incremented as for WidgetRepository.Update.
+	Update(ctx context.Context, employee *Employee) (*Employee, error)
+	// AssignManager makes managerID the manager of employee id, or leaves it
+	// without one if managerID is nil, and increments its version. In the
+	// same step it checks that the employee is not terminated, that
+	// managerID is a Manager that is not terminated and that no reporting
+	// cycle forms, returning a *ReportingCycleError for a cycle, and then
+	// that version still is the employee version, returning a
+	// *VersionConflictError otherwise.
+	AssignManager(ctx context.Context, id int, managerID *int, version int) (*Employee, error)
+	// ReassignReports moves every direct report of fromManagerID, terminated
+	// ones included, to toManagerID, or leaves them without a manager if
+	// toManagerID is nil, increments their versions and returns them ordered
+	// by ID. In the same step it checks that fromManagerID is a Manager and
+	// toManagerID a Manager that is not terminated and not below it,
+	// returning a *ReportingCycleError for a cycle.
+	ReassignReports(ctx context.Context, fromManagerID int, toManagerID *int) ([]*Employee, error)
+}
+
+// StockLedgerRepository persists the stock ledger of widgets. Entries are
+// append-only.
+type StockLedgerRepository interface {
+	// List returns all entries, oldest first.
+	List(ctx context.Context) ([]StockEntry, error)
+	// ListByWidget returns the entries of one widget, oldest first.
+	ListByWidget(ctx context.Context, widgetID int) ([]StockEntry, error)
+	// Append stores entry without touching the widget, for quantity changes
+	// that were already applied, e.g. by UpdateWidget. Use
+	// WidgetRepository.AdjustStock to change stock. A zero ID is replaced
+	// with the next free ID.
+	Append(ctx context.Context, entry StockEntry) (StockEntry, error)
+}
+
+// ProductStatusHistoryRepository persists the status changes of products.
+// Entries are append-only.
+type ProductStatusHistoryRepository interface {
+	// List returns all entries, oldest first.
+	List(ctx context.Context) ([]ProductStatusChange, error)
+	// ListByProduct returns the entries of one product, oldest first.
+	ListByProduct(ctx context.Context, productID int) ([]ProductStatusChange, error)
+	// Append stores entry for a status change that was already applied to
+	// the product. A zero ID is replaced with the next free ID.
+	Append(ctx context.Context, entry ProductStatusChange) (ProductStatusChange, error)
+}
+
+// ProductPriceHistoryRepository persists the price changes of products.
+// Entries are append-only.
+type ProductPriceHistoryRepository interface {
+	// List returns all entries, oldest first.
+	List(ctx context.Context) ([]ProductPriceChange, error)
+	// ListByProduct returns the entries of one product, oldest first.
+	ListByProduct(ctx context.Context, productID int) ([]ProductPriceChange, error)
+	// Append stores entry for a price change that was already applied to
+	// the product. A zero ID is replaced with the next free ID.
+	Append(ctx context.Context, entry ProductPriceChange) (ProductPriceChange, error)
+}
+
+// EmployeeRoleHistoryRepository persists the role changes of employees.
+// Entries are append-only.
+type EmployeeRoleHistoryRepository interface {
+	// List returns all entries, oldest first.
+	List(ctx context.Context) ([]EmployeeRoleChange, error)
+	// ListByEmployee returns the entries of one employee, oldest first.
+	ListByEmployee(ctx context.Context, employeeID int) ([]EmployeeRoleChange, error)
+	// Append stores entry for a role change that was already applied to the
+	// employee. A zero ID is replaced with the next free ID.
+	Append(ctx context.Context, entry EmployeeRoleChange) (EmployeeRoleChange, error)
+}
+
+// OrderRepository persists orders. Placing and cancelling an order move the
+// stock of its products and widgets in the same atomic step; widget stock
+// changes are appended to the stock ledger.
+type OrderRepository interface {
+	// Get returns the order with id, or ErrNotFound.
+	Get(ctx context.Context, id int) (Order, error)
+	// List returns all orders ordered by ID.
+	List(ctx context.Context) ([]Order, error)
+	// ListByUser returns the orders of one user ordered by ID.
+	ListByUser(ctx context.Context, userID int) ([]Order, error)
+	// Create stores order without touching stock, e.g. when restoring a
+	// snapshot. A zero ID is replaced with the next free ID, and a zero
+	// version with 1.
+	Create(ctx context.Context, order Order) (Order, error)
+	// Place takes the items of order out of the stock of their products and
+	// widgets and stores the order as Create does. The items must name
+	// distinct products and widgets. If an item has too little stock, Place
+	// fails with an *InsufficientProductStockError or an
+	// *InsufficientStockError and changes nothing.
+	Place(ctx context.Context, order Order) (Order, error)
+	// Update replaces the stored order with the same ID. Versions are
+	// checked and incremented as for WidgetRepository.Update.
+	Update(ctx context.Context, order Order) (Order, error)
+	// Cancel is Update for an order that is being cancelled: it also puts
+	// the items back into the stock of their products and widgets.
+	Cancel(ctx context.Context, order Order) (Order, error)
+}
+
+// ProductVariantRepository persists product variants.
+type ProductVariantRepository interface {
+	// Get returns the variant with id, or ErrNotFound.
+	Get(ctx context.Context, id int) (ProductVariant, error)
+	// List returns all variants ordered by ID.
+	List(ctx context.Context) ([]ProductVariant, error)
+	// ListByProducts returns the variants of several products at once,
+	// ordered by ID.
+	ListByProducts(ctx context.Context, productIDs []int) ([]ProductVariant, error)
+	// Create stores a new variant. A zero ID is replaced with the next free
+	// ID, and a zero version with 1.
+	Create(ctx context.Context, variant ProductVariant) (ProductVariant, error)
+	// Update replaces the stored variant with the same ID. Versions are
+	// checked and incremented as for WidgetRepository.Update.
+	Update(ctx context.Context, variant ProductVariant) (ProductVariant, error)
+	// Delete removes the variant with id and returns it, or ErrNotFound.
+	Delete(ctx context.Context, id int) (ProductVariant, error)
+}
+
+// CartRepository persists the carts of users, one per user.
+type CartRepository interface {
+	// Get returns the cart of the user with userID, or ErrNotFound if the
+	// user never had one.
+	Get(ctx context.Context, userID int) (Cart, error)
+	// List returns all carts ordered by user ID.
+	List(ctx context.Context) ([]Cart, error)
+	// Put stores cart, replacing the cart of the same user, and returns it
+	// with the next version. The stored cart must still be at cart.Version,
+	// or Put returns a *VersionConflictError; a user without a cart can be
+	// given one at any version.
+	Put(ctx context.Context, cart Cart) (Cart, error)
+}
+
+// AuditRepository persists the audit log. Entries are append-only.
+type AuditRepository interface {
+	// Append stores entry with the next free ID.
+	Append(ctx context.Context, entry AuditEntry) (AuditEntry, error)
+	// List returns at most limit entries matching filter, newest first. A
+	// non-zero before only returns entries with a smaller ID.
+	List(ctx context.Context, filter AuditFilter, before, limit int) ([]AuditEntry, error)
+}
+
+// scope is attached to the entities the handlers return so that their field
+// resolvers can reach the store. quickgraph calls field methods without the
+// request context, so the scope captures it from the top-level handler.
+type scope struct {
+	ctx     context.Context
+	store   Store
+	loaders *loaders // Batch the lookups of the field resolvers
+}
+
+func newScope(ctx context.Context, store Store) *scope {
+	sc := &scope{ctx: ctx, store: store}
+	sc.loaders = newLoaders(sc)
+	return sc
+}
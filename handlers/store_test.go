@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gburgyan/go-quickgraph"
+)
+
+// newTestGraph builds a Graphy instance with the widget, product and
+// employee handlers registered against store.
+func newTestGraph(ctx context.Context, store Store) *quickgraph.Graphy {
+	graph := &quickgraph.Graphy{}
+	RegisterWidgetHandlers(ctx, graph, store)
+	RegisterProductHandlers(ctx, graph, store)
+	RegisterEmployeeHandlers(ctx, graph, store)
+	graph.RegisterTypes(ctx, Employee{}, Developer{}, Manager{}, EmployeeResultUnion{})
+	if err := RegisterScalarHandlers(ctx, graph); err != nil {
+		panic(err)
+	}
+	return graph
+}
+
+// forEachStore runs test as a subtest against a memory store and a SQLite
+// store, both seeded with the sample data.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	for name, store := range map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": openTestSQLiteStore(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := SeedSampleData(context.Background(), store); err != nil {
+				t.Fatalf("SeedSampleData failed: %v", err)
+			}
+			test(t, store)
+		})
+	}
+}
+
+// userContext returns a context authenticated as the user with id in store.
+func userContext(t *testing.T, store Store, id int) context.Context {
+	t.Helper()
+	ctx := context.Background()
+	user, err := store.Users().Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get user %d failed: %v", id, err)
+	}
+	return context.WithValue(ctx, UserContextKey, &user)
+}
+
+func TestIndependentGraphsDoNotShareState(t *testing.T) {
+	ctx := context.Background()
+
+	storeA := NewMemoryStore()
+	if err := SeedSampleData(ctx, storeA); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	storeB := NewMemoryStore()
+	if err := SeedSampleData(ctx, storeB); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+
+	graphA := newTestGraph(ctx, storeA)
+	graphB := newTestGraph(ctx, storeB)
+
+	_, err := graphA.ProcessRequest(ctx, `mutation { CreateWidget(widget: {name: "Only in A", price: 1.5, quantity: 3}) { id } }`, "")
+	if err != nil {
+		t.Fatalf("CreateWidget failed: %v", err)
+	}
+
+	resA, err := graphA.ProcessRequest(ctx, `{ GetWidgets { edges { node { name } } } }`, "")
+	if err != nil {
+		t.Fatalf("GetWidgets on A failed: %v", err)
+	}
+	resB, err := graphB.ProcessRequest(ctx, `{ GetWidgets { edges { node { name } } } }`, "")
+	if err != nil {
+		t.Fatalf("GetWidgets on B failed: %v", err)
+	}
+
+	if !strings.Contains(resA, "Only in A") {
+		t.Errorf("expected graph A to see its new widget, got %s", resA)
+	}
+	if strings.Contains(resB, "Only in A") {
+		t.Errorf("graph B must not see widgets created through graph A, got %s", resB)
+	}
+}
+
+func TestIndependentStoresDoNotShareEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storeA, storeB := NewMemoryStore(), NewMemoryStore()
+	updatesA, _ := NewSubscriptionHandlers(storeA).ProductUpdates(ctx, nil, nil)
+	updatesB, _ := NewSubscriptionHandlers(storeB).ProductUpdates(ctx, nil, nil)
+
+	storeA.Events().BroadcastProductUpdate(ctx, Product{ID: 1, Name: "Only in A"}, "created")
+
+	select {
+	case update := <-updatesA:
+		if update.Product.ID != 1 {
+			t.Errorf("unexpected update %+v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected store A's subscriber to receive the update")
+	}
+	select {
+	case update := <-updatesB:
+		t.Errorf("store B must not receive the events of store A, got %+v", update)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscriptionsResolveWithSubscriberContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := openTestSQLiteStore(t)
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	admin, err := store.Users().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get admin failed: %v", err)
+	}
+	updates, _ := NewSubscriptionHandlers(store).ProductUpdates(ctx, nil, nil)
+
+	// The mutating request is over before the subscriber resolves anything
+	mutationCtx, endMutation := context.WithCancel(context.WithValue(ctx, UserContextKey, &admin))
+	if _, err := NewProductHandlers(store).SetProductPrice(mutationCtx, 1, Money{Amount: 89900, Currency: "USD"}, 1); err != nil {
+		t.Fatalf("SetProductPrice failed: %v", err)
+	}
+	endMutation()
+
+	select {
+	case update := <-updates:
+		if update.Product.scope.ctx.Value(UserContextKey) != nil {
+			t.Error("expected the product to be bound to the anonymous subscriber, not the admin")
+		}
+		category, err := update.Product.Category()
+		if err != nil || category == nil || category.Name != "Electronics" {
+			t.Errorf("expected the category to resolve, got %v, %v", category, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a product update")
+	}
+}
+
+func TestFieldResolversUseOwningStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	graph := newTestGraph(ctx, store)
+
+	res, err := graph.ProcessRequest(ctx, `{ GetProduct(id: 1) { Name Category { Name } Reviews(page: {}) { edges { node { Rating User { Username } } } } AverageRating } }`, "")
+	if err != nil {
+		t.Fatalf("GetProduct failed: %v", err)
+	}
+	for _, want := range []string{`"Electronics"`, `"john_customer"`, `"jane_customer"`, `4.5`} {
+		if !strings.Contains(res, want) {
+			t.Errorf("expected %s in response, got %s", want, res)
+		}
+	}
+}
+
+func TestPromoteToManagerKeepsTypeDiscovery(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := SeedSampleData(ctx, store); err != nil {
+		t.Fatalf("SeedSampleData failed: %v", err)
+	}
+	h := NewEmployeeHandlers(store)
+	admin, err := store.Users().Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get admin failed: %v", err)
+	}
+	customer, err := store.Users().Get(ctx, 2)
+	if err != nil {
+		t.Fatalf("Get customer failed: %v", err)
+	}
+
+	if _, err := h.PromoteToManager(context.WithValue(ctx, UserContextKey, &customer), 3, "Platform", 1); err == nil || !strings.Contains(err.Error(), "admin role required") {
+		t.Errorf("expected promotions to require an admin, got %v", err)
+	}
+	if _, err := h.PromoteToManager(context.WithValue(ctx, UserContextKey, &admin), 3, "Platform", 1); err != nil {
+		t.Fatalf("PromoteToManager failed: %v", err)
+	}
+
+	emp, err := h.GetEmployee(ctx, 3)
+	if err != nil {
+		t.Fatalf("GetEmployee failed: %v", err)
+	}
+	mgr, ok := quickgraph.Discover[*Manager](emp)
+	if !ok {
+		t.Fatalf("promoted employee should be discoverable as Manager, got %T", emp.ActualType())
+	}
+	if mgr.Department != "Platform" {
+		t.Errorf("expected department Platform, got %s", mgr.Department)
+	}
+}
+
+func TestStaleVersionIsRejectedWithConflict(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store Store) {
+		graph := newTestGraph(ctx, store)
+
+		update := `mutation { UpdateWidget(widget: {id: 1, name: "Edited", price: 1, quantity: 1, version: 1}) { version } }`
+		res, err := graph.ProcessRequest(ctx, update, "")
+		if err != nil {
+			t.Fatalf("first update failed: %v", err)
+		}
+		if !strings.Contains(res, `"version":2`) {
+			t.Errorf("expected version 2 after the update, got %s", res)
+		}
+
+		// A second editor still holding version 1 must not overwrite the change
+		res, _ = graph.ProcessRequest(ctx, update, "")
+		for _, want := range []string{`"code":"VERSION_CONFLICT"`, `"currentVersion":"2"`} {
+			if !strings.Contains(res, want) {
+				t.Errorf("expected %s in conflict response, got %s", want, res)
+			}
+		}
+
+		_, err = store.Products().Update(ctx, Product{ID: 1, Name: "Stale", CategoryID: 1, Version: 5})
+		var conflict *VersionConflictError
+		if !errors.As(err, &conflict) || conflict.CurrentVersion != 1 {
+			t.Errorf("expected a product version conflict at version 1, got %v", err)
+		}
+		if _, err := store.Products().Update(ctx, Product{ID: 99, Version: 1}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for a missing product, got %v", err)
+		}
+	})
+}
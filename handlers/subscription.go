@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gburgyan/go-quickgraph"
@@ -12,108 +14,236 @@ import (
 // ProductUpdate represents a product update event
 type ProductUpdate struct {
 	Product   Product   `json:"product"`
-	Action    string    `json:"action"` // "created", "updated", "deleted"
+	Action    string    `json:"action"`  // "created", "updated", "deleted", "restored"
+	Version   int       `json:"version"` // Product version after the change
 	Timestamp time.Time `json:"timestamp"`
 }
 
 // WidgetUpdate represents a widget update event
 type WidgetUpdate struct {
 	Widget    Widget    `json:"widget"`
-	Action    string    `json:"action"` // "created", "updated", "deleted"
+	Action    string    `json:"action"`  // "created", "updated", "deleted", "restored"
+	Version   int       `json:"version"` // Widget version after the change
 	Timestamp time.Time `json:"timestamp"`
 }
 
-// OrderUpdate represents an order update event
-type OrderUpdate struct {
-	OrderID   string    `json:"orderId"`
-	Status    string    `json:"status"`
-	Message   string    `json:"message"`
+// EmployeeUpdate represents a change of an employee
+type EmployeeUpdate struct {
+	Employee  *Employee `json:"employee"`
+	Action    string    `json:"action"`  // "created", "updated", "promoted", "demoted", "transferred", "terminated", "restored"
+	Version   int       `json:"version"` // Employee version after the change
 	Timestamp time.Time `json:"timestamp"`
 }
 
-// Global subscriber registries for broadcasting updates
-var (
-	productUpdateChan = make(chan ProductUpdate, 100)
-	widgetUpdateChan  = make(chan WidgetUpdate, 100)
-	orderUpdateChan   = make(chan OrderUpdate, 100)
+// OrderUpdate represents an order status change
+type OrderUpdate struct {
+	OrderID   int         `json:"orderId"`
+	Status    OrderStatus `json:"status"`
+	Message   string      `json:"message"`
+	Timestamp time.Time   `json:"timestamp"`
 
-	// Widget subscription management
-	widgetSubscribers = make(map[string]chan WidgetUpdate)
-	widgetSubsMutex   sync.RWMutex
-)
+	// Customer of the order, who may receive the update along with admins
+	userID int `json:"-" graphy:"-"`
+}
+
+// subscribers fans events out to the subscriptions of each tenant. Every
+// subscription has its own buffered channel, so each subscriber sees every
+// event of its tenant and never an event of another tenant.
+type subscribers[T any] struct {
+	mu       sync.RWMutex
+	byTenant map[string]map[string]chan T
+}
+
+func newSubscribers[T any]() *subscribers[T] {
+	return &subscribers[T]{byTenant: make(map[string]map[string]chan T)}
+}
+
+// add registers a subscription of tenant and returns its channel.
+func (s *subscribers[T]) add(tenant, subID string) chan T {
+	ch := make(chan T, 10)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byTenant[tenant] == nil {
+		s.byTenant[tenant] = make(map[string]chan T)
+	}
+	s.byTenant[tenant][subID] = ch
+	return ch
+}
+
+// remove unregisters a subscription and closes its channel.
+func (s *subscribers[T]) remove(tenant, subID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.byTenant[tenant][subID]; ok {
+		close(ch)
+		delete(s.byTenant[tenant], subID)
+		if len(s.byTenant[tenant]) == 0 {
+			delete(s.byTenant, tenant)
+		}
+	}
+}
+
+// count returns the number of subscriptions of tenant.
+func (s *subscribers[T]) count(tenant string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byTenant[tenant])
+}
+
+// publish sends update to every subscription of tenant without blocking.
+// Subscribers that are too slow to keep up miss the update. delivered, if
+// not nil, is called with the outcome for each subscription.
+func (s *subscribers[T]) publish(tenant string, update T, delivered func(subID string, ok bool)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for subID, ch := range s.byTenant[tenant] {
+		ok := true
+		select {
+		case ch <- update:
+		default:
+			ok = false
+		}
+		if delivered != nil {
+			delivered(subID, ok)
+		}
+	}
+}
 
-// BroadcastProductUpdate sends a product update to all subscribers
-func BroadcastProductUpdate(product Product, action string) {
-	select {
-	case productUpdateChan <- ProductUpdate{
+// Events delivers the change events of a Store to the subscriptions of
+// its tenants. Every Store owns its own Events, so independent handler sets
+// never receive each other's events.
+//
+// Entities are published without the scope of the mutation that changed
+// them: that scope carries the mutating request, which is cancelled once
+// the mutation returns, and its user. Each subscription binds the entities
+// it sends to a scope of its own context instead, so nested fields resolve
+// with the subscriber's identity.
+type Events struct {
+	products  *subscribers[ProductUpdate]
+	widgets   *subscribers[WidgetUpdate]
+	employees *subscribers[EmployeeUpdate]
+	orders    *subscribers[OrderUpdate]
+	lowStock  *subscribers[LowStockAlert]
+}
+
+// NewEvents returns Events without any subscriptions.
+func NewEvents() *Events {
+	return &Events{
+		products:  newSubscribers[ProductUpdate](),
+		widgets:   newSubscribers[WidgetUpdate](),
+		employees: newSubscribers[EmployeeUpdate](),
+		orders:    newSubscribers[OrderUpdate](),
+		lowStock:  newSubscribers[LowStockAlert](),
+	}
+}
+
+var subscriptionSeq atomic.Int64
+
+// newSubscriptionID returns a process-wide unique subscription ID.
+func newSubscriptionID(kind string) string {
+	return fmt.Sprintf("%s-sub-%d", kind, subscriptionSeq.Add(1))
+}
+
+// BroadcastProductUpdate sends a product update to the subscribers of the
+// tenant in ctx
+func (e *Events) BroadcastProductUpdate(ctx context.Context, product Product, action string) {
+	product.scope = nil
+	e.products.publish(TenantFromContext(ctx), ProductUpdate{
 		Product:   product,
 		Action:    action,
+		Version:   product.Version,
 		Timestamp: time.Now(),
-	}:
-	default:
-		// Channel full, drop the message (in production, use better buffering)
-	}
+	}, nil)
 }
 
-// BroadcastWidgetUpdate sends a widget update to all subscribers
-func BroadcastWidgetUpdate(widget Widget, action string) {
+// BroadcastWidgetUpdate sends a widget update to the subscribers of the
+// tenant in ctx
+func (e *Events) BroadcastWidgetUpdate(ctx context.Context, widget Widget, action string) {
+	tenant := TenantFromContext(ctx)
+	widget.scope = nil
 	update := WidgetUpdate{
 		Widget:    widget,
 		Action:    action,
+		Version:   widget.Version,
 		Timestamp: time.Now(),
 	}
 
-	widgetSubsMutex.RLock()
-	subscriberCount := len(widgetSubscribers)
-	widgetSubsMutex.RUnlock()
-
-	fmt.Printf("🔔 Broadcasting widget update: %s widget ID=%d to %d subscribers\n", action, widget.ID, subscriberCount)
+	subscriberCount := e.widgets.count(tenant)
+	fmt.Printf("🔔 Broadcasting widget update: %s widget ID=%d to %d subscribers of tenant %s\n", action, widget.ID, subscriberCount, tenant)
 
-	widgetSubsMutex.RLock()
-	defer widgetSubsMutex.RUnlock()
-
-	// Send to all registered subscribers
 	sent := 0
-	for subId, ch := range widgetSubscribers {
-		select {
-		case ch <- update:
+	e.widgets.publish(tenant, update, func(subId string, ok bool) {
+		if ok {
 			sent++
 			fmt.Printf("  ✅ Sent to subscriber %s\n", subId)
-		default:
+		} else {
 			fmt.Printf("  ❌ Failed to send to subscriber %s (channel full)\n", subId)
 		}
-	}
+	})
 	fmt.Printf("📊 Broadcast complete: %d/%d subscribers received update\n", sent, subscriberCount)
 }
 
-// BroadcastOrderUpdate sends an order update to subscribers
-func BroadcastOrderUpdate(orderID, status, message string) {
-	select {
-	case orderUpdateChan <- OrderUpdate{
-		OrderID:   orderID,
-		Status:    status,
+// BroadcastEmployeeUpdate sends an employee update to the subscribers of
+// the tenant in ctx
+func (e *Events) BroadcastEmployeeUpdate(ctx context.Context, employee *Employee, action string) {
+	e.employees.publish(TenantFromContext(ctx), EmployeeUpdate{
+		Employee:  cloneEmployee(employee),
+		Action:    action,
+		Version:   employee.Version,
+		Timestamp: time.Now(),
+	}, nil)
+}
+
+// BroadcastOrderUpdate sends the current status of order to the
+// subscribers of the tenant in ctx
+func (e *Events) BroadcastOrderUpdate(ctx context.Context, order Order, message string) {
+	e.orders.publish(TenantFromContext(ctx), OrderUpdate{
+		OrderID:   order.ID,
+		Status:    order.Status,
 		Message:   message,
 		Timestamp: time.Now(),
-	}:
-	default:
-		// Channel full, drop the message
-	}
+		userID:    order.UserID,
+	}, nil)
+}
+
+// BroadcastLowStockAlert sends a low-stock alert to the subscribers of the
+// tenant in ctx
+func (e *Events) BroadcastLowStockAlert(ctx context.Context, alert LowStockAlert) {
+	alert.Widget.scope = nil
+	e.lowStock.publish(TenantFromContext(ctx), alert, nil)
 }
 
-// ProductUpdates subscription - subscribes to product changes
-// Use -1 for categoryId to get updates for all categories
-func ProductUpdates(ctx context.Context, categoryId int) <-chan ProductUpdate {
+// ProductUpdates subscription - subscribes to product changes of the
+// caller's tenant.
+// Leave out categoryId, or use -1, to get updates for all categories. filter
+// takes the same conditions as GetProducts and limits the updates to the
+// products it matches after the change.
+func (h *SubscriptionHandlers) ProductUpdates(ctx context.Context, categoryId *int, filter *ProductFilter) (<-chan ProductUpdate, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	if filter.usesSubcategories() {
+		return nil, errors.New("includeSubcategories is not supported by productUpdates")
+	}
+	if filter.usesRatings() {
+		return nil, errors.New("minRating is not supported by productUpdates")
+	}
 	ch := make(chan ProductUpdate)
+	tenant, events := TenantFromContext(ctx), h.store.Events()
+	subId := newSubscriptionID("product")
+	subCh := events.products.add(tenant, subId)
 
 	go func() {
 		defer close(ch)
+		defer events.products.remove(tenant, subId)
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case update := <-productUpdateChan:
-				// If categoryId is -1, send all updates; otherwise filter by specific category
-				if categoryId == -1 || update.Product.CategoryID == categoryId {
+			case update := <-subCh:
+				allCategories := categoryId == nil || *categoryId == -1
+				if (allCategories || update.Product.CategoryID == *categoryId) && filter.matches(update.Product) {
+					update.Product.bind(newScope(ctx, h.store))
 					select {
 					case ch <- update:
 					case <-ctx.Done():
@@ -124,33 +254,29 @@ func ProductUpdates(ctx context.Context, categoryId int) <-chan ProductUpdate {
 		}
 	}()
 
-	return ch
+	return ch, nil
 }
 
-// WidgetUpdates subscription - subscribes to widget updates
+// WidgetUpdates subscription - subscribes to widget updates of the caller's
+// tenant.
 // Use -1 for widgetId to get updates for all widgets
-func WidgetUpdates(ctx context.Context, widgetId int) <-chan WidgetUpdate {
+func (h *SubscriptionHandlers) WidgetUpdates(ctx context.Context, widgetId int) <-chan WidgetUpdate {
 	ch := make(chan WidgetUpdate, 10)
-	subCh := make(chan WidgetUpdate, 10)
+	tenant, events := TenantFromContext(ctx), h.store.Events()
 
 	// Generate unique subscription ID
-	subId := fmt.Sprintf("widget-sub-%d", time.Now().UnixNano())
+	subId := newSubscriptionID("widget")
 
-	fmt.Printf("🔗 New widget subscription: %s (filter: widgetId=%v)\n", subId, widgetId)
+	fmt.Printf("🔗 New widget subscription: %s (tenant: %s, filter: widgetId=%v)\n", subId, tenant, widgetId)
 
 	// Register subscriber
-	widgetSubsMutex.Lock()
-	widgetSubscribers[subId] = subCh
-	widgetSubsMutex.Unlock()
+	subCh := events.widgets.add(tenant, subId)
 
 	go func() {
 		defer close(ch)
 		defer func() {
 			// Unregister subscriber
-			widgetSubsMutex.Lock()
-			delete(widgetSubscribers, subId)
-			close(subCh)
-			widgetSubsMutex.Unlock()
+			events.widgets.remove(tenant, subId)
 			fmt.Printf("🔌 Widget subscription closed: %s\n", subId)
 		}()
 
@@ -162,7 +288,7 @@ func WidgetUpdates(ctx context.Context, widgetId int) <-chan WidgetUpdate {
 			Widget: Widget{
 				ID:       0,
 				Name:     "Connection established",
-				Price:    0,
+				Price:    Money{Currency: storeCurrency},
 				Quantity: 0,
 			},
 			Action:    "connected",
@@ -186,6 +312,7 @@ func WidgetUpdates(ctx context.Context, widgetId int) <-chan WidgetUpdate {
 				// If widgetId is -1, send all updates; otherwise filter by specific ID
 				if widgetId == -1 || update.Widget.ID == widgetId {
 					fmt.Printf("✅ Widget update passed filter, sending to client: %s\n", subId)
+					update.Widget.bind(newScope(ctx, h.store))
 					select {
 					case ch <- update:
 					case <-ctx.Done():
@@ -201,33 +328,62 @@ func WidgetUpdates(ctx context.Context, widgetId int) <-chan WidgetUpdate {
 	return ch
 }
 
-// OrderStatusUpdates subscription - subscribes to order status changes
-func OrderStatusUpdates(ctx context.Context, orderId string) (<-chan OrderUpdate, error) {
-	if orderId == "" {
-		return nil, fmt.Errorf("orderId is required")
+// OrderStatusUpdates subscription - subscribes to the status changes of an
+// order placed by the authenticated user. Admins can follow any order.
+func (h *SubscriptionHandlers) OrderStatusUpdates(ctx context.Context, orderId int) (<-chan OrderUpdate, error) {
+	user, err := requireUser(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	ch := make(chan OrderUpdate)
+	tenant, events := TenantFromContext(ctx), h.store.Events()
+	subId := newSubscriptionID("order")
+	subCh := events.orders.add(tenant, subId)
 
-	// Simulate initial order creation
 	go func() {
-		time.Sleep(100 * time.Millisecond)
-		BroadcastOrderUpdate(orderId, "processing", "Order is being processed")
-		time.Sleep(2 * time.Second)
-		BroadcastOrderUpdate(orderId, "shipped", "Order has been shipped")
-		time.Sleep(3 * time.Second)
-		BroadcastOrderUpdate(orderId, "delivered", "Order has been delivered")
+		defer close(ch)
+		defer events.orders.remove(tenant, subId)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update := <-subCh:
+				// Filter by order ID
+				if update.OrderID == orderId && canSeeOrder(user, update.userID) {
+					select {
+					case ch <- update:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
 	}()
 
+	return ch, nil
+}
+
+// EmployeeUpdates subscription - subscribes to employee changes of the
+// caller's tenant. Omit employeeId to get updates for all employees.
+func (h *SubscriptionHandlers) EmployeeUpdates(ctx context.Context, employeeId *int) <-chan EmployeeUpdate {
+	ch := make(chan EmployeeUpdate)
+	tenant, events := TenantFromContext(ctx), h.store.Events()
+	subId := newSubscriptionID("employee")
+	subCh := events.employees.add(tenant, subId)
+
 	go func() {
 		defer close(ch)
+		defer events.employees.remove(tenant, subId)
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case update := <-orderUpdateChan:
-				// Filter by order ID
-				if update.OrderID == orderId {
+			case update := <-subCh:
+				if employeeId == nil || update.Employee.ID == *employeeId {
+					// Every subscriber gets its own copy to bind
+					update.Employee = cloneEmployee(update.Employee)
+					update.Employee.scope = newScope(ctx, h.store)
 					select {
 					case ch <- update:
 					case <-ctx.Done():
@@ -238,7 +394,39 @@ func OrderStatusUpdates(ctx context.Context, orderId string) (<-chan OrderUpdate
 		}
 	}()
 
-	return ch, nil
+	return ch
+}
+
+// LowStockAlerts subscription - fires when a widget of the caller's tenant
+// drops to or below its reorder threshold. Omit widgetId to watch all
+// widgets.
+func (h *SubscriptionHandlers) LowStockAlerts(ctx context.Context, widgetId *int) <-chan LowStockAlert {
+	ch := make(chan LowStockAlert)
+	tenant, events := TenantFromContext(ctx), h.store.Events()
+	subId := newSubscriptionID("low-stock")
+	subCh := events.lowStock.add(tenant, subId)
+
+	go func() {
+		defer close(ch)
+		defer events.lowStock.remove(tenant, subId)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case alert := <-subCh:
+				if widgetId == nil || alert.Widget.ID == *widgetId {
+					alert.Widget.bind(newScope(ctx, h.store))
+					select {
+					case ch <- alert:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return ch
 }
 
 // CurrentTime subscription - emits current time at specified intervals
@@ -280,16 +468,33 @@ type TimeUpdate struct {
 	Formatted string `json:"formatted"`
 }
 
+// SubscriptionHandlers serves the subscriptions to the events of a Store.
+type SubscriptionHandlers struct {
+	store Store
+}
+
+// NewSubscriptionHandlers creates subscription handlers for the events of
+// store.
+func NewSubscriptionHandlers(store Store) *SubscriptionHandlers {
+	return &SubscriptionHandlers{store: store}
+}
+
 // RegisterSubscriptionHandlers registers all subscription handlers
-func RegisterSubscriptionHandlers(ctx context.Context, graph *quickgraph.Graphy) {
+func RegisterSubscriptionHandlers(ctx context.Context, graph *quickgraph.Graphy, store Store) {
+	h := NewSubscriptionHandlers(store)
+
 	// Product subscriptions
-	graph.RegisterSubscription(ctx, "productUpdates", ProductUpdates, "categoryId")
+	graph.RegisterSubscription(ctx, "productUpdates", h.ProductUpdates, "categoryId", "filter")
 
 	// Widget subscriptions
-	graph.RegisterSubscription(ctx, "widgetUpdates", WidgetUpdates, "widgetId")
+	graph.RegisterSubscription(ctx, "widgetUpdates", h.WidgetUpdates, "widgetId")
+	graph.RegisterSubscription(ctx, "lowStockAlerts", h.LowStockAlerts, "widgetId")
+
+	// Employee subscriptions
+	graph.RegisterSubscription(ctx, "employeeUpdates", h.EmployeeUpdates, "employeeId")
 
 	// Order subscriptions
-	graph.RegisterSubscription(ctx, "orderStatusUpdates", OrderStatusUpdates, "orderId")
+	graph.RegisterSubscription(ctx, "orderStatusUpdates", h.OrderStatusUpdates, "orderId")
 
 	// Utility subscriptions
 	graph.RegisterSubscription(ctx, "currentTime", CurrentTime, "intervalMs")
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultTenant serves requests that do not name a tenant. Its data lives
+// at the configured paths themselves, so single-tenant setups keep working
+// unchanged.
+const DefaultTenant = "default"
+
+// TenantHeader names the tenant of an HTTP or WebSocket request.
+const TenantHeader = "X-Tenant-ID"
+
+// tenantClaim is the JWT claim that names the tenant of a bearer token.
+const tenantClaim = "tenant"
+
+const tenantContextKey contextKey = "tenant"
+
+// Tenant IDs end up in file names, so they are restricted to a safe set.
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidTenantID reports whether id can be used as a tenant ID.
+func ValidTenantID(id string) bool {
+	return tenantIDPattern.MatchString(id)
+}
+
+// ParseTenantList splits a comma-separated list of tenant IDs, such as the
+// value of a -tenants flag, skipping empty entries. Each entry must be a
+// valid tenant ID or AnyTenant.
+func ParseTenantList(list string) ([]string, error) {
+	var tenants []string
+	for _, tenant := range strings.Split(list, ",") {
+		if tenant = strings.TrimSpace(tenant); tenant == "" {
+			continue
+		}
+		if tenant != AnyTenant && !ValidTenantID(tenant) {
+			return nil, fmt.Errorf("invalid tenant ID %q", tenant)
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+// WithTenant returns a context whose store lookups and subscription events
+// are scoped to tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, or DefaultTenant.
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantContextKey).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// TenantFromRequest resolves the tenant of r from the tenant claim of a JWT
+// bearer token, the tenant of a demo token, or the X-Tenant-ID header. When
+// both are present they must agree. Requests naming neither belong to
+// DefaultTenant.
+//
+// The token signature is NOT verified here; like the demo authentication,
+// this expects a gateway in front of the server to have done so.
+func TenantFromRequest(r *http.Request) (string, error) {
+	header := strings.TrimSpace(r.Header.Get(TenantHeader))
+	claim, err := tenantFromBearerToken(r.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+
+	tenant := DefaultTenant
+	switch {
+	case claim != "" && header != "" && claim != header:
+		return "", fmt.Errorf("%s %q does not match the tenant of the bearer token", TenantHeader, header)
+	case claim != "":
+		tenant = claim
+	case header != "":
+		tenant = header
+	}
+	if !ValidTenantID(tenant) {
+		return "", fmt.Errorf("invalid tenant ID %q", tenant)
+	}
+	return tenant, nil
+}
+
+// tenantFromBearerToken returns the tenant claim of a JWT bearer token or
+// the tenant of a demo token. Other tokens have no tenant.
+func tenantFromBearerToken(authHeader string) (string, error) {
+	if tenant, _, ok := demoToken(authHeader); ok {
+		return tenant, nil
+	}
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok {
+		return "", nil
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", nil
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("invalid bearer token payload")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", errors.New("invalid bearer token payload")
+	}
+	switch tenant := claims[tenantClaim].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return tenant, nil
+	default:
+		return "", fmt.Errorf("bearer token claim %q must be a string", tenantClaim)
+	}
+}
+
+// TenantMiddleware puts the tenant of each request into its context. It must
+// run before any middleware that reads the store, such as AuthMiddleware,
+// and also covers WebSocket upgrades, so subscriptions are scoped as well.
+func TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := TenantFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+	})
+}
+
+// TenantFile returns the file that holds tenant's data for a setting that
+// names path, e.g. "data.db" becomes "data.acme.db" for tenant "acme". The
+// default tenant, empty paths and ":memory:" are returned unchanged.
+func TenantFile(path, tenant string) string {
+	if tenant == DefaultTenant || path == "" || path == ":memory:" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + tenant + ext
+}
+
+// TenantDir returns the directory that holds tenant's files inside dir,
+// e.g. "data/tenants/acme". The default tenant uses dir itself.
+func TenantDir(dir, tenant string) string {
+	if tenant == DefaultTenant || dir == "" {
+		return dir
+	}
+	return filepath.Join(dir, "tenants", tenant)
+}
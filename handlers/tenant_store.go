@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TenantOpener opens the Store holding the data of tenant. The returned
+// close function, if not nil, is called by TenantStore.Close.
+type TenantOpener func(ctx context.Context, tenant string) (Store, func() error, error)
+
+// AnyTenant, passed to NewTenantStore, accepts every valid tenant ID. Each
+// new tenant gets its own store, and with SQLite its own files, so only use
+// it where clients cannot pick arbitrary tenants.
+const AnyTenant = "*"
+
+// TenantStore is a Store that partitions all data by tenant. Every call is
+// routed to the Store of the tenant in its context (see WithTenant), so
+// handlers and field resolvers written against a single Store only ever see
+// the data of the requesting tenant. Tenant stores are opened on first use.
+type TenantStore struct {
+	open    TenantOpener
+	allowed map[string]bool // nil allows every valid tenant ID
+	events  *Events         // Shared by all tenants, which it keeps apart
+
+	mu     sync.Mutex
+	stores map[string]*tenantEntry
+	closes []func() error
+}
+
+// tenantEntry is the store of a tenant. ready is closed once the store has
+// been opened; until then, other requests for the tenant wait on it instead
+// of opening the store again.
+type tenantEntry struct {
+	ready chan struct{}
+	store Store
+	err   error
+}
+
+// NewTenantStore returns a TenantStore that opens tenant stores with open.
+// Only DefaultTenant and the allowed tenants can be used; other tenants are
+// rejected as unknown, unless allowed includes AnyTenant.
+func NewTenantStore(open TenantOpener, allowed ...string) *TenantStore {
+	s := &TenantStore{open: open, stores: make(map[string]*tenantEntry), events: NewEvents()}
+	s.allowed = map[string]bool{DefaultTenant: true}
+	for _, tenant := range allowed {
+		if tenant == AnyTenant {
+			s.allowed = nil
+			break
+		}
+		s.allowed[tenant] = true
+	}
+	return s
+}
+
+// For returns the Store of the tenant in ctx, opening it if necessary. The
+// store is opened without holding the lock, so a slow tenant does not block
+// the others.
+func (s *TenantStore) For(ctx context.Context) (Store, error) {
+	tenant := TenantFromContext(ctx)
+	if !ValidTenantID(tenant) || (s.allowed != nil && !s.allowed[tenant]) {
+		return nil, fmt.Errorf("unknown tenant %q", tenant)
+	}
+
+	s.mu.Lock()
+	entry, ok := s.stores[tenant]
+	if !ok {
+		entry = &tenantEntry{ready: make(chan struct{})}
+		s.stores[tenant] = entry
+	}
+	s.mu.Unlock()
+	if !ok {
+		s.openTenant(ctx, tenant, entry)
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.store, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// openTenant opens the store of tenant into entry. A failed open is
+// forgotten, so the next request tries again.
+func (s *TenantStore) openTenant(ctx context.Context, tenant string, entry *tenantEntry) {
+	store, closeStore, err := s.open(ctx, tenant)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		entry.err = fmt.Errorf("failed to open store for tenant %q: %w", tenant, err)
+		if s.stores[tenant] == entry {
+			delete(s.stores, tenant)
+		}
+	} else {
+		entry.store = store
+		if closeStore != nil {
+			s.closes = append(s.closes, closeStore)
+		}
+	}
+	close(entry.ready)
+}
+
+// Tenants returns the tenants whose stores have been opened, sorted.
+func (s *TenantStore) Tenants() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tenants := make([]string, 0, len(s.stores))
+	for tenant, entry := range s.stores {
+		select {
+		case <-entry.ready:
+			tenants = append(tenants, tenant)
+		default:
+		}
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// Close closes all opened tenant stores, newest first, and returns the
+// first error.
+func (s *TenantStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var firstErr error
+	for i := len(s.closes) - 1; i >= 0; i-- {
+		if err := s.closes[i](); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.closes = nil
+	s.stores = make(map[string]*tenantEntry)
+	return firstErr
+}
+
+func (s *TenantStore) Widgets() WidgetRepository          { return tenantWidgets{s} }
+func (s *TenantStore) Products() ProductRepository        { return tenantProducts{s} }
+func (s *TenantStore) Categories() CategoryRepository     { return tenantCategories{s} }
+func (s *TenantStore) Reviews() ReviewRepository          { return tenantReviews{s} }
+func (s *TenantStore) Users() UserRepository              { return tenantUsers{s} }
+func (s *TenantStore) Employees() EmployeeRepository      { return tenantEmployees{s} }
+func (s *TenantStore) StockLedger() StockLedgerRepository { return tenantStockLedger{s} }
+func (s *TenantStore) Audit() AuditRepository             { return tenantAudit{s} }
+func (s *TenantStore) ProductStatusHistory() ProductStatusHistoryRepository {
+	return tenantStatusHistory{s}
+}
+func (s *TenantStore) Orders() OrderRepository { return tenantOrders{s} }
+func (s *TenantStore) Carts() CartRepository   { return tenantCarts{s} }
+func (s *TenantStore) ProductVariants() ProductVariantRepository {
+	return tenantVariants{s}
+}
+func (s *TenantStore) ProductPriceHistory() ProductPriceHistoryRepository {
+	return tenantPriceHistory{s}
+}
+func (s *TenantStore) EmployeeRoleHistory() EmployeeRoleHistoryRepository {
+	return tenantRoleHistory{s}
+}
+func (s *TenantStore) RatingSummaries() RatingSummaryRepository {
+	return tenantRatings{s}
+}
+func (s *TenantStore) Events() *Events { return s.events }
+
+func (s *TenantStore) Snapshot(ctx context.Context) (*Snapshot, error) {
+	return routed(ctx, s, func(store Store) (*Snapshot, error) { return store.Snapshot(ctx) })
+}
+
+func (s *TenantStore) Restore(ctx context.Context, snapshot *Snapshot) error {
+	store, err := s.For(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Restore(ctx, snapshot)
+}
+
+// routed calls f with the Store of the tenant in ctx.
+func routed[T any](ctx context.Context, s *TenantStore, f func(Store) (T, error)) (T, error) {
+	store, err := s.For(ctx)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return f(store)
+}
+
+type tenantWidgets struct{ s *TenantStore }
+
+func (r tenantWidgets) Get(ctx context.Context, id int) (Widget, error) {
+	return routed(ctx, r.s, func(s Store) (Widget, error) { return s.Widgets().Get(ctx, id) })
+}
+
+func (r tenantWidgets) List(ctx context.Context) ([]Widget, error) {
+	return routed(ctx, r.s, func(s Store) ([]Widget, error) { return s.Widgets().List(ctx) })
+}
+
+func (r tenantWidgets) Create(ctx context.Context, widget Widget) (Widget, error) {
+	return routed(ctx, r.s, func(s Store) (Widget, error) { return s.Widgets().Create(ctx, widget) })
+}
+
+func (r tenantWidgets) Update(ctx context.Context, widget Widget) (Widget, error) {
+	return routed(ctx, r.s, func(s Store) (Widget, error) { return s.Widgets().Update(ctx, widget) })
+}
+
+func (r tenantWidgets) AdjustStock(ctx context.Context, entry StockEntry) (Widget, StockEntry, error) {
+	store, err := r.s.For(ctx)
+	if err != nil {
+		return Widget{}, StockEntry{}, err
+	}
+	return store.Widgets().AdjustStock(ctx, entry)
+}
+
+type tenantProducts struct{ s *TenantStore }
+
+func (r tenantProducts) Get(ctx context.Context, id int) (Product, error) {
+	return routed(ctx, r.s, func(s Store) (Product, error) { return s.Products().Get(ctx, id) })
+}
+
+func (r tenantProducts) List(ctx context.Context) ([]Product, error) {
+	return routed(ctx, r.s, func(s Store) ([]Product, error) { return s.Products().List(ctx) })
+}
+
+func (r tenantProducts) ListByCategory(ctx context.Context, categoryID int) ([]Product, error) {
+	return routed(ctx, r.s, func(s Store) ([]Product, error) { return s.Products().ListByCategory(ctx, categoryID) })
+}
+
+func (r tenantProducts) Create(ctx context.Context, product Product) (Product, error) {
+	return routed(ctx, r.s, func(s Store) (Product, error) { return s.Products().Create(ctx, product) })
+}
+
+func (r tenantProducts) Update(ctx context.Context, product Product) (Product, error) {
+	return routed(ctx, r.s, func(s Store) (Product, error) { return s.Products().Update(ctx, product) })
+}
+
+type tenantOrders struct{ s *TenantStore }
+
+func (r tenantOrders) Get(ctx context.Context, id int) (Order, error) {
+	return routed(ctx, r.s, func(s Store) (Order, error) { return s.Orders().Get(ctx, id) })
+}
+
+func (r tenantOrders) List(ctx context.Context) ([]Order, error) {
+	return routed(ctx, r.s, func(s Store) ([]Order, error) { return s.Orders().List(ctx) })
+}
+
+func (r tenantOrders) ListByUser(ctx context.Context, userID int) ([]Order, error) {
+	return routed(ctx, r.s, func(s Store) ([]Order, error) { return s.Orders().ListByUser(ctx, userID) })
+}
+
+func (r tenantOrders) Create(ctx context.Context, order Order) (Order, error) {
+	return routed(ctx, r.s, func(s Store) (Order, error) { return s.Orders().Create(ctx, order) })
+}
+
+func (r tenantOrders) Place(ctx context.Context, order Order) (Order, error) {
+	return routed(ctx, r.s, func(s Store) (Order, error) { return s.Orders().Place(ctx, order) })
+}
+
+func (r tenantOrders) Update(ctx context.Context, order Order) (Order, error) {
+	return routed(ctx, r.s, func(s Store) (Order, error) { return s.Orders().Update(ctx, order) })
+}
+
+func (r tenantOrders) Cancel(ctx context.Context, order Order) (Order, error) {
+	return routed(ctx, r.s, func(s Store) (Order, error) { return s.Orders().Cancel(ctx, order) })
+}
+
+type tenantCarts struct{ s *TenantStore }
+
+func (r tenantCarts) Get(ctx context.Context, userID int) (Cart, error) {
+	return routed(ctx, r.s, func(s Store) (Cart, error) { return s.Carts().Get(ctx, userID) })
+}
+
+func (r tenantCarts) List(ctx context.Context) ([]Cart, error) {
+	return routed(ctx, r.s, func(s Store) ([]Cart, error) { return s.Carts().List(ctx) })
+}
+
+func (r tenantCarts) Put(ctx context.Context, cart Cart) (Cart, error) {
+	return routed(ctx, r.s, func(s Store) (Cart, error) { return s.Carts().Put(ctx, cart) })
+}
+
+type tenantVariants struct{ s *TenantStore }
+
+func (r tenantVariants) Get(ctx context.Context, id int) (ProductVariant, error) {
+	return routed(ctx, r.s, func(s Store) (ProductVariant, error) { return s.ProductVariants().Get(ctx, id) })
+}
+
+func (r tenantVariants) List(ctx context.Context) ([]ProductVariant, error) {
+	return routed(ctx, r.s, func(s Store) ([]ProductVariant, error) { return s.ProductVariants().List(ctx) })
+}
+
+func (r tenantVariants) ListByProducts(ctx context.Context, productIDs []int) ([]ProductVariant, error) {
+	return routed(ctx, r.s, func(s Store) ([]ProductVariant, error) { return s.ProductVariants().ListByProducts(ctx, productIDs) })
+}
+
+func (r tenantVariants) Create(ctx context.Context, variant ProductVariant) (ProductVariant, error) {
+	return routed(ctx, r.s, func(s Store) (ProductVariant, error) { return s.ProductVariants().Create(ctx, variant) })
+}
+
+func (r tenantVariants) Update(ctx context.Context, variant ProductVariant) (ProductVariant, error) {
+	return routed(ctx, r.s, func(s Store) (ProductVariant, error) { return s.ProductVariants().Update(ctx, variant) })
+}
+
+func (r tenantVariants) Delete(ctx context.Context, id int) (ProductVariant, error) {
+	return routed(ctx, r.s, func(s Store) (ProductVariant, error) { return s.ProductVariants().Delete(ctx, id) })
+}
+
+type tenantCategories struct{ s *TenantStore }
+
+func (r tenantCategories) Get(ctx context.Context, id int) (Category, error) {
+	return routed(ctx, r.s, func(s Store) (Category, error) { return s.Categories().Get(ctx, id) })
+}
+
+func (r tenantCategories) List(ctx context.Context) ([]Category, error) {
+	return routed(ctx, r.s, func(s Store) ([]Category, error) { return s.Categories().List(ctx) })
+}
+
+func (r tenantCategories) GetMany(ctx context.Context, ids []int) ([]Category, error) {
+	return routed(ctx, r.s, func(s Store) ([]Category, error) { return s.Categories().GetMany(ctx, ids) })
+}
+
+func (r tenantCategories) Create(ctx context.Context, category Category) (Category, error) {
+	return routed(ctx, r.s, func(s Store) (Category, error) { return s.Categories().Create(ctx, category) })
+}
+
+func (r tenantCategories) Update(ctx context.Context, category Category) (Category, error) {
+	return routed(ctx, r.s, func(s Store) (Category, error) { return s.Categories().Update(ctx, category) })
+}
+
+func (r tenantCategories) Move(ctx context.Context, id int, parentID *int) (Category, error) {
+	return routed(ctx, r.s, func(s Store) (Category, error) { return s.Categories().Move(ctx, id, parentID) })
+}
+
+func (r tenantCategories) Delete(ctx context.Context, id int, deletedAt time.Time, deletedBy int) (Category, error) {
+	return routed(ctx, r.s, func(s Store) (Category, error) { return s.Categories().Delete(ctx, id, deletedAt, deletedBy) })
+}
+
+type tenantReviews struct{ s *TenantStore }
+
+func (r tenantReviews) Get(ctx context.Context, id int) (Review, error) {
+	return routed(ctx, r.s, func(s Store) (Review, error) { return s.Reviews().Get(ctx, id) })
+}
+
+func (r tenantReviews) List(ctx context.Context) ([]Review, error) {
+	return routed(ctx, r.s, func(s Store) ([]Review, error) { return s.Reviews().List(ctx) })
+}
+
+func (r tenantReviews) ListByProduct(ctx context.Context, productID int) ([]Review, error) {
+	return routed(ctx, r.s, func(s Store) ([]Review, error) { return s.Reviews().ListByProduct(ctx, productID) })
+}
+
+func (r tenantReviews) ListByUser(ctx context.Context, userID int) ([]Review, error) {
+	return routed(ctx, r.s, func(s Store) ([]Review, error) { return s.Reviews().ListByUser(ctx, userID) })
+}
+
+func (r tenantReviews) ListByProducts(ctx context.Context, productIDs []int) ([]Review, error) {
+	return routed(ctx, r.s, func(s Store) ([]Review, error) { return s.Reviews().ListByProducts(ctx, productIDs) })
+}
+
+func (r tenantReviews) ListByUsers(ctx context.Context, userIDs []int) ([]Review, error) {
+	return routed(ctx, r.s, func(s Store) ([]Review, error) { return s.Reviews().ListByUsers(ctx, userIDs) })
+}
+
+func (r tenantReviews) Create(ctx context.Context, review Review) (Review, error) {
+	return routed(ctx, r.s, func(s Store) (Review, error) { return s.Reviews().Create(ctx, review) })
+}
+
+func (r tenantReviews) Update(ctx context.Context, review Review) (Review, error) {
+	return routed(ctx, r.s, func(s Store) (Review, error) { return s.Reviews().Update(ctx, review) })
+}
+
+type tenantUsers struct{ s *TenantStore }
+
+func (r tenantUsers) Get(ctx context.Context, id int) (User, error) {
+	return routed(ctx, r.s, func(s Store) (User, error) { return s.Users().Get(ctx, id) })
+}
+
+func (r tenantUsers) List(ctx context.Context) ([]User, error) {
+	return routed(ctx, r.s, func(s Store) ([]User, error) { return s.Users().List(ctx) })
+}
+
+func (r tenantUsers) GetMany(ctx context.Context, ids []int) ([]User, error) {
+	return routed(ctx, r.s, func(s Store) ([]User, error) { return s.Users().GetMany(ctx, ids) })
+}
+
+func (r tenantUsers) Create(ctx context.Context, user User) (User, error) {
+	return routed(ctx, r.s, func(s Store) (User, error) { return s.Users().Create(ctx, user) })
+}
+
+type tenantEmployees struct{ s *TenantStore }
+
+func (r tenantEmployees) Get(ctx context.Context, id int) (*Employee, error) {
+	return routed(ctx, r.s, func(s Store) (*Employee, error) { return s.Employees().Get(ctx, id) })
+}
+
+func (r tenantEmployees) List(ctx context.Context) ([]*Employee, error) {
+	return routed(ctx, r.s, func(s Store) ([]*Employee, error) { return s.Employees().List(ctx) })
+}
+
+func (r tenantEmployees) GetMany(ctx context.Context, ids []int) ([]*Employee, error) {
+	return routed(ctx, r.s, func(s Store) ([]*Employee, error) { return s.Employees().GetMany(ctx, ids) })
+}
+
+func (r tenantEmployees) ListByManagers(ctx context.Context, managerIDs []int) ([]*Employee, error) {
+	return routed(ctx, r.s, func(s Store) ([]*Employee, error) { return s.Employees().ListByManagers(ctx, managerIDs) })
+}
+
+func (r tenantEmployees) Create(ctx context.Context, employee *Employee) (*Employee, error) {
+	return routed(ctx, r.s, func(s Store) (*Employee, error) { return s.Employees().Create(ctx, employee) })
+}
+
+func (r tenantEmployees) Update(ctx context.Context, employee *Employee) (*Employee, error) {
+	return routed(ctx, r.s, func(s Store) (*Employee, error) { return s.Employees().Update(ctx, employee) })
+}
+
+func (r tenantEmployees) AssignManager(ctx context.Context, id int, managerID *int, version int) (*Employee, error) {
+	return routed(ctx, r.s, func(s Store) (*Employee, error) { return s.Employees().AssignManager(ctx, id, managerID, version) })
+}
+
+func (r tenantEmployees) ReassignReports(ctx context.Context, fromManagerID int, toManagerID *int) ([]*Employee, error) {
+	return routed(ctx, r.s, func(s Store) ([]*Employee, error) {
+		return s.Employees().ReassignReports(ctx, fromManagerID, toManagerID)
+	})
+}
+
+type tenantStockLedger struct{ s *TenantStore }
+
+func (r tenantStockLedger) List(ctx context.Context) ([]StockEntry, error) {
+	return routed(ctx, r.s, func(s Store) ([]StockEntry, error) { return s.StockLedger().List(ctx) })
+}
+
+func (r tenantStockLedger) ListByWidget(ctx context.Context, widgetID int) ([]StockEntry, error) {
+	return routed(ctx, r.s, func(s Store) ([]StockEntry, error) { return s.StockLedger().ListByWidget(ctx, widgetID) })
+}
+
+func (r tenantStockLedger) Append(ctx context.Context, entry StockEntry) (StockEntry, error) {
+	return routed(ctx, r.s, func(s Store) (StockEntry, error) { return s.StockLedger().Append(ctx, entry) })
+}
+
+type tenantStatusHistory struct{ s *TenantStore }
+
+func (r tenantStatusHistory) List(ctx context.Context) ([]ProductStatusChange, error) {
+	return routed(ctx, r.s, func(s Store) ([]ProductStatusChange, error) { return s.ProductStatusHistory().List(ctx) })
+}
+
+func (r tenantStatusHistory) ListByProduct(ctx context.Context, productID int) ([]ProductStatusChange, error) {
+	return routed(ctx, r.s, func(s Store) ([]ProductStatusChange, error) {
+		return s.ProductStatusHistory().ListByProduct(ctx, productID)
+	})
+}
+
+func (r tenantStatusHistory) Append(ctx context.Context, entry ProductStatusChange) (ProductStatusChange, error) {
+	return routed(ctx, r.s, func(s Store) (ProductStatusChange, error) { return s.ProductStatusHistory().Append(ctx, entry) })
+}
+
+type tenantAudit struct{ s *TenantStore }
+
+func (r tenantAudit) Append(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	return routed(ctx, r.s, func(s Store) (AuditEntry, error) { return s.Audit().Append(ctx, entry) })
+}
+
+func (r tenantAudit) List(ctx context.Context, filter AuditFilter, before, limit int) ([]AuditEntry, error) {
+	return routed(ctx, r.s, func(s Store) ([]AuditEntry, error) { return s.Audit().List(ctx, filter, before, limit) })
+}
+
+type tenantPriceHistory struct{ s *TenantStore }
+
+func (r tenantPriceHistory) List(ctx context.Context) ([]ProductPriceChange, error) {
+	return routed(ctx, r.s, func(s Store) ([]ProductPriceChange, error) { return s.ProductPriceHistory().List(ctx) })
+}
+
+func (r tenantPriceHistory) ListByProduct(ctx context.Context, productID int) ([]ProductPriceChange, error) {
+	return routed(ctx, r.s, func(s Store) ([]ProductPriceChange, error) {
+		return s.ProductPriceHistory().ListByProduct(ctx, productID)
+	})
+}
+
+func (r tenantPriceHistory) Append(ctx context.Context, entry ProductPriceChange) (ProductPriceChange, error) {
+	return routed(ctx, r.s, func(s Store) (ProductPriceChange, error) { return s.ProductPriceHistory().Append(ctx, entry) })
+}
+
+type tenantRoleHistory struct{ s *TenantStore }
+
+func (r tenantRoleHistory) List(ctx context.Context) ([]EmployeeRoleChange, error) {
+	return routed(ctx, r.s, func(s Store) ([]EmployeeRoleChange, error) { return s.EmployeeRoleHistory().List(ctx) })
+}
+
+func (r tenantRoleHistory) ListByEmployee(ctx context.Context, employeeID int) ([]EmployeeRoleChange, error) {
+	return routed(ctx, r.s, func(s Store) ([]EmployeeRoleChange, error) {
+		return s.EmployeeRoleHistory().ListByEmployee(ctx, employeeID)
+	})
+}
+
+func (r tenantRoleHistory) Append(ctx context.Context, entry EmployeeRoleChange) (EmployeeRoleChange, error) {
+	return routed(ctx, r.s, func(s Store) (EmployeeRoleChange, error) { return s.EmployeeRoleHistory().Append(ctx, entry) })
+}
+
+type tenantRatings struct{ s *TenantStore }
+
+func (r tenantRatings) Get(ctx context.Context, productID int) (RatingSummary, error) {
+	return routed(ctx, r.s, func(s Store) (RatingSummary, error) { return s.RatingSummaries().Get(ctx, productID) })
+}
+
+func (r tenantRatings) List(ctx context.Context) ([]RatingSummary, error) {
+	return routed(ctx, r.s, func(s Store) ([]RatingSummary, error) { return s.RatingSummaries().List(ctx) })
+}
+
+func (r tenantRatings) ListByProducts(ctx context.Context, productIDs []int) ([]RatingSummary, error) {
+	return routed(ctx, r.s, func(s Store) ([]RatingSummary, error) { return s.RatingSummaries().ListByProducts(ctx, productIDs) })
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestTenantStore(t *testing.T, allowed ...string) *TenantStore {
+	t.Helper()
+	store := NewTenantStore(func(ctx context.Context, tenant string) (Store, func() error, error) {
+		store := NewMemoryStore()
+		return store, nil, SeedSampleData(ctx, store)
+	}, allowed...)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestTenantStoreIsolatesTenants(t *testing.T) {
+	ctx := context.Background()
+	store := newTestTenantStore(t, "acme")
+	graph := newTestGraph(ctx, store)
+	RegisterSearchHandlers(ctx, graph, store)
+
+	acmeCtx := WithTenant(ctx, "acme")
+	admin, err := store.Users().Get(acmeCtx, 1)
+	if err != nil {
+		t.Fatalf("Get admin failed: %v", err)
+	}
+	acmeAdminCtx := context.WithValue(acmeCtx, UserContextKey, &admin)
+
+	run := func(ctx context.Context, request string) string {
+		res, _ := graph.ProcessRequest(ctx, request, "")
+		return res
+	}
+
+	run(acmeAdminCtx, `mutation {
+		CreateWidget(widget: {name: "Acme Anvil", price: 10, quantity: 1}) { id }
+		DeleteProduct(id: 1) { ID }
+		TerminateEmployee(employeeId: 1) { __typename }
+	}`)
+
+	res := run(acmeCtx, `{ GetWidgets { edges { node { name } } } Search(query: "laptop") { totalCount } GetAllEmployees { totalCount } }`)
+	if !strings.Contains(res, "Acme Anvil") || !strings.Contains(res, `"Search":{"totalCount":0}`) {
+		t.Errorf("expected the acme changes to be visible to acme, got %s", res)
+	}
+	res = run(ctx, `{ GetWidgets { edges { node { name } } } Search(query: "laptop") { totalCount } GetProduct(id: 1) { Name } }`)
+	if strings.Contains(res, "Acme Anvil") || strings.Contains(res, `"Search":{"totalCount":0}`) || !strings.Contains(res, "Laptop") {
+		t.Errorf("expected the default tenant to be unaffected, got %s", res)
+	}
+
+	acmeEmployees, _ := store.Employees().List(acmeCtx)
+	defaultEmployees, _ := store.Employees().List(ctx)
+	if len(withoutDeleted(acmeEmployees, false)) != len(defaultEmployees)-1 {
+		t.Errorf("expected the termination to only affect acme")
+	}
+
+	if res := run(WithTenant(ctx, "globex"), `{ GetWidgets { totalCount } }`); !strings.Contains(res, `unknown tenant \"globex\"`) {
+		t.Errorf("expected an unlisted tenant to be rejected, got %s", res)
+	}
+	if got := store.Tenants(); len(got) != 2 || got[0] != "acme" || got[1] != DefaultTenant {
+		t.Errorf("expected the acme and default stores to be open, got %v", got)
+	}
+}
+
+func TestProductUpdatesAreScopedToTenant(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newTestTenantStore(t, "acme")
+	h := NewSubscriptionHandlers(store)
+	acmeCtx := WithTenant(ctx, "acme")
+	acmeA, _ := h.ProductUpdates(acmeCtx, intPtr(-1), nil)
+	acmeB, _ := h.ProductUpdates(acmeCtx, nil, nil)
+	other, _ := h.ProductUpdates(ctx, nil, nil)
+
+	store.Events().BroadcastProductUpdate(acmeCtx, Product{ID: 7, Name: "Acme Rocket"}, "created")
+
+	// Every subscriber of the tenant gets the event
+	for _, ch := range []<-chan ProductUpdate{acmeA, acmeB} {
+		select {
+		case update := <-ch:
+			if update.Product.ID != 7 {
+				t.Errorf("unexpected update %+v", update)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the acme subscriber to receive the update")
+		}
+	}
+	select {
+	case update := <-other:
+		t.Errorf("expected the default tenant to receive nothing, got %+v", update)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTokensOnlySignInToTheirTenant(t *testing.T) {
+	ctx := context.Background()
+	store := newTestTenantStore(t, "acme")
+	graph := newTestGraph(ctx, store)
+	handler := TenantMiddleware(AuthMiddleware(store, graph.HttpHandler()))
+	post := func(tenant, auth, query string) (int, string) {
+		body, _ := json.Marshal(map[string]string{"query": query})
+		req := httptest.NewRequest("POST", "/graphql", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if tenant != "" {
+			req.Header.Set(TenantHeader, tenant)
+		}
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	// The admin of the default tenant is nobody in acme
+	status, body := post("acme", "Bearer admin-token", `mutation { DeleteProduct(id: 1) { ID } }`)
+	if status != http.StatusBadRequest || !strings.Contains(body, "does not match the tenant of the bearer token") {
+		t.Errorf("expected a default tenant token to be rejected for acme, got %d %s", status, body)
+	}
+	if user := GetUserFromAuthHeader(WithTenant(ctx, "acme"), store, "Bearer admin-token"); user != nil {
+		t.Errorf("expected no acme user for a default tenant token, got %+v", user)
+	}
+	if product, err := store.Products().Get(WithTenant(ctx, "acme"), 1); err != nil || product.isDeleted() {
+		t.Errorf("expected the acme product to be untouched, got %+v, %v", product, err)
+	}
+
+	// acme tokens sign in to acme, and only there
+	if _, body := post("", "Bearer acme:admin-token", `mutation { DeleteProduct(id: 1) { ID } }`); !strings.Contains(body, `"DeleteProduct":{"ID":1}`) {
+		t.Errorf("expected the acme admin to delete the acme product, got %s", body)
+	}
+	if status, _ := post(DefaultTenant, "Bearer acme:admin-token", `{ GetProduct(id: 1) { ID } }`); status != http.StatusBadRequest {
+		t.Errorf("expected an acme token to be rejected for the default tenant, got %d", status)
+	}
+	if product, err := store.Products().Get(ctx, 1); err != nil || product.isDeleted() {
+		t.Errorf("expected the default product to be untouched, got %+v, %v", product, err)
+	}
+}
+
+func TestTenantFromRequest(t *testing.T) {
+	jwt := func(payload string) string {
+		return "Bearer e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+	}
+	for name, tc := range map[string]struct {
+		header, auth string
+		want, err    string
+	}{
+		"none":           {want: DefaultTenant},
+		"header":         {header: "acme", want: "acme"},
+		"demo token":     {auth: "Bearer admin-token", want: DefaultTenant},
+		"tenant token":   {auth: "Bearer acme:admin-token", want: "acme"},
+		"token mismatch": {header: "acme", auth: "Bearer admin-token", err: "does not match"},
+		"claim":          {auth: jwt(`{"sub":"1","tenant":"acme"}`), want: "acme"},
+		"claim agrees":   {header: "acme", auth: jwt(`{"tenant":"acme"}`), want: "acme"},
+		"claim mismatch": {header: "globex", auth: jwt(`{"tenant":"acme"}`), err: "does not match"},
+		"claim type":     {auth: jwt(`{"tenant":42}`), err: "must be a string"},
+		"invalid id":     {header: "../etc", err: "invalid tenant ID"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/graphql", nil)
+			if tc.header != "" {
+				r.Header.Set(TenantHeader, tc.header)
+			}
+			if tc.auth != "" {
+				r.Header.Set("Authorization", tc.auth)
+			}
+			got, err := TenantFromRequest(r)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error containing %q, got %q, %v", tc.err, got, err)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("expected %q, got %q, %v", tc.want, got, err)
+			}
+		})
+	}
+}
+
+func TestParseTenantList(t *testing.T) {
+	for _, tc := range []struct {
+		list string
+		want []string
+		err  string
+	}{
+		{list: "", want: nil},
+		{list: "acme, beta", want: []string{"acme", "beta"}},
+		{list: " acme,,*, ", want: []string{"acme", AnyTenant}},
+		{list: "acme,../etc", err: "invalid tenant ID"},
+	} {
+		got, err := ParseTenantList(tc.list)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("ParseTenantList(%q): expected error containing %q, got %q, %v", tc.list, tc.err, got, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseTenantList(%q) = %q, %v, want %q", tc.list, got, err, tc.want)
+		}
+	}
+}
+
+func TestTenantFile(t *testing.T) {
+	for _, tc := range []struct{ path, tenant, want string }{
+		{"data/app.db", DefaultTenant, "data/app.db"},
+		{"data/app.db", "acme", "data/app.acme.db"},
+		{"events", "acme", "events.acme"},
+		{":memory:", "acme", ":memory:"},
+	} {
+		if got := TenantFile(tc.path, tc.tenant); got != tc.want {
+			t.Errorf("TenantFile(%q, %q) = %q, want %q", tc.path, tc.tenant, got, tc.want)
+		}
+	}
+}
+
+func TestTenantStoreOpensEachTenantOnce(t *testing.T) {
+	ctx := context.Background()
+	var opens atomic.Int32
+	release := make(chan struct{})
+	store := NewTenantStore(func(ctx context.Context, tenant string) (Store, func() error, error) {
+		opens.Add(1)
+		if tenant == "slow" {
+			<-release
+		}
+		return NewMemoryStore(), nil, nil
+	}, "slow")
+	defer store.Close()
+
+	if _, err := store.For(WithTenant(ctx, "globex")); err == nil || !strings.Contains(err.Error(), `unknown tenant "globex"`) {
+		t.Errorf("expected an unlisted tenant to be rejected, got %v", err)
+	}
+
+	// A tenant that is still opening blocks neither other tenants nor
+	// requests that give up waiting
+	done := make(chan Store)
+	for i := 0; i < 2; i++ {
+		go func() {
+			s, _ := store.For(WithTenant(ctx, "slow"))
+			done <- s
+		}()
+	}
+	for opens.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := store.For(ctx); err != nil {
+		t.Fatalf("expected the default tenant to open, got %v", err)
+	}
+	waitCtx, cancel := context.WithTimeout(WithTenant(ctx, "slow"), 10*time.Millisecond)
+	defer cancel()
+	if _, err := store.For(waitCtx); err != context.DeadlineExceeded {
+		t.Errorf("expected waiting to stop with the context, got %v", err)
+	}
+
+	close(release)
+	if a, b := <-done, <-done; a == nil || a != b {
+		t.Errorf("expected both requests to get the same store, got %p and %p", a, b)
+	}
+	if n := opens.Load(); n != 2 {
+		t.Errorf("expected one open per tenant, got %d", n)
+	}
+}
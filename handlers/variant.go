@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A product can come in variants, such as the sizes and colors of a shirt.
+// Each variant has its own SKU and stock, and can override the product
+// price. Once a product has variants, its stock is the sum of theirs: it is
+// kept in step whenever a variant changes, and the product moves between
+// ACTIVE and OUT_OF_STOCK with it. Orders and carts then name the variant
+// along with the product.
+
+type ProductVariant struct {
+	ID            int
+	ProductID     int
+	SKU           string
+	Color         *HexColor
+	Size          *string
+	PriceOverride *Money // Replaces the product price for this variant
+	Stock         int
+	Version       int // Incremented on every update
+
+	// Scope used by the field resolvers below
+	scope *scope `json:"-" graphy:"-"`
+}
+
+// ProductVariantInput describes a variant. On update every field is
+// replaced, except that an unset stock keeps the current stock.
+type ProductVariantInput struct {
+	SKU           string    `json:"sku"`
+	Color         *HexColor `json:"color"`
+	Size          *string   `json:"size"`
+	PriceOverride *Money    `json:"priceOverride"`
+	Stock         *int      `json:"stock"`
+}
+
+// AddProductVariant adds a variant to a product. The first variant of a
+// product takes over the stock the product had so far, on top of its own.
+// Requires the admin role.
+func (h *ProductHandlers) AddProductVariant(ctx context.Context, productId int, input ProductVariantInput) (*ProductVariant, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	product, err := h.store.Products().Get(ctx, productId)
+	if errors.Is(err, ErrNotFound) || (err == nil && product.isDeleted()) {
+		return nil, fmt.Errorf("product with id %d not found", productId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	variant := ProductVariant{ProductID: productId}
+	if err := h.applyVariantInput(ctx, &variant, input); err != nil {
+		return nil, err
+	}
+	variants, err := h.store.ProductVariants().ListByProducts(ctx, []int{productId})
+	if err != nil {
+		return nil, err
+	}
+	if len(variants) == 0 {
+		variant.Stock += product.Stock
+	}
+	variant, err = h.store.ProductVariants().Create(ctx, variant)
+	if err != nil {
+		return nil, err
+	}
+	return h.variantChanged(ctx, variant)
+}
+
+// UpdateProductVariant replaces a variant. Requires the admin role.
+func (h *ProductHandlers) UpdateProductVariant(ctx context.Context, id int, input ProductVariantInput, version int) (*ProductVariant, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	variant, err := h.store.ProductVariants().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("variant with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	variant.Version = version
+	if err := h.applyVariantInput(ctx, &variant, input); err != nil {
+		return nil, err
+	}
+	variant, err = h.store.ProductVariants().Update(ctx, variant)
+	if err != nil {
+		return nil, err
+	}
+	return h.variantChanged(ctx, variant)
+}
+
+// RemoveProductVariant removes a variant and returns it, along with its
+// stock. Once the last variant is gone the product has no stock until it is
+// restocked. Orders keep referring to the variant, and carts holding it fail
+// at checkout. Requires the admin role.
+func (h *ProductHandlers) RemoveProductVariant(ctx context.Context, id int) (*ProductVariant, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	variant, err := h.store.ProductVariants().Delete(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("variant with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.variantChanged(ctx, variant)
+}
+
+// applyVariantInput validates input and copies it to variant.
+func (h *ProductHandlers) applyVariantInput(ctx context.Context, variant *ProductVariant, input ProductVariantInput) error {
+	sku := strings.TrimSpace(input.SKU)
+	if sku == "" {
+		return errors.New("SKU is required")
+	}
+	if input.Color != nil && !isValidHexColor(string(*input.Color)) {
+		return fmt.Errorf("invalid hex color format: %s", *input.Color)
+	}
+	if input.PriceOverride != nil {
+		if input.PriceOverride.Currency != storeCurrency {
+			return fmt.Errorf("price override must be in %s", storeCurrency)
+		}
+		if input.PriceOverride.Amount <= 0 {
+			return errors.New("price override must be greater than zero")
+		}
+	}
+	if input.Stock != nil && *input.Stock < 0 {
+		return errors.New("stock cannot be negative")
+	}
+
+	variants, err := h.store.ProductVariants().List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range variants {
+		if v.ID != variant.ID && strings.EqualFold(v.SKU, sku) {
+			return fmt.Errorf("SKU %s is already used by variant %d", sku, v.ID)
+		}
+	}
+
+	variant.SKU, variant.Color, variant.Size, variant.PriceOverride = sku, input.Color, input.Size, input.PriceOverride
+	if input.Stock != nil {
+		variant.Stock = *input.Stock
+	}
+	return nil
+}
+
+// variantChanged brings the product of variant in step with the stock of
+// its variants and returns variant bound to a scope.
+func (h *ProductHandlers) variantChanged(ctx context.Context, variant ProductVariant) (*ProductVariant, error) {
+	if _, err := h.syncVariantStock(ctx, variant.ProductID); err != nil {
+		return nil, fmt.Errorf("variant %d was saved: %w", variant.ID, err)
+	}
+	variant.scope = newScope(ctx, h.store)
+	return &variant, nil
+}
+
+// syncVariantStock sets the stock of a product to the sum of the stock of
+// its variants, which is 0 once its last variant was removed, then moves
+// the product between ACTIVE and OUT_OF_STOCK as updateStockStatus does.
+// InStock follows the status rules of updateInStock.
+func (h *ProductHandlers) syncVariantStock(ctx context.Context, productID int) (*Product, error) {
+	product, err := h.store.Products().Get(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := h.store.ProductVariants().ListByProducts(ctx, []int{productID})
+	if err != nil {
+		return nil, err
+	}
+	stock := 0
+	for _, v := range variants {
+		stock += v.Stock
+	}
+	product.Stock = stock
+	product.updateInStock()
+	if product, err = h.store.Products().Update(ctx, product); err != nil {
+		return nil, err
+	}
+	return h.updateStockStatus(ctx, product)
+}
+
+// variantOf returns the variant with variantID of the product with
+// productID.
+func (h *ProductHandlers) variantOf(ctx context.Context, productID, variantID int) (ProductVariant, error) {
+	variant, err := h.store.ProductVariants().Get(ctx, variantID)
+	if errors.Is(err, ErrNotFound) || (err == nil && variant.ProductID != productID) {
+		return ProductVariant{}, fmt.Errorf("product %d has no variant %d", productID, variantID)
+	}
+	return variant, err
+}
+
+// unitPrice returns the price of the variant, which is its override or the
+// price of product.
+func (v ProductVariant) unitPrice(product Product) Money {
+	if v.PriceOverride != nil {
+		return *v.PriceOverride
+	}
+	return product.Price
+}
+
+// Field resolvers
+
+// Variants returns the variants of the product ordered by ID.
+func (p *Product) Variants() ([]ProductVariant, error) {
+	variants, _, err := p.scope.loaders.productVariants.load(p.ID)
+	return variants, err
+}
+
+// Price returns the price of the variant: its override, or else the price
+// of its product.
+func (v *ProductVariant) Price() (Money, error) {
+	if v.PriceOverride != nil {
+		return *v.PriceOverride, nil
+	}
+	product, err := v.scope.store.Products().Get(v.scope.ctx, v.ProductID)
+	if err != nil {
+		return Money{}, err
+	}
+	return product.Price, nil
+}
+
+func (v *ProductVariant) Product() (*Product, error) {
+	return itemProduct(v.scope, &v.ProductID)
+}
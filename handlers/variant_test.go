@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestProductVariants(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		graph := newTestGraph(ctx, store)
+		admin, customer := userContext(t, store, 1), userContext(t, store, 2)
+		run := func(ctx context.Context, request string) string {
+			res, _ := graph.ProcessRequest(ctx, request, "")
+			return res
+		}
+
+		for _, tc := range []struct {
+			ctx           context.Context
+			request, want string
+		}{
+			{customer, `mutation { AddProductVariant(productId: 3, input: {sku: "TEE-M"}) { ID } }`, "admin role required"},
+
+			// The out of stock T-shirt goes back on sale with its first variant
+			{admin, `mutation { AddProductVariant(productId: 3, input: {sku: "TEE-M", color: "#FF0000", size: "M", stock: 5}) { ID Price Stock } }`,
+				`"ID":1,"Price":"24.99 USD","Stock":5`},
+			{admin, `mutation { AddProductVariant(productId: 3, input: {sku: "TEE-XL", size: "XL", priceOverride: "27.99 USD", stock: 2}) { ID Price } }`,
+				`"ID":2,"Price":"27.99 USD"`},
+			{admin, `{ GetProduct(id: 3) { InStock Status Stock Variants { SKU Color Size } } }`,
+				`"InStock":true,"Status":"ACTIVE","Stock":7,"Variants":[{"Color":"#FF0000","SKU":"TEE-M","Size":"M"},{"Color":null,"SKU":"TEE-XL","Size":"XL"}]`},
+
+			// Invalid variants are rejected
+			{admin, `mutation { AddProductVariant(productId: 2, input: {sku: "tee-m"}) { ID } }`, "SKU tee-m is already used by variant 1"},
+			{admin, `mutation { AddProductVariant(productId: 2, input: {sku: " "}) { ID } }`, "SKU is required"},
+			{admin, `mutation { AddProductVariant(productId: 2, input: {sku: "BOOK-EU", priceOverride: "9.99 EUR"}) { ID } }`,
+				"price override must be in USD"},
+			{admin, `mutation { AddProductVariant(productId: 9, input: {sku: "NONE"}) { ID } }`, "product with id 9 not found"},
+			{admin, `mutation { RestockProduct(id: 3, quantity: 5, version: 1) { ID } }`,
+				"product 3 has variants; restock them with UpdateProductVariant"},
+
+			// Products with variants are ordered by variant
+			{customer, `mutation { PlaceOrder(items: [{productId: 3, quantity: 1}]) { ID } }`,
+				"product 3 has variants; order one of them with variantId"},
+			{customer, `mutation { PlaceOrder(items: [{productId: 2, variantId: 1, quantity: 1}]) { ID } }`,
+				"product 2 has no variant 1"},
+			{customer, `mutation { PlaceOrder(items: [{productId: 3, variantId: 2, quantity: 3}]) { ID } }`,
+				`"extensions":{"code":"INSUFFICIENT_STOCK","productId":"3","stock":"2","variantId":"2"}`},
+			{customer, `mutation { AddToCart(productId: 3, variantId: 2, quantity: 2) { Items { Variant { SKU } } Subtotal } }`,
+				`"Items":[{"Variant":{"SKU":"TEE-XL"}}],"Subtotal":"55.98 USD"`},
+			{customer, `mutation { AddToCart(productId: 3, variantId: 1, quantity: 5) { Subtotal } }`, `"Subtotal":"180.93 USD"`},
+			{customer, `mutation { CheckoutCart { Items { Quantity UnitPrice Variant { SKU } } } }`,
+				`"Items":[{"Quantity":2,"UnitPrice":"27.99 USD","Variant":{"SKU":"TEE-XL"}},{"Quantity":5,"UnitPrice":"24.99 USD","Variant":{"SKU":"TEE-M"}}]`},
+			{customer, `{ GetProduct(id: 3) { InStock Status Stock Variants { Stock Version } } }`,
+				`"InStock":false,"Status":"OUT_OF_STOCK","Stock":0,"Variants":[{"Stock":0,"Version":2},{"Stock":0,"Version":2}]`},
+
+			// Restocking a variant puts the product back on sale
+			{admin, `mutation { UpdateProductVariant(id: 1, input: {sku: "TEE-M", size: "M", stock: 4}, version: 1) { ID } }`,
+				`"code":"VERSION_CONFLICT","currentVersion":"2","entity":"variant"`},
+			{admin, `mutation { UpdateProductVariant(id: 1, input: {sku: "TEE-M", size: "M", stock: 4}, version: 2) { Color Stock Version } }`,
+				`"Color":null,"Stock":4,"Version":3`},
+			{customer, `{ GetProduct(id: 3) { InStock Status Stock } }`, `"InStock":true,"Status":"ACTIVE","Stock":4`},
+
+			// Cancelling restocks the variants
+			{customer, `mutation { CancelOrder(id: 1) { Status } }`, `"Status":"CANCELLED"`},
+			{customer, `{ GetProduct(id: 3) { Stock Variants { Stock } } }`, `"Stock":11,"Variants":[{"Stock":9},{"Stock":2}]`},
+
+			// Removing a variant takes its stock with it; orders keep their items
+			{admin, `mutation { RemoveProductVariant(id: 2) { SKU } }`, `"SKU":"TEE-XL"`},
+			{admin, `mutation { RemoveProductVariant(id: 2) { SKU } }`, "variant with id 2 not found"},
+			{customer, `{ GetProduct(id: 3) { Stock Variants { SKU } } }`, `"Stock":9,"Variants":[{"SKU":"TEE-M"}]`},
+			{customer, `{ GetOrder(id: 1) { Items { Variant { SKU } } } }`, `"Items":[{"Variant":null},{"Variant":{"SKU":"TEE-M"}}]`},
+
+			// Without its last variant the product has no stock, and carts holding it cannot be checked out
+			{customer, `mutation { AddToCart(productId: 3, variantId: 1, quantity: 1) { Subtotal } }`, `"Subtotal":"24.99 USD"`},
+			{admin, `mutation { RemoveProductVariant(id: 1) { SKU } }`, `"SKU":"TEE-M"`},
+			{customer, `{ GetProduct(id: 3) { InStock Status Stock Variants { SKU } } }`,
+				`"InStock":false,"Status":"OUT_OF_STOCK","Stock":0,"Variants":[]`},
+			{customer, `{ Cart { Items { UnitPrice } } }`, "variant 1 of product 3 is no longer available"},
+			{customer, `mutation { CheckoutCart { ID } }`, "product 3 is OUT_OF_STOCK and cannot be ordered"},
+
+			// The first variant takes over the stock of the product
+			{admin, `mutation { AddProductVariant(productId: 2, input: {sku: "BOOK-HC", stock: 5}) { Stock } }`, `"Stock":105`},
+			{admin, `mutation { AddProductVariant(productId: 2, input: {sku: "BOOK-PB", stock: 1}) { Stock } }`, `"Stock":1`},
+			{customer, `{ GetProduct(id: 2) { InStock Status Stock } }`, `"InStock":true,"Status":"ACTIVE","Stock":106`},
+		} {
+			if res := run(tc.ctx, tc.request); !strings.Contains(res, tc.want) {
+				t.Errorf("%s: expected %s, got %s", tc.request, tc.want, res)
+			}
+		}
+	})
+}
@@ -3,98 +3,236 @@ package handlers
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/gburgyan/go-quickgraph"
-	"sync"
+	"time"
 )
 
 type Widget struct {
-	ID       int     `json:"id"`
-	Name     string  `json:"name"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Price    Money  `json:"price"` // In the store currency
+	Quantity int    `json:"quantity"`
+	Version  int    `json:"version"` // Incremented on every update; updates must send the version they read
+
+	// Quantity at or below which the widget needs reordering; null disables
+	// low-stock alerts. Set with SetWidgetReorderThreshold; ignored on update
+	ReorderThreshold *int `json:"reorderThreshold"`
+
+	// Set when the widget is soft-deleted; ignored on input
+	DeletedAt *time.Time `json:"deletedAt"`
+	DeletedBy *int       `json:"deletedBy"` // ID of the deleting user
+
+	// Deprecated: the price as a plain number, filled in by bind; ignored on
+	// input. Use price.
+	PriceFloat *float64 `json:"PriceFloat,omitempty" graphy:"PriceFloat,deprecated=Use price with its currency"`
+
+	// Scope used by the StockHistory field resolver
+	scope *scope `json:"-" graphy:"-"`
 }
 
 type WidgetCreateInput struct {
-	Name     string  `json:"name"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
+	Name             string `json:"name"`
+	Price            Money  `json:"price"`
+	Quantity         int    `json:"quantity"`
+	ReorderThreshold *int   `json:"reorderThreshold"`
 }
 
-var (
-	widgets = []Widget{
-		{
-			ID:       1,
-			Name:     "Widget 1",
-			Price:    1.00,
-			Quantity: 10,
-		},
-	}
-	widgetsMux sync.RWMutex
-)
+// WidgetHandlers serves the widget queries and mutations from a Store.
+type WidgetHandlers struct {
+	store Store
+}
 
-func RegisterWidgetHandlers(ctx context.Context, graphy *quickgraph.Graphy) {
-	graphy.RegisterQuery(ctx, "GetWidget", GetWidget, "id")
-	graphy.RegisterQuery(ctx, "GetWidgets", GetWidgets)
-	graphy.RegisterMutation(ctx, "CreateWidget", CreateWidget, "widget")
-	graphy.RegisterMutation(ctx, "UpdateWidget", UpdateWidget, "widget")
+// NewWidgetHandlers creates widget handlers backed by store.
+func NewWidgetHandlers(store Store) *WidgetHandlers {
+	return &WidgetHandlers{store: store}
 }
 
-func GetWidget(id int) (Widget, error) {
-	widgetsMux.RLock()
-	defer widgetsMux.RUnlock()
+func RegisterWidgetHandlers(ctx context.Context, graphy *quickgraph.Graphy, store Store) {
+	h := NewWidgetHandlers(store)
+	graphy.RegisterQuery(ctx, "GetWidget", h.GetWidget, "id")
+	graphy.RegisterQuery(ctx, "GetWidgets", h.GetWidgets, "includeDeleted", "first", "after", "last", "before")
+	graphy.RegisterMutation(ctx, "CreateWidget", h.CreateWidget, "widget")
+	graphy.RegisterMutation(ctx, "UpdateWidget", h.UpdateWidget, "widget")
+	graphy.RegisterMutation(ctx, "DeleteWidget", h.DeleteWidget, "id")
+	graphy.RegisterMutation(ctx, "RestoreWidget", h.RestoreWidget, "id")
+	graphy.RegisterMutation(ctx, "AdjustWidgetStock", h.AdjustWidgetStock, "widgetId", "delta", "reason")
+	graphy.RegisterMutation(ctx, "SetWidgetReorderThreshold", h.SetWidgetReorderThreshold, "widgetId", "threshold")
+	graphy.RegisterMutation(ctx, "ImportWidgets", h.ImportWidgets, "csv", "mode")
+}
 
-	for _, widget := range widgets {
-		if widget.ID == id {
-			return widget, nil
-		}
+// GetWidget returns a widget by ID. Deleted widgets are only visible to admins.
+func (h *WidgetHandlers) GetWidget(ctx context.Context, id int) (Widget, error) {
+	widget, err := h.store.Widgets().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && widget.isDeleted() && !canSeeDeleted(ctx)) {
+		return Widget{}, errors.New("widget not found")
 	}
-	return Widget{}, errors.New("widget not found")
+	widget.bind(newScope(ctx, h.store))
+	return widget, err
 }
 
-func GetWidgets() ([]Widget, error) {
-	widgetsMux.RLock()
-	defer widgetsMux.RUnlock()
+// GetWidgets returns a page of all widgets. Admins can pass includeDeleted
+// to also get the deleted ones.
+func (h *WidgetHandlers) GetWidgets(ctx context.Context, includeDeleted *bool, first *int, after *string, last *int, before *string) (*WidgetConnection, error) {
+	widgets, err := h.listWidgets(ctx, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	return newWidgetConnection(widgets, pageArgs(first, after, last, before))
+}
 
-	result := make([]Widget, len(widgets))
-	copy(result, widgets)
-	return result, nil
+// listWidgets returns all widgets GetWidgets pages through.
+func (h *WidgetHandlers) listWidgets(ctx context.Context, includeDeleted *bool) ([]Widget, error) {
+	withDeleted, err := showDeleted(ctx, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	widgets, err := h.store.Widgets().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bindWidgets(newScope(ctx, h.store), withoutDeleted(widgets, withDeleted)), nil
 }
 
-func CreateWidget(input WidgetCreateInput) (Widget, error) {
-	widgetsMux.Lock()
-	defer widgetsMux.Unlock()
+func (h *WidgetHandlers) CreateWidget(ctx context.Context, input WidgetCreateInput) (Widget, error) {
+	if err := checkPrice(input.Price); err != nil {
+		return Widget{}, err
+	}
+	if input.Quantity < 0 {
+		return Widget{}, errors.New("quantity cannot be negative")
+	}
+	if input.ReorderThreshold != nil && *input.ReorderThreshold < 0 {
+		return Widget{}, errors.New("threshold cannot be negative")
+	}
 
-	widget := Widget{
-		ID:       len(widgets) + 1,
-		Name:     input.Name,
-		Price:    input.Price,
-		Quantity: input.Quantity,
+	widget, err := h.store.Widgets().Create(ctx, Widget{
+		Name:             input.Name,
+		Price:            input.Price,
+		Quantity:         input.Quantity,
+		ReorderThreshold: input.ReorderThreshold,
+	})
+	if err != nil {
+		return Widget{}, err
+	}
+	// The initial quantity opens the stock ledger
+	if err := h.recordStockChange(ctx, Widget{}, widget, "initial stock"); err != nil {
+		return Widget{}, err
 	}
-	widgets = append(widgets, widget)
 
 	// Broadcast the widget creation
-	BroadcastWidgetUpdate(widget, "created")
+	h.store.Events().BroadcastWidgetUpdate(ctx, widget, "created")
 
+	widget.bind(newScope(ctx, h.store))
 	return widget, nil
 }
 
-func UpdateWidget(widget Widget) (Widget, error) {
+func (h *WidgetHandlers) UpdateWidget(ctx context.Context, widget Widget) (Widget, error) {
+	if err := checkPrice(widget.Price); err != nil {
+		return Widget{}, err
+	}
 	if widget.Quantity < 0 {
 		return Widget{}, errors.New("quantity cannot be negative")
 	}
 
-	widgetsMux.Lock()
-	defer widgetsMux.Unlock()
+	// Deleted widgets cannot be updated, and clients cannot set the deletion fields
+	stored, err := h.store.Widgets().Get(ctx, widget.ID)
+	if errors.Is(err, ErrNotFound) || (err == nil && stored.isDeleted()) {
+		return Widget{}, errors.New("widget not found")
+	}
+	if err != nil {
+		return Widget{}, err
+	}
+	widget.DeletedAt, widget.DeletedBy = nil, nil
+	widget.ReorderThreshold = stored.ReorderThreshold
+
+	widget, err = h.store.Widgets().Update(ctx, widget)
+	if errors.Is(err, ErrNotFound) {
+		return Widget{}, errors.New("widget not found")
+	}
+	if err != nil {
+		return Widget{}, err
+	}
+	// The version check guarantees stored is the state that was replaced
+	if err := h.recordStockChange(ctx, stored, widget, "set by UpdateWidget"); err != nil {
+		return Widget{}, err
+	}
+
+	// Broadcast the widget update
+	h.store.Events().BroadcastWidgetUpdate(ctx, widget, "updated")
 
-	for i, w := range widgets {
-		if w.ID == widget.ID {
-			widgets[i] = widget
+	widget.bind(newScope(ctx, h.store))
+	return widget, nil
+}
 
-			// Broadcast the widget update
-			BroadcastWidgetUpdate(widget, "updated")
+// DeleteWidget soft-deletes a widget, recording when and by whom. Only admins
+// may delete widgets.
+func (h *WidgetHandlers) DeleteWidget(ctx context.Context, id int) (Widget, error) {
+	user, err := requireAdmin(ctx)
+	if err != nil {
+		return Widget{}, err
+	}
+	deletedAt, deletedBy := deletionStamp(user)
+
+	widget, err := h.store.Widgets().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && widget.isDeleted()) {
+		return Widget{}, errors.New("widget not found")
+	}
+	if err != nil {
+		return Widget{}, err
+	}
+
+	widget.DeletedAt, widget.DeletedBy = deletedAt, deletedBy
+	widget, err = h.store.Widgets().Update(ctx, widget)
+	if err != nil {
+		return Widget{}, err
+	}
+
+	h.store.Events().BroadcastWidgetUpdate(ctx, widget, "deleted")
+
+	widget.bind(newScope(ctx, h.store))
+	return widget, nil
+}
+
+// RestoreWidget undoes DeleteWidget. Only admins can restore.
+func (h *WidgetHandlers) RestoreWidget(ctx context.Context, id int) (Widget, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return Widget{}, err
+	}
+
+	widget, err := h.store.Widgets().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return Widget{}, errors.New("widget not found")
+	}
+	if err != nil {
+		return Widget{}, err
+	}
+	if !widget.isDeleted() {
+		return Widget{}, fmt.Errorf("widget %d is not deleted", id)
+	}
+
+	widget.DeletedAt, widget.DeletedBy = nil, nil
+	widget, err = h.store.Widgets().Update(ctx, widget)
+	if err != nil {
+		return Widget{}, err
+	}
+
+	h.store.Events().BroadcastWidgetUpdate(ctx, widget, "restored")
+
+	widget.bind(newScope(ctx, h.store))
+	return widget, nil
+}
+
+// bind attaches sc to the widget so its field resolvers work, and fills in
+// PriceFloat.
+func (w *Widget) bind(sc *scope) {
+	w.scope = sc
+	price := w.Price.Dollars()
+	w.PriceFloat = &price
+}
 
-			return widget, nil
-		}
+func bindWidgets(sc *scope, widgets []Widget) []Widget {
+	for i := range widgets {
+		widgets[i].bind(sc)
 	}
-	return Widget{}, errors.New("widget not found")
+	return widgets
 }